@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go-multi-tenant/config"
+	"go-multi-tenant/models"
+	"go-multi-tenant/services"
+	"log"
+	"os"
+	"sort"
+)
+
+const usage = `Usage:
+  tenantctl backup  -tenant ID -out FILE
+  tenantctl inspect -in FILE
+  tenantctl restore -in FILE -tenant ID -force
+  tenantctl restore -in FILE -new-name NAME [-db-type shared|dedicated] [-plan ID] [-subdomain SUB]
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "backup":
+		runBackup(os.Args[2:])
+	case "inspect":
+		runInspect(os.Args[2:])
+	case "restore":
+		runRestore(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func connect() {
+	cfg := config.Load()
+	if err := config.InitMasterDB(cfg); err != nil {
+		log.Fatal("Failed to initialize master database:", err)
+	}
+	if err := config.InitRedis(cfg); err != nil {
+		log.Println("Warning: Redis connection failed. Cache will not be cleared.", err)
+	}
+	config.InitTenantManager(cfg)
+}
+
+func runBackup(args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	tenantID := fs.Uint("tenant", 0, "tenant ID to back up")
+	out := fs.String("out", "", "archive file to write")
+	fs.Parse(args)
+
+	if *tenantID == 0 || *out == "" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	connect()
+
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	manifest, err := services.NewBackupService().Backup(uint(*tenantID), f)
+	if err != nil {
+		os.Remove(*out)
+		log.Fatal("Backup failed: ", err)
+	}
+	printManifest(manifest)
+}
+
+func runInspect(args []string) {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	in := fs.String("in", "", "archive file to read")
+	fs.Parse(args)
+
+	if *in == "" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	f, size := openArchive(*in)
+	defer f.Close()
+
+	manifest, err := services.NewBackupService().ReadManifest(f, size)
+	if err != nil {
+		log.Fatal(err)
+	}
+	printManifest(manifest)
+}
+
+func runRestore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	in := fs.String("in", "", "archive file to read")
+	tenantID := fs.Uint("tenant", 0, "existing tenant to restore over")
+	force := fs.Bool("force", false, "confirm that the existing tenant's data will be replaced")
+	newName := fs.String("new-name", "", "create a new tenant with this name")
+	dbType := fs.String("db-type", "", "database type for a new tenant (shared or dedicated)")
+	planID := fs.Uint("plan", 0, "plan for a new tenant (defaults to the backup's plan)")
+	subdomain := fs.String("subdomain", "", "subdomain for a new tenant (defaults to the backup's if free)")
+	fs.Parse(args)
+
+	if *in == "" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if *tenantID != 0 && !*force {
+		log.Fatal("Restoring over an existing tenant deletes its current data; re-run with -force")
+	}
+
+	connect()
+
+	f, size := openArchive(*in)
+	defer f.Close()
+
+	tenant, err := services.NewBackupService().Restore(f, size, services.RestoreOptions{
+		TargetTenantID: uint(*tenantID),
+		NewTenantName:  *newName,
+		DatabaseType:   models.DatabaseType(*dbType),
+		PlanID:         uint(*planID),
+		Subdomain:      *subdomain,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Restored into tenant %d (%s, database %s)", tenant.ID, tenant.Name, tenant.GetActualDBName())
+}
+
+func openArchive(path string) (*os.File, int64) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return f, info.Size()
+}
+
+func printManifest(m *services.BackupManifest) {
+	fmt.Printf("Tenant:         %d (%s, %s)\n", m.TenantID, m.TenantName, m.DatabaseType)
+	fmt.Printf("Created:        %s\n", m.CreatedAt.Format("2006-01-02 15:04:05 MST"))
+	fmt.Printf("Schema version: %d\n", m.SchemaVersion)
+	names := make([]string, 0, len(m.Files))
+	for name := range m.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %-22s %6d rows\n", name, m.Files[name].Rows)
+	}
+}
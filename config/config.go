@@ -1,18 +1,75 @@
 package config
 
 import (
-	"fmt"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
+	AppEnv      string // development or production; development enables test-only features
 	ServerPort  string
 	MasterDBDSN string
 
 	// Database Connection Params
+	DBDriver   string // mysql, postgres or sqlite
 	DBHost     string
+	DBPort     int
 	DBUser     string
 	DBPassword string
+	DBSSLMode  string
+	SQLiteDir  string
+
+	// Tenant connection pools
+	TenantPoolMax         int
+	TenantPoolIdleTimeout time.Duration
+	TenantConnBudget      int
+
+	// Tenants are served from <subdomain>.<AppBaseDomain> or a verified custom domain.
+	AppBaseDomain string
+
+	// Where tenant data exports are written until they are downloaded or expire.
+	ExportDir string
+
+	// Billing
+	BillingCurrency string
+	// Provider used when tenants pay their own invoices; empty means only an
+	// operator can record payments.
+	PaymentProvider string
+	// How long an expired, unpaid tenant stays read-only before it is suspended.
+	GracePeriod time.Duration
+	// Days relative to plan expiry on which payment reminders go out; negative is before.
+	DunningSchedule []int
+
+	// Background jobs
+	QueueBackend         string // auto, redis or database; auto uses Redis when it answers at startup
+	QueueWorkers         int
+	JobVisibilityTimeout time.Duration
+
+	// Cron scheduler
+	SchedulerLock     string // auto, redis or database, as for QueueBackend
+	SchedulerTimezone string // Timezone the built-in job schedules are read in
+
+	// Outgoing email
+	MailTransport string // smtp, file or capture
+	MailFrom      string
+	SMTPHost      string
+	SMTPPort      int
+	SMTPUser      string
+	SMTPPassword  string
+	MailDir       string // Where the file transport writes .eml files
+
+	// Domain event bus
+	EventFanout  string // auto, redis or off; auto fans out over Redis when it answers at startup
+	EventWorkers int
+	StreamReplay int // Recent stream events kept per tenant for reconnecting clients
+
+	// Outgoing webhooks
+	WebhookTimeout time.Duration
+	// Allow webhook URLs on loopback and private networks; for development only.
+	WebhookAllowPrivate bool
 
 	RedisAddr string
 	RedisPass string
@@ -21,25 +78,87 @@ type Config struct {
 	JWTSecret string
 }
 
+// AppConfig is the configuration loaded at startup, for code that has no cfg passed in.
+var AppConfig *Config
+
 func Load() *Config {
 	dbHost := getEnv("DB_HOST", "localhost")
 	dbUser := getEnv("DB_USER", "root")
 	dbPassword := getEnv("DB_PASSWORD", "")
+	dbDriver := getEnv("DB_DRIVER", "mysql")
 
-	defaultMasterDSN := fmt.Sprintf("%s:%s@tcp(%s:3306)/master_db?charset=utf8mb4&parseTime=True&loc=Local",
-		dbUser, dbPassword, dbHost)
+	defaultPort := 3306
+	if driver, err := GetDBDriver(dbDriver); err == nil {
+		defaultPort = driver.DefaultPort()
+	}
 
-	return &Config{
-		ServerPort:  getEnv("SERVER_PORT", ":8080"),
-		MasterDBDSN: getEnv("MASTER_DB_DSN", defaultMasterDSN),
+	AppConfig = &Config{
+		AppEnv:     getEnv("APP_ENV", "production"),
+		ServerPort: getEnv("SERVER_PORT", ":8080"),
+		// Optional; when empty the master DSN is built from the DB_* settings.
+		MasterDBDSN: getEnv("MASTER_DB_DSN", ""),
+		DBDriver:    dbDriver,
 		DBHost:      dbHost,
+		DBPort:      getEnvInt("DB_PORT", defaultPort),
 		DBUser:      dbUser,
 		DBPassword:  dbPassword,
-		RedisAddr:   getEnv("REDIS_ADDR", "localhost:6379"),
-		RedisPass:   getEnv("REDIS_PASSWORD", ""),
+		DBSSLMode:   getEnv("DB_SSLMODE", "disable"),
+		SQLiteDir:   getEnv("SQLITE_DIR", "data"),
+
+		TenantPoolMax:         getEnvInt("TENANT_POOL_MAX", 100),
+		TenantPoolIdleTimeout: getEnvDuration("TENANT_POOL_IDLE_TIMEOUT", 10*time.Minute),
+		TenantConnBudget:      getEnvInt("TENANT_DB_CONN_BUDGET", 400),
+
+		AppBaseDomain: getEnv("APP_BASE_DOMAIN", "app.example"),
+
+		ExportDir: getEnv("EXPORT_DIR", "exports"),
+
+		BillingCurrency: getEnv("BILLING_CURRENCY", "USD"),
+		PaymentProvider: getEnv("PAYMENT_PROVIDER", ""),
+		GracePeriod:     getEnvDuration("GRACE_PERIOD", 7*24*time.Hour),
+		DunningSchedule: getEnvIntList("DUNNING_SCHEDULE", []int{-7, -3, 0, 3, 6}),
+
+		QueueBackend:         getEnv("QUEUE_BACKEND", "auto"),
+		QueueWorkers:         getEnvInt("QUEUE_WORKERS", 4),
+		JobVisibilityTimeout: getEnvDuration("JOB_VISIBILITY_TIMEOUT", 5*time.Minute),
+
+		SchedulerLock:     getEnv("SCHEDULER_LOCK", "auto"),
+		SchedulerTimezone: getEnv("SCHEDULER_TIMEZONE", "UTC"),
+
+		MailTransport: getEnv("MAIL_TRANSPORT", "file"),
+		MailFrom:      getEnv("MAIL_FROM", "no-reply@app.example"),
+		SMTPHost:      getEnv("SMTP_HOST", ""),
+		SMTPPort:      getEnvInt("SMTP_PORT", 587),
+		SMTPUser:      getEnv("SMTP_USER", ""),
+		SMTPPassword:  getEnv("SMTP_PASSWORD", ""),
+		MailDir:       getEnv("MAIL_DIR", "mail"),
+
+		EventFanout:  getEnv("EVENT_FANOUT", "auto"),
+		EventWorkers: getEnvInt("EVENT_WORKERS", 4),
+		StreamReplay: getEnvInt("STREAM_REPLAY", 200),
+
+		WebhookTimeout:      getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		WebhookAllowPrivate: getEnvBool("WEBHOOK_ALLOW_PRIVATE", false),
+
+		RedisAddr: getEnv("REDIS_ADDR", "localhost:6379"),
+		RedisPass: getEnv("REDIS_PASSWORD", ""),
 		// ✅ Default secret for dev, change in prod
 		JWTSecret: getEnv("JWT_SECRET", "super_secret_key_change_me_in_prod"),
 	}
+	return AppConfig
+}
+
+// DefaultDBConn is the server used by the master database, the shared tenant
+// database and any dedicated tenant without its own connection settings.
+func (c *Config) DefaultDBConn() DBConnInfo {
+	return DBConnInfo{
+		Host:     c.DBHost,
+		Port:     c.DBPort,
+		User:     c.DBUser,
+		Password: c.DBPassword,
+		SSLMode:  c.DBSSLMode,
+		DataDir:  c.SQLiteDir,
+	}
 }
 
 func getEnv(key, defaultValue string) string {
@@ -48,3 +167,42 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvIntList parses a comma-separated list such as "-3,0,3" and returns it sorted.
+func getEnvIntList(key string, defaultValue []int) []int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	var list []int
+	for _, part := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return defaultValue
+		}
+		list = append(list, n)
+	}
+	sort.Ints(list)
+	return list
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// DBConnInfo describes how to reach a database server. Not every driver uses
+// every field: SQLite only needs DataDir.
+type DBConnInfo struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	SSLMode  string
+	DataDir  string
+}
+
+// DBDriver hides the SQL dialect and DSN format of a database engine so the
+// master and tenant databases are not tied to MySQL.
+type DBDriver interface {
+	Name() string
+	DefaultPort() int
+	// Open uses a driver-specific DSN as-is.
+	Open(dsn string) gorm.Dialector
+	// Dialector opens dbName on the server described by conn.
+	Dialector(conn DBConnInfo, dbName string) gorm.Dialector
+	CreateDatabase(conn DBConnInfo, dbName string) error
+	DropDatabase(conn DBConnInfo, dbName string) error
+}
+
+var (
+	dbDrivers   = map[string]DBDriver{}
+	dbDriversMu sync.RWMutex
+)
+
+func RegisterDBDriver(driver DBDriver) {
+	dbDriversMu.Lock()
+	defer dbDriversMu.Unlock()
+	dbDrivers[driver.Name()] = driver
+}
+
+func GetDBDriver(name string) (DBDriver, error) {
+	dbDriversMu.RLock()
+	defer dbDriversMu.RUnlock()
+
+	driver, ok := dbDrivers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown database driver %q", name)
+	}
+	return driver, nil
+}
+
+func init() {
+	RegisterDBDriver(mysqlDriver{})
+	RegisterDBDriver(postgresDriver{})
+	RegisterDBDriver(sqliteDriver{})
+}
+
+// withServer opens a short-lived connection to the server itself (no database selected).
+func withServer(dialector gorm.Dialector, fn func(db *gorm.DB) error) error {
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		return err
+	}
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+
+	return fn(db)
+}
+
+// --- MySQL ---
+
+type mysqlDriver struct{}
+
+func (mysqlDriver) Name() string     { return "mysql" }
+func (mysqlDriver) DefaultPort() int { return 3306 }
+
+func (mysqlDriver) Open(dsn string) gorm.Dialector { return mysql.Open(dsn) }
+
+func (mysqlDriver) Dialector(conn DBConnInfo, dbName string) gorm.Dialector {
+	return mysql.Open(fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+		conn.User, conn.Password, conn.Host, conn.Port, dbName))
+}
+
+func (d mysqlDriver) CreateDatabase(conn DBConnInfo, dbName string) error {
+	return withServer(d.Dialector(conn, ""), func(db *gorm.DB) error {
+		return db.Exec(fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s`", dbName)).Error
+	})
+}
+
+func (d mysqlDriver) DropDatabase(conn DBConnInfo, dbName string) error {
+	return withServer(d.Dialector(conn, ""), func(db *gorm.DB) error {
+		return db.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS `%s`", dbName)).Error
+	})
+}
+
+// --- PostgreSQL ---
+
+type postgresDriver struct{}
+
+func (postgresDriver) Name() string     { return "postgres" }
+func (postgresDriver) DefaultPort() int { return 5432 }
+
+func (postgresDriver) Open(dsn string) gorm.Dialector { return postgres.Open(dsn) }
+
+func (postgresDriver) Dialector(conn DBConnInfo, dbName string) gorm.Dialector {
+	if dbName == "" {
+		dbName = "postgres"
+	}
+	sslMode := conn.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	return postgres.Open(fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		conn.Host, conn.Port, conn.User, conn.Password, dbName, sslMode))
+}
+
+// Postgres has no CREATE DATABASE IF NOT EXISTS, so check pg_database first.
+func (d postgresDriver) CreateDatabase(conn DBConnInfo, dbName string) error {
+	return withServer(d.Dialector(conn, ""), func(db *gorm.DB) error {
+		var count int64
+		if err := db.Raw("SELECT COUNT(*) FROM pg_database WHERE datname = ?", dbName).Scan(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		return db.Exec(fmt.Sprintf(`CREATE DATABASE "%s"`, strings.ReplaceAll(dbName, `"`, `""`))).Error
+	})
+}
+
+func (d postgresDriver) DropDatabase(conn DBConnInfo, dbName string) error {
+	return withServer(d.Dialector(conn, ""), func(db *gorm.DB) error {
+		return db.Exec(fmt.Sprintf(`DROP DATABASE IF EXISTS "%s"`, strings.ReplaceAll(dbName, `"`, `""`))).Error
+	})
+}
+
+// --- SQLite ---
+
+// sqliteDriver keeps each database as a file in DataDir, which makes a fully
+// local setup possible without a database server.
+type sqliteDriver struct{}
+
+func (sqliteDriver) Name() string     { return "sqlite" }
+func (sqliteDriver) DefaultPort() int { return 0 }
+
+func (sqliteDriver) path(conn DBConnInfo, dbName string) string {
+	dir := conn.DataDir
+	if dir == "" {
+		dir = "data"
+	}
+	return filepath.Join(dir, dbName+".db")
+}
+
+func (sqliteDriver) Open(dsn string) gorm.Dialector { return sqlite.Open(dsn) }
+
+func (d sqliteDriver) Dialector(conn DBConnInfo, dbName string) gorm.Dialector {
+	return sqlite.Open(d.path(conn, dbName) + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+}
+
+func (d sqliteDriver) CreateDatabase(conn DBConnInfo, dbName string) error {
+	return os.MkdirAll(filepath.Dir(d.path(conn, dbName)), 0o755)
+}
+
+func (d sqliteDriver) DropDatabase(conn DBConnInfo, dbName string) error {
+	path := d.path(conn, dbName)
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if err := os.Remove(path + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
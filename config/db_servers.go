@@ -0,0 +1,71 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"go-multi-tenant/models"
+	"os"
+	"strings"
+)
+
+// ResolveCredentials turns a server's credentials reference into a user and
+// password. Supported references:
+//
+//	""            - the default DB_USER / DB_PASSWORD
+//	"env:PREFIX"  - PREFIX_USER / PREFIX_PASSWORD from the environment
+func ResolveCredentials(ref string) (user, password string, err error) {
+	if ref == "" {
+		return AppConfig.DBUser, AppConfig.DBPassword, nil
+	}
+
+	scheme, name, ok := strings.Cut(ref, ":")
+	if !ok || name == "" {
+		return "", "", fmt.Errorf("malformed credentials reference %q", ref)
+	}
+	switch scheme {
+	case "env":
+		user = os.Getenv(name + "_USER")
+		if user == "" {
+			return "", "", fmt.Errorf("credentials reference %q: %s_USER is not set", ref, name)
+		}
+		return user, os.Getenv(name + "_PASSWORD"), nil
+	default:
+		return "", "", fmt.Errorf("unsupported credentials reference scheme %q", scheme)
+	}
+}
+
+// ServerConn builds the driver and connection settings for a registered server.
+func ServerConn(server *models.DatabaseServer) (DBDriver, DBConnInfo, error) {
+	if !server.IsActive {
+		return nil, DBConnInfo{}, fmt.Errorf("database server %s is disabled", server.Name)
+	}
+	driver, err := GetDBDriver(server.Driver)
+	if err != nil {
+		return nil, DBConnInfo{}, err
+	}
+	user, password, err := ResolveCredentials(server.CredentialsRef)
+	if err != nil {
+		return nil, DBConnInfo{}, err
+	}
+
+	conn := AppConfig.DefaultDBConn()
+	conn.Host = server.Host
+	conn.Port = server.Port
+	conn.User = user
+	conn.Password = password
+	if conn.Port == 0 {
+		conn.Port = driver.DefaultPort()
+	}
+	return driver, conn, nil
+}
+
+func loadDatabaseServer(id uint) (*models.DatabaseServer, error) {
+	if MasterDB == nil {
+		return nil, errors.New("master database is not connected")
+	}
+	var server models.DatabaseServer
+	if err := MasterDB.First(&server, id).Error; err != nil {
+		return nil, fmt.Errorf("database server %d not found: %w", id, err)
+	}
+	return &server, nil
+}
@@ -6,7 +6,6 @@ import (
 	"log"
 	"time"
 
-	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
@@ -14,8 +13,6 @@ import (
 var MasterDB *gorm.DB
 
 func InitMasterDB(cfg *Config) error {
-	var err error
-
 	newLogger := logger.New(
 		log.New(log.Writer(), "\r\n", log.LstdFlags),
 		logger.Config{
@@ -26,7 +23,19 @@ func InitMasterDB(cfg *Config) error {
 		},
 	)
 
-	MasterDB, err = gorm.Open(mysql.Open(cfg.MasterDBDSN), &gorm.Config{
+	driver, err := GetDBDriver(cfg.DBDriver)
+	if err != nil {
+		return err
+	}
+
+	dialector := driver.Dialector(cfg.DefaultDBConn(), "master_db")
+	if cfg.MasterDBDSN != "" {
+		dialector = driver.Open(cfg.MasterDBDSN)
+	} else if err := driver.CreateDatabase(cfg.DefaultDBConn(), "master_db"); err != nil {
+		return fmt.Errorf("failed to create master database: %w", err)
+	}
+
+	MasterDB, err = gorm.Open(dialector, &gorm.Config{
 		Logger: newLogger,
 	})
 	if err != nil {
@@ -46,6 +55,28 @@ func InitMasterDB(cfg *Config) error {
 		&models.User{},
 		&models.Role{},
 		&models.Permission{},
+		&models.TenantDomain{},
+		&models.TenantSetting{},
+		&models.DatabaseServer{},
+		&models.DataExport{},
+		&models.TenantUsage{},
+		&models.Invoice{},
+		&models.InvoiceLine{},
+		&models.Payment{},
+		&models.Coupon{},
+		&models.CouponRedemption{},
+		&models.Job{},
+		&models.CronRun{},
+		&models.SchedulerLock{},
+		&models.StreamTicket{},
+		&models.ScheduledTask{},
+		&models.ScheduledTaskRun{},
+		&models.UserImport{},
+		&models.OutboxEmail{},
+		&models.EmailTemplate{},
+		&models.WebhookSubscription{},
+		&models.WebhookDelivery{},
+		&models.WebhookAttempt{},
 	)
 
 	if err != nil {
@@ -1,82 +1,221 @@
 package config
 
 import (
+	"database/sql"
 	"fmt"
 	"go-multi-tenant/models"
 	"log"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"gorm.io/driver/mysql"
+	"golang.org/x/sync/singleflight"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
+// TenantSchemaVersion is bumped whenever the tenant database schema changes in a
+// way that matters to tenant backups.
+//
+//	2: notifications and notification preferences
+const TenantSchemaVersion = 2
+
+const minTenantPoolSize = 2
+
+// Pool weight and hard cap per plan. Weights decide each tenant's share of the
+// global connection budget.
+var planPoolSizing = map[models.PlanType]struct{ weight, maxConns int }{
+	models.PlanFree:     {weight: 1, maxConns: 5},
+	models.PlanStandard: {weight: 2, maxConns: 15},
+	models.PlanPremium:  {weight: 4, maxConns: 30},
+	"system_internal":   {weight: 4, maxConns: 30},
+}
+
+type tenantPool struct {
+	tenantID  uint
+	dbName    string
+	driver    string
+	host      string
+	planType  models.PlanType
+	db        *gorm.DB
+	maxOpen   int
+	createdAt time.Time
+	lastUsed  atomic.Int64 // UnixNano of the last GetTenantDB
+}
+
+func (p *tenantPool) lastUsedAt() time.Time {
+	return time.Unix(0, p.lastUsed.Load())
+}
+
+type TenantPoolStats struct {
+	TenantID        uint            `json:"tenant_id"`
+	DBName          string          `json:"db_name"`
+	Driver          string          `json:"driver"`
+	Host            string          `json:"host"`
+	PlanType        models.PlanType `json:"plan_type"`
+	MaxOpen         int             `json:"max_open"`
+	OpenConnections int             `json:"open_connections"`
+	InUse           int             `json:"in_use"`
+	Idle            int             `json:"idle"`
+	WaitCount       int64           `json:"wait_count"`
+	WaitDuration    string          `json:"wait_duration"`
+	CreatedAt       time.Time       `json:"created_at"`
+	LastUsed        time.Time       `json:"last_used"`
+}
+
+type TenantPoolSummary struct {
+	MaxPools         int               `json:"max_pools"`
+	IdleTimeout      string            `json:"idle_timeout"`
+	ConnectionBudget int               `json:"connection_budget"`
+	AllocatedConns   int               `json:"allocated_connections"`
+	OpenConnections  int               `json:"open_connections"`
+	Pools            []TenantPoolStats `json:"pools"`
+}
+
+// TenantDBManager keeps one connection pool per tenant, bounded by
+// TenantPoolMax entries. Pools idle for longer than TenantPoolIdleTimeout are
+// closed, and TenantConnBudget is split across open pools by plan weight.
+//
+// Callers keep the *gorm.DB they get for as long as a request or job runs, so
+// a pool is only closed once it has not been handed out for the idle timeout
+// and has no queries in flight. TenantPoolMax is therefore a soft limit: it
+// is exceeded while every pool over it is still in use.
 type TenantDBManager struct {
-	tenantDBs map[uint]*gorm.DB
-	mutex     sync.RWMutex
-	config    *Config
+	pools    map[uint]*tenantPool
+	migrated map[string]bool
+	mutex    sync.RWMutex
+	opening  singleflight.Group // One open per tenant, one migration per database
+	config   *Config
 }
 
 var TenantManager *TenantDBManager
 
 func InitTenantManager(cfg *Config) {
 	TenantManager = &TenantDBManager{
-		tenantDBs: make(map[uint]*gorm.DB),
-		config:    cfg,
+		pools:    make(map[uint]*tenantPool),
+		migrated: make(map[string]bool),
+		config:   cfg,
 	}
+	TenantManager.startJanitor()
 }
 
+// GetTenantDB returns the tenant's pool without a tenant scope; request code
+// should wrap it with ScopeToTenant.
 func (tm *TenantDBManager) GetTenantDB(tenant *models.Tenant) (*gorm.DB, error) {
 	if tenant.ID == 0 {
 		return nil, fmt.Errorf("tenant ID cannot be zero")
 	}
 
 	tm.mutex.RLock()
-	if db, exists := tm.tenantDBs[tenant.ID]; exists {
-		tm.mutex.RUnlock()
-		return db, nil
-	}
+	pool, exists := tm.pools[tenant.ID]
 	tm.mutex.RUnlock()
+	if exists {
+		pool.lastUsed.Store(time.Now().UnixNano())
+		return pool.db, nil
+	}
 
-	return tm.initializeTenantDB(tenant)
+	// Connecting and migrating happen outside the lock, once per tenant.
+	db, err, _ := tm.opening.Do("tenant:"+strconv.FormatUint(uint64(tenant.ID), 10), func() (interface{}, error) {
+		return tm.initializeTenantDB(tenant)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return db.(*gorm.DB), nil
 }
 
 func (tm *TenantDBManager) initializeTenantDB(tenant *models.Tenant) (*gorm.DB, error) {
-	tm.mutex.Lock()
-	defer tm.mutex.Unlock()
-
-	// Double check inside lock
-	if db, exists := tm.tenantDBs[tenant.ID]; exists {
-		return db, nil
+	tm.mutex.RLock()
+	pool, exists := tm.pools[tenant.ID]
+	tm.mutex.RUnlock()
+	if exists {
+		pool.lastUsed.Store(time.Now().UnixNano())
+		return pool.db, nil
 	}
 
 	actualDBName := tenant.GetActualDBName()
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:3306)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		tm.config.DBUser, tm.config.DBPassword, tm.config.DBHost, actualDBName)
+	driver, conn, err := tm.connectionFor(tenant)
+	if err != nil {
+		return nil, err
+	}
 
 	newLogger := logger.New(
 		log.New(log.Writer(), "\r\n", log.LstdFlags),
 		logger.Config{LogLevel: logger.Error},
 	)
 
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{Logger: newLogger})
+	db, err := gorm.Open(driver.Dialector(conn, actualDBName), &gorm.Config{Logger: newLogger})
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to tenant db %s: %w", actualDBName, err)
 	}
 
+	if err := registerTenantScope(db); err != nil {
+		return nil, fmt.Errorf("failed to register tenant scope: %w", err)
+	}
+
 	sqlDB, _ := db.DB()
-	sqlDB.SetMaxIdleConns(5)
-	sqlDB.SetMaxOpenConns(50)
+	sqlDB.SetMaxIdleConns(minTenantPoolSize)
+	sqlDB.SetMaxOpenConns(minTenantPoolSize)
 	sqlDB.SetConnMaxLifetime(30 * time.Minute)
+	sqlDB.SetConnMaxIdleTime(tm.config.TenantPoolIdleTimeout)
+
+	if err := tm.migrateOnce(db, migrationKey(driver, conn, actualDBName), actualDBName); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+
+	pool = &tenantPool{
+		tenantID:  tenant.ID,
+		dbName:    actualDBName,
+		driver:    driver.Name(),
+		host:      conn.Host,
+		planType:  tm.planTypeOf(tenant),
+		db:        db,
+		createdAt: time.Now(),
+	}
+	pool.lastUsed.Store(time.Now().UnixNano())
+
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	tm.pools[tenant.ID] = pool
+	tm.enforcePoolLimit()
+	tm.rebalance()
+	return db, nil
+}
 
+// migrateOnce migrates a database the first time any tenant in it is opened.
+// Tenants sharing a database wait for the same migration.
+func (tm *TenantDBManager) migrateOnce(db *gorm.DB, key, actualDBName string) error {
+	tm.mutex.RLock()
+	done := tm.migrated[key]
+	tm.mutex.RUnlock()
+	if done {
+		return nil
+	}
+	_, err, _ := tm.opening.Do("migrate:"+key, func() (interface{}, error) {
+		if err := migrateTenantDB(db, actualDBName); err != nil {
+			return nil, err
+		}
+		tm.mutex.Lock()
+		tm.migrated[key] = true
+		tm.mutex.Unlock()
+		return nil, nil
+	})
+	return err
+}
+
+func migrateTenantDB(db *gorm.DB, actualDBName string) error {
 	// 1. System Tables (Required for every tenant context)
 	if err := db.AutoMigrate(
 		&models.User{},
 		&models.Role{},
 		&models.Permission{},
+		&models.Notification{},
+		&models.NotificationPreference{},
 	); err != nil {
-		return nil, fmt.Errorf("failed to migrate system tables: %w", err)
+		return fmt.Errorf("failed to migrate system tables: %w", err)
 	}
 
 	// 2. Business Tables
@@ -89,45 +228,290 @@ func (tm *TenantDBManager) initializeTenantDB(tenant *models.Tenant) (*gorm.DB,
 			&models.Inventory{},
 			&models.PurchaseOrder{},
 		); err != nil {
-			return nil, fmt.Errorf("failed to migrate business tables: %w", err)
+			return fmt.Errorf("failed to migrate business tables: %w", err)
 		}
 	}
+	return nil
+}
 
-	tm.tenantDBs[tenant.ID] = db
-	return db, nil
+func (tm *TenantDBManager) planTypeOf(tenant *models.Tenant) models.PlanType {
+	if tenant.Plan != nil {
+		return tenant.Plan.Type
+	}
+	var plan models.Plan
+	if MasterDB != nil && MasterDB.Select("type").First(&plan, tenant.PlanID).Error == nil {
+		return plan.Type
+	}
+	return models.PlanFree
 }
 
-// Helper: Database Creation
-func (tm *TenantDBManager) CreateDedicatedDatabase(tenant *models.Tenant) error {
-	return tm.createDatabase(tenant.DBName)
+func poolSizing(planType models.PlanType) (weight, maxConns int) {
+	if sizing, ok := planPoolSizing[planType]; ok {
+		return sizing.weight, sizing.maxConns
+	}
+	return planPoolSizing[models.PlanFree].weight, planPoolSizing[models.PlanFree].maxConns
 }
 
-func (tm *TenantDBManager) CreateSharedDatabase() error {
-	return tm.createDatabase("shared_tenants_db")
+// enforcePoolLimit closes least recently used pools until there are at most
+// TenantPoolMax, skipping pools that may still be held by a caller. Must be
+// called with tm.mutex held.
+func (tm *TenantDBManager) enforcePoolLimit() {
+	if len(tm.pools) <= tm.config.TenantPoolMax {
+		return
+	}
+	cutoff := time.Now().Add(-tm.config.TenantPoolIdleTimeout)
+	for _, pool := range tm.leastRecentlyUsed() {
+		if len(tm.pools) <= tm.config.TenantPoolMax {
+			return
+		}
+		if tm.idle(pool, cutoff) {
+			tm.removeLocked(pool)
+		}
+	}
 }
 
-func (tm *TenantDBManager) createDatabase(dbName string) error {
-	// Connect without DB name to CREATE DATABASE
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:3306)/?charset=utf8mb4",
-		tm.config.DBUser, tm.config.DBPassword, tm.config.DBHost)
+// leastRecentlyUsed lists the pools, least recently handed out first. Must be
+// called with tm.mutex held.
+func (tm *TenantDBManager) leastRecentlyUsed() []*tenantPool {
+	pools := make([]*tenantPool, 0, len(tm.pools))
+	for _, pool := range tm.pools {
+		pools = append(pools, pool)
+	}
+	sort.Slice(pools, func(i, j int) bool { return pools[i].lastUsed.Load() < pools[j].lastUsed.Load() })
+	return pools
+}
 
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
-	if err != nil {
-		return err
+// idle reports whether a pool can be closed: it was last handed out before
+// cutoff, so no caller should still hold it, and it has no queries in flight.
+func (tm *TenantDBManager) idle(pool *tenantPool, cutoff time.Time) bool {
+	if pool.lastUsedAt().After(cutoff) {
+		return false
 	}
-	sqlDB, _ := db.DB()
-	defer sqlDB.Close()
+	sqlDB, _ := pool.db.DB()
+	return sqlDB.Stats().InUse == 0
+}
 
-	return db.Exec(fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s`", dbName)).Error
+// rebalance splits the connection budget across open pools by plan weight,
+// capped by each plan's maximum. Must be called with tm.mutex held.
+func (tm *TenantDBManager) rebalance() {
+	totalWeight := 0
+	for _, pool := range tm.pools {
+		weight, _ := poolSizing(pool.planType)
+		totalWeight += weight
+	}
+	if totalWeight == 0 {
+		return
+	}
+
+	for _, pool := range tm.pools {
+		weight, maxConns := poolSizing(pool.planType)
+		size := tm.config.TenantConnBudget * weight / totalWeight
+		if size > maxConns {
+			size = maxConns
+		}
+		if size < minTenantPoolSize {
+			size = minTenantPoolSize
+		}
+		if size != pool.maxOpen {
+			sqlDB, _ := pool.db.DB()
+			sqlDB.SetMaxOpenConns(size)
+			sqlDB.SetMaxIdleConns(size / 2)
+			pool.maxOpen = size
+		}
+	}
+}
+
+func (tm *TenantDBManager) removeLocked(pool *tenantPool) {
+	sqlDB, _ := pool.db.DB()
+	sqlDB.Close()
+	delete(tm.pools, pool.tenantID)
 }
 
-func (tm *TenantDBManager) ClearCache() {
+// Evict closes and forgets a single tenant's connection pool.
+func (tm *TenantDBManager) Evict(tenantID uint) bool {
 	tm.mutex.Lock()
 	defer tm.mutex.Unlock()
 
-	for _, db := range tm.tenantDBs {
-		sqlDB, _ := db.DB()
-		sqlDB.Close()
+	pool, exists := tm.pools[tenantID]
+	if !exists {
+		return false
 	}
-	tm.tenantDBs = make(map[uint]*gorm.DB)
+	tm.removeLocked(pool)
+	tm.rebalance()
+	return true
+}
+
+// EvictIdle closes pools that have not been handed out within the idle
+// timeout and have no queries in flight. It returns the number of pools closed.
+func (tm *TenantDBManager) EvictIdle() int {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	cutoff := time.Now().Add(-tm.config.TenantPoolIdleTimeout)
+	evicted := 0
+	for _, pool := range tm.pools {
+		if tm.idle(pool, cutoff) {
+			tm.removeLocked(pool)
+			evicted++
+		}
+	}
+	if evicted > 0 {
+		tm.rebalance()
+	}
+	return evicted
+}
+
+func (tm *TenantDBManager) startJanitor() {
+	interval := tm.config.TenantPoolIdleTimeout / 2
+	if interval <= 0 || interval > time.Minute {
+		interval = time.Minute
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if n := tm.EvictIdle(); n > 0 {
+				log.Printf("Closed %d idle tenant connection pools", n)
+			}
+		}
+	}()
+}
+
+func (tm *TenantDBManager) Stats() TenantPoolSummary {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	summary := TenantPoolSummary{
+		MaxPools:         tm.config.TenantPoolMax,
+		IdleTimeout:      tm.config.TenantPoolIdleTimeout.String(),
+		ConnectionBudget: tm.config.TenantConnBudget,
+		Pools:            make([]TenantPoolStats, 0, len(tm.pools)),
+	}
+
+	for _, pool := range tm.pools {
+		sqlDB, _ := pool.db.DB()
+		st := sqlDB.Stats()
+
+		summary.AllocatedConns += pool.maxOpen
+		summary.OpenConnections += st.OpenConnections
+		summary.Pools = append(summary.Pools, poolStats(pool, st))
+	}
+
+	sort.SliceStable(summary.Pools, func(i, j int) bool {
+		return summary.Pools[i].LastUsed.After(summary.Pools[j].LastUsed)
+	})
+	return summary
+}
+
+func poolStats(pool *tenantPool, st sql.DBStats) TenantPoolStats {
+	return TenantPoolStats{
+		TenantID:        pool.tenantID,
+		DBName:          pool.dbName,
+		Driver:          pool.driver,
+		Host:            pool.host,
+		PlanType:        pool.planType,
+		MaxOpen:         pool.maxOpen,
+		OpenConnections: st.OpenConnections,
+		InUse:           st.InUse,
+		Idle:            st.Idle,
+		WaitCount:       st.WaitCount,
+		WaitDuration:    st.WaitDuration.String(),
+		CreatedAt:       pool.createdAt,
+		LastUsed:        pool.lastUsedAt(),
+	}
+}
+
+// connectionFor resolves the driver and server for a tenant. Shared tenants
+// always live on the default server; dedicated tenants may override it.
+func (tm *TenantDBManager) connectionFor(tenant *models.Tenant) (DBDriver, DBConnInfo, error) {
+	driverName := tm.config.DBDriver
+	conn := tm.config.DefaultDBConn()
+
+	if tenant.DatabaseType == models.DedicatedDB {
+		src := tenant
+		// Credentials are not serialized into the tenant cache, so reload them.
+		if tenant.DBServerID == nil && tenant.DBUser == "" && tenant.DBPassword == "" && MasterDB != nil {
+			var stored models.Tenant
+			if err := MasterDB.Select("id", "db_server_id", "db_driver", "db_host", "db_port", "db_user", "db_password").
+				First(&stored, tenant.ID).Error; err == nil {
+				src = &stored
+			}
+		}
+
+		if src.DBServerID != nil {
+			server, err := loadDatabaseServer(*src.DBServerID)
+			if err != nil {
+				return nil, conn, err
+			}
+			return ServerConn(server)
+		}
+
+		if src.DBDriver != "" && src.DBDriver != driverName {
+			driverName = src.DBDriver
+			conn.Port = 0
+		}
+		if src.DBHost != "" {
+			conn.Host = src.DBHost
+		}
+		if src.DBPort != 0 {
+			conn.Port = src.DBPort
+		}
+		if src.DBUser != "" {
+			conn.User = src.DBUser
+			conn.Password = src.DBPassword
+		}
+	}
+
+	driver, err := GetDBDriver(driverName)
+	if err != nil {
+		return nil, conn, err
+	}
+	if conn.Port == 0 {
+		conn.Port = driver.DefaultPort()
+	}
+	return driver, conn, nil
+}
+
+func migrationKey(driver DBDriver, conn DBConnInfo, dbName string) string {
+	return fmt.Sprintf("%s/%s:%d/%s", driver.Name(), conn.Host, conn.Port, dbName)
+}
+
+// Helper: Database Creation
+func (tm *TenantDBManager) CreateDedicatedDatabase(tenant *models.Tenant) error {
+	driver, conn, err := tm.connectionFor(tenant)
+	if err != nil {
+		return err
+	}
+	return driver.CreateDatabase(conn, tenant.DBName)
+}
+
+func (tm *TenantDBManager) CreateSharedDatabase() error {
+	driver, err := GetDBDriver(tm.config.DBDriver)
+	if err != nil {
+		return err
+	}
+	return driver.CreateDatabase(tm.config.DefaultDBConn(), "shared_tenants_db")
+}
+
+func (tm *TenantDBManager) DropDedicatedDatabase(tenant *models.Tenant) error {
+	dbName := tenant.GetActualDBName()
+	if tenant.DatabaseType != models.DedicatedDB || dbName == "master_db" {
+		return fmt.Errorf("refusing to drop database %s", dbName)
+	}
+	driver, conn, err := tm.connectionFor(tenant)
+	if err != nil {
+		return err
+	}
+	tm.Evict(tenant.ID)
+
+	if err := driver.DropDatabase(conn, dbName); err != nil {
+		return err
+	}
+
+	tm.mutex.Lock()
+	delete(tm.migrated, migrationKey(driver, conn, dbName))
+	tm.mutex.Unlock()
+	return nil
 }
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrCrossTenantWrite = errors.New("refusing to write a row that belongs to another tenant")
+
+type tenantScopeKey struct{}
+
+// ScopeToTenant returns a handle whose queries, updates and deletes on models
+// with a TenantID column are filtered to tenantID, and whose creates stamp
+// TenantID automatically. The scope survives chaining and transactions.
+//
+// Two things are not covered:
+//   - The many2many join tables user_roles and role_permissions have no tenant
+//     column. They are reached only through a scoped user or role: preloading
+//     filters the roles on the other side, and appending another tenant's
+//     loaded role fails with ErrCrossTenantWrite. Permissions are shared by
+//     all tenants.
+//   - Raw and Exec SQL is passed through untouched and must filter by tenant
+//     itself.
+func ScopeToTenant(db *gorm.DB, tenantID uint) *gorm.DB {
+	return db.WithContext(context.WithValue(db.Statement.Context, tenantScopeKey{}, tenantID))
+}
+
+// WithoutTenantScope lifts the automatic tenant filter. Use it only in code that
+// deliberately works across tenants (super-admin tooling, background jobs) and
+// filters by tenant itself.
+func WithoutTenantScope(db *gorm.DB) *gorm.DB {
+	return db.WithContext(context.WithValue(db.Statement.Context, tenantScopeKey{}, uint(0)))
+}
+
+// TenantScopeOf reports the tenant a handle is scoped to, if any.
+func TenantScopeOf(db *gorm.DB) (uint, bool) {
+	if db.Statement == nil || db.Statement.Context == nil {
+		return 0, false
+	}
+	tenantID, ok := db.Statement.Context.Value(tenantScopeKey{}).(uint)
+	return tenantID, ok && tenantID != 0
+}
+
+func registerTenantScope(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Query().Before("gorm:query").Register("tenant_scope:query", addTenantCondition); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").Register("tenant_scope:row", addTenantCondition); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register("tenant_scope:update", addTenantCondition); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register("tenant_scope:update_tenant", checkTenantOnUpdate); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:delete").Register("tenant_scope:delete", addTenantCondition); err != nil {
+		return err
+	}
+	return cb.Create().Before("gorm:create").Register("tenant_scope:create", setTenantOnCreate)
+}
+
+func tenantColumn(db *gorm.DB) (uint, string, bool) {
+	tenantID, ok := TenantScopeOf(db)
+	if !ok || db.Statement.Schema == nil {
+		return 0, "", false
+	}
+	field := db.Statement.Schema.LookUpField("TenantID")
+	if field == nil || field.DBName == "" {
+		return 0, "", false
+	}
+	return tenantID, field.DBName, true
+}
+
+func addTenantCondition(db *gorm.DB) {
+	// Raw SQL is passed through untouched; callers of Raw/Exec filter themselves.
+	if db.Error != nil || db.Statement.SQL.Len() > 0 {
+		return
+	}
+	tenantID, column, ok := tenantColumn(db)
+	if !ok {
+		return
+	}
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: column}, Value: tenantID},
+	}})
+}
+
+// checkTenantOnUpdate refuses updates that would move rows to another tenant.
+// A full save of a row without a tenant gets the scoped tenant, as on create.
+func checkTenantOnUpdate(db *gorm.DB) {
+	if db.Error != nil || db.Statement.SQL.Len() > 0 {
+		return
+	}
+	tenantID, column, ok := tenantColumn(db)
+	if !ok {
+		return
+	}
+
+	if values, ok := db.Statement.Dest.(map[string]interface{}); ok {
+		for key, value := range values {
+			if f := db.Statement.Schema.LookUpField(key); f == nil || f.DBName != column {
+				continue
+			}
+			if !isTenant(value, tenantID) {
+				db.AddError(ErrCrossTenantWrite)
+				return
+			}
+		}
+		return
+	}
+
+	rv := db.Statement.ReflectValue
+	if rv.Kind() != reflect.Struct {
+		return
+	}
+	field := db.Statement.Schema.LookUpField("TenantID")
+	ctx := db.Statement.Context
+	value, zero := field.ValueOf(ctx, rv)
+	if zero {
+		if slices.Contains(db.Statement.Selects, "*") {
+			if err := field.Set(ctx, rv, tenantID); err != nil {
+				db.AddError(err)
+			}
+		}
+		return
+	}
+	if !isTenant(value, tenantID) {
+		db.AddError(ErrCrossTenantWrite)
+	}
+}
+
+// isTenant reports whether an update value is tenantID. Expressions and other
+// values that cannot be checked count as a different tenant.
+func isTenant(value interface{}, tenantID uint) bool {
+	v := reflect.Indirect(reflect.ValueOf(value))
+	switch {
+	case v.CanUint():
+		return v.Uint() == uint64(tenantID)
+	case v.CanInt():
+		return v.Int() == int64(tenantID)
+	}
+	return false
+}
+
+func setTenantOnCreate(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+	tenantID, column, ok := tenantColumn(db)
+	if !ok {
+		return
+	}
+	field := db.Statement.Schema.LookUpField("TenantID")
+	ctx := db.Statement.Context
+
+	// An upsert (Save falls back to one) must not take over another tenant's row.
+	if c, ok := db.Statement.Clauses["ON CONFLICT"]; ok {
+		if onConflict, ok := c.Expression.(clause.OnConflict); ok && !onConflict.DoNothing {
+			onConflict.Where.Exprs = append(onConflict.Where.Exprs, clause.Eq{
+				Column: clause.Column{Table: db.Statement.Table, Name: column}, Value: tenantID,
+			})
+			db.Statement.AddClause(onConflict)
+		}
+	}
+
+	stamp := func(row reflect.Value) {
+		if reflect.Indirect(row).Kind() != reflect.Struct {
+			return
+		}
+		value, zero := field.ValueOf(ctx, row)
+		if zero {
+			if err := field.Set(ctx, row, tenantID); err != nil {
+				db.AddError(err)
+			}
+			return
+		}
+		if current, ok := value.(uint); ok && current != tenantID {
+			db.AddError(ErrCrossTenantWrite)
+		}
+	}
+
+	rv := db.Statement.ReflectValue
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			stamp(rv.Index(i))
+		}
+	case reflect.Struct:
+		stamp(rv)
+	}
+}
@@ -0,0 +1,333 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"go-multi-tenant/models"
+	"reflect"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// tenantModels builds one row of every model stored in tenant databases. The
+// row for (tenantID, n) must not collide with any other on unique indexes.
+var tenantModels = []struct {
+	name string
+	row  func(tenantID uint, n int) interface{}
+}{
+	{"User", func(tenantID uint, n int) interface{} {
+		return &models.User{TenantID: tenantID, Username: fmt.Sprintf("user%d", n), Email: fmt.Sprintf("user%d@t%d.test", n, tenantID), Password: "x"}
+	}},
+	{"Role", func(tenantID uint, n int) interface{} {
+		return &models.Role{TenantID: tenantID, Name: fmt.Sprintf("role%d", n)}
+	}},
+	{"Notification", func(tenantID uint, n int) interface{} {
+		return &models.Notification{TenantID: tenantID, UserID: uint(n), Type: "test", Title: "t"}
+	}},
+	{"NotificationPreference", func(tenantID uint, n int) interface{} {
+		return &models.NotificationPreference{TenantID: tenantID, UserID: uint(n), Type: "test"}
+	}},
+	{"Category", func(tenantID uint, n int) interface{} {
+		return &models.Category{TenantID: tenantID, Name: fmt.Sprintf("cat%d", n)}
+	}},
+	{"Product", func(tenantID uint, n int) interface{} {
+		return &models.Product{TenantID: tenantID, Name: "p", SKU: fmt.Sprintf("sku%d", n)}
+	}},
+	{"Inventory", func(tenantID uint, n int) interface{} {
+		return &models.Inventory{TenantID: tenantID, ProductID: uint(tenantID)*1000 + uint(n)}
+	}},
+	{"PurchaseOrder", func(tenantID uint, n int) interface{} {
+		return &models.PurchaseOrder{TenantID: tenantID, ProductID: uint(n), Quantity: 1, BuyPrice: 1}
+	}},
+}
+
+func openScopeTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1) // Every connection to :memory: is a new database
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := registerTenantScope(db); err != nil {
+		t.Fatalf("register tenant scope: %v", err)
+	}
+	if err := migrateTenantDB(db, "tenant_test"); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+// seedTwoTenants stores two rows of the model for tenant 1 and one for tenant 2.
+func seedTwoTenants(t *testing.T, db *gorm.DB, row func(uint, int) interface{}) {
+	t.Helper()
+	for _, r := range []interface{}{row(1, 1), row(1, 2), row(2, 3)} {
+		if err := db.Create(r).Error; err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+}
+
+func newModel(row interface{}) interface{} {
+	return reflect.New(reflect.TypeOf(row).Elem()).Interface()
+}
+
+func countByTenant(t *testing.T, db *gorm.DB, model interface{}) map[uint]int64 {
+	t.Helper()
+	var rows []struct {
+		TenantID uint
+		Count    int64
+	}
+	if err := WithoutTenantScope(db).Model(model).
+		Select("tenant_id, COUNT(*) AS count").Group("tenant_id").Scan(&rows).Error; err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	counts := make(map[uint]int64)
+	for _, r := range rows {
+		counts[r.TenantID] = r.Count
+	}
+	return counts
+}
+
+func TestTenantScopeQuery(t *testing.T) {
+	for _, m := range tenantModels {
+		t.Run(m.name, func(t *testing.T) {
+			db := openScopeTestDB(t)
+			seedTwoTenants(t, db, m.row)
+			scoped := ScopeToTenant(db, 1)
+			model := newModel(m.row(0, 0))
+
+			var count int64
+			if err := scoped.Model(model).Count(&count).Error; err != nil {
+				t.Fatal(err)
+			}
+			if count != 2 {
+				t.Errorf("Count = %d, want 2", count)
+			}
+
+			var tenants []uint
+			if err := scoped.Model(model).Pluck("tenant_id", &tenants).Error; err != nil {
+				t.Fatal(err)
+			}
+			for _, id := range tenants {
+				if id != 1 {
+					t.Errorf("query returned a row of tenant %d", id)
+				}
+			}
+
+			// Tenant 2's row is not found even by primary key.
+			var other struct{ ID uint }
+			WithoutTenantScope(db).Model(model).Where("tenant_id = ?", 2).Select("id").Scan(&other)
+			err := scoped.First(newModel(m.row(0, 0)), other.ID).Error
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				t.Errorf("First of another tenant's row: err = %v, want record not found", err)
+			}
+
+			var unscoped int64
+			WithoutTenantScope(db).Model(model).Count(&unscoped)
+			if unscoped != 3 {
+				t.Errorf("WithoutTenantScope Count = %d, want 3", unscoped)
+			}
+		})
+	}
+}
+
+func TestTenantScopeUpdate(t *testing.T) {
+	for _, m := range tenantModels {
+		t.Run(m.name, func(t *testing.T) {
+			db := openScopeTestDB(t)
+			seedTwoTenants(t, db, m.row)
+			model := newModel(m.row(0, 0))
+
+			scoped := ScopeToTenant(db, 1)
+			res := scoped.Model(model).Where("1 = 1").Update("tenant_id", 3)
+			if !errors.Is(res.Error, ErrCrossTenantWrite) {
+				t.Errorf("moving rows to another tenant: err = %v, want ErrCrossTenantWrite", res.Error)
+			}
+			if res.RowsAffected != 0 {
+				t.Errorf("RowsAffected = %d, want 0", res.RowsAffected)
+			}
+			res = scoped.Model(model).Where("1 = 1").Updates(map[string]interface{}{"tenant_id": uint(2)})
+			if !errors.Is(res.Error, ErrCrossTenantWrite) {
+				t.Errorf("moving rows with Updates: err = %v, want ErrCrossTenantWrite", res.Error)
+			}
+			counts := countByTenant(t, db, model)
+			if counts[1] != 2 || counts[2] != 1 || counts[3] != 0 {
+				t.Errorf("rows by tenant after refused updates = %v, want none moved", counts)
+			}
+
+			res = scoped.Model(model).Where("1 = 1").Update("tenant_id", 1)
+			if res.Error != nil {
+				t.Fatal(res.Error)
+			}
+			if res.RowsAffected != 2 {
+				t.Errorf("RowsAffected = %d, want 2", res.RowsAffected)
+			}
+		})
+	}
+}
+
+// Saving a row loaded by another tenant must not move it either.
+func TestTenantScopeSave(t *testing.T) {
+	db := openScopeTestDB(t)
+	seedTwoTenants(t, db, tenantModels[0].row)
+
+	var other models.User
+	if err := WithoutTenantScope(db).Where("tenant_id = ?", 2).First(&other).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := ScopeToTenant(db, 1).Save(&other).Error; !errors.Is(err, ErrCrossTenantWrite) {
+		t.Errorf("save of another tenant's row: err = %v, want ErrCrossTenantWrite", err)
+	}
+	other.TenantID = 0
+	if err := ScopeToTenant(db, 1).Save(&other).Error; err != nil {
+		t.Fatal(err)
+	}
+	counts := countByTenant(t, db, &models.User{})
+	if counts[1] != 2 || counts[2] != 1 {
+		t.Errorf("rows by tenant after saves = %v, want none moved", counts)
+	}
+
+	var own models.User
+	if err := ScopeToTenant(db, 1).First(&own).Error; err != nil {
+		t.Fatal(err)
+	}
+	own.TenantID = 0
+	own.Username = "renamed"
+	if err := ScopeToTenant(db, 1).Save(&own).Error; err != nil {
+		t.Fatal(err)
+	}
+	var saved models.User
+	if err := WithoutTenantScope(db).First(&saved, own.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if saved.TenantID != 1 || saved.Username != "renamed" {
+		t.Errorf("saved own row = tenant %d, username %q; want tenant 1, renamed", saved.TenantID, saved.Username)
+	}
+}
+
+func TestTenantScopeDelete(t *testing.T) {
+	for _, m := range tenantModels {
+		t.Run(m.name, func(t *testing.T) {
+			db := openScopeTestDB(t)
+			seedTwoTenants(t, db, m.row)
+			model := newModel(m.row(0, 0))
+
+			res := ScopeToTenant(db, 1).Unscoped().Where("1 = 1").Delete(model)
+			if res.Error != nil {
+				t.Fatal(res.Error)
+			}
+			if res.RowsAffected != 2 {
+				t.Errorf("RowsAffected = %d, want 2", res.RowsAffected)
+			}
+			counts := countByTenant(t, db, model)
+			if counts[1] != 0 || counts[2] != 1 {
+				t.Errorf("rows by tenant after delete = %v, want only tenant 2's left", counts)
+			}
+		})
+	}
+}
+
+func TestTenantScopeCreate(t *testing.T) {
+	for _, m := range tenantModels {
+		t.Run(m.name, func(t *testing.T) {
+			db := openScopeTestDB(t)
+			scoped := ScopeToTenant(db, 1)
+
+			row := m.row(0, 1)
+			if err := scoped.Create(row).Error; err != nil {
+				t.Fatalf("create without tenant: %v", err)
+			}
+			if got := reflect.ValueOf(row).Elem().FieldByName("TenantID").Uint(); got != 1 {
+				t.Errorf("TenantID = %d, want it stamped to 1", got)
+			}
+
+			if err := scoped.Create(m.row(2, 2)).Error; !errors.Is(err, ErrCrossTenantWrite) {
+				t.Errorf("create for another tenant: err = %v, want ErrCrossTenantWrite", err)
+			}
+
+			batch := reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(row)), 0, 2)
+			batch = reflect.Append(batch, reflect.ValueOf(m.row(1, 3)), reflect.ValueOf(m.row(2, 4)))
+			if err := scoped.Create(batch.Interface()).Error; !errors.Is(err, ErrCrossTenantWrite) {
+				t.Errorf("batch with another tenant's row: err = %v, want ErrCrossTenantWrite", err)
+			}
+
+			counts := countByTenant(t, db, newModel(row))
+			if counts[1] != 1 || counts[2] != 0 {
+				t.Errorf("rows by tenant after creates = %v, want only the stamped row", counts)
+			}
+		})
+	}
+}
+
+func TestTenantScopeTransaction(t *testing.T) {
+	db := openScopeTestDB(t)
+	seedTwoTenants(t, db, tenantModels[0].row)
+
+	err := ScopeToTenant(db, 1).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.User{}).Count(&count).Error; err != nil {
+			return err
+		}
+		if count != 2 {
+			t.Errorf("Count in transaction = %d, want 2", count)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The join tables user_roles and role_permissions have no tenant column; they
+// are protected through the roles they point at.
+func TestTenantScopeJoinTables(t *testing.T) {
+	db := openScopeTestDB(t)
+	user := &models.User{TenantID: 1, Username: "u", Email: "u@t1.test", Password: "x"}
+	own := &models.Role{TenantID: 1, Name: "own"}
+	foreign := &models.Role{TenantID: 2, Name: "foreign"}
+	for _, r := range []interface{}{user, own, foreign} {
+		if err := db.Create(r).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	scoped := ScopeToTenant(db, 1)
+
+	if err := scoped.Model(user).Association("Roles").Append(own); err != nil {
+		t.Fatalf("append own role: %v", err)
+	}
+	if err := scoped.Model(user).Association("Roles").Append(foreign); !errors.Is(err, ErrCrossTenantWrite) {
+		t.Errorf("append another tenant's role: err = %v, want ErrCrossTenantWrite", err)
+	}
+
+	// A join row written around the scope still does not leak the role.
+	if err := db.Exec("INSERT INTO user_roles (user_id, role_id) VALUES (?, ?)", user.ID, foreign.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	var loaded models.User
+	if err := scoped.Preload("Roles").First(&loaded, user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Roles) != 1 || loaded.Roles[0].ID != own.ID {
+		t.Errorf("preloaded roles = %+v, want only the tenant's own role", loaded.Roles)
+	}
+}
+
+// Raw SQL is not filtered; callers of Raw and Exec filter by tenant themselves.
+func TestTenantScopeRawSQLIsNotFiltered(t *testing.T) {
+	db := openScopeTestDB(t)
+	seedTwoTenants(t, db, tenantModels[0].row)
+
+	var count int64
+	if err := ScopeToTenant(db, 1).Raw("SELECT COUNT(*) FROM users").Scan(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("raw Count = %d, want 3 (unfiltered)", count)
+	}
+}
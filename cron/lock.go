@@ -0,0 +1,117 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"go-multi-tenant/models"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// Lock hands out named leases. Acquire succeeds when the lease is free,
+// expired, or already held by holder, in which case it is renewed.
+type Lock interface {
+	Name() string
+	Acquire(name, holder string, ttl time.Duration) (bool, error)
+	Release(name, holder string) error
+	// Holder returns who holds a live lease, or "" when it is free.
+	Holder(name string) (string, error)
+}
+
+// acquireScript takes or renews a lease in one round trip.
+var acquireScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if current == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return 1
+end
+if current then return 0 end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return 1
+`)
+
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+type redisLock struct {
+	client *redis.Client
+	ctx    context.Context
+}
+
+func NewRedisLock(ctx context.Context, client *redis.Client) Lock {
+	return &redisLock{client: client, ctx: ctx}
+}
+
+func (l *redisLock) Name() string { return "redis" }
+
+func (l *redisLock) Acquire(name, holder string, ttl time.Duration) (bool, error) {
+	n, err := acquireScript.Run(l.ctx, l.client, []string{"lock:" + name}, holder, ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+func (l *redisLock) Release(name, holder string) error {
+	return releaseScript.Run(l.ctx, l.client, []string{"lock:" + name}, holder).Err()
+}
+
+func (l *redisLock) Holder(name string) (string, error) {
+	holder, err := l.client.Get(l.ctx, "lock:"+name).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return holder, err
+}
+
+type databaseLock struct {
+	db *gorm.DB
+}
+
+func NewDatabaseLock(db *gorm.DB) Lock {
+	return &databaseLock{db: db}
+}
+
+func (l *databaseLock) Name() string { return "database" }
+
+func (l *databaseLock) Acquire(name, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	res := l.db.Model(&models.SchedulerLock{}).
+		Where("name = ? AND (holder = ? OR expires_at < ?)", name, holder, now).
+		Updates(map[string]interface{}{"holder": holder, "expires_at": now.Add(ttl)})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	if res.RowsAffected > 0 {
+		return true, nil
+	}
+
+	// No row yet, or another holder's lease is live. The primary key makes
+	// concurrent inserts safe.
+	err := l.db.Create(&models.SchedulerLock{Name: name, Holder: holder, ExpiresAt: now.Add(ttl)}).Error
+	if err == nil {
+		return true, nil
+	}
+	var current models.SchedulerLock
+	if findErr := l.db.Where("name = ?", name).First(&current).Error; findErr != nil {
+		if errors.Is(findErr, gorm.ErrRecordNotFound) {
+			return false, err
+		}
+		return false, findErr
+	}
+	// Some databases report no affected rows when a renewal changes nothing.
+	return current.Holder == holder && current.ExpiresAt.After(now), nil
+}
+
+func (l *databaseLock) Release(name, holder string) error {
+	return l.db.Where("name = ? AND holder = ?", name, holder).Delete(&models.SchedulerLock{}).Error
+}
+
+func (l *databaseLock) Holder(name string) (string, error) {
+	var current models.SchedulerLock
+	err := l.db.Where("name = ? AND expires_at > ?", name, time.Now()).Limit(1).Find(&current).Error
+	return current.Holder, err
+}
@@ -0,0 +1,163 @@
+package cron
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // Daylight-saving cases must not depend on the host's zoneinfo
+)
+
+func TestSpecScheduleNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(layout string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", layout, newYork)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	// In New York clocks go forward at 02:00 on 8 March 2026 and back at 02:00
+	// on 1 November 2026; the repeated hour is told apart by UTC offset.
+	firstOneThirty := time.Date(2026, 11, 1, 5, 30, 0, 0, time.UTC).In(newYork)
+	secondOneOClock := time.Date(2026, 11, 1, 6, 0, 0, 0, time.UTC).In(newYork)
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time // Zero when the schedule never fires
+	}{
+		{"next minute", "* * * * *", at("2026-01-01 10:00"), at("2026-01-01 10:01")},
+		{"seconds are dropped", "* * * * *", at("2026-01-01 10:00").Add(59 * time.Second), at("2026-01-01 10:01")},
+		{"later today", "30 14 * * *", at("2026-01-01 10:00"), at("2026-01-01 14:30")},
+		{"tomorrow", "30 9 * * *", at("2026-01-01 10:00"), at("2026-01-02 09:30")},
+		{"step", "*/15 * * * *", at("2026-01-01 10:07"), at("2026-01-01 10:15")},
+		{"list and range", "0 9-17/4,20 * * *", at("2026-01-01 13:00"), at("2026-01-01 17:00")},
+		{"month names", "0 0 1 jun,dec *", at("2026-07-01 00:00"), at("2026-12-01 00:00")},
+		{"next year", "0 0 1 1 *", at("2026-01-01 00:00"), at("2027-01-01 00:00")},
+		{"leap day", "0 0 29 2 *", at("2026-01-01 00:00"), at("2028-02-29 00:00")},
+		{"31st skips short months", "0 0 31 * *", at("2026-04-01 00:00"), at("2026-05-31 00:00")},
+		{"impossible date", "0 0 30 2 *", at("2026-01-01 00:00"), time.Time{}},
+		{"impossible 31 April", "0 0 31 4 *", at("2026-01-01 00:00"), time.Time{}},
+
+		// Either day field may match when both are restricted...
+		{"day of month or week, week first", "0 0 13 * 5", at("2026-01-10 00:00"), at("2026-01-13 00:00")},
+		{"day of month or week, month first", "0 0 13 * 5", at("2026-01-13 00:00"), at("2026-01-16 00:00")},
+		// ...but a wildcard in one leaves only the other.
+		{"day of week only", "0 0 * * 1", at("2026-01-01 00:00"), at("2026-01-05 00:00")},
+		{"day of month only", "0 0 5 * *", at("2026-01-01 00:00"), at("2026-01-05 00:00")},
+		{"stepped day of month counts as restricted", "0 0 */10 * 1", at("2026-01-01 00:00"), at("2026-01-05 00:00")},
+
+		{"7 is Sunday", "0 0 * * 7", at("2026-01-01 00:00"), at("2026-01-04 00:00")},
+		{"0 is Sunday", "0 0 * * 0", at("2026-01-01 00:00"), at("2026-01-04 00:00")},
+		{"range ending on 7", "0 0 * * 6-7", at("2026-01-04 00:00"), at("2026-01-10 00:00")},
+		{"sun name", "0 0 * * sun", at("2026-01-01 00:00"), at("2026-01-04 00:00")},
+
+		// 02:30 does not exist on 8 March; the job next runs the day after.
+		{"spring forward skips the missing time", "30 2 * * *", at("2026-03-08 00:00"), at("2026-03-09 02:30")},
+		{"spring forward hourly", "0 * * * *", at("2026-03-08 01:30"), at("2026-03-08 03:00")},
+		{"spring forward after the gap", "30 3 * * *", at("2026-03-08 00:00"), at("2026-03-08 03:30")},
+		// 01:30 happens twice on 1 November; a job pinned to it runs once.
+		{"fall back runs in the first pass", "30 1 * * *", at("2026-11-01 00:00"), firstOneThirty},
+		{"fall back skips the repeat", "30 1 * * *", firstOneThirty, at("2026-11-02 01:30")},
+		{"fall back wildcard hour repeats", "0 * * * *", firstOneThirty, secondOneOClock},
+		{"fall back after the repeat", "0 2 * * *", firstOneThirty, at("2026-11-01 02:00")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.expr, err)
+			}
+			got := schedule.Next(tt.from)
+			if !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from.Format(time.RFC3339), got.Format(time.RFC3339), tt.want.Format(time.RFC3339))
+			}
+			if !got.IsZero() && got.Location() != tt.from.Location() {
+				t.Errorf("Next returned %s, want the location of its argument", got.Location())
+			}
+		})
+	}
+}
+
+func TestEveryScheduleNext(t *testing.T) {
+	from := time.Date(2026, 3, 8, 6, 59, 30, 500, time.UTC)
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"@every 1m", time.Date(2026, 3, 8, 7, 0, 30, 0, time.UTC)},
+		{"@every 90m", time.Date(2026, 3, 8, 8, 29, 30, 0, time.UTC)},
+		{"@every 1h30m", time.Date(2026, 3, 8, 8, 29, 30, 0, time.UTC)},
+		{"@every 24h", time.Date(2026, 3, 9, 6, 59, 30, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			schedule, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := schedule.Next(from); !got.Equal(tt.want) {
+				t.Errorf("Next = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{"* * * * *", false},
+		{"  0 0 * * *  ", false},
+		{"@daily", false},
+		{"@HOURLY", false},
+		{"@every 5m", false},
+		{"0 0 * * 7", false},
+		{"0 0 * JAN-MAR MON-FRI", false},
+
+		{"", true},
+		{"* * * *", true},
+		{"* * * * * *", true},
+		{"60 * * * *", true},
+		{"* 24 * * *", true},
+		{"* * 0 * *", true},
+		{"* * 32 * *", true},
+		{"* * * 13 *", true},
+		{"* * * * 8", true},
+		{"5-1 * * * *", true},
+		{"*/0 * * * *", true},
+		{"*/x * * * *", true},
+		{"a * * * *", true},
+		{"* * * foo *", true},
+		{"@weekdays", true},
+		{"@every 30s", true},
+		{"@every soon", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := Parse(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse(%q) error = %v, want error: %v", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Descriptors are shorthands for their five-field forms.
+func TestParseDescriptors(t *testing.T) {
+	from := time.Date(2026, 5, 13, 10, 20, 0, 0, time.UTC)
+	for name, std := range descriptors {
+		a, err := Parse(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := Parse(std)
+		if got, want := a.Next(from), b.Next(from); !got.Equal(want) {
+			t.Errorf("%s next = %s, want %s as for %q", name, got, want, std)
+		}
+	}
+}
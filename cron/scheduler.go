@@ -0,0 +1,374 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-multi-tenant/models"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	leaderLock = "scheduler:leader"
+	// The leader renews its lease every tick; it must outlive a few missed ticks.
+	leaderTTL = 45 * time.Second
+	tick      = 15 * time.Second
+	// A job's run lock is renewed while it runs, so this only bounds how long
+	// a crashed instance keeps the job blocked.
+	runLockTTL = 2 * time.Minute
+)
+
+// JobFunc runs a scheduled job and returns a short summary for the run history.
+type JobFunc func(ctx context.Context) (string, error)
+
+type entry struct {
+	name        string
+	spec        string
+	description string
+	schedule    Schedule
+	fn          JobFunc
+}
+
+// JobInfo describes a registered job for the admin API.
+type JobInfo struct {
+	Name        string          `json:"name"`
+	Schedule    string          `json:"schedule"`
+	Description string          `json:"description"`
+	NextRun     time.Time       `json:"next_run"`
+	LastRun     *models.CronRun `json:"last_run,omitempty"`
+}
+
+// Scheduler runs registered jobs on their schedules. Every instance runs a
+// Scheduler but only the one holding the leader lease fires scheduled runs;
+// a per-job lock keeps manual and scheduled runs of a job from overlapping.
+type Scheduler struct {
+	db       *gorm.DB
+	lock     Lock
+	instance string
+	location *time.Location
+
+	mu      sync.RWMutex
+	entries map[string]*entry
+	next    map[string]time.Time // Owned by the loop goroutine
+
+	leader atomic.Bool
+	stop   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	loopWG sync.WaitGroup
+	runWG  sync.WaitGroup
+}
+
+// New creates a scheduler that reads schedules in loc.
+func New(db *gorm.DB, lock Lock, loc *time.Location) *Scheduler {
+	host, _ := os.Hostname()
+	ctx, cancel := context.WithCancel(context.Background())
+	if loc == nil {
+		loc = time.UTC
+	}
+	return &Scheduler{
+		db:       db,
+		lock:     lock,
+		instance: fmt.Sprintf("%s:%d", host, os.Getpid()),
+		location: loc,
+		entries:  map[string]*entry{},
+		next:     map[string]time.Time{},
+		stop:     make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+func (s *Scheduler) Register(name, spec, description string, fn JobFunc) error {
+	schedule, err := Parse(spec)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", name, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[name] = &entry{name: name, spec: spec, description: description, schedule: schedule, fn: fn}
+	return nil
+}
+
+func (s *Scheduler) entry(name string) *entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.entries[name]
+}
+
+func (s *Scheduler) IsLeader() bool { return s.leader.Load() }
+
+func (s *Scheduler) Instance() string { return s.instance }
+
+func (s *Scheduler) LockName() string { return s.lock.Name() }
+
+// Leader returns the instance currently holding the leader lease.
+func (s *Scheduler) Leader() (string, error) { return s.lock.Holder(leaderLock) }
+
+func (s *Scheduler) Start() {
+	s.loopWG.Add(1)
+	go s.loop()
+	log.Printf("Scheduler: started on %s with %d jobs, using the %s lock", s.instance, len(s.entries), s.lock.Name())
+}
+
+// Stop gives up leadership and waits for running jobs until ctx expires, then
+// cancels them.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	close(s.stop)
+	s.loopWG.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		s.runWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			log.Println("Scheduler: some jobs ignored cancellation")
+		}
+		return ctx.Err()
+	}
+}
+
+func (s *Scheduler) loop() {
+	defer s.loopWG.Done()
+	defer func() {
+		if s.leader.Load() {
+			_ = s.lock.Release(leaderLock, s.instance)
+		}
+	}()
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		s.elect()
+		if s.leader.Load() {
+			s.fireDue(time.Now().In(s.location))
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) elect() {
+	ok, err := s.lock.Acquire(leaderLock, s.instance, leaderTTL)
+	if err != nil {
+		log.Printf("Scheduler: leader election failed: %v", err)
+		ok = false
+	}
+	was := s.leader.Swap(ok)
+	switch {
+	case ok && !was:
+		log.Printf("Scheduler: %s is now the leader", s.instance)
+		s.next = map[string]time.Time{}
+	case !ok && was:
+		log.Printf("Scheduler: %s lost leadership", s.instance)
+	}
+}
+
+func (s *Scheduler) fireDue(now time.Time) {
+	s.mu.RLock()
+	entries := make([]*entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	s.mu.RUnlock()
+
+	for _, e := range entries {
+		next, ok := s.next[e.name]
+		if !ok {
+			next = s.firstSlot(e, now)
+		}
+		if now.Before(next) {
+			s.next[e.name] = next
+			continue
+		}
+
+		slot := next
+		s.next[e.name] = e.schedule.Next(now)
+		s.runWG.Add(1)
+		go func() {
+			defer s.runWG.Done()
+			s.execute(e, models.CronTriggerSchedule, &slot, 0, nil)
+		}()
+	}
+}
+
+// firstSlot is where a new leader starts: the slot after the job's last
+// scheduled run, so a slot missed during a failover still runs once.
+func (s *Scheduler) firstSlot(e *entry, now time.Time) time.Time {
+	var last models.CronRun
+	err := s.db.Where("job = ? AND scheduled_for IS NOT NULL", e.name).
+		Order("scheduled_for DESC").Limit(1).Find(&last).Error
+	if err != nil || last.ID == 0 {
+		return e.schedule.Next(now)
+	}
+	return e.schedule.Next(last.ScheduledFor.In(s.location))
+}
+
+// Trigger starts a manual run of a job in the background.
+func (s *Scheduler) Trigger(name string, userID uint) (*models.CronRun, error) {
+	e := s.entry(name)
+	if e == nil {
+		return nil, fmt.Errorf("unknown job %q", name)
+	}
+
+	started := make(chan *models.CronRun, 1)
+	s.runWG.Add(1)
+	go func() {
+		defer s.runWG.Done()
+		s.execute(e, models.CronTriggerManual, nil, userID, started)
+	}()
+
+	run := <-started
+	if run == nil {
+		return nil, errors.New("failed to start the run")
+	}
+	return run, nil
+}
+
+// execute records a run, takes the job's run lock and runs it. If started is
+// set it receives the run row once it is known, or nil.
+func (s *Scheduler) execute(e *entry, trigger string, slot *time.Time, userID uint, started chan<- *models.CronRun) {
+	notify := func(run *models.CronRun) {
+		if started == nil {
+			return
+		}
+		if run != nil {
+			// The run keeps being updated here; hand over a snapshot.
+			snapshot := *run
+			run = &snapshot
+		}
+		started <- run
+		started = nil
+	}
+	defer notify(nil)
+
+	run := &models.CronRun{
+		Job:          e.name,
+		ScheduledFor: slot,
+		Trigger:      trigger,
+		TriggeredBy:  userID,
+		Instance:     s.instance,
+		Status:       models.CronRunRunning,
+		StartedAt:    time.Now(),
+	}
+	if err := s.db.Create(run).Error; err != nil {
+		// For a scheduled run this is the slot's unique key: another instance
+		// already took it.
+		if slot == nil {
+			log.Printf("Scheduler: failed to record a run of %s: %v", e.name, err)
+		}
+		return
+	}
+
+	holder := fmt.Sprintf("%s#%d", s.instance, run.ID)
+	runLock := "scheduler:job:" + e.name
+	if ok, err := s.lock.Acquire(runLock, holder, runLockTTL); err != nil || !ok {
+		reason := "previous run still in progress"
+		if err != nil {
+			reason = "could not take the run lock: " + err.Error()
+		}
+		s.finish(run, models.CronRunSkipped, "", reason)
+		notify(run)
+		return
+	}
+	defer func() { _ = s.lock.Release(runLock, holder) }()
+	notify(run)
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	go s.holdLock(ctx, runLock, holder)
+
+	output, err := safeRun(ctx, e.fn)
+	if err != nil {
+		log.Printf("Scheduler: %s failed after %s: %v", e.name, time.Since(run.StartedAt).Round(time.Millisecond), err)
+		s.finish(run, models.CronRunFailed, output, err.Error())
+		return
+	}
+	s.finish(run, models.CronRunSucceeded, output, "")
+}
+
+func safeRun(ctx context.Context, fn JobFunc) (output string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return fn(ctx)
+}
+
+// holdLock renews a run lock until ctx ends.
+func (s *Scheduler) holdLock(ctx context.Context, name, holder string) {
+	ticker := time.NewTicker(runLockTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if ok, err := s.lock.Acquire(name, holder, runLockTTL); err != nil || !ok {
+				log.Printf("Scheduler: could not renew %s for %s: %v", name, holder, err)
+			}
+		}
+	}
+}
+
+func (s *Scheduler) finish(run *models.CronRun, status, output, errMsg string) {
+	now := time.Now()
+	run.Status = status
+	run.Output = output
+	run.Error = errMsg
+	run.FinishedAt = &now
+	run.DurationMs = now.Sub(run.StartedAt).Milliseconds()
+	if err := s.db.Model(&models.CronRun{}).Where("id = ?", run.ID).Updates(map[string]interface{}{
+		"status":      status,
+		"output":      output,
+		"error":       errMsg,
+		"finished_at": now,
+		"duration_ms": run.DurationMs,
+	}).Error; err != nil {
+		log.Printf("Scheduler: failed to record the outcome of run %d: %v", run.ID, err)
+	}
+}
+
+// Jobs lists the registered jobs with their next run and latest run.
+func (s *Scheduler) Jobs() []JobInfo {
+	s.mu.RLock()
+	entries := make([]*entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	s.mu.RUnlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	now := time.Now().In(s.location)
+	infos := make([]JobInfo, 0, len(entries))
+	for _, e := range entries {
+		info := JobInfo{Name: e.name, Schedule: e.spec, Description: e.description, NextRun: e.schedule.Next(now)}
+		var last models.CronRun
+		if err := s.db.Where("job = ?", e.name).Order("id DESC").Limit(1).Find(&last).Error; err == nil && last.ID != 0 {
+			info.LastRun = &last
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
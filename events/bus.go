@@ -0,0 +1,302 @@
+// Package events is an in-process bus for domain events. Services publish an
+// event once its change is committed; subscribers run in the publisher's
+// goroutine or on a worker pool, and may also receive events published on
+// other instances through a Transport.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Event is something that happened in a tenant. EventTenant is 0 for events
+// that concern the whole platform.
+type Event interface {
+	EventName() string
+	EventTenant() uint
+}
+
+// Transport carries events between instances.
+type Transport interface {
+	Name() string
+	Publish(ctx context.Context, msg []byte) error
+	// Listen calls deliver with every message published by any instance,
+	// including this one, until ctx is done.
+	Listen(ctx context.Context, deliver func(msg []byte)) error
+}
+
+type Options struct {
+	Workers   int // Goroutines running async subscribers
+	QueueSize int
+}
+
+type subscription struct {
+	async        bool
+	allInstances bool
+	call         func(ctx context.Context, event Event)
+}
+
+// Option configures a subscription.
+type Option func(*subscription)
+
+// Async runs the subscriber on the bus's worker pool instead of in the
+// publisher's goroutine. Use it for slow work such as I/O.
+func Async() Option {
+	return func(s *subscription) { s.async = true }
+}
+
+// AllInstances also delivers events published on other instances. Use it for
+// per-instance state such as connection pools or open streams; side effects
+// that must happen once, such as sending a webhook, should not set it.
+func AllInstances() Option {
+	return func(s *subscription) { s.allInstances = true }
+}
+
+type asyncCall struct {
+	sub   *subscription
+	event Event
+	ctx   context.Context
+}
+
+type Bus struct {
+	mu       sync.RWMutex
+	subs     map[string][]*subscription
+	decoders map[string]func([]byte) (Event, error)
+
+	instance  string
+	queue     chan asyncCall
+	wg        sync.WaitGroup
+	transport Transport
+	outgoing  chan []byte // Events waiting to be sent to other instances
+	cancel    context.CancelFunc
+	closed    bool
+}
+
+func New(opts Options) *Bus {
+	if opts.Workers <= 0 {
+		opts.Workers = 4
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1024
+	}
+	host, _ := os.Hostname()
+	b := &Bus{
+		subs:     make(map[string][]*subscription),
+		decoders: make(map[string]func([]byte) (Event, error)),
+		instance: fmt.Sprintf("%s:%d", host, os.Getpid()),
+		queue:    make(chan asyncCall, opts.QueueSize),
+	}
+	for i := 0; i < opts.Workers; i++ {
+		b.wg.Add(1)
+		go b.worker()
+	}
+	return b
+}
+
+// Subscribe registers handler for events of type T.
+func Subscribe[T Event](b *Bus, handler func(ctx context.Context, event T), opts ...Option) {
+	var zero T
+	name := zero.EventName()
+	sub := &subscription{call: func(ctx context.Context, event Event) {
+		if e, ok := event.(T); ok {
+			handler(ctx, e)
+		}
+	}}
+	for _, opt := range opts {
+		opt(sub)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[name] = append(b.subs[name], sub)
+	b.decoders[name] = func(data []byte) (Event, error) {
+		var e T
+		err := json.Unmarshal(data, &e)
+		return e, err
+	}
+}
+
+type envelope struct {
+	Name     string          `json:"name"`
+	Instance string          `json:"instance"`
+	Event    json.RawMessage `json:"event"`
+}
+
+// Publish delivers event to the synchronous subscribers before returning and
+// queues it for the async ones. With a transport connected, the event is
+// also sent to the other instances in the background.
+func (b *Bus) Publish(ctx context.Context, event Event) {
+	b.dispatch(ctx, event, false)
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.transport == nil {
+		return
+	}
+	data, err := json.Marshal(event)
+	if err == nil {
+		data, err = json.Marshal(envelope{Name: event.EventName(), Instance: b.instance, Event: data})
+	}
+	if err != nil {
+		log.Printf("Events: failed to encode %s: %v", event.EventName(), err)
+		return
+	}
+	select {
+	case b.outgoing <- data:
+	default:
+		log.Printf("Events: fan-out backlog full, %s not sent to other instances", event.EventName())
+	}
+}
+
+// dispatch calls the subscribers of event; remote events only go to those
+// that asked for all instances.
+func (b *Bus) dispatch(ctx context.Context, event Event, remote bool) {
+	b.mu.RLock()
+	subs := b.subs[event.EventName()]
+	b.mu.RUnlock()
+
+	for _, sub := range subs {
+		if remote && !sub.allInstances {
+			continue
+		}
+		if !sub.async {
+			b.call(ctx, sub, event)
+			continue
+		}
+		b.enqueue(asyncCall{sub: sub, event: event, ctx: context.WithoutCancel(ctx)})
+	}
+}
+
+func (b *Bus) enqueue(call asyncCall) {
+	// The read lock keeps Close from closing the queue under the send.
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if !b.closed {
+		select {
+		case b.queue <- call:
+			return
+		default:
+		}
+	}
+	// Never block the publisher on a backed-up pool, nor drop the event.
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.call(call.ctx, call.sub, call.event)
+	}()
+}
+
+// call runs one subscriber; a panicking subscriber must not take down the
+// publisher or the other subscribers.
+func (b *Bus) call(ctx context.Context, sub *subscription, event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Events: subscriber of %s panicked: %v\n%s", event.EventName(), r, debug.Stack())
+		}
+	}()
+	sub.call(ctx, event)
+}
+
+func (b *Bus) worker() {
+	defer b.wg.Done()
+	for call := range b.queue {
+		b.call(call.ctx, call.sub, call.event)
+	}
+}
+
+// Connect starts fanning events out through transport and receiving the
+// events other instances publish.
+func (b *Bus) Connect(transport Transport) {
+	ctx, cancel := context.WithCancel(context.Background())
+	outgoing := make(chan []byte, cap(b.queue))
+	b.mu.Lock()
+	b.transport = transport
+	b.outgoing = outgoing
+	b.cancel = cancel
+	b.mu.Unlock()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg := <-outgoing:
+				pubCtx, cancelPub := context.WithTimeout(ctx, 2*time.Second)
+				if err := transport.Publish(pubCtx, msg); err != nil {
+					log.Printf("Events: failed to send an event to other instances: %v", err)
+				}
+				cancelPub()
+			}
+		}
+	}()
+
+	go func() {
+		for ctx.Err() == nil {
+			err := transport.Listen(ctx, b.receive)
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Events: %s listener stopped, reconnecting: %v", transport.Name(), err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}()
+}
+
+func (b *Bus) receive(msg []byte) {
+	var env envelope
+	if err := json.Unmarshal(msg, &env); err != nil || env.Instance == b.instance {
+		return
+	}
+	b.mu.RLock()
+	decode, ok := b.decoders[env.Name]
+	b.mu.RUnlock()
+	if !ok {
+		return
+	}
+	event, err := decode(env.Event)
+	if err != nil {
+		log.Printf("Events: failed to decode %s from %s: %v", env.Name, env.Instance, err)
+		return
+	}
+	b.dispatch(context.Background(), event, true)
+}
+
+// Instance identifies this process to the other instances.
+func (b *Bus) Instance() string { return b.instance }
+
+// Close stops receiving remote events and waits until ctx expires for the
+// async subscribers to finish. Events published afterwards still reach
+// subscribers but are no longer sent to other instances.
+func (b *Bus) Close(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		b.transport = nil
+		if b.cancel != nil {
+			b.cancel()
+		}
+		close(b.queue)
+	}
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
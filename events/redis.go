@@ -0,0 +1,46 @@
+package events
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisTransport fans events out over a Redis pub/sub channel. Pub/sub is
+// fire-and-forget: an instance that is disconnected misses what is published
+// meanwhile, so only use AllInstances for state that can be rebuilt.
+type redisTransport struct {
+	client  *redis.Client
+	channel string
+}
+
+func NewRedisTransport(client *redis.Client, channel string) Transport {
+	return &redisTransport{client: client, channel: channel}
+}
+
+func (t *redisTransport) Name() string { return "redis" }
+
+func (t *redisTransport) Publish(ctx context.Context, msg []byte) error {
+	return t.client.Publish(ctx, t.channel, msg).Err()
+}
+
+func (t *redisTransport) Listen(ctx context.Context, deliver func(msg []byte)) error {
+	pubsub := t.client.Subscribe(ctx, t.channel)
+	defer pubsub.Close()
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return err
+	}
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			deliver([]byte(msg.Payload))
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"go-multi-tenant/models"
 	"go-multi-tenant/services"
 	"net/http"
 
@@ -26,7 +27,12 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": err.Error()})
 		return
 	}
-	user, token, err := h.authService.Login(input.Email, input.Password)
+	var hostTenantID uint
+	if hostTenant, exists := c.Get("hostTenant"); exists {
+		hostTenantID = hostTenant.(*models.Tenant).ID
+	}
+
+	user, token, err := h.authService.Login(input.Email, input.Password, hostTenantID)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
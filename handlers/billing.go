@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"errors"
+	"go-multi-tenant/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type BillingHandler struct {
+	billingService *services.BillingService
+}
+
+func NewBillingHandler(billingService *services.BillingService) *BillingHandler {
+	return &BillingHandler{billingService: billingService}
+}
+
+// ListInvoices serves both the tenant's own history and the admin view (/tenants/:id/invoices).
+func (h *BillingHandler) ListInvoices(c *gin.Context) {
+	invoices, err := h.billingService.ListInvoices(subscriptionTenantID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": invoices})
+}
+
+func (h *BillingHandler) GetInvoice(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	invoice, err := h.billingService.GetInvoice(tenantID, uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": invoice})
+}
+
+// PayInvoice lets a tenant admin pay an open invoice with the configured provider.
+func (h *BillingHandler) PayInvoice(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	var req struct {
+		PaymentMethod string `json:"payment_method" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	payment, err := h.billingService.PayInvoice(tenantID, uint(id), req.PaymentMethod)
+	respondPayment(c, payment, err)
+}
+
+// RecordPayment lets a super admin record a payment, e.g. a bank transfer with provider "manual".
+func (h *BillingHandler) RecordPayment(c *gin.Context) {
+	tenantID, _ := strconv.Atoi(c.Param("id"))
+	invoiceID, _ := strconv.Atoi(c.Param("invoice_id"))
+
+	var req struct {
+		Provider      string `json:"provider" binding:"required"`
+		PaymentMethod string `json:"payment_method"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	payment, err := h.billingService.RecordPayment(uint(tenantID), uint(invoiceID), req.Provider, req.PaymentMethod)
+	respondPayment(c, payment, err)
+}
+
+func respondPayment(c *gin.Context, payment interface{}, err error) {
+	if err != nil {
+		var declined *services.PaymentDeclinedError
+		if errors.As(err, &declined) {
+			c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error(), "data": declined.Payment})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Payment recorded", "data": payment})
+}
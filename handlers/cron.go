@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"go-multi-tenant/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CronHandler struct {
+	cronService *services.CronService
+}
+
+func NewCronHandler(cronService *services.CronService) *CronHandler {
+	return &CronHandler{cronService: cronService}
+}
+
+func (h *CronHandler) ListJobs(c *gin.Context) {
+	jobs, err := h.cronService.ListJobs()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	status, err := h.cronService.Status()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": jobs, "scheduler": status})
+}
+
+func (h *CronHandler) ListRuns(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	runs, total, err := h.cronService.ListRuns(c.Query("job"), c.Query("status"), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data":      runs,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+func (h *CronHandler) Trigger(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	run, err := h.cronService.Trigger(c.Param("name"), userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Job started", "data": run})
+}
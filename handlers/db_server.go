@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"go-multi-tenant/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DBServerHandler struct {
+	dbServerService *services.DBServerService
+}
+
+func NewDBServerHandler(dbServerService *services.DBServerService) *DBServerHandler {
+	return &DBServerHandler{dbServerService: dbServerService}
+}
+
+func (h *DBServerHandler) Create(c *gin.Context) {
+	var req services.DBServerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	server, err := h.dbServerService.CreateServer(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "Database server registered", "data": server})
+}
+
+func (h *DBServerHandler) List(c *gin.Context) {
+	servers, err := h.dbServerService.ListServers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": servers})
+}
+
+func (h *DBServerHandler) Update(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	var req services.DBServerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	server, err := h.dbServerService.UpdateServer(uint(id), &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Database server updated", "data": server})
+}
+
+func (h *DBServerHandler) Delete(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	if err := h.dbServerService.DeleteServer(uint(id)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Database server removed"})
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"go-multi-tenant/models"
+	"go-multi-tenant/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DomainHandler struct {
+	domainService *services.DomainService
+}
+
+func NewDomainHandler(domainService *services.DomainService) *DomainHandler {
+	return &DomainHandler{domainService: domainService}
+}
+
+// LoginContext is public: it lets the login page brand itself for the tenant behind the host.
+func (h *DomainHandler) LoginContext(c *gin.Context) {
+	hostTenant, exists := c.Get("hostTenant")
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No workspace is served from this host"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": h.domainService.LoginContext(hostTenant.(*models.Tenant), c.Request.Host)})
+}
+
+func (h *DomainHandler) Create(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+
+	var req struct {
+		Domain string `json:"domain" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	domain, err := h.domainService.AddDomain(tenantID, req.Domain)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordName, recordValue := services.VerificationRecord(domain)
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Domain added. Publish the TXT record, then verify.",
+		"data":    domain,
+		"verification": gin.H{
+			"type":  "TXT",
+			"name":  recordName,
+			"value": recordValue,
+		},
+	})
+}
+
+func (h *DomainHandler) List(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+
+	domains, err := h.domainService.ListDomains(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": domains})
+}
+
+func (h *DomainHandler) Verify(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	domain, err := h.domainService.VerifyDomain(tenantID, uint(id))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Domain verified", "data": domain})
+}
+
+func (h *DomainHandler) Delete(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	if err := h.domainService.DeleteDomain(tenantID, uint(id)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Domain deleted"})
+}
@@ -46,8 +46,8 @@ func (h *InventoryHandler) GetLowStockAlerts(c *gin.Context) {
 
 	tenantID := c.MustGet("tenantID").(uint)
 
-	thresholdStr := c.DefaultQuery("threshold", "10")
-	threshold, _ := strconv.Atoi(thresholdStr)
+	// Without ?threshold= the tenant's low-stock setting applies.
+	threshold, _ := strconv.Atoi(c.Query("threshold"))
 
 	items, err := h.invService.GetLowStockAlerts(tenantDB, tenantID, threshold)
 	if err != nil {
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"errors"
+	"go-multi-tenant/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JobHandler serves a tenant's own jobs, or every tenant's jobs when created
+// with NewAdminJobHandler.
+type JobHandler struct {
+	queueService *services.QueueService
+	allTenants   bool
+}
+
+func NewJobHandler(queueService *services.QueueService) *JobHandler {
+	return &JobHandler{queueService: queueService}
+}
+
+func NewAdminJobHandler(queueService *services.QueueService) *JobHandler {
+	return &JobHandler{queueService: queueService, allTenants: true}
+}
+
+// scope returns the tenant whose jobs the request may see; nil means all.
+// Operators can narrow to one tenant with ?tenant_id=.
+func (h *JobHandler) scope(c *gin.Context) *uint {
+	if h.allTenants {
+		if raw := c.Query("tenant_id"); raw != "" {
+			id, _ := strconv.Atoi(raw)
+			tenantID := uint(id)
+			return &tenantID
+		}
+		return nil
+	}
+	tenantID := c.MustGet("tenantID").(uint)
+	return &tenantID
+}
+
+func (h *JobHandler) List(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	jobs, total, err := h.queueService.ListJobs(services.JobFilter{
+		TenantID: h.scope(c),
+		Type:     c.Query("type"),
+		State:    c.Query("state"),
+	}, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":      jobs,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+func (h *JobHandler) Get(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	job, err := h.queueService.GetJob(h.scope(c), uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": job})
+}
+
+func (h *JobHandler) Cancel(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	job, err := h.queueService.CancelJob(h.scope(c), uint(id))
+	if err != nil {
+		respondJobError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Job cancelled", "data": job})
+}
+
+func (h *JobHandler) Retry(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	job, err := h.queueService.RetryJob(h.scope(c), uint(id))
+	if err != nil {
+		respondJobError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Job queued for retry", "data": job})
+}
+
+func (h *JobHandler) Stats(c *gin.Context) {
+	stats, err := h.queueService.GetQueueStats()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": stats})
+}
+
+func respondJobError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrJobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"errors"
+	"go-multi-tenant/mail"
+	"go-multi-tenant/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MailHandler serves a tenant's email templates and outbox, or every
+// tenant's outbox when created with NewAdminMailHandler.
+type MailHandler struct {
+	mailService *services.MailService
+	allTenants  bool
+}
+
+func NewMailHandler(mailService *services.MailService) *MailHandler {
+	return &MailHandler{mailService: mailService}
+}
+
+func NewAdminMailHandler(mailService *services.MailService) *MailHandler {
+	return &MailHandler{mailService: mailService, allTenants: true}
+}
+
+func (h *MailHandler) ListTemplates(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+
+	templates, err := h.mailService.ListTemplates(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": templates})
+}
+
+func (h *MailHandler) GetTemplate(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+
+	view, err := h.mailService.GetTemplate(tenantID, c.Param("name"), c.Query("locale"))
+	if err != nil {
+		respondMailError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": view})
+}
+
+func (h *MailHandler) SaveTemplate(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+	userID := c.MustGet("userID").(uint)
+
+	var req services.EmailTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	view, err := h.mailService.SaveTemplate(tenantID, userID, c.Param("name"), &req)
+	if err != nil {
+		respondMailError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Email template saved", "data": view})
+}
+
+// DeleteTemplate removes the tenant's override so the built-in template is used again.
+func (h *MailHandler) DeleteTemplate(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+
+	locale := c.Query("locale")
+	if locale == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "locale is required"})
+		return
+	}
+	if err := h.mailService.DeleteTemplate(tenantID, c.Param("name"), locale); err != nil {
+		respondMailError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Email template reset to the default"})
+}
+
+// Preview renders the posted template, or the one in use when the body is
+// empty, with sample data.
+func (h *MailHandler) Preview(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+	name := c.Param("name")
+
+	var tmpl mail.Template
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&tmpl); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	} else {
+		view, err := h.mailService.GetTemplate(tenantID, name, c.Query("locale"))
+		if err != nil {
+			respondMailError(c, err)
+			return
+		}
+		tmpl = view.Template
+	}
+
+	preview, err := h.mailService.Preview(tenantID, name, &tmpl)
+	if err != nil {
+		respondMailError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": preview})
+}
+
+func (h *MailHandler) ListOutbox(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	var tenantID *uint
+	if !h.allTenants {
+		id := c.MustGet("tenantID").(uint)
+		tenantID = &id
+	} else if raw := c.Query("tenant_id"); raw != "" {
+		id, _ := strconv.Atoi(raw)
+		scoped := uint(id)
+		tenantID = &scoped
+	}
+
+	emails, total, err := h.mailService.ListOutbox(tenantID, c.Query("status"), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":      emails,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// Captured lists the messages held by the capture transport, for testing.
+func (h *MailHandler) Captured(c *gin.Context) {
+	messages, err := services.CapturedMail()
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": messages})
+}
+
+func respondMailError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrEmailTemplateNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
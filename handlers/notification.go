@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"errors"
+	"go-multi-tenant/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// NotificationHandler serves the signed-in user's own notifications; no
+// permission is needed beyond being signed in.
+type NotificationHandler struct {
+	notificationService *services.NotificationService
+}
+
+func NewNotificationHandler(notificationService *services.NotificationService) *NotificationHandler {
+	return &NotificationHandler{notificationService: notificationService}
+}
+
+func (h *NotificationHandler) List(c *gin.Context) {
+	tenantDB := c.MustGet("tenantDB").(*gorm.DB)
+	tenantID := c.MustGet("tenantID").(uint)
+	userID := c.MustGet("userID").(uint)
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	unreadOnly := c.Query("unread") == "true"
+
+	notifications, total, unread, err := h.notificationService.List(tenantDB, tenantID, userID, unreadOnly, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":      notifications,
+		"total":     total,
+		"unread":    unread,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+func (h *NotificationHandler) MarkRead(c *gin.Context) {
+	tenantDB := c.MustGet("tenantDB").(*gorm.DB)
+	tenantID := c.MustGet("tenantID").(uint)
+	userID := c.MustGet("userID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	notification, err := h.notificationService.MarkRead(tenantDB, tenantID, userID, uint(id))
+	if err != nil {
+		if errors.Is(err, services.ErrNotificationNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": notification})
+}
+
+func (h *NotificationHandler) MarkAllRead(c *gin.Context) {
+	tenantDB := c.MustGet("tenantDB").(*gorm.DB)
+	tenantID := c.MustGet("tenantID").(uint)
+	userID := c.MustGet("userID").(uint)
+
+	marked, err := h.notificationService.MarkAllRead(tenantDB, tenantID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "All notifications marked read", "marked": marked})
+}
+
+func (h *NotificationHandler) Preferences(c *gin.Context) {
+	tenantDB := c.MustGet("tenantDB").(*gorm.DB)
+	tenantID := c.MustGet("tenantID").(uint)
+	userID := c.MustGet("userID").(uint)
+
+	prefs, err := h.notificationService.Preferences(tenantDB, tenantID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": prefs})
+}
+
+func (h *NotificationHandler) UpdatePreferences(c *gin.Context) {
+	tenantDB := c.MustGet("tenantDB").(*gorm.DB)
+	tenantID := c.MustGet("tenantID").(uint)
+	userID := c.MustGet("userID").(uint)
+
+	var req struct {
+		Preferences []services.NotificationPreferenceRequest `json:"preferences" binding:"required,dive"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	prefs, err := h.notificationService.UpdatePreferences(tenantDB, tenantID, userID, req.Preferences)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Notification preferences updated", "data": prefs})
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"errors"
+	"go-multi-tenant/services"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PlanHandler struct {
+	planService *services.PlanService
+}
+
+func NewPlanHandler(planService *services.PlanService) *PlanHandler {
+	return &PlanHandler{planService: planService}
+}
+
+func (h *PlanHandler) Create(c *gin.Context) {
+	var req services.PlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plan, err := h.planService.CreatePlan(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "Plan created", "data": plan})
+}
+
+// List returns the plans tenants can choose from; ?include_inactive=true also lists retired plans.
+func (h *PlanHandler) List(c *gin.Context) {
+	plans, err := h.planService.ListPlans(c.Query("include_inactive") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": plans})
+}
+
+func (h *PlanHandler) Get(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	plan, err := h.planService.GetPlan(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": plan})
+}
+
+func (h *PlanHandler) Update(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	var req services.PlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plan, err := h.planService.UpdatePlan(uint(id), &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Plan updated", "data": plan})
+}
+
+func (h *PlanHandler) Delete(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	if err := h.planService.DeletePlan(uint(id)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Plan deleted"})
+}
+
+// GetSubscription serves both the tenant's own view and the admin view (/tenants/:id/subscription).
+func (h *PlanHandler) GetSubscription(c *gin.Context) {
+	sub, err := h.planService.GetSubscription(subscriptionTenantID(c))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": sub})
+}
+
+func (h *PlanHandler) ChangePlan(c *gin.Context) {
+	var req services.ChangePlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sub, err := h.planService.ChangePlan(subscriptionTenantID(c), &req)
+	if err != nil {
+		var blocked *services.DowngradeBlockedError
+		if errors.As(err, &blocked) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "violations": blocked.Violations})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	message := "Plan changed"
+	if req.AtRenewal {
+		message = "Plan change scheduled for renewal"
+	} else if sub.PendingChange != nil {
+		message = "Plan changes once invoice " + sub.PendingChange.Number + " is paid"
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message, "data": sub})
+}
+
+func (h *PlanHandler) SetPlanExpiry(c *gin.Context) {
+	var req struct {
+		PlanExpiry *time.Time `json:"plan_expiry"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sub, err := h.planService.SetPlanExpiry(subscriptionTenantID(c), req.PlanExpiry)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Plan expiry updated", "data": sub})
+}
+
+func subscriptionTenantID(c *gin.Context) uint {
+	if idParam := c.Param("id"); idParam != "" {
+		id, _ := strconv.Atoi(idParam)
+		return uint(id)
+	}
+	return c.MustGet("tenantID").(uint)
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"go-multi-tenant/services"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type PrivacyHandler struct {
+	privacyService *services.PrivacyService
+}
+
+func NewPrivacyHandler(privacyService *services.PrivacyService) *PrivacyHandler {
+	return &PrivacyHandler{privacyService: privacyService}
+}
+
+func (h *PrivacyHandler) RequestExport(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+	userID := c.MustGet("userID").(uint)
+
+	export, err := h.privacyService.RequestExport(tenantID, userID)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Export started", "data": export})
+}
+
+func (h *PrivacyHandler) ListExports(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+
+	exports, err := h.privacyService.ListExports(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": exports})
+}
+
+func (h *PrivacyHandler) GetExport(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	export, err := h.privacyService.GetExport(tenantID, uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": export})
+}
+
+func (h *PrivacyHandler) DownloadExport(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	path, err := h.privacyService.ExportFile(tenantID, uint(id))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.FileAttachment(path, filepath.Base(path))
+}
+
+func (h *PrivacyHandler) EraseUser(c *gin.Context) {
+	tenantDB := c.MustGet("tenantDB").(*gorm.DB)
+	tenantID := c.MustGet("tenantID").(uint)
+	actorID := c.MustGet("userID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	if err := h.privacyService.EraseUser(tenantDB, tenantID, uint(id), actorID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "User personal data erased"})
+}
@@ -62,9 +62,10 @@ func (h *PurchaseHandler) Receive(c *gin.Context) {
 	tenantDB := c.MustGet("tenantDB").(*gorm.DB)
 
 	tenantID := c.MustGet("tenantID").(uint)
+	userID := c.MustGet("userID").(uint)
 	id, _ := strconv.Atoi(c.Param("id"))
 
-	if err := h.service.ReceiveOrder(tenantDB, tenantID, uint(id)); err != nil {
+	if err := h.service.ReceiveOrder(tenantDB, tenantID, uint(id), userID); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
@@ -75,6 +76,7 @@ func (h *PurchaseHandler) UpdateRequest(c *gin.Context) {
 	tenantDB := c.MustGet("tenantDB").(*gorm.DB)
 
 	tenantID := c.MustGet("tenantID").(uint)
+	userID := c.MustGet("userID").(uint)
 	id, _ := strconv.Atoi(c.Param("id"))
 
 	var req struct {
@@ -85,7 +87,7 @@ func (h *PurchaseHandler) UpdateRequest(c *gin.Context) {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
-	if err := h.service.UpdateRequest(tenantDB, tenantID, uint(id), req.Quantity, req.BuyPrice); err != nil {
+	if err := h.service.UpdateRequest(tenantDB, tenantID, uint(id), userID, req.Quantity, req.BuyPrice); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"go-multi-tenant/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SandboxHandler struct {
+	sandboxService *services.SandboxService
+}
+
+func NewSandboxHandler(sandboxService *services.SandboxService) *SandboxHandler {
+	return &SandboxHandler{sandboxService: sandboxService}
+}
+
+func (h *SandboxHandler) Create(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+
+	var req services.CreateSandboxRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sandbox, err := h.sandboxService.CreateSandbox(tenantID, &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create sandbox", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    "Sandbox created successfully",
+		"tenant_id":  sandbox.ID,
+		"name":       sandbox.Name,
+		"expires_at": sandbox.SandboxExpiresAt,
+	})
+}
+
+func (h *SandboxHandler) List(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+
+	sandboxes, err := h.sandboxService.ListSandboxes(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": sandboxes})
+}
+
+func (h *SandboxHandler) Delete(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	sandbox, err := h.sandboxService.GetSandbox(tenantID, uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.sandboxService.DeleteSandbox(sandbox.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Sandbox deleted"})
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"errors"
+	"go-multi-tenant/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ScheduledTaskHandler struct {
+	taskService *services.ScheduledTaskService
+}
+
+func NewScheduledTaskHandler(taskService *services.ScheduledTaskService) *ScheduledTaskHandler {
+	return &ScheduledTaskHandler{taskService: taskService}
+}
+
+func (h *ScheduledTaskHandler) Actions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": h.taskService.Actions()})
+}
+
+func (h *ScheduledTaskHandler) Create(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+	userID := c.MustGet("userID").(uint)
+
+	var req services.ScheduledTaskRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	task, err := h.taskService.CreateTask(tenantID, userID, &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "Scheduled task created", "data": task})
+}
+
+func (h *ScheduledTaskHandler) List(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+
+	tasks, err := h.taskService.ListTasks(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": tasks})
+}
+
+func (h *ScheduledTaskHandler) Get(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	task, err := h.taskService.GetTask(tenantID, uint(id))
+	if err != nil {
+		respondTaskError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": task})
+}
+
+func (h *ScheduledTaskHandler) Update(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	var req services.ScheduledTaskRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	task, err := h.taskService.UpdateTask(tenantID, uint(id), &req)
+	if err != nil {
+		respondTaskError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Scheduled task updated", "data": task})
+}
+
+func (h *ScheduledTaskHandler) Delete(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	if err := h.taskService.DeleteTask(tenantID, uint(id)); err != nil {
+		respondTaskError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Scheduled task deleted"})
+}
+
+func (h *ScheduledTaskHandler) Pause(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	task, err := h.taskService.PauseTask(tenantID, uint(id))
+	if err != nil {
+		respondTaskError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Scheduled task paused", "data": task})
+}
+
+func (h *ScheduledTaskHandler) Resume(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	task, err := h.taskService.ResumeTask(tenantID, uint(id))
+	if err != nil {
+		respondTaskError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Scheduled task resumed", "data": task})
+}
+
+func (h *ScheduledTaskHandler) RunNow(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+	userID := c.MustGet("userID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	run, err := h.taskService.RunNow(tenantID, uint(id), userID)
+	if err != nil {
+		respondTaskError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Run queued", "data": run})
+}
+
+func (h *ScheduledTaskHandler) Runs(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	runs, total, err := h.taskService.ListRuns(tenantID, uint(id), page, pageSize)
+	if err != nil {
+		respondTaskError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data":      runs,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+func respondTaskError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrScheduledTaskNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, services.ErrTaskRunLimited) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"go-multi-tenant/services"
+	"go-multi-tenant/utils"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const streamHeartbeat = 25 * time.Second
+
+type StreamHandler struct {
+	streamService *services.StreamService
+}
+
+func NewStreamHandler(streamService *services.StreamService) *StreamHandler {
+	return &StreamHandler{streamService: streamService}
+}
+
+// Ticket issues a short-lived ticket for opening the stream with
+// EventSource, which cannot send the Authorization header. The ticket opens
+// one stream; EventSource's own reconnects may reuse it for a minute after
+// the connection drops. Past that the stream answers 401, EventSource gives
+// up, and the client requests a new ticket and opens a new EventSource.
+func (h *StreamHandler) Ticket(c *gin.Context) {
+	ticket, err := utils.GenerateStreamTicket(&utils.Claims{
+		UserID:   c.MustGet("userID").(uint),
+		TenantID: c.MustGet("tenantID").(uint),
+		Email:    c.GetString("userEmail"),
+		Role:     c.GetString("userRole"),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue stream ticket"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ticket": ticket, "expires_in": int(utils.StreamTicketTTL.Seconds())})
+}
+
+// Stream sends the tenant's events the user may see as Server-Sent Events.
+// A client reconnecting with Last-Event-ID first gets what it missed.
+func (h *StreamHandler) Stream(c *gin.Context) {
+	tenantDB := c.MustGet("tenantDB").(*gorm.DB)
+	tenantID := c.MustGet("tenantID").(uint)
+	userID := c.MustGet("userID").(uint)
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+
+	client, missed, err := h.streamService.Open(tenantDB, tenantID, userID, lastEventID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrStreamForbidden):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrStreamUnavailable):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	defer h.streamService.Close(client)
+
+	// The stream outlives any server write timeout.
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	fmt.Fprint(c.Writer, "retry: 3000\n\n")
+	for _, msg := range missed {
+		if writeStreamMessage(c.Writer, msg) != nil {
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-client.Done():
+			return
+		case msg := <-client.Messages():
+			if writeStreamMessage(c.Writer, msg) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
+
+func writeStreamMessage(w io.Writer, msg services.StreamMessage) error {
+	_, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", msg.ID, msg.Type, msg.Data)
+	return err
+}
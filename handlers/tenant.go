@@ -3,6 +3,7 @@ package handlers
 import (
 	"go-multi-tenant/services"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -44,3 +45,39 @@ func (h *TenantHandler) ListTenants(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, gin.H{"data": tenants})
 }
+
+func (h *TenantHandler) SetMode(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	var req services.SetTenantModeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tenant, err := h.tenantService.SetMode(uint(id), &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Tenant mode updated", "data": tenant})
+}
+
+func (h *TenantHandler) RedeemCoupon(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	redemption, err := h.tenantService.RedeemCoupon(tenantID, req.Code)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Coupon applied", "data": redemption})
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"go-multi-tenant/config"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TenantPoolHandler struct{}
+
+func NewTenantPoolHandler() *TenantPoolHandler {
+	return &TenantPoolHandler{}
+}
+
+func (h *TenantPoolHandler) List(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": config.TenantManager.Stats()})
+}
+
+func (h *TenantPoolHandler) Evict(c *gin.Context) {
+	tenantID, err := strconv.Atoi(c.Param("tenantID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tenant ID"})
+		return
+	}
+
+	if !config.TenantManager.Evict(uint(tenantID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No open pool for this tenant"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Tenant pool evicted"})
+}
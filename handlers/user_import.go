@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"errors"
+	"go-multi-tenant/services"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const maxImportBytes = 5 << 20
+
+type UserImportHandler struct {
+	importService *services.UserImportService
+}
+
+func NewUserImportHandler(importService *services.UserImportService) *UserImportHandler {
+	return &UserImportHandler{importService: importService}
+}
+
+// Create takes the users either as a multipart "file" upload, whose format
+// comes from its extension, or as a raw text/csv or application/json body.
+// ?format= overrides either; ?dry_run=true only validates.
+func (h *UserImportHandler) Create(c *gin.Context) {
+	tenantDB := c.MustGet("tenantDB").(*gorm.DB)
+	tenantID := c.MustGet("tenantID").(uint)
+	userID := c.MustGet("userID").(uint)
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+	var body io.Reader = c.Request.Body
+	format := ""
+	if file, err := c.FormFile("file"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		defer f.Close()
+		body = f
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(file.Filename)), ".")
+	} else {
+		switch c.ContentType() {
+		case "text/csv":
+			format = "csv"
+		case "application/json":
+			format = "json"
+		}
+	}
+	if override := c.Query("format"); override != "" {
+		format = override
+	}
+
+	rows, err := services.ParseUserImport(format, body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	check, imp, err := h.importService.StartImport(tenantDB, tenantID, userID, format, rows, dryRun)
+	switch {
+	case errors.Is(err, services.ErrUserImportInvalid):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "data": check})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	case dryRun:
+		c.JSON(http.StatusOK, gin.H{"message": "All rows are valid", "data": check})
+	default:
+		c.JSON(http.StatusAccepted, gin.H{"message": "Import queued", "data": imp})
+	}
+}
+
+func (h *UserImportHandler) List(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	imports, total, err := h.importService.ListImports(tenantID, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data":      imports,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+func (h *UserImportHandler) Get(c *gin.Context) {
+	tenantID := c.MustGet("tenantID").(uint)
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	imp, err := h.importService.GetImport(tenantID, uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": imp})
+}
@@ -0,0 +1,124 @@
+// Package mail delivers rendered email messages through a configurable
+// transport.
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+// Message is a rendered email. Text is required; HTML is sent as an
+// alternative when set.
+type Message struct {
+	From    string   `json:"from"`
+	To      []string `json:"to"`
+	Subject string   `json:"subject"`
+	Text    string   `json:"text"`
+	HTML    string   `json:"html,omitempty"`
+}
+
+// Mailer sends messages. Implementations must be safe for concurrent use.
+type Mailer interface {
+	Name() string
+	Send(ctx context.Context, msg *Message) error
+}
+
+func (m *Message) build() *gomail.Message {
+	gm := gomail.NewMessage()
+	gm.SetHeader("From", m.From)
+	gm.SetHeader("To", m.To...)
+	gm.SetHeader("Subject", m.Subject)
+	gm.SetBody("text/plain", m.Text)
+	if m.HTML != "" {
+		gm.AddAlternative("text/html", m.HTML)
+	}
+	return gm
+}
+
+type smtpMailer struct {
+	dialer *gomail.Dialer
+}
+
+// NewSMTPMailer sends through an SMTP server, using STARTTLS when the server
+// offers it. A new connection is made for every message.
+func NewSMTPMailer(host string, port int, username, password string) Mailer {
+	return &smtpMailer{dialer: gomail.NewDialer(host, port, username, password)}
+}
+
+func (m *smtpMailer) Name() string { return "smtp" }
+
+func (m *smtpMailer) Send(ctx context.Context, msg *Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.dialer.DialAndSend(msg.build())
+}
+
+type fileMailer struct {
+	dir string
+	seq atomic.Uint64
+}
+
+// NewFileMailer writes every message to dir as an .eml file, for development.
+func NewFileMailer(dir string) (Mailer, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &fileMailer{dir: dir}, nil
+}
+
+func (m *fileMailer) Name() string { return "file" }
+
+func (m *fileMailer) Send(ctx context.Context, msg *Message) error {
+	name := fmt.Sprintf("%s-%d-%d.eml", time.Now().UTC().Format("20060102T150405"), os.Getpid(), m.seq.Add(1))
+	f, err := os.OpenFile(filepath.Join(m.dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := msg.build().WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// CaptureMailer keeps the most recent messages in memory instead of sending
+// them, for local runs and inspection.
+type CaptureMailer struct {
+	mu       sync.Mutex
+	limit    int
+	messages []Message
+}
+
+func NewCaptureMailer(limit int) *CaptureMailer {
+	if limit <= 0 {
+		limit = 100
+	}
+	return &CaptureMailer{limit: limit}
+}
+
+func (m *CaptureMailer) Name() string { return "capture" }
+
+func (m *CaptureMailer) Send(ctx context.Context, msg *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, *msg)
+	if len(m.messages) > m.limit {
+		m.messages = m.messages[len(m.messages)-m.limit:]
+	}
+	return nil
+}
+
+// Messages returns the captured messages, oldest first.
+func (m *CaptureMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
@@ -0,0 +1,69 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Template is an email's subject, plain-text body and optional HTML body,
+// each a Go template over the same data.
+type Template struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html,omitempty"`
+}
+
+// Rendered is the output of Template.Render.
+type Rendered struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Render executes t against data. A key missing from data is an error, so a
+// mistyped field in a template is caught when it is rendered with sample data.
+func (t Template) Render(data interface{}, funcs map[string]interface{}) (*Rendered, error) {
+	if strings.TrimSpace(t.Subject) == "" || strings.TrimSpace(t.Text) == "" {
+		return nil, errors.New("subject and text are required")
+	}
+
+	var out Rendered
+	var err error
+	if out.Subject, err = renderText("subject", t.Subject, data, funcs); err != nil {
+		return nil, err
+	}
+	out.Subject = strings.TrimSpace(out.Subject)
+	if strings.ContainsAny(out.Subject, "\r\n") {
+		return nil, errors.New("subject must be a single line")
+	}
+	if out.Text, err = renderText("text", t.Text, data, funcs); err != nil {
+		return nil, err
+	}
+	if t.HTML != "" {
+		tmpl, err := htmltemplate.New("html").Option("missingkey=error").Funcs(funcs).Parse(t.HTML)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, err
+		}
+		out.HTML = buf.String()
+	}
+	return &out, nil
+}
+
+func renderText(name, src string, data interface{}, funcs map[string]interface{}) (string, error) {
+	tmpl, err := texttemplate.New(name).Option("missingkey=error").Funcs(funcs).Parse(src)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
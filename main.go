@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"go-multi-tenant/config"
 	"go-multi-tenant/routes"
 	"go-multi-tenant/services"
 	"go-multi-tenant/utils"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -40,12 +46,32 @@ func main() {
 		log.Println("Master data seeded successfully")
 	}
 
+	if err := services.InitEventBus(cfg); err != nil {
+		log.Fatal("Failed to start event bus:", err)
+	}
+
+	if err := services.InitPayments(cfg); err != nil {
+		log.Fatal("Failed to set up payments:", err)
+	}
+
+	if err := services.InitMailer(cfg); err != nil {
+		log.Fatal("Failed to set up mail:", err)
+	}
+
+	if err := services.InitJobQueue(cfg); err != nil {
+		log.Fatal("Failed to start job queue:", err)
+	}
+
+	if err := services.InitScheduler(cfg); err != nil {
+		log.Fatal("Failed to start scheduler:", err)
+	}
+
 	router := gin.Default()
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		ExposeHeaders:    []string{"Content-Length", "Retry-After", "X-Maintenance-Starts-At"},
 		AllowCredentials: true,
 	}))
 	routes.SetupRoutes(router)
@@ -55,8 +81,33 @@ func main() {
 		serverPort = ":8080"
 	}
 
-	log.Printf("Server starting on port %s", serverPort)
-	if err := router.Run(serverPort); err != nil {
-		log.Fatal("Failed to start server:", err)
+	srv := &http.Server{Addr: serverPort, Handler: router}
+	srv.RegisterOnShutdown(services.CloseStreams)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		log.Printf("Server starting on port %s", serverPort)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Failed to start server:", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server shutdown: %v", err)
+	}
+	if err := services.StopScheduler(shutdownCtx); err != nil {
+		log.Printf("Scheduler shutdown: %v", err)
+	}
+	if err := services.ShutdownJobQueue(shutdownCtx); err != nil {
+		log.Printf("Job queue shutdown: %v", err)
+	}
+	if err := services.ShutdownEventBus(shutdownCtx); err != nil {
+		log.Printf("Event bus shutdown: %v", err)
 	}
 }
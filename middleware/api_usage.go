@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"errors"
+	"go-multi-tenant/models"
+	"go-multi-tenant/services"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// APIUsageMiddleware meters authenticated API calls per tenant and month and
+// rejects calls beyond the plan's quota. Calls are counted in Redis when it is
+// available. It must run after TenantDBMiddleware.
+func APIUsageMiddleware() gin.HandlerFunc {
+	usageService := services.NewUsageService()
+
+	return func(c *gin.Context) {
+		// Tenants over quota must still be able to see usage, upgrade and pay.
+		if path := c.FullPath(); strings.HasPrefix(path, "/api/v1/usage") || isBillingPath(path) {
+			c.Next()
+			return
+		}
+
+		tenant := c.MustGet("currentTenant").(*models.Tenant)
+		tenantDB := c.MustGet("tenantDB").(*gorm.DB)
+
+		if err := usageService.ReserveForTenant(tenantDB, tenant, models.MetricAPICalls, 1); err != nil {
+			var quotaErr *services.QuotaExceededError
+			if errors.As(err, &quotaErr) {
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Monthly API call quota exceeded"})
+				return
+			}
+			// Metering problems must not take the API down.
+			log.Printf("API usage metering failed for tenant %d: %v", tenant.ID, err)
+		}
+		c.Next()
+	}
+}
@@ -1,6 +1,8 @@
 package middleware
 
 import (
+	"errors"
+	"go-multi-tenant/services"
 	"go-multi-tenant/utils"
 	"net/http"
 	"strings"
@@ -29,11 +31,45 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		c.Set("userID", claims.UserID)
-		c.Set("tenantID", claims.TenantID)
-		c.Set("userEmail", claims.Email)
-		c.Set("userRole", claims.Role)
+		setClaims(c, claims)
+		c.Next()
+	}
+}
 
+// StreamAuthMiddleware authenticates the event stream with the Authorization
+// header or, for EventSource clients, a stream ticket in the query string. A
+// ticket opens one stream and is released for reconnects when it closes.
+func StreamAuthMiddleware() gin.HandlerFunc {
+	streamService := services.NewStreamService()
+	auth := AuthMiddleware()
+	return func(c *gin.Context) {
+		ticket := c.Query("ticket")
+		if ticket == "" || c.GetHeader("Authorization") != "" {
+			auth(c)
+			return
+		}
+		claims, err := utils.ValidateStreamTicket(ticket)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired stream ticket"})
+			return
+		}
+		if err := streamService.ClaimTicket(claims); err != nil {
+			if errors.Is(err, services.ErrStreamTicketUsed) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			} else {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check stream ticket"})
+			}
+			return
+		}
+		defer streamService.ReleaseTicket(claims.ID)
+		setClaims(c, claims)
 		c.Next()
 	}
 }
+
+func setClaims(c *gin.Context, claims *utils.Claims) {
+	c.Set("userID", claims.UserID)
+	c.Set("tenantID", claims.TenantID)
+	c.Set("userEmail", claims.Email)
+	c.Set("userRole", claims.Role)
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"errors"
+	"go-multi-tenant/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HostTenantMiddleware resolves the tenant from the request host (subdomain or
+// verified custom domain) and stores it as "hostTenant". Generic hosts pass through.
+func HostTenantMiddleware() gin.HandlerFunc {
+	domainService := services.NewDomainService()
+
+	return func(c *gin.Context) {
+		tenant, err := domainService.ResolveHost(c.Request.Host)
+		if err != nil {
+			if errors.Is(err, services.ErrHostTenantNotFound) {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Unknown workspace"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if tenant != nil {
+			if !tenant.IsActive {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Tenant account is suspended"})
+				return
+			}
+			c.Set("hostTenant", tenant)
+		}
+		c.Next()
+	}
+}
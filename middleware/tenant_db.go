@@ -6,6 +6,8 @@ import (
 	"go-multi-tenant/models"
 	"go-multi-tenant/services"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -21,6 +23,12 @@ func TenantDBMiddleware() gin.HandlerFunc {
 		}
 		tenantID := tenantIDInterface.(uint)
 
+		// A token issued for one tenant must not be replayed on another tenant's host.
+		if hostTenant, exists := c.Get("hostTenant"); exists && hostTenant.(*models.Tenant).ID != tenantID {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Token does not belong to this workspace"})
+			return
+		}
+
 		// 2. Fetch Tenant Info (Redis First -> Then Master DB)
 		var tenant models.Tenant
 		cacheKey := fmt.Sprintf("tenant_info:%d", tenantID)
@@ -41,16 +49,87 @@ func TenantDBMiddleware() gin.HandlerFunc {
 			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Tenant account is suspended"})
 			return
 		}
+		if !enforceTenantMode(c, &tenant) {
+			return
+		}
 		// 3. Connect to Tenant DB
 		tenantDB, err := config.TenantManager.GetTenantDB(&tenant)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to tenant database"})
 			return
 		}
-		c.Set("tenantDB", tenantDB)
+		// Pre-scoped so every query on a tenant-owned model is filtered by tenant_id.
+		c.Set("tenantDB", config.ScopeToTenant(tenantDB, tenant.ID))
 
 		c.Set("currentTenant", &tenant)
 
 		c.Next()
 	}
 }
+
+// enforceTenantMode blocks writes for read-only tenants and everything but
+// system operator traffic for tenants under maintenance. It returns false once it
+// has aborted the request.
+func enforceTenantMode(c *gin.Context, tenant *models.Tenant) bool {
+	now := time.Now()
+
+	switch tenant.EffectiveMode(now) {
+	case models.ModeMaintenance:
+		if services.IsSystemOperator(tenant, c.MustGet("userID").(uint)) {
+			return true
+		}
+		if tenant.MaintenanceEndsAt != nil {
+			c.Header("Retry-After", strconv.Itoa(int(time.Until(*tenant.MaintenanceEndsAt).Seconds())+1))
+		}
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"error":   "Workspace is under maintenance",
+			"code":    "tenant_maintenance",
+			"message": tenant.MaintenanceMessage,
+			"ends_at": tenant.MaintenanceEndsAt,
+		})
+		return false
+
+	case models.ModeReadOnly:
+		if !isReadRequest(c) && c.FullPath() != streamTicketPath {
+			c.AbortWithStatusJSON(http.StatusLocked, gin.H{
+				"error":   "Workspace is read-only; changes are temporarily disabled",
+				"code":    "tenant_read_only",
+				"message": tenant.MaintenanceMessage,
+			})
+			return false
+		}
+	}
+
+	// Past-due tenants keep read access and can still pay or change plan, and
+	// mark read the notifications telling them so.
+	if tenant.BillingStatus == models.BillingPastDue && !isReadRequest(c) && c.FullPath() != streamTicketPath &&
+		!isBillingPath(c.FullPath()) && !strings.HasPrefix(c.FullPath(), "/api/v1/notifications") {
+		c.AbortWithStatusJSON(http.StatusLocked, gin.H{
+			"error": "Subscription payment is overdue; the workspace is read-only until it is paid",
+			"code":  "tenant_past_due",
+		})
+		return false
+	}
+
+	// Let clients warn users ahead of a scheduled window.
+	if tenant.UpcomingMaintenance(now) {
+		c.Header("X-Maintenance-Starts-At", tenant.MaintenanceStartsAt.UTC().Format(time.RFC3339))
+	}
+	return true
+}
+
+// streamTicketPath is a POST, but the ticket it issues only opens the
+// read-only event stream, so workspaces that cannot write may still use it.
+const streamTicketPath = "/api/v1/stream/ticket"
+
+func isReadRequest(c *gin.Context) bool {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+func isBillingPath(path string) bool {
+	return strings.HasPrefix(path, "/api/v1/invoices") || strings.HasPrefix(path, "/api/v1/subscription")
+}
@@ -37,6 +37,7 @@ type Inventory struct {
 	ID            uint      `gorm:"primaryKey" json:"id"`
 	TenantID      uint      `gorm:"index;not null" json:"tenant_id"`
 	ProductID     uint      `gorm:"uniqueIndex;not null" json:"product_id"`
+	Product       *Product  `gorm:"foreignKey:ProductID" json:"product,omitempty"`
 	Quantity      int       `gorm:"default:0" json:"quantity"`
 	LowStockAlert int       `gorm:"default:10" json:"low_stock_alert"`
 	Location      string    `json:"location"`
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DiscountPercent = "percent"
+	DiscountFixed   = "fixed"
+)
+
+// Coupon discounts a tenant's subscription invoices, e.g. "3 months 50% off".
+type Coupon struct {
+	ID           uint    `gorm:"primaryKey" json:"id"`
+	Code         string  `gorm:"type:varchar(50);uniqueIndex;not null" json:"code"`
+	Description  string  `gorm:"type:varchar(255)" json:"description"`
+	DiscountType string  `gorm:"type:varchar(20);not null" json:"discount_type"`
+	Amount       float64 `json:"amount"` // Percent off, or a fixed amount in the billing currency
+	// DurationMonths is how long after redemption the discount applies to
+	// invoices, whatever they bill. 0 = forever.
+	DurationMonths int        `json:"duration_months"`
+	MaxRedemptions int        `json:"max_redemptions"` // 0 = Unlimited
+	TimesRedeemed  int        `gorm:"default:0" json:"times_redeemed"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"` // Last moment it can be redeemed
+	// Plans restricts the coupon to these plans; empty means any plan.
+	Plans     []Plan         `gorm:"many2many:coupon_plans;" json:"plans,omitempty"`
+	IsActive  bool           `json:"is_active"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// CouponRedemption is a coupon applied to one tenant. A tenant has at most one
+// active redemption at a time.
+type CouponRedemption struct {
+	ID                 uint       `gorm:"primaryKey" json:"id"`
+	CouponID           uint       `gorm:"index;not null" json:"coupon_id"`
+	Coupon             *Coupon    `gorm:"foreignKey:CouponID" json:"coupon,omitempty"`
+	TenantID           uint       `gorm:"index;not null" json:"tenant_id"`
+	PlanID             uint       `json:"plan_id"`
+	Active             bool       `gorm:"index" json:"active"`
+	InvoicesDiscounted int        `json:"invoices_discounted"`
+	TotalDiscount      float64    `json:"total_discount"`
+	RedeemedAt         time.Time  `gorm:"autoCreateTime" json:"redeemed_at"`
+	EndedAt            *time.Time `json:"ended_at,omitempty"`
+}
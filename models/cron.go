@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+const (
+	CronRunRunning   = "running"
+	CronRunSucceeded = "succeeded"
+	CronRunFailed    = "failed"
+	CronRunSkipped   = "skipped" // The previous run was still in progress
+)
+
+const (
+	CronTriggerSchedule = "schedule"
+	CronTriggerManual   = "manual"
+)
+
+// CronRun records one execution of a scheduled job. Scheduled runs are unique
+// per job and slot, so a slot never runs twice even across a leader change.
+type CronRun struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	Job          string     `gorm:"type:varchar(100);not null;uniqueIndex:idx_cron_run_slot;index" json:"job"`
+	ScheduledFor *time.Time `gorm:"uniqueIndex:idx_cron_run_slot" json:"scheduled_for,omitempty"` // Nil for manual runs
+	Trigger      string     `gorm:"type:varchar(20);not null" json:"trigger"`
+	TriggeredBy  uint       `json:"triggered_by,omitempty"`
+	Instance     string     `gorm:"type:varchar(100)" json:"instance"`
+	Status       string     `gorm:"type:varchar(20);index;not null" json:"status"`
+	Output       string     `gorm:"type:text" json:"output,omitempty"`
+	Error        string     `gorm:"type:text" json:"error,omitempty"`
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+	DurationMs   int64      `json:"duration_ms"`
+}
+
+// SchedulerLock is a lease held by one instance until ExpiresAt, used when
+// Redis is not available for locking.
+type SchedulerLock struct {
+	Name      string    `gorm:"type:varchar(150);primaryKey" json:"name"`
+	Holder    string    `gorm:"type:varchar(150);not null" json:"holder"`
+	ExpiresAt time.Time `gorm:"not null" json:"expires_at"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+const (
+	ExportPending   = "pending"
+	ExportRunning   = "running"
+	ExportCompleted = "completed"
+	ExportFailed    = "failed"
+)
+
+// DataExport tracks an asynchronous export of everything a tenant owns.
+type DataExport struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	TenantID    uint       `gorm:"index;not null" json:"tenant_id"`
+	RequestedBy uint       `json:"requested_by"`
+	Status      string     `gorm:"type:varchar(20);not null" json:"status"`
+	FilePath    string     `gorm:"type:varchar(500)" json:"-"`
+	SizeBytes   int64      `json:"size_bytes"`
+	Error       string     `gorm:"type:text" json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// DatabaseServer is a database host that dedicated tenant databases can be
+// placed on. Credentials are not stored here: CredentialsRef points at where
+// they live (see config.ResolveCredentials).
+type DatabaseServer struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	Name           string    `gorm:"type:varchar(100);uniqueIndex;not null" json:"name"`
+	Driver         string    `gorm:"type:varchar(20);not null" json:"driver"`
+	Host           string    `gorm:"type:varchar(255);not null" json:"host"`
+	Port           int       `json:"port"`
+	Region         string    `gorm:"type:varchar(50);index;not null" json:"region"`
+	CredentialsRef string    `gorm:"type:varchar(255)" json:"credentials_ref"`
+	Capacity       int       `gorm:"default:0" json:"capacity"` // max tenant databases, 0 = unlimited
+	IsActive       bool      `gorm:"default:true" json:"is_active"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
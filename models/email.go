@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+const (
+	OutboxPending    = "pending" // Waiting to be handed to the job queue
+	OutboxQueued     = "queued"
+	OutboxSent       = "sent"
+	OutboxFailed     = "failed"
+	OutboxSuppressed = "suppressed" // Never sent, e.g. for sandbox tenants
+)
+
+// OutboxEmail is a rendered email waiting to be sent, or the record of one
+// that was. Rows are written in the same transaction as the change that
+// causes the email, then handed to the job queue for delivery.
+type OutboxEmail struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	TenantID  uint       `gorm:"index" json:"tenant_id"` // 0 for platform email
+	Template  string     `gorm:"type:varchar(100);not null" json:"template"`
+	Locale    string     `gorm:"type:varchar(10)" json:"locale"`
+	To        string     `gorm:"type:text;not null" json:"to"` // Comma-separated
+	Subject   string     `gorm:"type:varchar(255);not null" json:"subject"`
+	Text      string     `gorm:"type:text" json:"-"`
+	HTML      string     `gorm:"type:text" json:"-"`
+	Status    string     `gorm:"type:varchar(20);index;not null" json:"status"`
+	JobID     uint       `json:"job_id,omitempty"`
+	Error     string     `gorm:"type:text" json:"error,omitempty"`
+	SentAt    *time.Time `json:"sent_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// EmailTemplate is a tenant's override of a built-in email template in one locale.
+type EmailTemplate struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	TenantID  uint      `gorm:"not null;uniqueIndex:idx_email_template" json:"tenant_id"`
+	Name      string    `gorm:"type:varchar(100);not null;uniqueIndex:idx_email_template" json:"name"`
+	Locale    string    `gorm:"type:varchar(10);not null;uniqueIndex:idx_email_template" json:"locale"`
+	Subject   string    `gorm:"type:varchar(255);not null" json:"subject"`
+	Text      string    `gorm:"type:text;not null" json:"text"`
+	HTML      string    `gorm:"type:text" json:"html,omitempty"`
+	UpdatedBy uint      `json:"updated_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
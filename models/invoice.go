@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+const (
+	InvoiceOpen = "open"
+	InvoicePaid = "paid"
+	InvoiceVoid = "void"
+)
+
+const (
+	PaymentSucceeded = "succeeded"
+	PaymentFailed    = "failed"
+)
+
+// Invoice bills a tenant for one period of its plan. Proration invoices cover
+// the rest of the current period after an immediate plan change.
+type Invoice struct {
+	ID          uint          `gorm:"primaryKey" json:"id"`
+	TenantID    uint          `gorm:"index;not null" json:"tenant_id"`
+	Number      string        `gorm:"type:varchar(30);uniqueIndex" json:"number"`
+	PlanID      uint          `json:"plan_id"`
+	Reason      string        `gorm:"type:varchar(20);not null" json:"reason"` // renewal or plan_change
+	PeriodStart time.Time     `json:"period_start"`
+	PeriodEnd   time.Time     `json:"period_end"`
+	Currency    string        `gorm:"type:varchar(3);not null" json:"currency"`
+	Subtotal    float64       `json:"subtotal"`
+	Discount    float64       `json:"discount"` // From a coupon
+	Total       float64       `json:"total"`
+	Status      string        `gorm:"type:varchar(20);index;not null" json:"status"`
+	DueAt       time.Time     `json:"due_at"`
+	PaidAt      *time.Time    `json:"paid_at,omitempty"`
+	Lines       []InvoiceLine `gorm:"foreignKey:InvoiceID" json:"lines,omitempty"`
+	Payments    []Payment     `gorm:"foreignKey:InvoiceID" json:"payments,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+}
+
+type InvoiceLine struct {
+	ID          uint    `gorm:"primaryKey" json:"id"`
+	InvoiceID   uint    `gorm:"index;not null" json:"invoice_id"`
+	Description string  `gorm:"type:varchar(255);not null" json:"description"`
+	Amount      float64 `json:"amount"` // Negative for credits
+}
+
+// Payment is one attempt to settle an invoice through a payment provider.
+type Payment struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	InvoiceID     uint      `gorm:"index;not null" json:"invoice_id"`
+	TenantID      uint      `gorm:"index;not null" json:"tenant_id"`
+	Provider      string    `gorm:"type:varchar(50);not null" json:"provider"`
+	Reference     string    `gorm:"type:varchar(255)" json:"reference,omitempty"`
+	Amount        float64   `json:"amount"`
+	Status        string    `gorm:"type:varchar(20);not null" json:"status"`
+	FailureReason string    `gorm:"type:varchar(500)" json:"failure_reason,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+const (
+	JobPending   = "pending" // Waiting for RunAt, including retries after a failure
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobDead      = "dead" // Out of attempts or failed permanently; kept for inspection
+	JobCancelled = "cancelled"
+)
+
+// Job is a unit of background work. The row is the source of truth for the
+// job's state; the queue broker only decides who picks it up next.
+type Job struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	Type        string `gorm:"type:varchar(100);index;not null" json:"type"`
+	TenantID    uint   `gorm:"index" json:"tenant_id"` // 0 for system jobs
+	CreatedBy   uint   `json:"created_by,omitempty"`
+	Payload     string `gorm:"type:text" json:"payload"`
+	State       string `gorm:"type:varchar(20);index;not null" json:"state"`
+	Attempts    int    `json:"attempts"`
+	MaxAttempts int    `json:"max_attempts"`
+	// RunAt is when the job becomes due; retries move it forward.
+	RunAt time.Time `gorm:"index" json:"run_at"`
+	// LockedUntil is the visibility timeout of a running job. A worker extends
+	// it while alive; once it lapses the job is handed to another worker.
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+	LockedBy    string     `gorm:"type:varchar(100)" json:"locked_by,omitempty"`
+	LastError   string     `gorm:"type:text" json:"last_error,omitempty"`
+	Result      string     `gorm:"type:text" json:"result,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
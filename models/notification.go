@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Notification is a message shown to one user in the app. It lives in the
+// tenant database next to the user.
+type Notification struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	TenantID  uint       `gorm:"index:idx_notifications_user;not null" json:"tenant_id"`
+	UserID    uint       `gorm:"index:idx_notifications_user;not null" json:"user_id"`
+	Type      string     `gorm:"type:varchar(100);not null" json:"type"`
+	Title     string     `gorm:"type:varchar(255);not null" json:"title"`
+	Body      string     `gorm:"type:text" json:"body"`
+	Link      string     `gorm:"type:varchar(255)" json:"link,omitempty"` // API path of what it is about
+	Data      string     `gorm:"type:text" json:"-"`                      // JSON object
+	ReadAt    *time.Time `gorm:"index" json:"read_at"`
+	CreatedAt time.Time  `gorm:"index" json:"created_at"`
+}
+
+// NotificationPreference overrides the default channels of one notification
+// type for a user. Types without a row use their defaults.
+type NotificationPreference struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	TenantID  uint      `gorm:"uniqueIndex:idx_notification_pref;not null" json:"-"`
+	UserID    uint      `gorm:"uniqueIndex:idx_notification_pref;not null" json:"-"`
+	Type      string    `gorm:"type:varchar(100);uniqueIndex:idx_notification_pref;not null" json:"type"`
+	InApp     bool      `json:"in_app"`
+	Email     bool      `json:"email"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -15,15 +15,28 @@ const (
 )
 
 type Plan struct {
-	ID           uint           `gorm:"primaryKey" json:"id"`
-	Name         string         `gorm:"type:varchar(100);not null" json:"name"` // e.g. "Free Tier", "Gold Monthly"
-	Type         PlanType       `gorm:"type:varchar(50);not null" json:"type"`
-	Price        float64        `json:"price"`
-	MaxUsers     int            `json:"max_users"`     // 0 = Unlimited
-	MaxProducts  int            `json:"max_products"`  // 0 = Unlimited
-	StorageLimit int            `json:"storage_limit"` // MBs
-	IsActive     bool           `gorm:"default:true" json:"is_active"`
-	CreatedAt    time.Time      `json:"created_at"`
-	UpdatedAt    time.Time      `json:"updated_at"`
-	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+	ID           uint     `gorm:"primaryKey" json:"id"`
+	Name         string   `gorm:"type:varchar(100);not null" json:"name"` // e.g. "Free Tier", "Gold Monthly"
+	Type         PlanType `gorm:"type:varchar(50);not null" json:"type"`
+	Price        float64  `json:"price"`
+	MaxUsers     int      `json:"max_users"`     // 0 = Unlimited
+	MaxProducts  int      `json:"max_products"`  // 0 = Unlimited
+	StorageLimit int      `json:"storage_limit"` // MBs
+
+	// Monthly quotas, 0 = Unlimited.
+	MaxAPICalls       int `json:"max_api_calls"`
+	MaxPurchaseOrders int `json:"max_purchase_orders"`
+
+	// MaxSandboxes a tenant may keep at once. 0 = none.
+	MaxSandboxes int `json:"max_sandboxes"`
+
+	// BillingCycleDays is how far PlanExpiry moves on each renewal. 0 = lifetime.
+	BillingCycleDays int `gorm:"default:30" json:"billing_cycle_days"`
+	// TrialDays of free use before the first invoice, for new tenants. 0 = no trial.
+	TrialDays int `json:"trial_days"`
+
+	IsActive  bool           `gorm:"default:true" json:"is_active"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
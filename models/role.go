@@ -6,6 +6,9 @@ import (
 	"gorm.io/gorm"
 )
 
+// SuperAdminRoleName is the system owner's role in the master tenant.
+const SuperAdminRoleName = "Super Administrator"
+
 type Role struct {
 	ID           uint   `gorm:"primaryKey" json:"id"`
 	Name         string `gorm:"type:varchar(100);not null" json:"name"`
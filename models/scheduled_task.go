@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+const (
+	TaskActive = "active"
+	TaskPaused = "paused"
+)
+
+// ScheduledTask is a recurring action a tenant has set up. Its cron
+// expression is read in Timezone; NextRunAt is kept in absolute time so the
+// dispatcher can find due tasks across tenants with one query.
+type ScheduledTask struct {
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	TenantID uint   `gorm:"index;not null" json:"tenant_id"`
+	Name     string `gorm:"type:varchar(100);not null" json:"name"`
+	Action   string `gorm:"type:varchar(50);not null" json:"action"`
+	Params   string `gorm:"type:text" json:"-"` // JSON, validated against the action
+	CronExpr string `gorm:"type:varchar(100);not null" json:"cron"`
+	Timezone string `gorm:"type:varchar(64);not null" json:"timezone"`
+	// Recipients are other users of the workspace who receive the result, by
+	// email, as a JSON array. The task's creator always receives it.
+	Recipients string     `gorm:"type:text" json:"-"`
+	Status     string     `gorm:"type:varchar(20);index;not null" json:"status"`
+	NextRunAt  *time.Time `gorm:"index" json:"next_run_at,omitempty"` // Nil while paused
+	LastRunAt  *time.Time `json:"last_run_at,omitempty"`
+	CreatedBy  uint       `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// ScheduledTaskRun is one execution of a task; the work itself is a job.
+type ScheduledTaskRun struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	TaskID       uint       `gorm:"not null;uniqueIndex:idx_task_run_slot" json:"task_id"`
+	TenantID     uint       `gorm:"index;not null" json:"tenant_id"`
+	ScheduledFor time.Time  `gorm:"not null;uniqueIndex:idx_task_run_slot" json:"scheduled_for"`
+	Manual       bool       `json:"manual"`
+	JobID        uint       `json:"job_id,omitempty"`
+	Status       string     `gorm:"type:varchar(20);not null" json:"status"` // A job state
+	Output       string     `gorm:"type:text" json:"output,omitempty"`
+	Error        string     `gorm:"type:text" json:"error,omitempty"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// StreamTicket records that a stream ticket was used, so that it opens one
+// stream and is reused only by that stream's reconnects.
+type StreamTicket struct {
+	ID         string     `gorm:"type:varchar(64);primaryKey" json:"id"` // The ticket's JWT ID
+	TenantID   uint       `gorm:"not null" json:"tenant_id"`
+	UserID     uint       `gorm:"not null" json:"user_id"`
+	OpenedAt   time.Time  `json:"opened_at"`
+	ReleasedAt *time.Time `json:"released_at,omitempty"` // When its stream last closed; nil while open
+	ExpiresAt  time.Time  `gorm:"index;not null" json:"expires_at"`
+}
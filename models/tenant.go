@@ -13,19 +13,73 @@ const (
 	DedicatedDB DatabaseType = "dedicated"
 )
 
+// TenantMode controls what a tenant's users may do, e.g. while a data
+// migration runs against its database.
+type TenantMode string
+
+const (
+	ModeNormal      TenantMode = "normal"
+	ModeReadOnly    TenantMode = "read_only"
+	ModeMaintenance TenantMode = "maintenance"
+)
+
+// BillingStatus follows a tenant through its trial and unpaid renewals.
+type BillingStatus string
+
+const (
+	BillingTrialing  BillingStatus = "trialing"
+	BillingActive    BillingStatus = "active"
+	BillingPastDue   BillingStatus = "past_due"  // Expired and unpaid; read-only during the grace period
+	BillingSuspended BillingStatus = "suspended" // Grace period over; IsActive is false
+)
+
 type Tenant struct {
-	ID           uint           `gorm:"primaryKey" json:"id"`
-	Name         string         `gorm:"type:varchar(255);uniqueIndex;not null" json:"name"`
-	DatabaseType DatabaseType   `gorm:"type:varchar(50);not null" json:"database_type"`
-	DBName       string         `gorm:"type:varchar(255);not null" json:"db_name"`
-	IsActive     bool           `gorm:"default:true" json:"is_active"`
-	APIKey       string         `gorm:"type:varchar(64);uniqueIndex" json:"api_key"`
-	PlanID       uint           `json:"plan_id"`
-	Plan         *Plan          `gorm:"foreignKey:PlanID" json:"plan,omitempty"`
-	PlanExpiry   *time.Time     `json:"plan_expiry,omitempty"` // Null for lifetime
-	CreatedAt    time.Time      `json:"created_at"`
-	UpdatedAt    time.Time      `json:"updated_at"`
-	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+	ID           uint         `gorm:"primaryKey" json:"id"`
+	Name         string       `gorm:"type:varchar(255);uniqueIndex;not null" json:"name"`
+	DatabaseType DatabaseType `gorm:"type:varchar(50);not null" json:"database_type"`
+	DBName       string       `gorm:"type:varchar(255);not null" json:"db_name"`
+	IsActive     bool         `gorm:"default:true" json:"is_active"`
+	APIKey       string       `gorm:"type:varchar(64);uniqueIndex" json:"api_key"`
+	Subdomain    *string      `gorm:"type:varchar(63);uniqueIndex" json:"subdomain,omitempty"`
+	PlanID       uint         `json:"plan_id"`
+	Plan         *Plan        `gorm:"foreignKey:PlanID" json:"plan,omitempty"`
+	PlanExpiry   *time.Time   `json:"plan_expiry,omitempty"` // Null for lifetime
+	// PendingPlanID is a plan change scheduled to take effect at the next renewal.
+	PendingPlanID *uint `json:"pending_plan_id,omitempty"`
+	// CreditBalance is owed to the tenant, e.g. unused time after a downgrade,
+	// and is deducted from the next invoice.
+	CreditBalance float64 `json:"credit_balance"`
+
+	BillingStatus BillingStatus `gorm:"type:varchar(20);default:active" json:"billing_status"`
+	TrialEndsAt   *time.Time    `json:"trial_ends_at,omitempty"`
+	// DunningStep counts the payment reminders already sent for the current renewal.
+	DunningStep int `gorm:"default:0" json:"-"`
+
+	// DBServerID pins a dedicated database to a registered server, e.g. to keep
+	// data in DataRegion. It takes precedence over the connection overrides below.
+	DBServerID *uint  `gorm:"index" json:"db_server_id,omitempty"`
+	DataRegion string `gorm:"type:varchar(50)" json:"data_region,omitempty"`
+
+	// Connection overrides for dedicated databases; empty values fall back to the
+	// server defaults from config.
+	DBDriver   string `gorm:"type:varchar(20)" json:"db_driver,omitempty"`
+	DBHost     string `gorm:"type:varchar(255)" json:"db_host,omitempty"`
+	DBPort     int    `json:"db_port,omitempty"`
+	DBUser     string `gorm:"type:varchar(100)" json:"-"`
+	DBPassword string `gorm:"type:varchar(255)" json:"-"`
+
+	IsSandbox        bool       `gorm:"default:false" json:"is_sandbox"`
+	SandboxSourceID  *uint      `gorm:"index" json:"sandbox_source_id,omitempty"`
+	SandboxExpiresAt *time.Time `json:"sandbox_expires_at,omitempty"`
+
+	Mode                TenantMode `gorm:"type:varchar(20);default:normal" json:"mode"`
+	MaintenanceMessage  string     `gorm:"type:varchar(500)" json:"maintenance_message,omitempty"`
+	MaintenanceStartsAt *time.Time `json:"maintenance_starts_at,omitempty"`
+	MaintenanceEndsAt   *time.Time `json:"maintenance_ends_at,omitempty"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 func (t *Tenant) GetActualDBName() string {
@@ -34,3 +88,26 @@ func (t *Tenant) GetActualDBName() string {
 	}
 	return t.DBName
 }
+
+// EffectiveMode is the mode in force at now: a scheduled maintenance window
+// overrides the stored mode while it is open.
+func (t *Tenant) EffectiveMode(now time.Time) TenantMode {
+	if t.MaintenanceStartsAt != nil && !now.Before(*t.MaintenanceStartsAt) &&
+		(t.MaintenanceEndsAt == nil || now.Before(*t.MaintenanceEndsAt)) {
+		return ModeMaintenance
+	}
+	if t.Mode == "" {
+		return ModeNormal
+	}
+	return t.Mode
+}
+
+// UpcomingMaintenance reports whether a maintenance window is scheduled to start after now.
+func (t *Tenant) UpcomingMaintenance(now time.Time) bool {
+	return t.MaintenanceStartsAt != nil && now.Before(*t.MaintenanceStartsAt)
+}
+
+// Sandboxes must never reach real customers, so email and webhooks are suppressed for them.
+func (t *Tenant) AllowsOutboundDelivery() bool {
+	return !t.IsSandbox
+}
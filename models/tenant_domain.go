@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// TenantDomain is a custom domain a tenant serves its workspace from. It only
+// resolves once the DNS TXT record carrying VerificationToken has been found.
+// Several tenants may claim a domain; only one can verify it.
+type TenantDomain struct {
+	ID                uint       `gorm:"primaryKey" json:"id"`
+	TenantID          uint       `gorm:"uniqueIndex:idx_domain_tenant,priority:2;index;not null" json:"tenant_id"`
+	Domain            string     `gorm:"type:varchar(255);uniqueIndex:idx_domain_tenant,priority:1;not null" json:"domain"`
+	VerificationToken string     `gorm:"type:varchar(64);not null" json:"verification_token"`
+	VerifiedAt        *time.Time `json:"verified_at,omitempty"`
+	// VerifiedDomain repeats Domain once verified, so that the unique index
+	// applies to verified domains only.
+	VerifiedDomain *string   `gorm:"type:varchar(255);uniqueIndex" json:"-"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+func (d *TenantDomain) IsVerified() bool {
+	return d.VerifiedAt != nil
+}
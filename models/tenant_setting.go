@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// TenantSetting holds a tenant's preferences as a JSON document. Version is
+// bumped on every change and lets clients detect concurrent edits.
+type TenantSetting struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	TenantID  uint      `gorm:"uniqueIndex;not null" json:"tenant_id"`
+	Version   int       `gorm:"not null;default:1" json:"version"`
+	Data      string    `gorm:"type:text;not null" json:"-"`
+	UpdatedBy uint      `json:"updated_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Metered resources. Gauges (users, products, storage) have an empty Period;
+// monthly counters use "YYYY-MM".
+const (
+	MetricUsers          = "users"
+	MetricProducts       = "products"
+	MetricStorageBytes   = "storage_bytes"
+	MetricAPICalls       = "api_calls"
+	MetricPurchaseOrders = "purchase_orders"
+)
+
+// TenantUsage is one usage counter. Limits are enforced with conditional
+// updates on Value, so concurrent requests cannot overshoot them.
+type TenantUsage struct {
+	ID       uint   `gorm:"primaryKey" json:"-"`
+	TenantID uint   `gorm:"uniqueIndex:idx_usage_metric;not null" json:"tenant_id"`
+	Metric   string `gorm:"type:varchar(40);uniqueIndex:idx_usage_metric;not null" json:"metric"`
+	Period   string `gorm:"type:varchar(7);uniqueIndex:idx_usage_metric;not null;default:''" json:"period,omitempty"`
+	Value    int64  `gorm:"not null;default:0" json:"value"`
+	// WarnedLevel is the highest warning (80 or 100 percent) already emitted for this counter.
+	WarnedLevel int       `gorm:"not null;default:0" json:"warned_level"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
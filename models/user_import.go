@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+const (
+	ImportPending   = "pending"
+	ImportRunning   = "running"
+	ImportCompleted = "completed"
+	ImportFailed    = "failed"
+)
+
+// UserImport tracks an asynchronous bulk import of users into a tenant.
+type UserImport struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	TenantID    uint   `gorm:"index;not null" json:"tenant_id"`
+	RequestedBy uint   `json:"requested_by"`
+	JobID       uint   `json:"job_id,omitempty"`
+	Format      string `gorm:"type:varchar(10);not null" json:"format"`
+	Status      string `gorm:"type:varchar(20);not null" json:"status"`
+	TotalRows   int    `json:"total_rows"`
+	Created     int    `json:"created"`
+	Failed      int    `json:"failed"`
+	// Rows holds the validated rows as JSON sealed with the app secret until
+	// the import finishes, since it carries passwords; Results holds the
+	// outcome of each row as JSON.
+	Rows        string     `gorm:"type:text" json:"-"`
+	Results     string     `gorm:"type:text" json:"-"`
+	Error       string     `gorm:"type:text" json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
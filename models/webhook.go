@@ -0,0 +1,63 @@
+package models
+
+import "time"
+
+const (
+	DeliveryPending    = "pending" // Queued or waiting for a retry
+	DeliverySucceeded  = "succeeded"
+	DeliveryFailed     = "failed" // Out of attempts
+	DeliverySuppressed = "suppressed"
+)
+
+// WebhookSubscription sends a tenant's events of the chosen types to a URL.
+// Deliveries are signed with Secret, which is shown only when it is created
+// or rotated.
+type WebhookSubscription struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	TenantID    uint   `gorm:"index;not null" json:"tenant_id"`
+	URL         string `gorm:"type:varchar(2048);not null" json:"url"`
+	Description string `gorm:"type:varchar(255)" json:"description"`
+	Events      string `gorm:"type:text;not null" json:"-"` // JSON array of event types
+	Secret      string `gorm:"type:varchar(100);not null" json:"-"`
+	IsActive    bool   `gorm:"default:true" json:"is_active"`
+	// Failed attempts since the last success; the subscription is disabled
+	// when this reaches the limit.
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	DisabledAt          *time.Time `json:"disabled_at,omitempty"`
+	DisabledReason      string     `gorm:"type:varchar(255)" json:"disabled_reason,omitempty"`
+	CreatedBy           uint       `json:"created_by"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+// WebhookDelivery is one event sent to one subscription. Each HTTP request
+// for it is logged as a WebhookAttempt.
+type WebhookDelivery struct {
+	ID             uint   `gorm:"primaryKey" json:"id"`
+	TenantID       uint   `gorm:"index;not null" json:"tenant_id"`
+	SubscriptionID uint   `gorm:"index;not null" json:"subscription_id"`
+	EventID        string `gorm:"type:varchar(40);index;not null" json:"event_id"` // Shared by redeliveries
+	Event          string `gorm:"type:varchar(100);not null" json:"event"`
+	Payload        string `gorm:"type:text;not null" json:"-"` // The JSON body sent
+	Status         string `gorm:"type:varchar(20);index;not null" json:"status"`
+	Attempts       int    `json:"attempts"`
+	ResponseCode   int    `json:"response_code,omitempty"` // Of the last attempt
+	JobID          uint   `json:"job_id,omitempty"`
+	// RedeliveryOf is the delivery this one was manually resent from.
+	RedeliveryOf *uint            `json:"redelivery_of,omitempty"`
+	DeliveredAt  *time.Time       `json:"delivered_at,omitempty"`
+	CreatedAt    time.Time        `json:"created_at"`
+	UpdatedAt    time.Time        `json:"updated_at"`
+	AttemptLog   []WebhookAttempt `gorm:"foreignKey:DeliveryID" json:"attempt_log,omitempty"`
+}
+
+type WebhookAttempt struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	DeliveryID   uint      `gorm:"index;not null" json:"delivery_id"`
+	Attempt      int       `json:"attempt"`
+	ResponseCode int       `json:"response_code,omitempty"` // 0 when no response was received
+	ResponseBody string    `gorm:"type:text" json:"response_body,omitempty"`
+	Error        string    `gorm:"type:text" json:"error,omitempty"`
+	DurationMs   int64     `json:"duration_ms"`
+	CreatedAt    time.Time `json:"created_at"`
+}
@@ -0,0 +1,91 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"go-multi-tenant/models"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// Broker hands out the IDs of due jobs. The jobs table stays authoritative: a
+// popped ID is only a hint and the worker still has to claim the row, so a
+// broker may lose or duplicate IDs without losing or double-running jobs.
+type Broker interface {
+	Name() string
+	// Push makes a job available from runAt on.
+	Push(jobID uint, runAt time.Time) error
+	// Pop returns the next due job ID, or 0 when nothing is due.
+	Pop() (uint, error)
+}
+
+// databaseBroker polls the jobs table. Lag only returns jobs that have been
+// due for a while, which lets it sweep up jobs another broker lost.
+type databaseBroker struct {
+	db  *gorm.DB
+	lag time.Duration
+}
+
+func NewDatabaseBroker(db *gorm.DB) Broker {
+	return &databaseBroker{db: db}
+}
+
+func (b *databaseBroker) Name() string { return "database" }
+
+func (b *databaseBroker) Push(jobID uint, runAt time.Time) error { return nil }
+
+func (b *databaseBroker) Pop() (uint, error) {
+	var ids []uint
+	err := b.db.Model(&models.Job{}).
+		Where("state = ? AND run_at <= ?", models.JobPending, time.Now().Add(-b.lag)).
+		Order("run_at, id").Limit(1).Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+const redisReadyKey = "jobs:ready"
+
+// popDueScript removes and returns the earliest job whose score (run time in
+// milliseconds) has passed, atomically so two workers never get the same ID.
+var popDueScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, 1)
+if #ids == 0 then return false end
+redis.call('ZREM', KEYS[1], ids[1])
+return ids[1]
+`)
+
+// redisBroker keeps due jobs in a sorted set scored by run time.
+type redisBroker struct {
+	client *redis.Client
+	ctx    context.Context
+}
+
+func NewRedisBroker(ctx context.Context, client *redis.Client) Broker {
+	return &redisBroker{client: client, ctx: ctx}
+}
+
+func (b *redisBroker) Name() string { return "redis" }
+
+func (b *redisBroker) Push(jobID uint, runAt time.Time) error {
+	return b.client.ZAdd(b.ctx, redisReadyKey, redis.Z{
+		Score:  float64(runAt.UnixMilli()),
+		Member: strconv.FormatUint(uint64(jobID), 10),
+	}).Err()
+}
+
+func (b *redisBroker) Pop() (uint, error) {
+	res, err := popDueScript.Run(b.ctx, b.client, []string{redisReadyKey}, time.Now().UnixMilli()).Text()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(res, 10, 64)
+	return uint(id), err
+}
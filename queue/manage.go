@@ -0,0 +1,124 @@
+package queue
+
+import (
+	"go-multi-tenant/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Cancel stops a pending job from running. It reports false when the job is
+// no longer pending.
+func (q *Queue) Cancel(jobID uint) (bool, error) {
+	res := q.db.Model(&models.Job{}).
+		Where("id = ? AND state = ?", jobID, models.JobPending).
+		Updates(map[string]interface{}{"state": models.JobCancelled, "finished_at": time.Now()})
+	return res.RowsAffected > 0, res.Error
+}
+
+// Retry gives a dead-lettered job a fresh set of attempts. It reports false
+// when the job is not dead.
+func (q *Queue) Retry(jobID uint) (bool, error) {
+	now := time.Now()
+	res := q.db.Model(&models.Job{}).
+		Where("id = ? AND state = ?", jobID, models.JobDead).
+		Updates(map[string]interface{}{
+			"state":       models.JobPending,
+			"attempts":    0,
+			"run_at":      now,
+			"finished_at": nil,
+		})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return false, res.Error
+	}
+	q.push(jobID, now)
+	return true, nil
+}
+
+type TypeStats struct {
+	Type    string `json:"type"`
+	Pending int64  `json:"pending"`
+	Running int64  `json:"running"`
+	Dead    int64  `json:"dead"`
+}
+
+type Stats struct {
+	Broker string `json:"broker"`
+	// Workers and BusyWorkers describe this instance; ActiveWorkers counts
+	// workers holding a job across all instances.
+	Workers       int   `json:"workers"`
+	BusyWorkers   int   `json:"busy_workers"`
+	ActiveWorkers int64 `json:"active_workers"`
+
+	Due       int64 `json:"due"`       // Pending and ready to run
+	Scheduled int64 `json:"scheduled"` // Pending with a future run time, including retries
+	Running   int64 `json:"running"`
+	Dead      int64 `json:"dead"`
+	// OldestDueSeconds is how long the oldest due job has been waiting.
+	OldestDueSeconds float64 `json:"oldest_due_seconds"`
+
+	CompletedLastMinute int64 `json:"completed_last_minute"`
+	CompletedLastHour   int64 `json:"completed_last_hour"`
+	FailedLastHour      int64 `json:"failed_last_hour"` // Moved to dead-letter
+
+	ByType []TypeStats `json:"by_type"`
+}
+
+// Stats reports queue depth and throughput from the jobs table.
+func (q *Queue) Stats() (*Stats, error) {
+	now := time.Now()
+	stats := &Stats{
+		Broker:      q.broker.Name(),
+		Workers:     q.opts.Workers,
+		BusyWorkers: int(q.busy.Load()),
+	}
+	jobs := func() *gorm.DB { return q.db.Model(&models.Job{}) }
+
+	counts := []struct {
+		dest  *int64
+		query string
+		args  []interface{}
+	}{
+		{&stats.Due, "state = ? AND run_at <= ?", []interface{}{models.JobPending, now}},
+		{&stats.Scheduled, "state = ? AND run_at > ?", []interface{}{models.JobPending, now}},
+		{&stats.Running, "state = ?", []interface{}{models.JobRunning}},
+		{&stats.Dead, "state = ?", []interface{}{models.JobDead}},
+		{&stats.CompletedLastMinute, "state = ? AND finished_at >= ?", []interface{}{models.JobCompleted, now.Add(-time.Minute)}},
+		{&stats.CompletedLastHour, "state = ? AND finished_at >= ?", []interface{}{models.JobCompleted, now.Add(-time.Hour)}},
+		{&stats.FailedLastHour, "state = ? AND finished_at >= ?", []interface{}{models.JobDead, now.Add(-time.Hour)}},
+	}
+	for _, c := range counts {
+		if err := jobs().Where(c.query, c.args...).Count(c.dest).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if err := jobs().Where("state = ?", models.JobRunning).
+		Distinct("locked_by").Count(&stats.ActiveWorkers).Error; err != nil {
+		return nil, err
+	}
+
+	var oldest models.Job
+	err := jobs().Where("state = ? AND run_at <= ?", models.JobPending, now).
+		Order("run_at").Limit(1).Find(&oldest).Error
+	if err != nil {
+		return nil, err
+	}
+	if oldest.ID != 0 {
+		stats.OldestDueSeconds = now.Sub(oldest.RunAt).Seconds()
+	}
+
+	err = jobs().
+		Select("type, "+
+			"SUM(CASE WHEN state = ? THEN 1 ELSE 0 END) AS pending, "+
+			"SUM(CASE WHEN state = ? THEN 1 ELSE 0 END) AS running, "+
+			"SUM(CASE WHEN state = ? THEN 1 ELSE 0 END) AS dead",
+			models.JobPending, models.JobRunning, models.JobDead).
+		Where("state IN ?", []string{models.JobPending, models.JobRunning, models.JobDead}).
+		Group("type").Order("type").
+		Scan(&stats.ByType).Error
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
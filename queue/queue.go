@@ -1,183 +1,488 @@
-// package queue
-
-// import (
-// 	"sync"
-// 	"time"
-// )
-
-// type JobStatus string
-
-// const (
-// 	JobStatusPending   JobStatus = "pending"
-// 	JobStatusCompleted JobStatus = "completed"
-// 	JobStatusFailed    JobStatus = "failed"
-// )
-
-// type Job struct {
-// 	ID        string    `json:"id"`
-// 	TenantID  uint      `json:"tenant_id"`
-// 	Data      string    `json:"data"`
-// 	Status    JobStatus `json:"status"`
-// 	Result    string    `json:"result,omitempty"`
-// 	Error     string    `json:"error,omitempty"`
-// 	CreatedAt time.Time `json:"created_at"`
-// 	UpdatedAt time.Time `json:"updated_at"`
-// }
-
-// type Queue struct {
-// 	jobs         []Job
-// 	completed    []Job
-// 	mu           sync.Mutex
-// 	notEmpty     *sync.Cond
-// 	maxCompleted int
-// }
-
-// func NewQueue() *Queue {
-// 	q := &Queue{
-// 		jobs:         make([]Job, 0),
-// 		completed:    make([]Job, 0),
-// 		maxCompleted: 1000,
-// 	}
-// 	q.notEmpty = sync.NewCond(&q.mu)
-
-// 	go q.startCleanupWorker()
-
-// 	return q
-// }
-
-// func (q *Queue) Enqueue(job Job) {
-// 	q.mu.Lock()
-// 	defer q.mu.Unlock()
-
-// 	job.Status = JobStatusPending
-// 	job.CreatedAt = time.Now()
-// 	job.UpdatedAt = time.Now()
-
-// 	q.jobs = append(q.jobs, job)
-// 	q.notEmpty.Signal()
-// }
-
-// func (q *Queue) Dequeue() (Job, bool) {
-// 	q.mu.Lock()
-// 	defer q.mu.Unlock()
-
-// 	for len(q.jobs) == 0 {
-// 		q.notEmpty.Wait()
-// 	}
-
-// 	job := q.jobs[0]
-// 	q.jobs = q.jobs[1:]
-// 	return job, true
-// }
-
-// func (q *Queue) MarkCompleted(jobID string, result string, err error) {
-// 	q.mu.Lock()
-// 	defer q.mu.Unlock()
-
-// 	for i, job := range q.jobs {
-// 		if job.ID == jobID {
-
-// 			q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
-// 			break
-// 		}
-// 	}
-
-// 	completedJob := Job{
-// 		ID:        jobID,
-// 		Status:    JobStatusCompleted,
-// 		Result:    result,
-// 		UpdatedAt: time.Now(),
-// 	}
-
-// 	if err != nil {
-// 		completedJob.Status = JobStatusFailed
-// 		completedJob.Error = err.Error()
-// 	}
-
-// 	q.completed = append(q.completed, completedJob)
-
-// 	if len(q.completed) > q.maxCompleted {
-// 		q.completed = q.completed[len(q.completed)-q.maxCompleted:]
-// 	}
-// }
-
-// func (q *Queue) GetJobStatus(jobID string) (Job, bool) {
-// 	q.mu.Lock()
-// 	defer q.mu.Unlock()
-
-// 	for _, job := range q.jobs {
-// 		if job.ID == jobID {
-// 			return job, true
-// 		}
-// 	}
-
-// 	for _, job := range q.completed {
-// 		if job.ID == jobID {
-// 			return job, true
-// 		}
-// 	}
-
-// 	return Job{}, false
-// }
-
-// func (q *Queue) GetAllJobs() map[string][]Job {
-// 	q.mu.Lock()
-// 	defer q.mu.Unlock()
-
-// 	return map[string][]Job{
-// 		"pending":   q.jobs,
-// 		"completed": q.completed,
-// 	}
-// }
-
-// func (q *Queue) Size() int {
-// 	q.mu.Lock()
-// 	defer q.mu.Unlock()
-// 	return len(q.jobs)
-// }
-
-// func (q *Queue) CompletedSize() int {
-// 	q.mu.Lock()
-// 	defer q.mu.Unlock()
-// 	return len(q.completed)
-// }
-
-// func (q *Queue) IsEmpty() bool {
-// 	q.mu.Lock()
-// 	defer q.mu.Unlock()
-// 	return len(q.jobs) == 0
-// }
-
-// func (q *Queue) CleanupCompletedJobs() int {
-// 	q.mu.Lock()
-// 	defer q.mu.Unlock()
-
-// 	cutoffTime := time.Now().Add(-1 * time.Hour)
-// 	initialCount := len(q.completed)
-
-// 	filtered := make([]Job, 0)
-// 	for _, job := range q.completed {
-// 		if job.UpdatedAt.After(cutoffTime) {
-// 			filtered = append(filtered, job)
-// 		}
-// 	}
-
-// 	q.completed = filtered
-// 	cleanedCount := initialCount - len(q.completed)
-
-// 	return cleanedCount
-// }
-
-// func (q *Queue) startCleanupWorker() {
-// 	ticker := time.NewTicker(30 * time.Minute)
-// 	defer ticker.Stop()
-
-// 	for range ticker.C {
-// 		cleaned := q.CleanupCompletedJobs()
-// 		if cleaned > 0 {
-
-//				println("Queue cleanup: removed", cleaned, "completed jobs older than 1 hour")
-//			}
-//		}
-//	}
 package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-multi-tenant/models"
+	"log"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// A broker that is up but lost a push is covered by sweeping the table for
+// jobs that have been due at least this long.
+const orphanLag = 30 * time.Second
+
+type Options struct {
+	Workers      int
+	PollInterval time.Duration
+	// VisibilityTimeout is how long a job stays claimed without a heartbeat
+	// before it is re-delivered to another worker.
+	VisibilityTimeout time.Duration
+	MaxAttempts       int
+	BackoffBase       time.Duration
+	BackoffMax        time.Duration
+}
+
+func (o *Options) setDefaults() {
+	if o.Workers <= 0 {
+		o.Workers = 4
+	}
+	if o.PollInterval <= 0 {
+		o.PollInterval = time.Second
+	}
+	if o.VisibilityTimeout <= 0 {
+		o.VisibilityTimeout = 5 * time.Minute
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 5
+	}
+	if o.BackoffBase <= 0 {
+		o.BackoffBase = 10 * time.Second
+	}
+	if o.BackoffMax <= 0 {
+		o.BackoffMax = time.Hour
+	}
+}
+
+type EnqueueOptions struct {
+	TenantID    uint
+	CreatedBy   uint
+	RunAt       time.Time // Zero means now
+	MaxAttempts int       // Zero uses the queue default
+}
+
+// Handler runs one job. The result is stored as JSON on success. ctx is
+// cancelled when the worker loses the job or is forced to shut down.
+type Handler func(ctx context.Context, job *models.Job) (interface{}, error)
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks an error that retrying cannot fix, such as invalid input;
+// the job goes to the dead-letter state straight away.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent.
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+type Queue struct {
+	db     *gorm.DB
+	broker Broker
+	// direct replaces the broker while it fails; sweep finds jobs it lost.
+	direct     Broker
+	sweep      Broker
+	brokerDown atomic.Bool
+
+	opts     Options
+	handlers map[string]Handler
+	busy     atomic.Int32
+	mu       sync.RWMutex
+
+	workerPrefix string
+	stop         chan struct{}
+	ctx          context.Context
+	cancel       context.CancelFunc
+	wg           sync.WaitGroup
+}
+
+func New(db *gorm.DB, broker Broker, opts Options) *Queue {
+	opts.setDefaults()
+	host, _ := os.Hostname()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	q := &Queue{
+		db:           db,
+		broker:       broker,
+		opts:         opts,
+		handlers:     map[string]Handler{},
+		workerPrefix: fmt.Sprintf("%s:%d", host, os.Getpid()),
+		stop:         make(chan struct{}),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+	if _, ok := broker.(*databaseBroker); !ok {
+		q.direct = &databaseBroker{db: db}
+		q.sweep = &databaseBroker{db: db, lag: orphanLag}
+	}
+	return q
+}
+
+func (q *Queue) BrokerName() string { return q.broker.Name() }
+
+func (q *Queue) Options() Options { return q.opts }
+
+func (q *Queue) Register(jobType string, handler Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[jobType] = handler
+}
+
+func (q *Queue) handler(jobType string) Handler {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.handlers[jobType]
+}
+
+// Handle registers a handler that receives the job's payload decoded into T.
+// A payload that does not decode fails the job permanently.
+func Handle[T any](q *Queue, jobType string, fn func(ctx context.Context, job *models.Job, payload T) (interface{}, error)) {
+	q.Register(jobType, func(ctx context.Context, job *models.Job) (interface{}, error) {
+		var payload T
+		if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+			return nil, Permanent(fmt.Errorf("invalid payload: %w", err))
+		}
+		return fn(ctx, job, payload)
+	})
+}
+
+// Enqueue stores a job and hands it to the broker.
+func (q *Queue) Enqueue(jobType string, payload interface{}, opts EnqueueOptions) (*models.Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	if opts.RunAt.IsZero() {
+		opts.RunAt = time.Now()
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = q.opts.MaxAttempts
+	}
+
+	job := &models.Job{
+		Type:        jobType,
+		TenantID:    opts.TenantID,
+		CreatedBy:   opts.CreatedBy,
+		Payload:     string(data),
+		State:       models.JobPending,
+		MaxAttempts: opts.MaxAttempts,
+		RunAt:       opts.RunAt,
+	}
+	if err := q.db.Create(job).Error; err != nil {
+		return nil, err
+	}
+	q.push(job.ID, job.RunAt)
+	return job, nil
+}
+
+func (q *Queue) push(jobID uint, runAt time.Time) {
+	if err := q.broker.Push(jobID, runAt); err != nil {
+		log.Printf("Queue: %s broker push failed for job %d, the database sweep will pick it up: %v", q.broker.Name(), jobID, err)
+	}
+}
+
+// Start launches the workers and the reaper that re-delivers jobs whose
+// visibility timeout lapsed.
+func (q *Queue) Start() {
+	for i := 1; i <= q.opts.Workers; i++ {
+		q.wg.Add(1)
+		go q.worker(fmt.Sprintf("%s-%d", q.workerPrefix, i))
+	}
+	q.wg.Add(1)
+	go q.reaper()
+	log.Printf("Queue: started %d workers on the %s broker", q.opts.Workers, q.broker.Name())
+}
+
+// Shutdown stops taking new jobs and waits for running ones. If ctx expires
+// first, running handlers are cancelled and their jobs released for another
+// worker without using up an attempt.
+func (q *Queue) Shutdown(ctx context.Context) error {
+	close(q.stop)
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Println("Queue: all workers stopped")
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			log.Println("Queue: some handlers ignored cancellation; their jobs will be re-delivered")
+		}
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) stopping() bool {
+	select {
+	case <-q.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *Queue) worker(workerID string) {
+	defer q.wg.Done()
+
+	for !q.stopping() {
+		job := q.next(workerID)
+		if job == nil {
+			select {
+			case <-q.stop:
+				return
+			case <-time.After(q.opts.PollInterval):
+			}
+			continue
+		}
+		q.run(workerID, job)
+	}
+}
+
+// next claims the next due job, falling back to the database while the
+// broker is unreachable.
+func (q *Queue) next(workerID string) *models.Job {
+	// A lost race for a row is retried a few times before backing off.
+	for try := 0; try < 3; try++ {
+		jobID, err := q.broker.Pop()
+		if err != nil {
+			if !q.brokerDown.Swap(true) {
+				log.Printf("Queue: %s broker unavailable, falling back to the database: %v", q.broker.Name(), err)
+			}
+			jobID, err = q.direct.Pop()
+		} else if q.brokerDown.Swap(false) {
+			log.Printf("Queue: %s broker is back", q.broker.Name())
+		}
+		if err == nil && jobID == 0 && q.sweep != nil {
+			jobID, err = q.sweep.Pop()
+		}
+		if err != nil {
+			log.Printf("Queue: failed to fetch jobs: %v", err)
+			return nil
+		}
+		if jobID == 0 {
+			return nil
+		}
+		if job := q.claim(jobID, workerID); job != nil {
+			return job
+		}
+	}
+	return nil
+}
+
+// claim takes a pending, due job for workerID. Only one worker can win it.
+func (q *Queue) claim(jobID uint, workerID string) *models.Job {
+	now := time.Now()
+	lockedUntil := now.Add(q.opts.VisibilityTimeout)
+
+	res := q.db.Model(&models.Job{}).
+		Where("id = ? AND state = ? AND run_at <= ?", jobID, models.JobPending, now).
+		Updates(map[string]interface{}{
+			"state":        models.JobRunning,
+			"attempts":     gorm.Expr("attempts + 1"),
+			"locked_until": lockedUntil,
+			"locked_by":    workerID,
+			"started_at":   now,
+		})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return nil
+	}
+
+	var job models.Job
+	if err := q.db.First(&job, jobID).Error; err != nil {
+		return nil
+	}
+	return &job
+}
+
+func (q *Queue) run(workerID string, job *models.Job) {
+	q.busy.Add(1)
+	defer q.busy.Add(-1)
+
+	handler := q.handler(job.Type)
+	if handler == nil {
+		q.finish(workerID, job, nil, Permanent(fmt.Errorf("no handler registered for job type %q", job.Type)))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(q.ctx)
+	defer cancel()
+	go q.heartbeat(ctx, cancel, workerID, job.ID)
+
+	result, err := safeCall(ctx, handler, job)
+	q.finish(workerID, job, result, err)
+}
+
+func safeCall(ctx context.Context, handler Handler, job *models.Job) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// heartbeat keeps extending the job's visibility timeout while the handler
+// runs. If the row is no longer ours (cancelled, or re-delivered after a
+// stall) the handler's context is cancelled.
+func (q *Queue) heartbeat(ctx context.Context, cancel context.CancelFunc, workerID string, jobID uint) {
+	ticker := time.NewTicker(q.opts.VisibilityTimeout / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			res := q.db.Model(&models.Job{}).
+				Where("id = ? AND state = ? AND locked_by = ?", jobID, models.JobRunning, workerID).
+				Update("locked_until", time.Now().Add(q.opts.VisibilityTimeout))
+			if res.Error == nil && res.RowsAffected == 0 {
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+func (q *Queue) finish(workerID string, job *models.Job, result interface{}, err error) {
+	now := time.Now()
+	updates := map[string]interface{}{"locked_until": nil, "locked_by": ""}
+
+	var permanent *permanentError
+	retryAt := time.Time{}
+	switch {
+	case err == nil:
+		data, marshalErr := json.Marshal(result)
+		if marshalErr != nil {
+			data, _ = json.Marshal(fmt.Sprintf("%v", result))
+		}
+		updates["state"] = models.JobCompleted
+		updates["result"] = string(data)
+		updates["last_error"] = ""
+		updates["finished_at"] = now
+	case q.ctx.Err() != nil:
+		// Forced shutdown: hand the job back untouched.
+		updates["state"] = models.JobPending
+		updates["run_at"] = now
+		updates["attempts"] = gorm.Expr("attempts - 1")
+		updates["last_error"] = "interrupted by shutdown"
+		retryAt = now
+	case !errors.As(err, &permanent) && job.Attempts < job.MaxAttempts:
+		retryAt = now.Add(q.backoff(job.Attempts))
+		updates["state"] = models.JobPending
+		updates["run_at"] = retryAt
+		updates["last_error"] = err.Error()
+	default:
+		updates["state"] = models.JobDead
+		updates["last_error"] = err.Error()
+		updates["finished_at"] = now
+	}
+
+	res := q.db.Model(&models.Job{}).
+		Where("id = ? AND state = ? AND locked_by = ?", job.ID, models.JobRunning, workerID).
+		Updates(updates)
+	if res.Error != nil {
+		log.Printf("Queue: failed to record the outcome of job %d: %v", job.ID, res.Error)
+		return
+	}
+	if res.RowsAffected == 0 {
+		log.Printf("Queue: job %d (%s) was taken from worker %s before it finished; outcome discarded", job.ID, job.Type, workerID)
+		return
+	}
+
+	switch updates["state"] {
+	case models.JobCompleted:
+	case models.JobPending:
+		q.push(job.ID, retryAt)
+		log.Printf("Queue: job %d (%s) attempt %d/%d failed, retrying at %s: %v",
+			job.ID, job.Type, job.Attempts, job.MaxAttempts, retryAt.Format(time.RFC3339), err)
+	case models.JobDead:
+		log.Printf("Queue: job %d (%s) moved to the dead-letter queue after %d attempts: %v", job.ID, job.Type, job.Attempts, err)
+	}
+}
+
+// backoff grows exponentially with the attempt number, with up to 20% jitter
+// so that jobs failing together do not retry in lockstep.
+func (q *Queue) backoff(attempt int) time.Duration {
+	delay := q.opts.BackoffBase
+	for i := 1; i < attempt && delay < q.opts.BackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > q.opts.BackoffMax {
+		delay = q.opts.BackoffMax
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
+
+func (q *Queue) reaper() {
+	defer q.wg.Done()
+
+	interval := q.opts.VisibilityTimeout / 2
+	if interval > 30*time.Second {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.stop:
+			return
+		case <-ticker.C:
+			q.reapExpired()
+		}
+	}
+}
+
+// reapExpired re-delivers running jobs whose worker stopped heartbeating,
+// or dead-letters them if they have no attempts left.
+func (q *Queue) reapExpired() {
+	now := time.Now()
+
+	var expired []models.Job
+	if err := q.db.Where("state = ? AND locked_until < ?", models.JobRunning, now).Find(&expired).Error; err != nil {
+		log.Printf("Queue: failed to look for stalled jobs: %v", err)
+		return
+	}
+
+	for _, job := range expired {
+		updates := map[string]interface{}{
+			"state":        models.JobPending,
+			"run_at":       now,
+			"locked_until": nil,
+			"locked_by":    "",
+			"last_error":   "worker stopped responding (visibility timeout)",
+		}
+		if job.Attempts >= job.MaxAttempts {
+			updates["state"] = models.JobDead
+			updates["finished_at"] = now
+		}
+
+		res := q.db.Model(&models.Job{}).
+			Where("id = ? AND state = ? AND locked_until < ?", job.ID, models.JobRunning, now).
+			Updates(updates)
+		if res.Error != nil || res.RowsAffected == 0 {
+			continue
+		}
+		if updates["state"] == models.JobPending {
+			q.push(job.ID, now)
+			log.Printf("Queue: job %d (%s) stalled on %s, re-delivering", job.ID, job.Type, job.LockedBy)
+		} else {
+			log.Printf("Queue: job %d (%s) stalled on its last attempt, moved to the dead-letter queue", job.ID, job.Type)
+		}
+	}
+}
@@ -24,6 +24,29 @@ func SetupRoutes(router *gin.Engine) {
 	roleService := services.NewRoleService()
 	purchaseService := services.NewPurchaseService()
 	purchaseHandler := handlers.NewPurchaseHandler(purchaseService)
+	sandboxService := services.NewSandboxService()
+	sandboxHandler := handlers.NewSandboxHandler(sandboxService)
+	tenantPoolHandler := handlers.NewTenantPoolHandler()
+	dbServerHandler := handlers.NewDBServerHandler(services.NewDBServerService())
+	domainService := services.NewDomainService()
+	domainHandler := handlers.NewDomainHandler(domainService)
+	settingsService := services.NewSettingsService()
+	settingsHandler := handlers.NewSettingsHandler(settingsService)
+	privacyHandler := handlers.NewPrivacyHandler(services.NewPrivacyService())
+	planHandler := handlers.NewPlanHandler(services.NewPlanService())
+	usageHandler := handlers.NewUsageHandler(services.NewUsageService())
+	billingHandler := handlers.NewBillingHandler(services.NewBillingService())
+	couponHandler := handlers.NewCouponHandler(services.NewCouponService())
+	jobHandler := handlers.NewJobHandler(services.NewQueueService())
+	adminJobHandler := handlers.NewAdminJobHandler(services.NewQueueService())
+	cronHandler := handlers.NewCronHandler(services.NewCronService())
+	scheduledTaskHandler := handlers.NewScheduledTaskHandler(services.NewScheduledTaskService())
+	userImportHandler := handlers.NewUserImportHandler(services.NewUserImportService())
+	mailHandler := handlers.NewMailHandler(services.NewMailService())
+	adminMailHandler := handlers.NewAdminMailHandler(services.NewMailService())
+	webhookHandler := handlers.NewWebhookHandler(services.NewWebhookService())
+	notificationHandler := handlers.NewNotificationHandler(services.NewNotificationService())
+	streamHandler := handlers.NewStreamHandler(services.NewStreamService())
 
 	authHandler := handlers.NewAuthHandler(authService)
 	tenantHandler := handlers.NewTenantHandler(tenantService)
@@ -35,20 +58,35 @@ func SetupRoutes(router *gin.Engine) {
 	permHandler := handlers.NewPermissionHandler(permissionService)
 
 	api := router.Group("/api/v1")
+	api.Use(middleware.HostTenantMiddleware())
 
 	api.POST("/login", authHandler.Login)
+	api.GET("/login-context", domainHandler.LoginContext)
 
 	protected := api.Group("/")
 	protected.Use(middleware.AuthMiddleware())
 	protected.Use(middleware.TenantDBMiddleware())
+	protected.Use(middleware.APIUsageMiddleware())
+
+	// Real-time events; EventSource clients authenticate with a ticket.
+	stream := api.Group("/stream")
+	stream.Use(middleware.StreamAuthMiddleware())
+	stream.Use(middleware.TenantDBMiddleware())
+	stream.Use(middleware.APIUsageMiddleware())
+	stream.GET("", streamHandler.Stream)
+	protected.POST("/stream/ticket", streamHandler.Ticket)
 
 	users := protected.Group("/users")
 	{
 		users.POST("", middleware.PermissionMiddleware("user:create"), userHandler.CreateUser)
+		users.POST("/import", middleware.PermissionMiddleware("user:create"), userImportHandler.Create)
+		users.GET("/imports", middleware.PermissionMiddleware("user:create"), userImportHandler.List)
+		users.GET("/imports/:id", middleware.PermissionMiddleware("user:create"), userImportHandler.Get)
 		users.GET("", middleware.PermissionMiddleware("user:read"), userHandler.ListUsers)
 		users.GET("/:id", middleware.PermissionMiddleware("user:read"), userHandler.GetUser)
 		users.PUT("/:id", middleware.PermissionMiddleware("user:update"), userHandler.UpdateUser)
 		users.DELETE("/:id", middleware.PermissionMiddleware("user:delete"), userHandler.DeleteUser)
+		users.POST("/:id/erase", middleware.PermissionMiddleware("data:erase"), privacyHandler.EraseUser)
 	}
 
 	products := protected.Group("/products")
@@ -85,6 +123,53 @@ func SetupRoutes(router *gin.Engine) {
 
 	protected.POST("/tenants", middleware.PermissionMiddleware("tenant:create"), tenantHandler.CreateTenant)
 	protected.GET("/tenants", middleware.PermissionMiddleware("tenant:manage"), tenantHandler.ListTenants)
+	protected.PUT("/tenants/:id/mode", middleware.PermissionMiddleware("tenant:manage"), tenantHandler.SetMode)
+	protected.GET("/tenants/:id/subscription", middleware.PermissionMiddleware("plan:manage"), planHandler.GetSubscription)
+	protected.PUT("/tenants/:id/subscription", middleware.PermissionMiddleware("plan:manage"), planHandler.ChangePlan)
+	protected.PUT("/tenants/:id/subscription/expiry", middleware.PermissionMiddleware("plan:manage"), planHandler.SetPlanExpiry)
+	protected.GET("/tenants/:id/invoices", middleware.PermissionMiddleware("plan:manage"), billingHandler.ListInvoices)
+	protected.POST("/tenants/:id/invoices/:invoice_id/payments", middleware.PermissionMiddleware("plan:manage"), billingHandler.RecordPayment)
+
+	plans := protected.Group("/plans")
+	{
+		plans.GET("", planHandler.List)
+		plans.POST("", middleware.PermissionMiddleware("plan:manage"), planHandler.Create)
+		plans.GET("/:id", middleware.PermissionMiddleware("plan:manage"), planHandler.Get)
+		plans.PUT("/:id", middleware.PermissionMiddleware("plan:manage"), planHandler.Update)
+		plans.DELETE("/:id", middleware.PermissionMiddleware("plan:manage"), planHandler.Delete)
+	}
+
+	protected.GET("/usage", middleware.PermissionMiddleware("subscription:manage"), usageHandler.GetUsage)
+
+	subscription := protected.Group("/subscription")
+	{
+		subscription.GET("", middleware.PermissionMiddleware("subscription:manage"), planHandler.GetSubscription)
+		subscription.PUT("", middleware.PermissionMiddleware("subscription:manage"), planHandler.ChangePlan)
+		subscription.POST("/coupon", middleware.PermissionMiddleware("subscription:manage"), tenantHandler.RedeemCoupon)
+	}
+
+	coupons := protected.Group("/coupons")
+	{
+		coupons.POST("", middleware.PermissionMiddleware("plan:manage"), couponHandler.Create)
+		coupons.GET("", middleware.PermissionMiddleware("plan:manage"), couponHandler.List)
+		coupons.GET("/:id", middleware.PermissionMiddleware("plan:manage"), couponHandler.Get)
+		coupons.PUT("/:id", middleware.PermissionMiddleware("plan:manage"), couponHandler.Update)
+		coupons.GET("/:id/redemptions", middleware.PermissionMiddleware("plan:manage"), couponHandler.Redemptions)
+	}
+
+	invoices := protected.Group("/invoices")
+	{
+		invoices.GET("", middleware.PermissionMiddleware("subscription:manage"), billingHandler.ListInvoices)
+		invoices.GET("/:id", middleware.PermissionMiddleware("subscription:manage"), billingHandler.GetInvoice)
+		invoices.POST("/:id/pay", middleware.PermissionMiddleware("subscription:manage"), billingHandler.PayInvoice)
+	}
+
+	sandboxes := protected.Group("/sandboxes")
+	{
+		sandboxes.POST("", middleware.PermissionMiddleware("sandbox:manage"), sandboxHandler.Create)
+		sandboxes.GET("", middleware.PermissionMiddleware("sandbox:manage"), sandboxHandler.List)
+		sandboxes.DELETE("/:id", middleware.PermissionMiddleware("sandbox:manage"), sandboxHandler.Delete)
+	}
 
 	modules := protected.Group("/modules")
 	{
@@ -103,6 +188,108 @@ func SetupRoutes(router *gin.Engine) {
 		perms.DELETE("/:id", middleware.PermissionMiddleware("system:manage"), permHandler.Delete)
 	}
 
+	domains := protected.Group("/domains")
+	{
+		domains.POST("", middleware.PermissionMiddleware("domain:manage"), domainHandler.Create)
+		domains.GET("", middleware.PermissionMiddleware("domain:manage"), domainHandler.List)
+		domains.POST("/:id/verify", middleware.PermissionMiddleware("domain:manage"), domainHandler.Verify)
+		domains.DELETE("/:id", middleware.PermissionMiddleware("domain:manage"), domainHandler.Delete)
+	}
+
+	exports := protected.Group("/exports")
+	{
+		exports.POST("", middleware.PermissionMiddleware("data:export"), privacyHandler.RequestExport)
+		exports.GET("", middleware.PermissionMiddleware("data:export"), privacyHandler.ListExports)
+		exports.GET("/:id", middleware.PermissionMiddleware("data:export"), privacyHandler.GetExport)
+		exports.GET("/:id/download", middleware.PermissionMiddleware("data:export"), privacyHandler.DownloadExport)
+	}
+
+	jobs := protected.Group("/jobs")
+	{
+		jobs.GET("", middleware.PermissionMiddleware("job:view"), jobHandler.List)
+		jobs.GET("/:id", middleware.PermissionMiddleware("job:view"), jobHandler.Get)
+		jobs.POST("/:id/cancel", middleware.PermissionMiddleware("job:manage"), jobHandler.Cancel)
+		jobs.POST("/:id/retry", middleware.PermissionMiddleware("job:manage"), jobHandler.Retry)
+	}
+
+	tasks := protected.Group("/scheduled-tasks")
+	{
+		tasks.GET("/actions", middleware.PermissionMiddleware("task:manage"), scheduledTaskHandler.Actions)
+		tasks.GET("", middleware.PermissionMiddleware("task:manage"), scheduledTaskHandler.List)
+		tasks.POST("", middleware.PermissionMiddleware("task:manage"), scheduledTaskHandler.Create)
+		tasks.GET("/:id", middleware.PermissionMiddleware("task:manage"), scheduledTaskHandler.Get)
+		tasks.PUT("/:id", middleware.PermissionMiddleware("task:manage"), scheduledTaskHandler.Update)
+		tasks.DELETE("/:id", middleware.PermissionMiddleware("task:manage"), scheduledTaskHandler.Delete)
+		tasks.POST("/:id/pause", middleware.PermissionMiddleware("task:manage"), scheduledTaskHandler.Pause)
+		tasks.POST("/:id/resume", middleware.PermissionMiddleware("task:manage"), scheduledTaskHandler.Resume)
+		tasks.POST("/:id/run", middleware.PermissionMiddleware("task:manage"), scheduledTaskHandler.RunNow)
+		tasks.GET("/:id/runs", middleware.PermissionMiddleware("task:manage"), scheduledTaskHandler.Runs)
+	}
+
+	settings := protected.Group("/settings")
+	{
+		settings.GET("", middleware.PermissionMiddleware("settings:manage"), settingsHandler.Get)
+		settings.PATCH("", middleware.PermissionMiddleware("settings:manage"), settingsHandler.Update)
+	}
+
+	emailTemplates := protected.Group("/email-templates")
+	{
+		emailTemplates.GET("", middleware.PermissionMiddleware("settings:manage"), mailHandler.ListTemplates)
+		emailTemplates.GET("/:name", middleware.PermissionMiddleware("settings:manage"), mailHandler.GetTemplate)
+		emailTemplates.PUT("/:name", middleware.PermissionMiddleware("settings:manage"), mailHandler.SaveTemplate)
+		emailTemplates.DELETE("/:name", middleware.PermissionMiddleware("settings:manage"), mailHandler.DeleteTemplate)
+		emailTemplates.POST("/:name/preview", middleware.PermissionMiddleware("settings:manage"), mailHandler.Preview)
+	}
+	protected.GET("/email-outbox", middleware.PermissionMiddleware("settings:manage"), mailHandler.ListOutbox)
+
+	webhooks := protected.Group("/webhooks")
+	{
+		webhooks.GET("/events", middleware.PermissionMiddleware("webhook:manage"), webhookHandler.Events)
+		webhooks.GET("", middleware.PermissionMiddleware("webhook:manage"), webhookHandler.List)
+		webhooks.POST("", middleware.PermissionMiddleware("webhook:manage"), webhookHandler.Create)
+		webhooks.GET("/:id", middleware.PermissionMiddleware("webhook:manage"), webhookHandler.Get)
+		webhooks.PUT("/:id", middleware.PermissionMiddleware("webhook:manage"), webhookHandler.Update)
+		webhooks.DELETE("/:id", middleware.PermissionMiddleware("webhook:manage"), webhookHandler.Delete)
+		webhooks.POST("/:id/rotate-secret", middleware.PermissionMiddleware("webhook:manage"), webhookHandler.RotateSecret)
+		webhooks.GET("/:id/deliveries", middleware.PermissionMiddleware("webhook:manage"), webhookHandler.Deliveries)
+		webhooks.GET("/deliveries/:id", middleware.PermissionMiddleware("webhook:manage"), webhookHandler.GetDelivery)
+		webhooks.POST("/deliveries/:id/redeliver", middleware.PermissionMiddleware("webhook:manage"), webhookHandler.Redeliver)
+	}
+
+	// Every signed-in user has their own notifications.
+	notifications := protected.Group("/notifications")
+	{
+		notifications.GET("", notificationHandler.List)
+		notifications.POST("/read-all", notificationHandler.MarkAllRead)
+		notifications.POST("/:id/read", notificationHandler.MarkRead)
+		notifications.GET("/preferences", notificationHandler.Preferences)
+		notifications.PUT("/preferences", notificationHandler.UpdatePreferences)
+	}
+
+	admin := protected.Group("/admin")
+	{
+		admin.GET("/tenant-pools", middleware.PermissionMiddleware("system:manage"), tenantPoolHandler.List)
+		admin.DELETE("/tenant-pools/:tenantID", middleware.PermissionMiddleware("system:manage"), tenantPoolHandler.Evict)
+
+		admin.POST("/db-servers", middleware.PermissionMiddleware("system:manage"), dbServerHandler.Create)
+		admin.GET("/db-servers", middleware.PermissionMiddleware("system:manage"), dbServerHandler.List)
+		admin.PUT("/db-servers/:id", middleware.PermissionMiddleware("system:manage"), dbServerHandler.Update)
+		admin.DELETE("/db-servers/:id", middleware.PermissionMiddleware("system:manage"), dbServerHandler.Delete)
+
+		admin.GET("/queue", middleware.PermissionMiddleware("system:manage"), adminJobHandler.Stats)
+		admin.GET("/jobs", middleware.PermissionMiddleware("system:manage"), adminJobHandler.List)
+		admin.GET("/jobs/:id", middleware.PermissionMiddleware("system:manage"), adminJobHandler.Get)
+		admin.POST("/jobs/:id/cancel", middleware.PermissionMiddleware("system:manage"), adminJobHandler.Cancel)
+		admin.POST("/jobs/:id/retry", middleware.PermissionMiddleware("system:manage"), adminJobHandler.Retry)
+
+		admin.GET("/cron", middleware.PermissionMiddleware("system:manage"), cronHandler.ListJobs)
+		admin.GET("/cron/runs", middleware.PermissionMiddleware("system:manage"), cronHandler.ListRuns)
+		admin.POST("/cron/:name/run", middleware.PermissionMiddleware("system:manage"), cronHandler.Trigger)
+
+		admin.GET("/mail/outbox", middleware.PermissionMiddleware("system:manage"), adminMailHandler.ListOutbox)
+		admin.GET("/mail/captured", middleware.PermissionMiddleware("system:manage"), adminMailHandler.Captured)
+	}
+
 	purchase := protected.Group("/purchase-orders")
 	{
 
@@ -7,6 +7,7 @@ import (
 	"go-multi-tenant/models"
 	"go-multi-tenant/repositories"
 	"go-multi-tenant/utils"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -21,7 +22,9 @@ func NewAuthService(tenantRepo repositories.TenantRepository) *AuthService {
 	}
 }
 
-func (s *AuthService) Login(email, password string) (*models.User, string, error) {
+// Login authenticates a user. When the request came in on a tenant's host,
+// hostTenantID is that tenant and users of other tenants are rejected.
+func (s *AuthService) Login(email, password string, hostTenantID uint) (*models.User, string, error) {
 
 	identity, err := s.tenantRepo.GetGlobalIdentity(email)
 	if err != nil {
@@ -30,6 +33,9 @@ func (s *AuthService) Login(email, password string) (*models.User, string, error
 		}
 		return nil, "", err
 	}
+	if hostTenantID != 0 && identity.TenantID != hostTenantID {
+		return nil, "", errors.New("invalid credentials")
+	}
 
 	// 2. Fetch Tenant Info
 	tenant, err := s.tenantRepo.GetByID(identity.TenantID)
@@ -41,12 +47,14 @@ func (s *AuthService) Login(email, password string) (*models.User, string, error
 		return nil, "", errors.New("company account is suspended")
 	}
 
+	underMaintenance := tenant.EffectiveMode(time.Now()) == models.ModeMaintenance
+
 	tenantDB, err := config.TenantManager.GetTenantDB(tenant)
 	if err != nil {
 		return nil, "", errors.New("database connection failed")
 	}
 
-	userRepo := repositories.NewUserRepository(tenantDB)
+	userRepo := repositories.NewUserRepository(config.ScopeToTenant(tenantDB, tenant.ID))
 	user, err := userRepo.GetByEmail(email)
 	if err != nil {
 		return nil, "", errors.New("invalid credentials")
@@ -65,6 +73,12 @@ func (s *AuthService) Login(email, password string) (*models.User, string, error
 	if len(user.Roles) > 0 {
 		roleName = user.Roles[0].Name
 	}
+	if underMaintenance && !IsSystemOperator(tenant, user.ID) {
+		if tenant.MaintenanceMessage != "" {
+			return nil, "", fmt.Errorf("workspace is under maintenance: %s", tenant.MaintenanceMessage)
+		}
+		return nil, "", errors.New("workspace is under maintenance")
+	}
 
 	token, err := utils.GenerateToken(user.ID, user.TenantID, user.Email, roleName)
 	if err != nil {
@@ -73,3 +87,18 @@ func (s *AuthService) Login(email, password string) (*models.User, string, error
 
 	return user, token, nil
 }
+
+// IsSystemOperator reports whether the user belongs to the master tenant and
+// holds system:manage there. Only such users get past a maintenance window;
+// role names are chosen by each tenant and prove nothing.
+func IsSystemOperator(tenant *models.Tenant, userID uint) bool {
+	if tenant.GetActualDBName() != "master_db" {
+		return false
+	}
+	var user models.User
+	if err := config.GetMasterDB().Preload("Roles.Permissions").
+		Where("id = ? AND tenant_id = ?", userID, tenant.ID).Limit(1).Find(&user).Error; err != nil || user.ID == 0 {
+		return false
+	}
+	return user.IsActive && user.HasPermission("system:manage")
+}
@@ -0,0 +1,763 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-multi-tenant/config"
+	"go-multi-tenant/models"
+	"go-multi-tenant/utils"
+	"io"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	BackupFormat        = "go-multi-tenant/tenant-backup"
+	BackupFormatVersion = 1
+	backupManifestFile  = "manifest.json"
+)
+
+type BackupManifest struct {
+	Format        string                     `json:"format"`
+	FormatVersion int                        `json:"format_version"`
+	SchemaVersion int                        `json:"schema_version"`
+	TenantID      uint                       `json:"tenant_id"`
+	TenantName    string                     `json:"tenant_name"`
+	DatabaseType  models.DatabaseType        `json:"database_type"`
+	PlanID        uint                       `json:"plan_id"`
+	Subdomain     string                     `json:"subdomain,omitempty"`
+	CreatedAt     time.Time                  `json:"created_at"`
+	Files         map[string]BackupFileEntry `json:"files"`
+}
+
+type BackupFileEntry struct {
+	Rows   int    `json:"rows"`
+	SHA256 string `json:"sha256"`
+}
+
+// Backup rows embed the models but re-expose the fields the API hides from JSON.
+type backupUser struct {
+	models.User
+	Password  string         `json:"password"`
+	DeletedAt gorm.DeletedAt `json:"deleted_at"`
+}
+
+type backupRole struct {
+	models.Role
+	DeletedAt gorm.DeletedAt `json:"deleted_at"`
+}
+
+type backupCategory struct {
+	models.Category
+	DeletedAt gorm.DeletedAt `json:"deleted_at"`
+}
+
+type backupProduct struct {
+	models.Product
+	DeletedAt gorm.DeletedAt `json:"deleted_at"`
+}
+
+type backupPurchaseOrder struct {
+	models.PurchaseOrder
+	DeletedAt gorm.DeletedAt `json:"deleted_at"`
+}
+
+type backupNotification struct {
+	models.Notification
+	Data string `json:"data"`
+}
+
+type backupNotificationPreference struct {
+	models.NotificationPreference
+	UserID uint `json:"user_id"`
+}
+
+type backupUserRole struct {
+	UserID uint `json:"user_id"`
+	RoleID uint `json:"role_id"`
+}
+
+// Permissions are shared between tenants, so role grants are stored by name.
+type backupRolePermission struct {
+	RoleID         uint   `json:"role_id"`
+	PermissionName string `json:"permission_name"`
+}
+
+type tenantBackupData struct {
+	Users           []backupUser
+	Roles           []backupRole
+	UserRoles       []backupUserRole
+	RolePermissions []backupRolePermission
+	Categories      []backupCategory
+	Products        []backupProduct
+	Inventories     []models.Inventory
+	PurchaseOrders  []backupPurchaseOrder
+
+	Notifications           []backupNotification
+	NotificationPreferences []backupNotificationPreference
+}
+
+func (d *tenantBackupData) files() []struct {
+	name string
+	rows interface{}
+} {
+	return []struct {
+		name string
+		rows interface{}
+	}{
+		{"users.json", &d.Users},
+		{"roles.json", &d.Roles},
+		{"user_roles.json", &d.UserRoles},
+		{"role_permissions.json", &d.RolePermissions},
+		{"categories.json", &d.Categories},
+		{"products.json", &d.Products},
+		{"inventories.json", &d.Inventories},
+		{"purchase_orders.json", &d.PurchaseOrders},
+		{"notifications.json", &d.Notifications},
+		{"notification_preferences.json", &d.NotificationPreferences},
+	}
+}
+
+type RestoreOptions struct {
+	// TargetTenantID restores over an existing tenant, replacing its data.
+	TargetTenantID uint
+	// NewTenantName creates a fresh tenant to restore into.
+	NewTenantName string
+	DatabaseType  models.DatabaseType
+	PlanID        uint
+	// Subdomain of the new tenant. It defaults to the backup's when that is
+	// free, and otherwise to one derived from NewTenantName.
+	Subdomain string
+}
+
+type BackupService struct{}
+
+func NewBackupService() *BackupService {
+	return &BackupService{}
+}
+
+func (s *BackupService) Backup(tenantID uint, w io.Writer) (*BackupManifest, error) {
+	var tenant models.Tenant
+	if err := config.GetMasterDB().First(&tenant, tenantID).Error; err != nil {
+		return nil, fmt.Errorf("tenant not found: %w", err)
+	}
+	if tenant.GetActualDBName() == "master_db" {
+		return nil, errors.New("the master tenant cannot be backed up")
+	}
+
+	tenantDB, err := config.TenantManager.GetTenantDB(&tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := exportTenantData(tenantDB, tenant.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &BackupManifest{
+		Format:        BackupFormat,
+		FormatVersion: BackupFormatVersion,
+		SchemaVersion: config.TenantSchemaVersion,
+		TenantID:      tenant.ID,
+		TenantName:    tenant.Name,
+		DatabaseType:  tenant.DatabaseType,
+		PlanID:        tenant.PlanID,
+		CreatedAt:     time.Now().UTC(),
+		Files:         make(map[string]BackupFileEntry),
+	}
+	if tenant.Subdomain != nil {
+		manifest.Subdomain = *tenant.Subdomain
+	}
+
+	zw := zip.NewWriter(w)
+	for _, f := range data.files() {
+		payload, err := json.MarshalIndent(f.rows, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err := writeZipFile(zw, f.name, payload); err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(payload)
+		manifest.Files[f.name] = BackupFileEntry{
+			Rows:   countRows(payload),
+			SHA256: hex.EncodeToString(sum[:]),
+		}
+	}
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeZipFile(zw, backupManifestFile, manifestJSON); err != nil {
+		return nil, err
+	}
+
+	return manifest, zw.Close()
+}
+
+func exportTenantData(db *gorm.DB, tenantID uint) (*tenantBackupData, error) {
+	data := &tenantBackupData{}
+	tenantUsers := db.Unscoped().Model(&models.User{}).Select("id").Where("tenant_id = ?", tenantID)
+	tenantRoles := db.Unscoped().Model(&models.Role{}).Select("id").Where("tenant_id = ?", tenantID)
+
+	var users []models.User
+	if err := db.Unscoped().Where("tenant_id = ?", tenantID).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		data.Users = append(data.Users, backupUser{User: u, Password: u.Password, DeletedAt: u.DeletedAt})
+	}
+
+	var roles []models.Role
+	if err := db.Unscoped().Where("tenant_id = ?", tenantID).Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range roles {
+		data.Roles = append(data.Roles, backupRole{Role: r, DeletedAt: r.DeletedAt})
+	}
+
+	if err := db.Table("user_roles").Select("user_id, role_id").
+		Where("user_id IN (?)", tenantUsers).
+		Find(&data.UserRoles).Error; err != nil {
+		return nil, err
+	}
+
+	if err := db.Table("role_permissions").
+		Select("role_permissions.role_id, permissions.name AS permission_name").
+		Joins("JOIN permissions ON permissions.id = role_permissions.permission_id").
+		Where("role_permissions.role_id IN (?)", tenantRoles).
+		Find(&data.RolePermissions).Error; err != nil {
+		return nil, err
+	}
+
+	var categories []models.Category
+	if err := db.Unscoped().Where("tenant_id = ?", tenantID).Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	for _, c := range categories {
+		data.Categories = append(data.Categories, backupCategory{Category: c, DeletedAt: c.DeletedAt})
+	}
+
+	var products []models.Product
+	if err := db.Unscoped().Where("tenant_id = ?", tenantID).Find(&products).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range products {
+		data.Products = append(data.Products, backupProduct{Product: p, DeletedAt: p.DeletedAt})
+	}
+
+	if err := db.Where("tenant_id = ?", tenantID).Find(&data.Inventories).Error; err != nil {
+		return nil, err
+	}
+
+	var orders []models.PurchaseOrder
+	if err := db.Unscoped().Where("tenant_id = ?", tenantID).Find(&orders).Error; err != nil {
+		return nil, err
+	}
+	for _, o := range orders {
+		data.PurchaseOrders = append(data.PurchaseOrders, backupPurchaseOrder{PurchaseOrder: o, DeletedAt: o.DeletedAt})
+	}
+
+	var notifications []models.Notification
+	if err := db.Where("tenant_id = ?", tenantID).Find(&notifications).Error; err != nil {
+		return nil, err
+	}
+	for _, n := range notifications {
+		data.Notifications = append(data.Notifications, backupNotification{Notification: n, Data: n.Data})
+	}
+
+	var prefs []models.NotificationPreference
+	if err := db.Where("tenant_id = ?", tenantID).Find(&prefs).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range prefs {
+		data.NotificationPreferences = append(data.NotificationPreferences, backupNotificationPreference{NotificationPreference: p, UserID: p.UserID})
+	}
+
+	return data, nil
+}
+
+// ReadManifest validates the archive and returns its manifest without restoring anything.
+func (s *BackupService) ReadManifest(r io.ReaderAt, size int64) (*BackupManifest, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup archive: %w", err)
+	}
+	manifest, _, err := s.load(zr)
+	return manifest, err
+}
+
+func (s *BackupService) load(zr *zip.Reader) (*BackupManifest, *tenantBackupData, error) {
+	raw, err := readZipFile(zr, backupManifestFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var manifest BackupManifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return nil, nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if manifest.Format != BackupFormat {
+		return nil, nil, fmt.Errorf("not a tenant backup (format %q)", manifest.Format)
+	}
+	if manifest.FormatVersion != BackupFormatVersion {
+		return nil, nil, fmt.Errorf("unsupported backup format version %d (expected %d)", manifest.FormatVersion, BackupFormatVersion)
+	}
+	// Older archives are fine (newer tables simply restore empty); newer ones may carry columns we can't place.
+	if manifest.SchemaVersion > config.TenantSchemaVersion {
+		return nil, nil, fmt.Errorf("backup schema version %d is newer than this server's schema version %d", manifest.SchemaVersion, config.TenantSchemaVersion)
+	}
+
+	data := &tenantBackupData{}
+	for _, f := range data.files() {
+		entry, ok := manifest.Files[f.name]
+		if !ok {
+			continue
+		}
+		payload, err := readZipFile(zr, f.name)
+		if err != nil {
+			return nil, nil, err
+		}
+		sum := sha256.Sum256(payload)
+		if hex.EncodeToString(sum[:]) != entry.SHA256 {
+			return nil, nil, fmt.Errorf("checksum mismatch for %s", f.name)
+		}
+		if err := json.Unmarshal(payload, f.rows); err != nil {
+			return nil, nil, fmt.Errorf("invalid %s: %w", f.name, err)
+		}
+	}
+
+	return &manifest, data, nil
+}
+
+func (s *BackupService) Restore(r io.ReaderAt, size int64, opts RestoreOptions) (*models.Tenant, error) {
+	if (opts.TargetTenantID == 0) == (opts.NewTenantName == "") {
+		return nil, errors.New("specify either a target tenant or a new tenant name")
+	}
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup archive: %w", err)
+	}
+	manifest, data, err := s.load(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	masterDB := config.GetMasterDB()
+	if err := s.checkIdentityConflicts(masterDB, data, opts.TargetTenantID); err != nil {
+		return nil, err
+	}
+
+	var tenant *models.Tenant
+	created := false
+	if opts.TargetTenantID != 0 {
+		tenant = &models.Tenant{}
+		if err := masterDB.First(tenant, opts.TargetTenantID).Error; err != nil {
+			return nil, fmt.Errorf("target tenant not found: %w", err)
+		}
+		if tenant.GetActualDBName() == "master_db" {
+			return nil, errors.New("cannot restore into the master tenant")
+		}
+	} else {
+		tenant, err = s.createRestoreTenant(manifest, opts)
+		if err != nil {
+			return nil, err
+		}
+		created = true
+	}
+	// A tenant created for the restore is removed again if the restore fails.
+	fail := func(err error) (*models.Tenant, error) {
+		if created {
+			s.discardRestoreTenant(tenant)
+		}
+		return nil, err
+	}
+
+	tenantDB, err := config.TenantManager.GetTenantDB(tenant)
+	if err != nil {
+		return fail(err)
+	}
+
+	// IDs can only be kept when restoring a tenant onto itself; otherwise they may collide in shared databases.
+	keepIDs := tenant.ID == manifest.TenantID
+	err = tenantDB.Transaction(func(tx *gorm.DB) error {
+		if opts.TargetTenantID != 0 {
+			if err := wipeTenantData(tx, tenant.ID); err != nil {
+				return err
+			}
+		}
+		return importTenantData(tx, tenant.ID, data, keepIDs)
+	})
+	if err != nil {
+		return fail(fmt.Errorf("restore failed: %w", err))
+	}
+
+	err = masterDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("tenant_id = ?", tenant.ID).Delete(&models.GlobalIdentity{}).Error; err != nil {
+			return err
+		}
+		for _, u := range data.Users {
+			if u.DeletedAt.Valid {
+				continue
+			}
+			if err := tx.Create(&models.GlobalIdentity{Email: u.Email, TenantID: tenant.ID}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fail(fmt.Errorf("failed to restore global identities: %w", err))
+	}
+
+	if err := NewUsageService().Recalculate(tenant.ID); err != nil {
+		log.Printf("Failed to reset usage counters for tenant %d: %v", tenant.ID, err)
+	}
+
+	cacheService := NewCacheService()
+	_ = cacheService.Delete(fmt.Sprintf("tenant_info:%d", tenant.ID))
+	_ = cacheService.ClearPattern(fmt.Sprintf("user_perms:%d:*", tenant.ID))
+	clearUserCache(tenant.ID)
+
+	return tenant, nil
+}
+
+func (s *BackupService) checkIdentityConflicts(masterDB *gorm.DB, data *tenantBackupData, targetTenantID uint) error {
+	var emails []string
+	for _, u := range data.Users {
+		if !u.DeletedAt.Valid {
+			emails = append(emails, u.Email)
+		}
+	}
+	if len(emails) == 0 {
+		return nil
+	}
+
+	var taken []models.GlobalIdentity
+	query := masterDB.Where("email IN ?", emails)
+	if targetTenantID != 0 {
+		query = query.Where("tenant_id <> ?", targetTenantID)
+	}
+	if err := query.Find(&taken).Error; err != nil {
+		return err
+	}
+	if len(taken) > 0 {
+		return fmt.Errorf("email %s is already registered to tenant %d", taken[0].Email, taken[0].TenantID)
+	}
+	return nil
+}
+
+func (s *BackupService) createRestoreTenant(manifest *BackupManifest, opts RestoreOptions) (*models.Tenant, error) {
+	dbType := opts.DatabaseType
+	if dbType == "" {
+		dbType = manifest.DatabaseType
+	}
+	planID := opts.PlanID
+	if planID == 0 {
+		planID = manifest.PlanID
+	}
+
+	masterDB := config.GetMasterDB()
+	requested := opts.Subdomain
+	if requested == "" && manifest.Subdomain != "" {
+		var taken int64
+		masterDB.Model(&models.Tenant{}).Where("subdomain = ?", manifest.Subdomain).Count(&taken)
+		if taken == 0 {
+			requested = manifest.Subdomain
+		}
+	}
+	subdomain, err := NormalizeSubdomain(requested, opts.NewTenantName)
+	if err != nil {
+		return nil, err
+	}
+	var taken int64
+	masterDB.Model(&models.Tenant{}).Where("subdomain = ?", subdomain).Count(&taken)
+	if taken > 0 {
+		return nil, fmt.Errorf("subdomain %s is already taken", subdomain)
+	}
+
+	apiKey, err := utils.GenerateSecureKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate api key: %v", err)
+	}
+	tenant := &models.Tenant{
+		Name:         opts.NewTenantName,
+		DatabaseType: dbType,
+		DBName:       tenantDBName(opts.NewTenantName, dbType),
+		Subdomain:    &subdomain,
+		IsActive:     true,
+		PlanID:       planID,
+		APIKey:       apiKey,
+	}
+	if err := masterDB.Create(tenant).Error; err != nil {
+		return nil, err
+	}
+
+	if dbType == models.DedicatedDB {
+		if err := config.TenantManager.CreateDedicatedDatabase(tenant); err != nil {
+			masterDB.Unscoped().Delete(tenant)
+			return nil, err
+		}
+	}
+	return tenant, nil
+}
+
+// discardRestoreTenant removes a tenant created by a restore that failed,
+// along with whatever part of the data already reached its database.
+func (s *BackupService) discardRestoreTenant(tenant *models.Tenant) {
+	if tenant.DatabaseType == models.DedicatedDB {
+		if err := config.TenantManager.DropDedicatedDatabase(tenant); err != nil {
+			log.Printf("Restore: failed to drop database of tenant %d: %v", tenant.ID, err)
+		}
+	} else if tenantDB, err := config.TenantManager.GetTenantDB(tenant); err == nil {
+		if err := tenantDB.Transaction(func(tx *gorm.DB) error {
+			return wipeTenantData(tx, tenant.ID)
+		}); err != nil {
+			log.Printf("Restore: failed to remove data of tenant %d: %v", tenant.ID, err)
+		}
+		config.TenantManager.Evict(tenant.ID)
+	}
+
+	masterDB := config.GetMasterDB()
+	if err := masterDB.Unscoped().Where("tenant_id = ?", tenant.ID).Delete(&models.GlobalIdentity{}).Error; err != nil {
+		log.Printf("Restore: failed to remove identities of tenant %d: %v", tenant.ID, err)
+	}
+	if err := masterDB.Unscoped().Delete(tenant).Error; err != nil {
+		log.Printf("Restore: failed to remove tenant %d: %v", tenant.ID, err)
+	}
+}
+
+func wipeTenantData(tx *gorm.DB, tenantID uint) error {
+	tenantUsers := tx.Unscoped().Model(&models.User{}).Select("id").Where("tenant_id = ?", tenantID)
+	tenantRoles := tx.Unscoped().Model(&models.Role{}).Select("id").Where("tenant_id = ?", tenantID)
+
+	if err := tx.Exec("DELETE FROM user_roles WHERE user_id IN (?)", tenantUsers).Error; err != nil {
+		return err
+	}
+	if err := tx.Exec("DELETE FROM role_permissions WHERE role_id IN (?)", tenantRoles).Error; err != nil {
+		return err
+	}
+
+	for _, model := range []interface{}{
+		&models.PurchaseOrder{},
+		&models.Inventory{},
+		&models.Product{},
+		&models.Category{},
+		&models.Notification{},
+		&models.NotificationPreference{},
+		&models.Role{},
+		&models.User{},
+	} {
+		if err := tx.Unscoped().Where("tenant_id = ?", tenantID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func importTenantData(tx *gorm.DB, tenantID uint, data *tenantBackupData, keepIDs bool) error {
+	// Old ID -> new ID, per table. Identity when keepIDs is set.
+	userIDs := map[uint]uint{}
+	roleIDs := map[uint]uint{}
+	categoryIDs := map[uint]uint{}
+	productIDs := map[uint]uint{}
+
+	insert := func(row interface{}, id *uint) (uint, error) {
+		old := *id
+		if !keepIDs {
+			*id = 0
+		}
+		if err := tx.Omit(clause.Associations).Create(row).Error; err != nil {
+			return 0, err
+		}
+		return old, nil
+	}
+
+	for _, r := range data.Users {
+		u := r.User
+		u.TenantID, u.Password, u.DeletedAt = tenantID, r.Password, r.DeletedAt
+		old, err := insert(&u, &u.ID)
+		if err != nil {
+			return err
+		}
+		userIDs[old] = u.ID
+	}
+
+	for _, r := range data.Roles {
+		role := r.Role
+		role.TenantID, role.DeletedAt = tenantID, r.DeletedAt
+		old, err := insert(&role, &role.ID)
+		if err != nil {
+			return err
+		}
+		roleIDs[old] = role.ID
+	}
+
+	for _, ur := range data.UserRoles {
+		userID, okUser := userIDs[ur.UserID]
+		roleID, okRole := roleIDs[ur.RoleID]
+		if !okUser || !okRole {
+			continue
+		}
+		if err := tx.Table("user_roles").Create(map[string]interface{}{"user_id": userID, "role_id": roleID}).Error; err != nil {
+			return err
+		}
+	}
+
+	permIDs := map[string]uint{}
+	for _, rp := range data.RolePermissions {
+		roleID, ok := roleIDs[rp.RoleID]
+		if !ok {
+			continue
+		}
+		permID, ok := permIDs[rp.PermissionName]
+		if !ok {
+			var perm models.Permission
+			if err := config.GetMasterDB().Where("name = ?", rp.PermissionName).First(&perm).Error; err != nil {
+				// The permission no longer exists in the system; drop the grant.
+				continue
+			}
+			local := models.Permission{Name: perm.Name}
+			if err := tx.Where(models.Permission{Name: perm.Name}).
+				Attrs(models.Permission{Description: perm.Description, Category: perm.Category, ModuleID: perm.ModuleID}).
+				FirstOrCreate(&local).Error; err != nil {
+				return err
+			}
+			permID = local.ID
+			permIDs[rp.PermissionName] = permID
+		}
+		if err := tx.Table("role_permissions").Create(map[string]interface{}{"role_id": roleID, "permission_id": permID}).Error; err != nil {
+			return err
+		}
+	}
+
+	for _, r := range data.Categories {
+		c := r.Category
+		c.TenantID, c.DeletedAt = tenantID, r.DeletedAt
+		old, err := insert(&c, &c.ID)
+		if err != nil {
+			return err
+		}
+		categoryIDs[old] = c.ID
+	}
+
+	for _, r := range data.Products {
+		p := r.Product
+		p.TenantID, p.DeletedAt = tenantID, r.DeletedAt
+		p.Category, p.Inventory = nil, nil
+		if p.CategoryID != nil {
+			if newID, ok := categoryIDs[*p.CategoryID]; ok {
+				p.CategoryID = &newID
+			} else {
+				p.CategoryID = nil
+			}
+		}
+		old, err := insert(&p, &p.ID)
+		if err != nil {
+			return err
+		}
+		productIDs[old] = p.ID
+	}
+
+	for _, inv := range data.Inventories {
+		productID, ok := productIDs[inv.ProductID]
+		if !ok {
+			continue
+		}
+		inv.TenantID, inv.ProductID = tenantID, productID
+		if _, err := insert(&inv, &inv.ID); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range data.PurchaseOrders {
+		po := r.PurchaseOrder
+		po.TenantID, po.DeletedAt = tenantID, r.DeletedAt
+		po.Product = nil
+		productID, ok := productIDs[po.ProductID]
+		if !ok {
+			continue
+		}
+		po.ProductID = productID
+		po.RequestedBy = userIDs[po.RequestedBy]
+		if po.ApprovedBy != nil {
+			if approver, ok := userIDs[*po.ApprovedBy]; ok {
+				po.ApprovedBy = &approver
+			} else {
+				po.ApprovedBy = nil
+			}
+		}
+		if _, err := insert(&po, &po.ID); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range data.Notifications {
+		n := r.Notification
+		userID, ok := userIDs[n.UserID]
+		if !ok {
+			continue
+		}
+		n.TenantID, n.UserID, n.Data = tenantID, userID, r.Data
+		if _, err := insert(&n, &n.ID); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range data.NotificationPreferences {
+		p := r.NotificationPreference
+		userID, ok := userIDs[r.UserID]
+		if !ok {
+			continue
+		}
+		// Preference IDs are not exported, so they are always assigned anew.
+		p.ID, p.TenantID, p.UserID = 0, tenantID, userID
+		if err := tx.Create(&p).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeZipFile(zw *zip.Writer, name string, payload []byte) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(payload)
+	return err
+}
+
+func readZipFile(zr *zip.Reader, name string) ([]byte, error) {
+	f, err := zr.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("backup is missing %s", name)
+	}
+	defer f.Close()
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, f); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func countRows(payload []byte) int {
+	var rows []json.RawMessage
+	if err := json.Unmarshal(payload, &rows); err != nil {
+		return 0
+	}
+	return len(rows)
+}
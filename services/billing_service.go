@@ -0,0 +1,510 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-multi-tenant/config"
+	"go-multi-tenant/models"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	invoiceReasonRenewal    = "renewal"
+	invoiceReasonPlanChange = "plan_change"
+
+	accountCreditLine = "Account credit"
+	// couponLinePrefix starts the description of an invoice's coupon discount line.
+	couponLinePrefix = "Coupon "
+)
+
+type BillingService struct{}
+
+func NewBillingService() *BillingService {
+	return &BillingService{}
+}
+
+// PaymentDeclinedError is returned when the provider refused the charge. The
+// failed attempt is still recorded against the invoice.
+type PaymentDeclinedError struct {
+	Payment *models.Payment
+}
+
+func (e *PaymentDeclinedError) Error() string {
+	return "payment declined: " + e.Payment.FailureReason
+}
+
+// Renewal invoices open this long before the period ends so tenants can pay
+// before their plan expires.
+const renewalInvoiceLead = 7 * 24 * time.Hour
+
+// GenerateDueInvoices opens a renewal invoice for every paying tenant whose
+// period ends soon and that has no invoice for the next period yet. Paying it
+// moves PlanExpiry to the end of that period.
+func (s *BillingService) GenerateDueInvoices() int {
+	var due []models.Tenant
+	if err := config.GetMasterDB().Preload("Plan").
+		Where("plan_expiry IS NOT NULL AND plan_expiry <= ? AND is_sandbox = ? AND billing_status <> ?",
+			time.Now().Add(renewalInvoiceLead), false, models.BillingSuspended).
+		Find(&due).Error; err != nil {
+		log.Printf("Error fetching tenants due for renewal: %v", err)
+		return 0
+	}
+
+	created := 0
+	for i := range due {
+		opened, err := s.openRenewalInvoice(&due[i])
+		if err != nil {
+			log.Printf("Tenant %s: failed to create renewal invoice: %v", due[i].Name, err)
+			continue
+		}
+		if opened {
+			created++
+		}
+	}
+	return created
+}
+
+// openRenewalInvoice invoices the period that starts at the tenant's
+// PlanExpiry, on the plan scheduled for it. It reports false when there is
+// nothing to bill or the period is already invoiced.
+func (s *BillingService) openRenewalInvoice(tenant *models.Tenant) (bool, error) {
+	masterDB := config.GetMasterDB()
+
+	plan := tenant.Plan
+	if tenant.PendingPlanID != nil {
+		pending, err := NewPlanService().GetPlan(*tenant.PendingPlanID)
+		if err != nil {
+			return false, err
+		}
+		plan = pending
+	}
+	if plan == nil || plan.Price == 0 || plan.BillingCycleDays == 0 {
+		return false, nil
+	}
+
+	var invoiced int64
+	masterDB.Model(&models.Invoice{}).
+		Where("tenant_id = ? AND reason = ? AND status <> ? AND period_end > ?",
+			tenant.ID, invoiceReasonRenewal, models.InvoiceVoid, *tenant.PlanExpiry).
+		Count(&invoiced)
+	if invoiced > 0 {
+		return false, nil
+	}
+
+	start := *tenant.PlanExpiry
+	end := *nextExpiry(start, plan)
+	lines := []models.InvoiceLine{{
+		Description: fmt.Sprintf("%s (%s – %s)", plan.Name, start.Format("2006-01-02"), end.Format("2006-01-02")),
+		Amount:      plan.Price,
+	}}
+
+	err := masterDB.Transaction(func(tx *gorm.DB) error {
+		_, err := s.issueInvoice(tx, tenant, plan, invoiceReasonRenewal, start, end, lines)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	s.clearTenantCache(tenant.ID)
+	return true, nil
+}
+
+// prorateChange bills an immediate switch from the tenant's current plan to
+// plan. Unused time on the old plan is credited. When both plans share a
+// billing cycle the renewal date is kept and only the rest of the period is
+// charged; otherwise a fresh period starts now.
+//
+// When an invoice is issued the switch is applied once it is paid, at once if
+// nothing is due, and the invoice is returned. Otherwise the caller switches
+// the plan itself with the returned PlanExpiry.
+func (s *BillingService) prorateChange(tx *gorm.DB, tenant *models.Tenant, plan *models.Plan, now time.Time) (*time.Time, *models.Invoice, error) {
+	// An unpaid renewal was priced for the old plan, and an unpaid change was
+	// for another plan; the new one is billed below.
+	if err := s.voidOpenInvoices(tx, tenant.ID, invoiceReasonRenewal, invoiceReasonPlanChange); err != nil {
+		return nil, nil, err
+	}
+
+	// Nothing was paid during a trial, so there is nothing to prorate; a paid
+	// plan simply keeps the trial's end date.
+	if tenant.BillingStatus == models.BillingTrialing {
+		if plan.Price == 0 {
+			return nil, nil, nil
+		}
+		return tenant.PlanExpiry, nil, nil
+	}
+
+	old := tenant.Plan
+	var lines []models.InvoiceLine
+
+	fraction := 0.0
+	if old != nil && old.BillingCycleDays > 0 && tenant.PlanExpiry != nil && tenant.PlanExpiry.After(now) {
+		period := time.Duration(old.BillingCycleDays) * 24 * time.Hour
+		fraction = math.Min(float64(tenant.PlanExpiry.Sub(now))/float64(period), 1)
+	}
+	if old != nil && old.Price > 0 && fraction > 0 {
+		lines = append(lines, models.InvoiceLine{
+			Description: fmt.Sprintf("Unused time on %s until %s", old.Name, tenant.PlanExpiry.Format("2006-01-02")),
+			Amount:      -roundMoney(old.Price * fraction),
+		})
+	}
+
+	var expiry *time.Time
+	periodEnd := now
+	switch {
+	case plan.Price == 0:
+		// Free plans never lapse.
+	case old.Price > 0 && fraction > 0 && old.BillingCycleDays == plan.BillingCycleDays:
+		expiry = tenant.PlanExpiry
+		periodEnd = *expiry
+		lines = append(lines, models.InvoiceLine{
+			Description: fmt.Sprintf("%s (%s – %s)", plan.Name, now.Format("2006-01-02"), periodEnd.Format("2006-01-02")),
+			Amount:      roundMoney(plan.Price * fraction),
+		})
+	default:
+		expiry = nextExpiry(now, plan)
+		description := plan.Name + " (lifetime)"
+		if expiry != nil {
+			periodEnd = *expiry
+			description = fmt.Sprintf("%s (%s – %s)", plan.Name, now.Format("2006-01-02"), periodEnd.Format("2006-01-02"))
+		}
+		lines = append(lines, models.InvoiceLine{Description: description, Amount: plan.Price})
+	}
+
+	if len(lines) == 0 {
+		return expiry, nil, nil
+	}
+	invoice, err := s.issueInvoice(tx, tenant, plan, invoiceReasonPlanChange, now, periodEnd, lines)
+	if err != nil {
+		return nil, nil, err
+	}
+	return expiry, invoice, nil
+}
+
+func (s *BillingService) voidOpenRenewals(tx *gorm.DB, tenantID uint) error {
+	return s.voidOpenInvoices(tx, tenantID, invoiceReasonRenewal)
+}
+
+// voidOpenInvoices voids the tenant's open invoices issued for reasons. The
+// account credit they had taken goes back to the balance, and their coupon
+// discounts come off the redemption's totals.
+func (s *BillingService) voidOpenInvoices(tx *gorm.DB, tenantID uint, reasons ...string) error {
+	var invoices []models.Invoice
+	if err := tx.Preload("Lines").
+		Where("tenant_id = ? AND reason IN ? AND status = ?", tenantID, reasons, models.InvoiceOpen).
+		Find(&invoices).Error; err != nil {
+		return err
+	}
+	if len(invoices) == 0 {
+		return nil
+	}
+	ids := make([]uint, 0, len(invoices))
+	credit := 0.0
+	for _, invoice := range invoices {
+		ids = append(ids, invoice.ID)
+		for _, line := range invoice.Lines {
+			switch {
+			case line.Description == accountCreditLine:
+				credit -= line.Amount
+			case strings.HasPrefix(line.Description, couponLinePrefix) && invoice.Discount > 0:
+				if err := NewCouponService().reverseDiscount(tx, &invoice, line); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err := tx.Model(&models.Invoice{}).Where("id IN ?", ids).Update("status", models.InvoiceVoid).Error; err != nil {
+		return err
+	}
+	if credit <= 0 {
+		return nil
+	}
+	return tx.Model(&models.Tenant{}).Where("id = ?", tenantID).
+		Update("credit_balance", gorm.Expr("credit_balance + ?", roundMoney(credit))).Error
+}
+
+// renewalPrepaid reports whether a period that has not started yet is already paid.
+func (s *BillingService) renewalPrepaid(tenantID uint) bool {
+	var count int64
+	config.GetMasterDB().Model(&models.Invoice{}).
+		Where("tenant_id = ? AND reason = ? AND status = ? AND period_start > ?",
+			tenantID, invoiceReasonRenewal, models.InvoicePaid, time.Now()).
+		Count(&count)
+	return count > 0
+}
+
+// pendingPeriodStarted reports whether the tenant paid in advance for a period
+// on its pending plan and that period has begun.
+func (s *BillingService) pendingPeriodStarted(tenant *models.Tenant, now time.Time) bool {
+	var count int64
+	config.GetMasterDB().Model(&models.Invoice{}).
+		Where("tenant_id = ? AND reason = ? AND status = ? AND plan_id = ? AND period_start <= ? AND period_end > ?",
+			tenant.ID, invoiceReasonRenewal, models.InvoicePaid, *tenant.PendingPlanID, now, now).
+		Count(&count)
+	return count > 0
+}
+
+// issueInvoice applies the tenant's coupon and credit balance to lines and
+// stores the invoice. A negative subtotal becomes credit; a zero total is paid
+// at once.
+func (s *BillingService) issueInvoice(tx *gorm.DB, tenant *models.Tenant, plan *models.Plan, reason string, start, end time.Time, lines []models.InvoiceLine) (*models.Invoice, error) {
+	var fresh models.Tenant
+	if err := tx.Select("id", "credit_balance").First(&fresh, tenant.ID).Error; err != nil {
+		return nil, err
+	}
+	credit := fresh.CreditBalance
+
+	subtotal := 0.0
+	for _, line := range lines {
+		subtotal += line.Amount
+	}
+	subtotal = roundMoney(subtotal)
+
+	discountLine, err := NewCouponService().discountFor(tx, tenant.ID, plan, subtotal, start)
+	if err != nil {
+		return nil, err
+	}
+	discount := 0.0
+	if discountLine != nil {
+		lines = append(lines, *discountLine)
+		discount = -discountLine.Amount
+	}
+
+	total := roundMoney(subtotal - discount)
+	if total < 0 {
+		credit += -total
+		total = 0
+	} else if credit > 0 && total > 0 {
+		applied := math.Min(credit, total)
+		lines = append(lines, models.InvoiceLine{Description: accountCreditLine, Amount: -applied})
+		credit -= applied
+		total -= applied
+	}
+	total = roundMoney(total)
+
+	var count int64
+	tx.Model(&models.Invoice{}).Where("tenant_id = ?", tenant.ID).Count(&count)
+
+	now := time.Now()
+	invoice := &models.Invoice{
+		TenantID:    tenant.ID,
+		Number:      fmt.Sprintf("INV-%d-%05d", tenant.ID, count+1),
+		PlanID:      plan.ID,
+		Reason:      reason,
+		PeriodStart: start,
+		PeriodEnd:   end,
+		Currency:    config.AppConfig.BillingCurrency,
+		Subtotal:    subtotal,
+		Discount:    discount,
+		Total:       total,
+		Status:      models.InvoiceOpen,
+		DueAt:       start,
+		Lines:       lines,
+	}
+	if total == 0 {
+		invoice.Status = models.InvoicePaid
+		invoice.PaidAt = &now
+	}
+	if err := tx.Create(invoice).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&models.Tenant{}).Where("id = ?", tenant.ID).
+		Update("credit_balance", roundMoney(credit)).Error; err != nil {
+		return nil, err
+	}
+	if invoice.Status == models.InvoicePaid {
+		if _, err := s.settle(tx, invoice); err != nil {
+			return nil, err
+		}
+	}
+	return invoice, nil
+}
+
+// settle gives the tenant what a paid invoice bought: the plan of a plan
+// change, or the renewed period. It reports whether a suspended tenant was
+// reactivated.
+func (s *BillingService) settle(tx *gorm.DB, invoice *models.Invoice) (bool, error) {
+	if invoice.Reason == invoiceReasonPlanChange {
+		return s.applyPlanChange(tx, invoice)
+	}
+	return s.extendExpiry(tx, invoice)
+}
+
+// applyPlanChange switches the tenant to the plan of a paid plan-change
+// invoice. Free and lifetime plans never lapse; otherwise the plan runs until
+// the end of the billed period.
+func (s *BillingService) applyPlanChange(tx *gorm.DB, invoice *models.Invoice) (bool, error) {
+	var plan models.Plan
+	if err := tx.First(&plan, invoice.PlanID).Error; err != nil {
+		return false, err
+	}
+	var expiry *time.Time
+	if plan.Price > 0 && invoice.PeriodEnd.After(invoice.PeriodStart) {
+		expiry = &invoice.PeriodEnd
+	}
+	if err := tx.Model(&models.Tenant{}).Where("id = ?", invoice.TenantID).Updates(map[string]interface{}{
+		"plan_id":         plan.ID,
+		"pending_plan_id": nil,
+		"plan_expiry":     expiry,
+	}).Error; err != nil {
+		return false, err
+	}
+	return clearDelinquency(tx, invoice.TenantID)
+}
+
+// extendExpiry moves the tenant's PlanExpiry to the end of a paid period and
+// clears any delinquency. It reports whether a suspended tenant was reactivated.
+func (s *BillingService) extendExpiry(tx *gorm.DB, invoice *models.Invoice) (bool, error) {
+	res := tx.Model(&models.Tenant{}).
+		Where("id = ? AND plan_expiry IS NOT NULL AND plan_expiry < ?", invoice.TenantID, invoice.PeriodEnd).
+		Update("plan_expiry", invoice.PeriodEnd)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return false, res.Error
+	}
+	return clearDelinquency(tx, invoice.TenantID)
+}
+
+// PayInvoice charges an open invoice through the configured payment provider.
+func (s *BillingService) PayInvoice(tenantID, invoiceID uint, paymentMethod string) (*models.Payment, error) {
+	if config.AppConfig.PaymentProvider == "" {
+		return nil, ErrPaymentsDisabled
+	}
+	return s.RecordPayment(tenantID, invoiceID, config.AppConfig.PaymentProvider, paymentMethod)
+}
+
+// RecordPayment settles an open invoice through the named provider. For the
+// "manual" provider paymentMethod is the reference of a payment made elsewhere.
+func (s *BillingService) RecordPayment(tenantID, invoiceID uint, providerName, paymentMethod string) (*models.Payment, error) {
+	provider, err := GetPaymentProvider(providerName)
+	if err != nil {
+		return nil, err
+	}
+	invoice, err := s.GetInvoice(tenantID, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	if invoice.Status != models.InvoiceOpen {
+		return nil, fmt.Errorf("invoice is %s", invoice.Status)
+	}
+	if invoice.Reason == invoiceReasonPlanChange && invoice.PeriodEnd.After(invoice.PeriodStart) && !invoice.PeriodEnd.After(time.Now()) {
+		return nil, errors.New("the period of this plan change has ended; change the plan again")
+	}
+
+	result, err := provider.Charge(ChargeRequest{
+		TenantID:      invoice.TenantID,
+		InvoiceID:     invoice.ID,
+		Amount:        invoice.Total,
+		Currency:      invoice.Currency,
+		PaymentMethod: paymentMethod,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("payment provider unavailable: %w", err)
+	}
+
+	payment := &models.Payment{
+		InvoiceID: invoice.ID,
+		TenantID:  invoice.TenantID,
+		Provider:  provider.Name(),
+		Reference: result.Reference,
+		Amount:    invoice.Total,
+		Status:    models.PaymentSucceeded,
+	}
+	if !result.Succeeded {
+		payment.Status = models.PaymentFailed
+		payment.FailureReason = result.FailureReason
+		if err := config.GetMasterDB().Create(payment).Error; err != nil {
+			return nil, err
+		}
+		return payment, &PaymentDeclinedError{Payment: payment}
+	}
+
+	// The receipt is written to the outbox in the same transaction, so it is
+	// sent exactly when the payment is recorded.
+	recipients, err := recipientsWith(invoice.TenantID, "subscription:manage")
+	if err != nil {
+		log.Printf("Billing: no receipt recipients for tenant %d: %v", invoice.TenantID, err)
+	}
+	var receipt *models.OutboxEmail
+
+	reactivated := false
+	err = config.GetMasterDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(payment).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		res := tx.Model(&models.Invoice{}).
+			Where("id = ? AND status = ?", invoice.ID, models.InvoiceOpen).
+			Updates(map[string]interface{}{"status": models.InvoicePaid, "paid_at": &now})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			// Paid twice concurrently: keep the money as credit rather than lose it.
+			return tx.Model(&models.Tenant{}).Where("id = ?", invoice.TenantID).
+				Update("credit_balance", gorm.Expr("credit_balance + ?", payment.Amount)).Error
+		}
+		if reactivated, err = s.settle(tx, invoice); err != nil {
+			return err
+		}
+		if len(recipients) > 0 {
+			// The savepoint keeps a failed insert from aborting the payment's
+			// transaction, which Postgres would otherwise refuse to commit.
+			err := tx.Transaction(func(sp *gorm.DB) error {
+				var err error
+				receipt, err = NewMailService().Queue(sp, invoice.TenantID, EmailPaymentReceipt, recipients, map[string]interface{}{
+					"InvoiceNumber": invoice.Number,
+					"Amount":        payment.Amount,
+					"Currency":      invoice.Currency,
+					"PaidAt":        now,
+					"PeriodStart":   invoice.PeriodStart,
+					"PeriodEnd":     invoice.PeriodEnd,
+				})
+				return err
+			})
+			if err != nil {
+				// A receipt that cannot be written must not lose the payment.
+				receipt = nil
+				log.Printf("Billing: receipt for invoice %s not queued: %v", invoice.Number, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("payment %s succeeded but could not be recorded: %w", payment.Reference, err)
+	}
+	NewMailService().Dispatch(receipt)
+
+	s.clearTenantCache(invoice.TenantID)
+	if reactivated {
+		notifyReactivated(invoice.TenantID)
+	}
+	return payment, nil
+}
+
+func (s *BillingService) ListInvoices(tenantID uint) ([]models.Invoice, error) {
+	var invoices []models.Invoice
+	err := config.GetMasterDB().Preload("Lines").
+		Where("tenant_id = ?", tenantID).Order("id DESC").Find(&invoices).Error
+	return invoices, err
+}
+
+func (s *BillingService) GetInvoice(tenantID, invoiceID uint) (*models.Invoice, error) {
+	var invoice models.Invoice
+	if err := config.GetMasterDB().Preload("Lines").Preload("Payments").
+		Where("id = ? AND tenant_id = ?", invoiceID, tenantID).First(&invoice).Error; err != nil {
+		return nil, errors.New("invoice not found")
+	}
+	return &invoice, nil
+}
+
+func (s *BillingService) clearTenantCache(tenantID uint) {
+	_ = NewCacheService().Delete(fmt.Sprintf("tenant_info:%d", tenantID))
+}
+
+func roundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,457 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-multi-tenant/config"
+	"go-multi-tenant/models"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// setupBillingTest points the services at a fresh SQLite master database, a
+// Redis that never answers (so cache deletes fail fast) and the fake provider.
+func setupBillingTest(t *testing.T) *gorm.DB {
+	t.Helper()
+	cfg := &config.Config{
+		AppEnv:          "development",
+		DBDriver:        "sqlite",
+		SQLiteDir:       t.TempDir(),
+		BillingCurrency: "USD",
+		PaymentProvider: "fake",
+	}
+	if err := config.InitMasterDB(cfg); err != nil {
+		t.Fatalf("init master db: %v", err)
+	}
+	sqlDB, _ := config.MasterDB.DB()
+	t.Cleanup(func() { sqlDB.Close() })
+
+	config.AppConfig = cfg
+	config.InitTenantManager(cfg)
+	if err := config.TenantManager.CreateSharedDatabase(); err != nil {
+		t.Fatalf("create shared db: %v", err)
+	}
+	config.RedisClient = redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	t.Cleanup(func() { config.RedisClient.Close() })
+	RegisterPaymentProvider(&FakePaymentProvider{})
+	return config.MasterDB
+}
+
+func createTestPlan(t *testing.T, db *gorm.DB, name string, price float64, cycleDays int) *models.Plan {
+	t.Helper()
+	plan := &models.Plan{Name: name, Type: models.PlanStandard, Price: price, BillingCycleDays: cycleDays, IsActive: true}
+	if err := db.Create(plan).Error; err != nil {
+		t.Fatalf("create plan: %v", err)
+	}
+	return plan
+}
+
+func createTestTenant(t *testing.T, db *gorm.DB, plan *models.Plan, expiry time.Time) *models.Tenant {
+	t.Helper()
+	var count int64
+	db.Model(&models.Tenant{}).Count(&count)
+	tenant := &models.Tenant{
+		Name:          fmt.Sprintf("Tenant %d", count+1),
+		DatabaseType:  models.SharedDB,
+		DBName:        "shared_tenants_db",
+		APIKey:        fmt.Sprintf("key-%d", count+1),
+		PlanID:        plan.ID,
+		PlanExpiry:    &expiry,
+		BillingStatus: models.BillingActive,
+		IsActive:      true,
+	}
+	if err := db.Create(tenant).Error; err != nil {
+		t.Fatalf("create tenant: %v", err)
+	}
+	tenant.Plan = plan
+	return tenant
+}
+
+func reloadTenant(t *testing.T, db *gorm.DB, id uint) *models.Tenant {
+	t.Helper()
+	var tenant models.Tenant
+	if err := db.First(&tenant, id).Error; err != nil {
+		t.Fatalf("reload tenant: %v", err)
+	}
+	return &tenant
+}
+
+func reloadInvoice(t *testing.T, db *gorm.DB, id uint) *models.Invoice {
+	t.Helper()
+	var invoice models.Invoice
+	if err := db.Preload("Lines").Preload("Payments").First(&invoice, id).Error; err != nil {
+		t.Fatalf("reload invoice: %v", err)
+	}
+	return &invoice
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 0.005
+}
+
+func lineAmount(invoice *models.Invoice, description string) (float64, bool) {
+	for _, line := range invoice.Lines {
+		if line.Description == description {
+			return line.Amount, true
+		}
+	}
+	return 0, false
+}
+
+func TestProrateChange(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		price       float64
+		cycleDays   int
+		wantTotal   float64
+		wantExpiry  time.Time
+		wantInvoice bool
+	}{
+		// Half of a 30-day period left: 15 back for the old plan, 30 for the new one.
+		{"same cycle keeps the renewal date", 60, 30, 15, now.AddDate(0, 0, 15), true},
+		// A different cycle starts a fresh period, billed in full.
+		{"new cycle starts a fresh period", 300, 365, 285, now.AddDate(0, 0, 365), true},
+		// The unused time is more than the new plan costs for the rest of the period.
+		{"downgrade leaves credit", 10, 30, 0, now.AddDate(0, 0, 15), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupBillingTest(t)
+			old := createTestPlan(t, db, "Old", 30, 30)
+			plan := createTestPlan(t, db, "New", tt.price, tt.cycleDays)
+			tenant := createTestTenant(t, db, old, now.AddDate(0, 0, 15))
+
+			var expiry *time.Time
+			var invoice *models.Invoice
+			err := db.Transaction(func(tx *gorm.DB) error {
+				var err error
+				expiry, invoice, err = NewBillingService().prorateChange(tx, tenant, plan, now)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expiry == nil || !expiry.Equal(tt.wantExpiry) {
+				t.Errorf("expiry = %v, want %v", expiry, tt.wantExpiry)
+			}
+			if (invoice != nil) != tt.wantInvoice {
+				t.Fatalf("invoice = %v, want one: %v", invoice, tt.wantInvoice)
+			}
+			if invoice == nil {
+				return
+			}
+			if !almostEqual(invoice.Total, tt.wantTotal) {
+				t.Errorf("total = %.2f, want %.2f", invoice.Total, tt.wantTotal)
+			}
+			if invoice.Reason != invoiceReasonPlanChange || invoice.PlanID != plan.ID {
+				t.Errorf("invoice reason %q for plan %d, want %q for plan %d", invoice.Reason, invoice.PlanID, invoiceReasonPlanChange, plan.ID)
+			}
+			if credit, ok := lineAmount(invoice, "Unused time on Old until "+now.AddDate(0, 0, 15).Format("2006-01-02")); !ok || !almostEqual(credit, -15) {
+				t.Errorf("unused time line = %.2f (found %v), want -15", credit, ok)
+			}
+
+			got := reloadTenant(t, db, tenant.ID)
+			if tt.wantTotal > 0 {
+				if got.PlanID != old.ID {
+					t.Errorf("plan switched to %d before the invoice was paid", got.PlanID)
+				}
+				return
+			}
+			// Nothing due: the change is paid at once and the rest becomes credit.
+			if invoice.Status != models.InvoicePaid || got.PlanID != plan.ID {
+				t.Errorf("invoice %s, tenant on plan %d; want paid and plan %d", invoice.Status, got.PlanID, plan.ID)
+			}
+			if !almostEqual(got.CreditBalance, 10) {
+				t.Errorf("credit balance = %.2f, want 10", got.CreditBalance)
+			}
+		})
+	}
+}
+
+func TestProrateChangeVoidsOpenInvoices(t *testing.T) {
+	db := setupBillingTest(t)
+	now := time.Now()
+	old := createTestPlan(t, db, "Old", 30, 30)
+	plan := createTestPlan(t, db, "New", 60, 30)
+	tenant := createTestTenant(t, db, old, now.AddDate(0, 0, 3))
+
+	if opened, err := NewBillingService().openRenewalInvoice(tenant); err != nil || !opened {
+		t.Fatalf("open renewal: opened %v, err %v", opened, err)
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		_, _, err := NewBillingService().prorateChange(tx, tenant, plan, now)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var renewals []models.Invoice
+	db.Where("tenant_id = ? AND reason = ?", tenant.ID, invoiceReasonRenewal).Find(&renewals)
+	if len(renewals) != 1 || renewals[0].Status != models.InvoiceVoid {
+		t.Errorf("renewals after a plan change = %+v, want one void", renewals)
+	}
+}
+
+func TestIssueInvoiceAppliesCredit(t *testing.T) {
+	tests := []struct {
+		name       string
+		credit     float64
+		wantTotal  float64
+		wantLine   float64
+		wantCredit float64
+		wantStatus string
+	}{
+		{"partial credit", 10, 20, -10, 0, models.InvoiceOpen},
+		{"credit covers the invoice", 50, 0, -30, 20, models.InvoicePaid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupBillingTest(t)
+			plan := createTestPlan(t, db, "Pro", 30, 30)
+			expiry := time.Now().Add(48 * time.Hour)
+			tenant := createTestTenant(t, db, plan, expiry)
+			db.Model(tenant).Update("credit_balance", tt.credit)
+
+			end := expiry.AddDate(0, 0, 30)
+			var invoice *models.Invoice
+			err := db.Transaction(func(tx *gorm.DB) error {
+				var err error
+				invoice, err = NewBillingService().issueInvoice(tx, tenant, plan, invoiceReasonRenewal, expiry, end,
+					[]models.InvoiceLine{{Description: "Pro", Amount: 30}})
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			invoice = reloadInvoice(t, db, invoice.ID)
+			if !almostEqual(invoice.Total, tt.wantTotal) || invoice.Status != tt.wantStatus {
+				t.Errorf("invoice total %.2f %s, want %.2f %s", invoice.Total, invoice.Status, tt.wantTotal, tt.wantStatus)
+			}
+			if line, ok := lineAmount(invoice, accountCreditLine); !ok || !almostEqual(line, tt.wantLine) {
+				t.Errorf("credit line = %.2f (found %v), want %.2f", line, ok, tt.wantLine)
+			}
+			got := reloadTenant(t, db, tenant.ID)
+			if !almostEqual(got.CreditBalance, tt.wantCredit) {
+				t.Errorf("credit balance = %.2f, want %.2f", got.CreditBalance, tt.wantCredit)
+			}
+			// A renewal paid from credit is settled straight away.
+			if tt.wantStatus == models.InvoicePaid && !got.PlanExpiry.Equal(end) {
+				t.Errorf("plan expiry = %v, want %v", got.PlanExpiry, end)
+			}
+		})
+	}
+}
+
+func TestIssueInvoiceAppliesCoupon(t *testing.T) {
+	db := setupBillingTest(t)
+	plan := createTestPlan(t, db, "Pro", 30, 30)
+	expiry := time.Now().Add(48 * time.Hour)
+	tenant := createTestTenant(t, db, plan, expiry)
+
+	coupon := &models.Coupon{Code: "HALF", DiscountType: models.DiscountPercent, Amount: 50, IsActive: true}
+	if err := db.Create(coupon).Error; err != nil {
+		t.Fatal(err)
+	}
+	redemption := &models.CouponRedemption{CouponID: coupon.ID, TenantID: tenant.ID, PlanID: plan.ID, Active: true}
+	if err := db.Create(redemption).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var invoice *models.Invoice
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		invoice, err = NewBillingService().issueInvoice(tx, tenant, plan, invoiceReasonRenewal, expiry, expiry.AddDate(0, 0, 30),
+			[]models.InvoiceLine{{Description: "Pro", Amount: 30}})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !almostEqual(invoice.Discount, 15) || !almostEqual(invoice.Total, 15) {
+		t.Errorf("discount %.2f total %.2f, want 15 and 15", invoice.Discount, invoice.Total)
+	}
+	if line, ok := lineAmount(invoice, "Coupon HALF (50% off)"); !ok || !almostEqual(line, -15) {
+		t.Errorf("coupon line = %.2f (found %v), want -15", line, ok)
+	}
+
+	var got models.CouponRedemption
+	db.First(&got, redemption.ID)
+	if got.InvoicesDiscounted != 1 || !almostEqual(got.TotalDiscount, 15) {
+		t.Errorf("redemption discounted %d invoices for %.2f, want 1 for 15", got.InvoicesDiscounted, got.TotalDiscount)
+	}
+
+	// Voiding the invoice takes its discount back off the redemption.
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		return NewBillingService().voidOpenRenewals(tx, tenant.ID)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.First(&got, redemption.ID)
+	if got.InvoicesDiscounted != 0 || !almostEqual(got.TotalDiscount, 0) {
+		t.Errorf("after void the redemption discounted %d invoices for %.2f, want 0 for 0", got.InvoicesDiscounted, got.TotalDiscount)
+	}
+}
+
+func TestSettle(t *testing.T) {
+	db := setupBillingTest(t)
+	old := createTestPlan(t, db, "Old", 30, 30)
+	plan := createTestPlan(t, db, "New", 60, 30)
+	now := time.Now()
+
+	t.Run("renewal extends the expiry and reactivates", func(t *testing.T) {
+		tenant := createTestTenant(t, db, old, now.Add(-time.Hour))
+		db.Model(tenant).Updates(map[string]interface{}{"billing_status": models.BillingSuspended, "is_active": false})
+		end := now.AddDate(0, 0, 30)
+		invoice := &models.Invoice{TenantID: tenant.ID, PlanID: old.ID, Reason: invoiceReasonRenewal, PeriodStart: now, PeriodEnd: end}
+
+		reactivated, err := NewBillingService().settle(db, invoice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := reloadTenant(t, db, tenant.ID)
+		if !reactivated || !got.IsActive || got.BillingStatus != models.BillingActive {
+			t.Errorf("reactivated %v, active %v, status %s; want a reactivated active tenant", reactivated, got.IsActive, got.BillingStatus)
+		}
+		if !got.PlanExpiry.Equal(end) {
+			t.Errorf("plan expiry = %v, want %v", got.PlanExpiry, end)
+		}
+	})
+
+	t.Run("renewal never shortens the expiry", func(t *testing.T) {
+		later := now.AddDate(0, 0, 60)
+		tenant := createTestTenant(t, db, old, later)
+		invoice := &models.Invoice{TenantID: tenant.ID, PlanID: old.ID, Reason: invoiceReasonRenewal, PeriodStart: now, PeriodEnd: now.AddDate(0, 0, 30)}
+
+		if _, err := NewBillingService().settle(db, invoice); err != nil {
+			t.Fatal(err)
+		}
+		if got := reloadTenant(t, db, tenant.ID); !got.PlanExpiry.Equal(later) {
+			t.Errorf("plan expiry = %v, want %v", got.PlanExpiry, later)
+		}
+	})
+
+	t.Run("plan change switches the plan", func(t *testing.T) {
+		tenant := createTestTenant(t, db, old, now.AddDate(0, 0, 10))
+		db.Model(tenant).Update("pending_plan_id", old.ID)
+		end := now.AddDate(0, 0, 10)
+		invoice := &models.Invoice{TenantID: tenant.ID, PlanID: plan.ID, Reason: invoiceReasonPlanChange, PeriodStart: now, PeriodEnd: end}
+
+		if _, err := NewBillingService().settle(db, invoice); err != nil {
+			t.Fatal(err)
+		}
+		got := reloadTenant(t, db, tenant.ID)
+		if got.PlanID != plan.ID || got.PendingPlanID != nil || !got.PlanExpiry.Equal(end) {
+			t.Errorf("tenant on plan %d, pending %v, expiry %v; want plan %d, none pending, expiry %v",
+				got.PlanID, got.PendingPlanID, got.PlanExpiry, plan.ID, end)
+		}
+	})
+}
+
+// racingProvider approves charges like the fake provider, but pays the
+// invoice through another request while the charge is in flight.
+type racingProvider struct {
+	FakePaymentProvider
+	during func()
+}
+
+func (p *racingProvider) Name() string { return "racing" }
+
+func (p *racingProvider) Charge(req ChargeRequest) (*ChargeResult, error) {
+	if p.during != nil {
+		p.during()
+	}
+	return p.FakePaymentProvider.Charge(req)
+}
+
+func openTestRenewal(t *testing.T, db *gorm.DB) (*models.Tenant, *models.Invoice) {
+	t.Helper()
+	plan := createTestPlan(t, db, "Pro", 30, 30)
+	tenant := createTestTenant(t, db, plan, time.Now().Add(48*time.Hour))
+	if opened, err := NewBillingService().openRenewalInvoice(tenant); err != nil || !opened {
+		t.Fatalf("open renewal: opened %v, err %v", opened, err)
+	}
+	var invoice models.Invoice
+	if err := db.Where("tenant_id = ?", tenant.ID).First(&invoice).Error; err != nil {
+		t.Fatal(err)
+	}
+	return tenant, &invoice
+}
+
+func TestPayInvoice(t *testing.T) {
+	db := setupBillingTest(t)
+	tenant, invoice := openTestRenewal(t, db)
+
+	payment, err := NewBillingService().PayInvoice(tenant.ID, invoice.ID, "tok_visa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != models.PaymentSucceeded || payment.Provider != "fake" || !almostEqual(payment.Amount, 30) {
+		t.Errorf("payment = %+v, want 30 succeeded through fake", payment)
+	}
+	got := reloadInvoice(t, db, invoice.ID)
+	if got.Status != models.InvoicePaid || got.PaidAt == nil {
+		t.Errorf("invoice %s, paid at %v; want paid", got.Status, got.PaidAt)
+	}
+	if expiry := reloadTenant(t, db, tenant.ID).PlanExpiry; !expiry.Equal(invoice.PeriodEnd) {
+		t.Errorf("plan expiry = %v, want %v", expiry, invoice.PeriodEnd)
+	}
+
+	if _, err := NewBillingService().PayInvoice(tenant.ID, invoice.ID, "tok_visa"); err == nil {
+		t.Error("paying a paid invoice again succeeded")
+	}
+}
+
+func TestPayInvoiceDeclined(t *testing.T) {
+	db := setupBillingTest(t)
+	tenant, invoice := openTestRenewal(t, db)
+
+	_, err := NewBillingService().PayInvoice(tenant.ID, invoice.ID, FakeDeclineMethod)
+	var declined *PaymentDeclinedError
+	if !errors.As(err, &declined) {
+		t.Fatalf("err = %v, want PaymentDeclinedError", err)
+	}
+	got := reloadInvoice(t, db, invoice.ID)
+	if got.Status != models.InvoiceOpen || len(got.Payments) != 1 || got.Payments[0].Status != models.PaymentFailed {
+		t.Errorf("invoice %s with payments %+v, want open with one failed payment", got.Status, got.Payments)
+	}
+}
+
+// Two payments racing for one invoice: the second finds it already paid and
+// keeps the money as account credit instead of settling twice.
+func TestRecordPaymentTwice(t *testing.T) {
+	db := setupBillingTest(t)
+	tenant, invoice := openTestRenewal(t, db)
+
+	provider := &racingProvider{}
+	provider.during = func() {
+		provider.during = nil
+		if _, err := NewBillingService().RecordPayment(tenant.ID, invoice.ID, "racing", "tok_visa"); err != nil {
+			t.Errorf("first payment: %v", err)
+		}
+	}
+	RegisterPaymentProvider(provider)
+
+	if _, err := NewBillingService().RecordPayment(tenant.ID, invoice.ID, "racing", "tok_visa"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := reloadInvoice(t, db, invoice.ID)
+	if got.Status != models.InvoicePaid || len(got.Payments) != 2 {
+		t.Errorf("invoice %s with %d payments, want paid with 2", got.Status, len(got.Payments))
+	}
+	after := reloadTenant(t, db, tenant.ID)
+	if !almostEqual(after.CreditBalance, invoice.Total) {
+		t.Errorf("credit balance = %.2f, want the second payment %.2f", after.CreditBalance, invoice.Total)
+	}
+	if !after.PlanExpiry.Equal(invoice.PeriodEnd) {
+		t.Errorf("plan expiry = %v, want %v (extended once)", after.PlanExpiry, invoice.PeriodEnd)
+	}
+}
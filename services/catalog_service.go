@@ -1,39 +1,34 @@
 package services
 
 import (
-	"fmt"
-	"go-multi-tenant/config"
 	"go-multi-tenant/models"
 	"go-multi-tenant/repositories"
 
 	"gorm.io/gorm"
 )
 
-type CatalogService struct{}
+type CatalogService struct {
+	settingsService *SettingsService
+	usageService    *UsageService
+}
 
 func NewCatalogService() *CatalogService {
-	return &CatalogService{}
+	return &CatalogService{settingsService: NewSettingsService(), usageService: NewUsageService()}
 }
 
 func (s *CatalogService) CreateProduct(tenantDB *gorm.DB, tenantID uint, product *models.Product) error {
 	repo := repositories.NewCatalogRepository(tenantDB)
 
-	var tenant models.Tenant
-	config.GetMasterDB().Preload("Plan").First(&tenant, tenantID)
-
-	if tenant.Plan.MaxProducts > 0 {
-		currentCount, _ := repo.CountProducts(tenantID)
-		if int(currentCount) >= tenant.Plan.MaxProducts {
-			return fmt.Errorf("plan limit reached: you can only add %d products", tenant.Plan.MaxProducts)
-		}
+	if err := s.usageService.Reserve(tenantDB, tenantID, models.MetricProducts, 1); err != nil {
+		return err
 	}
 
 	if product.Inventory == nil {
 		product.Inventory = &models.Inventory{
 			TenantID:      tenantID,
 			Quantity:      0,
-			LowStockAlert: 10,
-			Location:      "Main Warehouse",
+			LowStockAlert: s.settingsService.LowStockThreshold(tenantID),
+			Location:      s.settingsService.DefaultWarehouse(tenantID),
 		}
 	} else {
 
@@ -41,7 +36,11 @@ func (s *CatalogService) CreateProduct(tenantDB *gorm.DB, tenantID uint, product
 	}
 
 	product.TenantID = tenantID
-	return repo.CreateProduct(product)
+	if err := repo.CreateProduct(product); err != nil {
+		s.usageService.Release(tenantID, models.MetricProducts, 1)
+		return err
+	}
+	return nil
 }
 func (s *CatalogService) ListProducts(tenantDB *gorm.DB, tenantID uint, page, pageSize int) ([]models.Product, int64, error) {
 	repo := repositories.NewCatalogRepository(tenantDB)
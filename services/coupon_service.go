@@ -0,0 +1,355 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-multi-tenant/config"
+	"go-multi-tenant/models"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var couponCodeRegex = regexp.MustCompile(`^[A-Z0-9_-]{3,50}$`)
+
+type CouponService struct{}
+
+func NewCouponService() *CouponService {
+	return &CouponService{}
+}
+
+type CouponRequest struct {
+	Code           string     `json:"code" binding:"required"`
+	Description    string     `json:"description"`
+	DiscountType   string     `json:"discount_type" binding:"required"`
+	Amount         float64    `json:"amount" binding:"required"`
+	DurationMonths int        `json:"duration_months"`
+	MaxRedemptions int        `json:"max_redemptions"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	PlanIDs        []uint     `json:"plan_ids"`
+	IsActive       *bool      `json:"is_active"`
+}
+
+func (req *CouponRequest) apply(coupon *models.Coupon) error {
+	code := normalizeCouponCode(req.Code)
+	if !couponCodeRegex.MatchString(code) {
+		return errors.New("code must be 3-50 letters, digits, '-' or '_'")
+	}
+	switch req.DiscountType {
+	case models.DiscountPercent:
+		if req.Amount <= 0 || req.Amount > 100 {
+			return errors.New("a percent discount must be between 0 and 100")
+		}
+	case models.DiscountFixed:
+		if req.Amount <= 0 {
+			return errors.New("a fixed discount must be positive")
+		}
+	default:
+		return fmt.Errorf("invalid discount type %q", req.DiscountType)
+	}
+	if req.DurationMonths < 0 || req.MaxRedemptions < 0 {
+		return errors.New("duration_months and max_redemptions cannot be negative")
+	}
+
+	coupon.Code = code
+	coupon.Description = req.Description
+	coupon.DiscountType = req.DiscountType
+	coupon.Amount = req.Amount
+	coupon.DurationMonths = req.DurationMonths
+	coupon.MaxRedemptions = req.MaxRedemptions
+	coupon.ExpiresAt = req.ExpiresAt
+	if req.IsActive != nil {
+		coupon.IsActive = *req.IsActive
+	}
+	return nil
+}
+
+func (s *CouponService) CreateCoupon(req *CouponRequest) (*models.Coupon, error) {
+	coupon := &models.Coupon{IsActive: true}
+	if err := req.apply(coupon); err != nil {
+		return nil, err
+	}
+	plans, err := s.loadPlans(req.PlanIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var taken int64
+	config.GetMasterDB().Model(&models.Coupon{}).Where("code = ?", coupon.Code).Count(&taken)
+	if taken > 0 {
+		return nil, fmt.Errorf("coupon %s already exists", coupon.Code)
+	}
+
+	coupon.Plans = plans
+	if err := config.GetMasterDB().Omit("Plans.*").Create(coupon).Error; err != nil {
+		return nil, err
+	}
+	return coupon, nil
+}
+
+func (s *CouponService) ListCoupons() ([]models.Coupon, error) {
+	var coupons []models.Coupon
+	err := config.GetMasterDB().Preload("Plans").Order("id DESC").Find(&coupons).Error
+	return coupons, err
+}
+
+func (s *CouponService) GetCoupon(id uint) (*models.Coupon, error) {
+	var coupon models.Coupon
+	if err := config.GetMasterDB().Preload("Plans").First(&coupon, id).Error; err != nil {
+		return nil, errors.New("coupon not found")
+	}
+	return &coupon, nil
+}
+
+// UpdateCoupon changes a coupon for future redemptions; redemptions already
+// made keep running on the coupon's current discount.
+func (s *CouponService) UpdateCoupon(id uint, req *CouponRequest) (*models.Coupon, error) {
+	coupon, err := s.GetCoupon(id)
+	if err != nil {
+		return nil, err
+	}
+	originalCode := coupon.Code
+	if err := req.apply(coupon); err != nil {
+		return nil, err
+	}
+	if coupon.Code != originalCode && coupon.TimesRedeemed > 0 {
+		return nil, errors.New("a coupon that has been redeemed cannot be renamed")
+	}
+	plans, err := s.loadPlans(req.PlanIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.GetMasterDB().Transaction(func(tx *gorm.DB) error {
+		// times_redeemed is left alone; redemptions may be racing this update.
+		if err := tx.Model(&models.Coupon{}).Where("id = ?", coupon.ID).Updates(map[string]interface{}{
+			"code":            coupon.Code,
+			"description":     coupon.Description,
+			"discount_type":   coupon.DiscountType,
+			"amount":          coupon.Amount,
+			"duration_months": coupon.DurationMonths,
+			"max_redemptions": coupon.MaxRedemptions,
+			"expires_at":      coupon.ExpiresAt,
+			"is_active":       coupon.IsActive,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Model(coupon).Omit("Plans.*").Association("Plans").Replace(plans)
+	})
+	if err != nil {
+		return nil, err
+	}
+	coupon.Plans = plans
+	return coupon, nil
+}
+
+func (s *CouponService) loadPlans(ids []uint) ([]models.Plan, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	var plans []models.Plan
+	if err := config.GetMasterDB().Where("id IN ?", ids).Find(&plans).Error; err != nil {
+		return nil, err
+	}
+	if len(plans) != len(ids) {
+		return nil, errors.New("one or more plans were not found")
+	}
+	return plans, nil
+}
+
+// ValidateCoupon checks that code can be redeemed now for planID.
+func (s *CouponService) ValidateCoupon(db *gorm.DB, code string, planID uint) (*models.Coupon, error) {
+	var coupon models.Coupon
+	if err := db.Preload("Plans").Where("code = ?", normalizeCouponCode(code)).First(&coupon).Error; err != nil {
+		return nil, errors.New("coupon not found")
+	}
+	if !coupon.IsActive {
+		return nil, errors.New("coupon is no longer available")
+	}
+	if coupon.ExpiresAt != nil && time.Now().After(*coupon.ExpiresAt) {
+		return nil, errors.New("coupon has expired")
+	}
+	if coupon.MaxRedemptions > 0 && coupon.TimesRedeemed >= coupon.MaxRedemptions {
+		return nil, errors.New("coupon has been fully redeemed")
+	}
+	if !couponAllowsPlan(&coupon, planID) {
+		return nil, errors.New("coupon does not apply to this plan")
+	}
+	return &coupon, nil
+}
+
+// redeem applies a coupon to a tenant inside tx. The redemption count is
+// claimed with a conditional update so MaxRedemptions holds under concurrency.
+func (s *CouponService) redeem(tx *gorm.DB, tenantID uint, code string, planID uint) (*models.CouponRedemption, error) {
+	coupon, err := s.ValidateCoupon(tx, code, planID)
+	if err != nil {
+		return nil, err
+	}
+
+	var active int64
+	tx.Model(&models.CouponRedemption{}).Where("tenant_id = ? AND active = ?", tenantID, true).Count(&active)
+	if active > 0 {
+		return nil, errors.New("a coupon is already applied to this subscription")
+	}
+	var used int64
+	tx.Model(&models.CouponRedemption{}).Where("tenant_id = ? AND coupon_id = ?", tenantID, coupon.ID).Count(&used)
+	if used > 0 {
+		return nil, errors.New("coupon has already been used by this workspace")
+	}
+
+	res := tx.Model(&models.Coupon{}).
+		Where("id = ? AND (max_redemptions = 0 OR times_redeemed < max_redemptions)", coupon.ID).
+		Update("times_redeemed", gorm.Expr("times_redeemed + 1"))
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, errors.New("coupon has been fully redeemed")
+	}
+
+	redemption := &models.CouponRedemption{
+		CouponID: coupon.ID,
+		TenantID: tenantID,
+		PlanID:   planID,
+		Active:   true,
+	}
+	if err := tx.Create(redemption).Error; err != nil {
+		return nil, err
+	}
+	redemption.Coupon = coupon
+	return redemption, nil
+}
+
+// discountFor returns the invoice line for the tenant's active coupon, or nil
+// when none applies to plan. Every invoice for a period starting within the
+// coupon's duration is discounted, renewals and plan changes alike; the first
+// one past it ends the redemption.
+func (s *CouponService) discountFor(tx *gorm.DB, tenantID uint, plan *models.Plan, subtotal float64, periodStart time.Time) (*models.InvoiceLine, error) {
+	if subtotal <= 0 {
+		return nil, nil
+	}
+	var redemption models.CouponRedemption
+	err := tx.Preload("Coupon.Plans").Where("tenant_id = ? AND active = ?", tenantID, true).First(&redemption).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	coupon := redemption.Coupon
+	if coupon == nil {
+		return nil, nil
+	}
+	if coupon.DurationMonths > 0 && !periodStart.Before(redemption.RedeemedAt.AddDate(0, coupon.DurationMonths, 0)) {
+		return nil, tx.Model(&redemption).Updates(map[string]interface{}{
+			"active":   false,
+			"ended_at": time.Now(),
+		}).Error
+	}
+	if !couponAllowsPlan(coupon, plan.ID) {
+		return nil, nil
+	}
+
+	discount := coupon.Amount
+	label := fmt.Sprintf("%s%s (%s off)", couponLinePrefix, coupon.Code, formatMoney(coupon.Amount))
+	if coupon.DiscountType == models.DiscountPercent {
+		discount = subtotal * coupon.Amount / 100
+		label = fmt.Sprintf("%s%s (%g%% off)", couponLinePrefix, coupon.Code, coupon.Amount)
+	}
+	discount = roundMoney(math.Min(discount, subtotal))
+
+	if err := tx.Model(&redemption).Updates(map[string]interface{}{
+		"total_discount":      gorm.Expr("total_discount + ?", discount),
+		"invoices_discounted": gorm.Expr("invoices_discounted + 1"),
+	}).Error; err != nil {
+		return nil, err
+	}
+	return &models.InvoiceLine{Description: label, Amount: -discount}, nil
+}
+
+// reverseDiscount takes a voided invoice's coupon discount back off the
+// redemption that granted it, which may have ended since. A workspace redeems
+// each coupon once, so the code on the line identifies the redemption.
+func (s *CouponService) reverseDiscount(tx *gorm.DB, invoice *models.Invoice, line models.InvoiceLine) error {
+	code, _, _ := strings.Cut(strings.TrimPrefix(line.Description, couponLinePrefix), " ")
+	var redemption models.CouponRedemption
+	err := tx.Joins("JOIN coupons ON coupons.id = coupon_redemptions.coupon_id").
+		Where("coupon_redemptions.tenant_id = ? AND coupons.code = ?", invoice.TenantID, code).
+		Order("coupon_redemptions.id DESC").
+		First(&redemption).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Model(&redemption).Updates(map[string]interface{}{
+		"total_discount":      gorm.Expr("total_discount - ?", invoice.Discount),
+		"invoices_discounted": gorm.Expr("invoices_discounted - 1"),
+	}).Error
+}
+
+type CouponRedemptionRow struct {
+	models.CouponRedemption
+	TenantName string `json:"tenant_name"`
+	PlanName   string `json:"plan_name"`
+}
+
+type CouponReport struct {
+	Coupon        *models.Coupon        `json:"coupon"`
+	Redemptions   []CouponRedemptionRow `json:"redemptions"`
+	ActiveCount   int                   `json:"active_count"`
+	TotalDiscount float64               `json:"total_discount"`
+}
+
+// RedemptionReport lists who redeemed a coupon and how much it has discounted so far.
+func (s *CouponService) RedemptionReport(couponID uint) (*CouponReport, error) {
+	coupon, err := s.GetCoupon(couponID)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []CouponRedemptionRow
+	if err := config.GetMasterDB().Model(&models.CouponRedemption{}).
+		Select("coupon_redemptions.*, tenants.name AS tenant_name, plans.name AS plan_name").
+		Joins("LEFT JOIN tenants ON tenants.id = coupon_redemptions.tenant_id").
+		Joins("LEFT JOIN plans ON plans.id = coupon_redemptions.plan_id").
+		Where("coupon_redemptions.coupon_id = ?", couponID).
+		Order("coupon_redemptions.id DESC").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	report := &CouponReport{Coupon: coupon, Redemptions: rows}
+	for _, row := range rows {
+		if row.Active {
+			report.ActiveCount++
+		}
+		report.TotalDiscount += row.TotalDiscount
+	}
+	report.TotalDiscount = roundMoney(report.TotalDiscount)
+	return report, nil
+}
+
+func couponAllowsPlan(coupon *models.Coupon, planID uint) bool {
+	if len(coupon.Plans) == 0 {
+		return true
+	}
+	for _, plan := range coupon.Plans {
+		if plan.ID == planID {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func formatMoney(amount float64) string {
+	return fmt.Sprintf("%.2f %s", amount, config.AppConfig.BillingCurrency)
+}
@@ -1,175 +1,261 @@
-// package services
-
-// import (
-// 	"fmt"
-// 	"go-multi-tenant/config"
-// 	"go-multi-tenant/models"
-// 	"go-multi-tenant/repositories"
-// 	"log"
-// 	"time"
-
-// 	"github.com/robfig/cron/v3"
-// 	"gopkg.in/gomail.v2"
-// )
-
-// type CronService struct {
-// 	cron *cron.Cron
-// }
-
-// func NewCronService() *CronService {
-// 	c := cron.New()
-// 	return &CronService{cron: c}
-// }
-
-// func (s *CronService) Start() {
-// 	// 1. Plan Expiry Check (Rozana Raat 12 Bajay)
-// 	_, err := s.cron.AddFunc("0 0 * * *", func() {
-// 		log.Println("⏰ CRON: Checking Plan Expiries...")
-// 		s.HandlePlanExpiries()
-// 	})
-// 	if err != nil {
-// 		log.Printf("Error scheduling expiry job: %v", err)
-// 	}
-
-// 	// 2. Low Stock Alert (Rozana Subah 9 Bajay)
-// 	_, err = s.cron.AddFunc("0 9 * * *", func() {
-// 		log.Println("⏰ CRON: Checking Low Stock...")
-// 		s.CheckLowStock()
-// 	})
-// 	if err != nil {
-// 		log.Printf("Error scheduling stock job: %v", err)
-// 	}
-
-// 	s.cron.Start()
-// 	log.Println("✅ Cron Scheduler Started")
-// }
-
-// func (s *CronService) Stop() {
-// 	s.cron.Stop()
-// }
-
-// // ---------------------------------------------------------
-// // JOB 1: Handle Plan Expiry (Suspend + Warning Email)
-// // ---------------------------------------------------------
-// func (s *CronService) HandlePlanExpiries() {
-// 	db := config.GetMasterDB()
-// 	var tenants []models.Tenant
-
-// 	now := time.Now()
-// 	warningThreshold := now.Add(3 * 24 * time.Hour) // 3 Days later
-
-// 	// Filter: Sirf wo tenants jo ACTIVE hain aur jinki expiry set hai
-// 	if err := db.Preload("Plan").Where("is_active = ? AND plan_expiry IS NOT NULL", true).Find(&tenants).Error; err != nil {
-// 		log.Printf("Error fetching tenants: %v", err)
-// 		return
-// 	}
-
-// 	for _, tenant := range tenants {
-
-// 		// A. Check if EXPIRED (Suspension Logic)
-// 		if tenant.PlanExpiry.Before(now) {
-// 			log.Printf("🚫 Tenant %s expired. Suspending account...", tenant.Name)
-
-// 			// ✅ CHANGE: Inactive kar do (Downgrade nahi)
-// 			tenant.IsActive = false
-// 			db.Save(&tenant)
-
-// 			// 3. Cache Clear karo (Taaki wo foran logout ho jaye)
-// 			cacheService := NewCacheService()
-// 			cacheService.Delete(fmt.Sprintf("tenant_info:%d", tenant.ID))
-
-// 			// Notify Admin
-// 			email, _ := s.GetTenantAdminEmail(&tenant)
-// 			if email != "" {
-// 				s.SendEmail(email, "Account Suspended - Plan Expired",
-// 					fmt.Sprintf("Your subscription for <b>%s</b> has expired. Your access has been suspended. Please renew your plan to continue.", tenant.Name))
-// 			}
-// 			continue
-// 		}
-
-// 		// B. Check if EXPIRING SOON (Warning Logic)
-// 		if tenant.PlanExpiry.Before(warningThreshold) {
-// 			log.Printf("⚠️ Tenant %s expiring soon. Sending warning...", tenant.Name)
-
-// 			email, _ := s.GetTenantAdminEmail(&tenant)
-// 			if email != "" {
-// 				daysLeft := int(time.Until(*tenant.PlanExpiry).Hours() / 24)
-// 				s.SendEmail(email, "Action Required: Plan Expiring Soon",
-// 					fmt.Sprintf("Your plan for <b>%s</b> will expire in %d days. If not renewed, your account will be suspended.", tenant.Name, daysLeft))
-// 			}
-// 		}
-// 	}
-// }
-
-// // ---------------------------------------------------------
-// // JOB 2: Low Stock Alerts
-// // ---------------------------------------------------------
-// func (s *CronService) CheckLowStock() {
-// 	// Sirf Active tenants ka stock check karo
-// 	var tenants []models.Tenant
-// 	if err := config.GetMasterDB().Where("is_active = ?", true).Find(&tenants).Error; err != nil {
-// 		return
-// 	}
-
-// 	for _, tenant := range tenants {
-// 		tenantDB, err := config.TenantManager.GetTenantDB(&tenant)
-// 		if err != nil {
-// 			continue
-// 		}
-
-// 		repo := repositories.NewInventoryRepository(tenantDB)
-// 		items, err := repo.GetLowStockProducts(10)
-
-// 		if err == nil && len(items) > 0 {
-// 			email, _ := s.GetTenantAdminEmail(&tenant)
-// 			if email != "" {
-// 				s.SendEmail(email, "Low Stock Alert",
-// 					fmt.Sprintf("You have %d items running low on stock. Please check your inventory.", len(items)))
-// 			}
-// 		}
-// 	}
-// }
-
-// // ---------------------------------------------------------
-// // Helpers
-// // ---------------------------------------------------------
-
-// func (s *CronService) GetTenantAdminEmail(tenant *models.Tenant) (string, error) {
-// 	tenantDB, err := config.TenantManager.GetTenantDB(tenant)
-// 	if err != nil {
-// 		return "", err
-// 	}
-
-// 	var user models.User
-// 	err = tenantDB.Table("users").
-// 		Select("users.email").
-// 		Joins("JOIN user_roles ON user_roles.user_id = users.id").
-// 		Joins("JOIN roles ON roles.id = user_roles.role_id").
-// 		Where("roles.name = ?", "Tenant Admin").
-// 		Limit(1).
-// 		First(&user).Error
-
-// 	return user.Email, err
-// }
-
-// func (s *CronService) SendEmail(to string, subject string, body string) {
-// 	from := "muhammad.anas.khalid.13@gmail.com"
-// 	pass := "atuyfmtqmsqmepxh"
-// 	host := "smtp.gmail.com"
-// 	port := 587
-
-// 	m := gomail.NewMessage()
-// 	m.SetHeader("From", from)
-// 	m.SetHeader("To", to)
-// 	m.SetHeader("Subject", subject)
-// 	m.SetBody("text/html", fmt.Sprintf("<div style='font-family: sans-serif; padding: 20px; border: 1px solid #ccc;'>%s</div>", body))
-
-// 	d := gomail.NewDialer(host, port, from, pass)
-
-//		if err := d.DialAndSend(m); err != nil {
-//			log.Printf("❌ Failed to send email to %s: %v", to, err)
-//		} else {
-//			log.Printf("✅ Email sent to %s", to)
-//		}
-//	}
 package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-multi-tenant/config"
+	"go-multi-tenant/cron"
+	"go-multi-tenant/models"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Finished jobs and scheduler runs are kept this long for the history APIs.
+const historyRetention = 30 * 24 * time.Hour
+
+var scheduler *cron.Scheduler
+
+// LowStockAlert is emitted by the daily low-stock check for each tenant with
+// items at or below its threshold.
+type LowStockAlert struct {
+	TenantID   uint               `json:"tenant_id"`
+	TenantName string             `json:"tenant_name"`
+	Items      []models.Inventory `json:"items"`
+}
+
+var (
+	lowStockHandlers   []func(LowStockAlert)
+	lowStockHandlersMu sync.RWMutex
+)
+
+// OnLowStock registers a handler called for every low-stock alert.
+func OnLowStock(handler func(LowStockAlert)) {
+	lowStockHandlersMu.Lock()
+	defer lowStockHandlersMu.Unlock()
+	lowStockHandlers = append(lowStockHandlers, handler)
+}
+
+func emitLowStock(alert LowStockAlert) {
+	log.Printf("Low stock: tenant %s has %d items at or below threshold", alert.TenantName, len(alert.Items))
+
+	lowStockHandlersMu.RLock()
+	defer lowStockHandlersMu.RUnlock()
+	for _, handler := range lowStockHandlers {
+		handler(alert)
+	}
+}
+
+// InitScheduler starts the cron scheduler. Every instance runs one, but only
+// the elected leader fires scheduled jobs.
+func InitScheduler(cfg *config.Config) error {
+	lock, err := newSchedulerLock(cfg)
+	if err != nil {
+		return err
+	}
+	loc, err := time.LoadLocation(cfg.SchedulerTimezone)
+	if err != nil {
+		return fmt.Errorf("invalid SCHEDULER_TIMEZONE: %w", err)
+	}
+
+	scheduler = cron.New(config.GetMasterDB(), lock, loc)
+	s := NewCronService()
+	jobs := []struct {
+		name, spec, description string
+		fn                      cron.JobFunc
+	}{
+		{"billing", "0 * * * *", "Apply scheduled plan changes, open renewal invoices and walk expired tenants through dunning", s.RunBilling},
+		{"low-stock", "0 9 * * *", "Alert tenants about items at or below their low-stock threshold", s.CheckLowStock},
+		{"storage-metering", "30 * * * *", "Re-estimate storage usage for every active tenant", s.RefreshStorage},
+		{"cleanup", "15 * * * *", "Remove expired sandboxes and exports, and prune old job, run, outbox and webhook history", s.Cleanup},
+		{"notification-purge", "45 3 * * *", "Remove notifications read more than 30 days ago in every tenant", s.PurgeNotifications},
+		{"scheduled-tasks", "* * * * *", "Queue runs of tenant scheduled tasks that are due", NewScheduledTaskService().DispatchDue},
+		{"mail-outbox", "* * * * *", "Queue outbox emails that were committed but not yet sent", NewMailService().RelayOutbox},
+		{"api-usage-flush", "* * * * *", "Copy API call counts from Redis to the usage counters", s.FlushAPIUsage},
+	}
+	for _, job := range jobs {
+		if err := scheduler.Register(job.name, job.spec, job.description, job.fn); err != nil {
+			return err
+		}
+	}
+
+	scheduler.Start()
+	return nil
+}
+
+func newSchedulerLock(cfg *config.Config) (cron.Lock, error) {
+	switch cfg.SchedulerLock {
+	case "database":
+		return cron.NewDatabaseLock(config.GetMasterDB()), nil
+	case "redis":
+		if config.RedisClient == nil {
+			return nil, errors.New("SCHEDULER_LOCK is redis but Redis is not configured")
+		}
+		return cron.NewRedisLock(config.Ctx, config.RedisClient), nil
+	case "auto", "":
+		if config.RedisClient != nil && config.RedisClient.Ping(config.Ctx).Err() == nil {
+			return cron.NewRedisLock(config.Ctx, config.RedisClient), nil
+		}
+		log.Println("Scheduler: Redis unavailable, using database locks")
+		return cron.NewDatabaseLock(config.GetMasterDB()), nil
+	default:
+		return nil, fmt.Errorf("unknown SCHEDULER_LOCK %q", cfg.SchedulerLock)
+	}
+}
+
+// StopScheduler gives up leadership and waits for running jobs until ctx expires.
+func StopScheduler(ctx context.Context) error {
+	if scheduler == nil {
+		return nil
+	}
+	return scheduler.Stop(ctx)
+}
+
+type CronService struct{}
+
+func NewCronService() *CronService {
+	return &CronService{}
+}
+
+func (s *CronService) RunBilling(ctx context.Context) (string, error) {
+	NewPlanService().ApplyScheduledChanges()
+	opened := NewBillingService().GenerateDueInvoices()
+	NewDunningService().HandlePlanExpiries()
+	return fmt.Sprintf("opened %d renewal invoices", opened), nil
+}
+
+func (s *CronService) CheckLowStock(ctx context.Context) (string, error) {
+	var tenants []models.Tenant
+	if err := config.GetMasterDB().Where("is_active = ?", true).Find(&tenants).Error; err != nil {
+		return "", fmt.Errorf("error fetching tenants: %w", err)
+	}
+
+	inventoryService := NewInventoryService()
+	alerted := 0
+	for i := range tenants {
+		tenant := &tenants[i]
+		if ctx.Err() != nil {
+			return fmt.Sprintf("stopped after alerting %d tenants", alerted), ctx.Err()
+		}
+		if tenant.GetActualDBName() == "master_db" {
+			continue
+		}
+		tenantDB, err := config.TenantManager.GetTenantDB(tenant)
+		if err != nil {
+			log.Printf("Low-stock check skipped tenant %s: %v", tenant.Name, err)
+			continue
+		}
+		items, err := inventoryService.GetLowStockAlerts(config.ScopeToTenant(tenantDB, tenant.ID), tenant.ID, 0)
+		if err != nil {
+			log.Printf("Low-stock check failed for tenant %s: %v", tenant.Name, err)
+			continue
+		}
+		if len(items) > 0 {
+			emitLowStock(LowStockAlert{TenantID: tenant.ID, TenantName: tenant.Name, Items: items})
+			alerted++
+		}
+	}
+	return fmt.Sprintf("%d of %d tenants have low stock", alerted, len(tenants)), nil
+}
+
+func (s *CronService) FlushAPIUsage(ctx context.Context) (string, error) {
+	flushed, err := NewUsageService().FlushAPIUsage()
+	return fmt.Sprintf("flushed %d API call counters", flushed), err
+}
+
+func (s *CronService) RefreshStorage(ctx context.Context) (string, error) {
+	NewUsageService().RefreshAllStorage()
+	return "", nil
+}
+
+func (s *CronService) Cleanup(ctx context.Context) (string, error) {
+	cutoff := time.Now().Add(-historyRetention)
+	var errs []error
+
+	sandboxes, err := NewSandboxService().ExpireSandboxes()
+	errs = append(errs, err)
+	exports := NewPrivacyService().PurgeExpiredExports()
+	// Before purging jobs: an abandoned import is found through its job.
+	_, err = NewUserImportService().FailAbandonedImports()
+	errs = append(errs, err)
+	jobs, err := NewQueueService().PurgeFinishedJobs(cutoff)
+	errs = append(errs, err)
+	cronRuns := config.GetMasterDB().Where("finished_at < ?", cutoff).Delete(&models.CronRun{})
+	errs = append(errs, cronRuns.Error)
+	taskRuns := config.GetMasterDB().Where("finished_at < ?", cutoff).Delete(&models.ScheduledTaskRun{})
+	errs = append(errs, taskRuns.Error)
+	emails := config.GetMasterDB().Where("status IN ? AND updated_at < ?",
+		[]string{models.OutboxSent, models.OutboxFailed, models.OutboxSuppressed}, cutoff).Delete(&models.OutboxEmail{})
+	errs = append(errs, emails.Error)
+	deliveries, err := NewWebhookService().PurgeDeliveries(cutoff)
+	errs = append(errs, err)
+	tickets, err := NewStreamService().PurgeExpiredTickets()
+	errs = append(errs, err)
+
+	return fmt.Sprintf("removed %d sandboxes, %d exports, %d jobs, %d run records, %d outbox emails, %d webhook deliveries and %d stream tickets",
+		sandboxes, exports, jobs, cronRuns.RowsAffected+taskRuns.RowsAffected, emails.RowsAffected, deliveries, tickets), errors.Join(errs...)
+}
+
+// PurgeNotifications runs apart from Cleanup, and only daily, because it
+// opens every tenant's database.
+func (s *CronService) PurgeNotifications(ctx context.Context) (string, error) {
+	removed, err := NewNotificationService().PurgeRead(time.Now().Add(-historyRetention))
+	return fmt.Sprintf("removed %d read notifications", removed), err
+}
+
+func (s *CronService) ListJobs() ([]cron.JobInfo, error) {
+	if scheduler == nil {
+		return nil, errors.New("scheduler is not running")
+	}
+	return scheduler.Jobs(), nil
+}
+
+type SchedulerStatus struct {
+	Instance string `json:"instance"`
+	Leader   string `json:"leader"`
+	Lock     string `json:"lock"`
+}
+
+func (s *CronService) Status() (*SchedulerStatus, error) {
+	if scheduler == nil {
+		return nil, errors.New("scheduler is not running")
+	}
+	leader, err := scheduler.Leader()
+	if err != nil {
+		return nil, err
+	}
+	return &SchedulerStatus{Instance: scheduler.Instance(), Leader: leader, Lock: scheduler.LockName()}, nil
+}
+
+var cronRunStatuses = []string{models.CronRunRunning, models.CronRunSucceeded, models.CronRunFailed, models.CronRunSkipped}
+
+func (s *CronService) ListRuns(job, status string, page, pageSize int) ([]models.CronRun, int64, error) {
+	query := config.GetMasterDB().Model(&models.CronRun{})
+	if job != "" {
+		query = query.Where("job = ?", job)
+	}
+	if status != "" {
+		if !slices.Contains(cronRunStatuses, status) {
+			return nil, 0, fmt.Errorf("invalid status %q", status)
+		}
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var runs []models.CronRun
+	err := query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&runs).Error
+	return runs, total, err
+}
+
+// Trigger runs a job now, on this instance, regardless of leadership.
+func (s *CronService) Trigger(name string, userID uint) (*models.CronRun, error) {
+	if scheduler == nil {
+		return nil, errors.New("scheduler is not running")
+	}
+	return scheduler.Trigger(name, userID)
+}
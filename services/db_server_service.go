@@ -0,0 +1,189 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-multi-tenant/config"
+	"go-multi-tenant/models"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+type DBServerService struct{}
+
+func NewDBServerService() *DBServerService {
+	return &DBServerService{}
+}
+
+// DBServerUsage is a registered server with the number of tenant databases on it.
+type DBServerUsage struct {
+	models.DatabaseServer
+	TenantCount int64 `json:"tenant_count"`
+}
+
+type DBServerRequest struct {
+	Name           string `json:"name" binding:"required"`
+	Driver         string `json:"driver" binding:"required"`
+	Host           string `json:"host" binding:"required"`
+	Port           int    `json:"port"`
+	Region         string `json:"region" binding:"required"`
+	CredentialsRef string `json:"credentials_ref"`
+	Capacity       int    `json:"capacity"`
+	IsActive       *bool  `json:"is_active"`
+}
+
+func (req *DBServerRequest) apply(server *models.DatabaseServer) error {
+	if _, err := config.GetDBDriver(req.Driver); err != nil {
+		return err
+	}
+	if req.Capacity < 0 {
+		return errors.New("capacity cannot be negative")
+	}
+	if _, _, err := config.ResolveCredentials(req.CredentialsRef); err != nil {
+		return err
+	}
+
+	server.Name = req.Name
+	server.Driver = req.Driver
+	server.Host = req.Host
+	server.Port = req.Port
+	server.Region = strings.ToLower(req.Region)
+	server.CredentialsRef = req.CredentialsRef
+	server.Capacity = req.Capacity
+	if req.IsActive != nil {
+		server.IsActive = *req.IsActive
+	}
+	return nil
+}
+
+func (s *DBServerService) CreateServer(req *DBServerRequest) (*models.DatabaseServer, error) {
+	server := &models.DatabaseServer{IsActive: true}
+	if err := req.apply(server); err != nil {
+		return nil, err
+	}
+	if err := config.GetMasterDB().Create(server).Error; err != nil {
+		return nil, fmt.Errorf("failed to register server: %w", err)
+	}
+	return server, nil
+}
+
+func (s *DBServerService) UpdateServer(id uint, req *DBServerRequest) (*models.DatabaseServer, error) {
+	server, err := s.getServer(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Moving a server that already hosts tenants would strand their databases.
+	count := s.tenantCount(id)
+	if count > 0 && (req.Driver != server.Driver || req.Host != server.Host || req.Port != server.Port || !strings.EqualFold(req.Region, server.Region)) {
+		return nil, fmt.Errorf("server hosts %d tenant databases; only name, credentials, capacity and status can change", count)
+	}
+
+	if err := req.apply(server); err != nil {
+		return nil, err
+	}
+	if err := config.GetMasterDB().Save(server).Error; err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+func (s *DBServerService) ListServers() ([]DBServerUsage, error) {
+	var servers []models.DatabaseServer
+	if err := config.GetMasterDB().Order("region, name").Find(&servers).Error; err != nil {
+		return nil, err
+	}
+
+	result := make([]DBServerUsage, len(servers))
+	for i, server := range servers {
+		result[i] = DBServerUsage{DatabaseServer: server, TenantCount: s.tenantCount(server.ID)}
+	}
+	return result, nil
+}
+
+func (s *DBServerService) DeleteServer(id uint) error {
+	server, err := s.getServer(id)
+	if err != nil {
+		return err
+	}
+	if count := s.tenantCount(id); count > 0 {
+		return fmt.Errorf("server still hosts %d tenant databases", count)
+	}
+	return config.GetMasterDB().Delete(server).Error
+}
+
+func (s *DBServerService) getServer(id uint) (*models.DatabaseServer, error) {
+	var server models.DatabaseServer
+	if err := config.GetMasterDB().First(&server, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("database server not found")
+		}
+		return nil, err
+	}
+	return &server, nil
+}
+
+func (s *DBServerService) tenantCount(serverID uint) int64 {
+	var count int64
+	config.GetMasterDB().Model(&models.Tenant{}).Where("db_server_id = ?", serverID).Count(&count)
+	return count
+}
+
+func (s *DBServerService) hasCapacity(server *models.DatabaseServer) bool {
+	return server.Capacity == 0 || s.tenantCount(server.ID) < int64(server.Capacity)
+}
+
+// PlaceDedicated picks the server for a new dedicated tenant database:
+//   - an explicitly requested server must be active, have room and match the region;
+//   - otherwise a region picks its least-loaded active server with spare capacity;
+//   - with neither, nil is returned and the default server is used.
+//
+// A region that cannot be satisfied is an error; we never fall back to a
+// server outside the requested region.
+func (s *DBServerService) PlaceDedicated(region string, serverID *uint) (*models.DatabaseServer, error) {
+	region = strings.ToLower(strings.TrimSpace(region))
+
+	if serverID != nil {
+		server, err := s.getServer(*serverID)
+		if err != nil {
+			return nil, err
+		}
+		if !server.IsActive {
+			return nil, fmt.Errorf("database server %s is disabled", server.Name)
+		}
+		if region != "" && server.Region != region {
+			return nil, fmt.Errorf("database server %s is in region %s, not %s", server.Name, server.Region, region)
+		}
+		if !s.hasCapacity(server) {
+			return nil, fmt.Errorf("database server %s is full", server.Name)
+		}
+		return server, nil
+	}
+
+	if region == "" {
+		return nil, nil
+	}
+
+	var candidates []models.DatabaseServer
+	if err := config.GetMasterDB().Where("region = ? AND is_active = ?", region, true).Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+
+	var best *models.DatabaseServer
+	var bestCount int64
+	for i := range candidates {
+		server := &candidates[i]
+		count := s.tenantCount(server.ID)
+		if server.Capacity > 0 && count >= int64(server.Capacity) {
+			continue
+		}
+		if best == nil || count < bestCount {
+			best, bestCount = server, count
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no database server with spare capacity in region %s", region)
+	}
+	return best, nil
+}
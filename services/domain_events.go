@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-multi-tenant/config"
+	"go-multi-tenant/events"
+	"go-multi-tenant/models"
+	"log"
+	"time"
+)
+
+// Domain events. Services publish them with publishEvent once the change
+// they describe is committed.
+
+type UserCreated struct {
+	TenantID  uint      `json:"tenant_id"`
+	UserID    uint      `json:"user_id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	RoleID    uint      `json:"role_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (e UserCreated) EventName() string { return "user.created" }
+func (e UserCreated) EventTenant() uint { return e.TenantID }
+
+type UserUpdated struct {
+	TenantID uint `json:"tenant_id"`
+	UserID   uint `json:"user_id"`
+}
+
+func (e UserUpdated) EventName() string { return "user.updated" }
+func (e UserUpdated) EventTenant() uint { return e.TenantID }
+
+type UserDeleted struct {
+	TenantID uint `json:"tenant_id"`
+	UserID   uint `json:"user_id"`
+}
+
+func (e UserDeleted) EventName() string { return "user.deleted" }
+func (e UserDeleted) EventTenant() uint { return e.TenantID }
+
+// RoleChanged is published when a role's permissions change, with UserID 0,
+// or when a user is given a different role.
+type RoleChanged struct {
+	TenantID uint `json:"tenant_id"`
+	RoleID   uint `json:"role_id"`
+	UserID   uint `json:"user_id,omitempty"`
+}
+
+func (e RoleChanged) EventName() string { return "role.changed" }
+func (e RoleChanged) EventTenant() uint { return e.TenantID }
+
+type StockAdjusted struct {
+	TenantID    uint   `json:"tenant_id"`
+	ProductID   uint   `json:"product_id"`
+	SKU         string `json:"sku"`
+	ProductName string `json:"product_name"`
+	Location    string `json:"location"`
+	Before      int    `json:"before"`
+	After       int    `json:"after"`
+}
+
+func (e StockAdjusted) EventName() string { return "stock.adjusted" }
+func (e StockAdjusted) EventTenant() uint { return e.TenantID }
+
+// FellBelow reports whether the adjustment took the quantity from above
+// threshold to at or below it.
+func (e StockAdjusted) FellBelow(threshold int) bool {
+	return e.Before > threshold && e.After <= threshold
+}
+
+// PurchaseOrderStatusChanged is published when an order is created (with an
+// empty From) and on every status change after that.
+type PurchaseOrderStatusChanged struct {
+	TenantID uint                 `json:"tenant_id"`
+	Order    models.PurchaseOrder `json:"order"`
+	From     string               `json:"from"`
+	To       string               `json:"to"`
+	ActorID  uint                 `json:"actor_id"`
+}
+
+func (e PurchaseOrderStatusChanged) EventName() string { return "purchase_order.status_changed" }
+func (e PurchaseOrderStatusChanged) EventTenant() uint { return e.TenantID }
+
+// NotificationsCreated carries in-app notifications just stored for users.
+type NotificationsCreated struct {
+	TenantID      uint               `json:"tenant_id"`
+	Notifications []NotificationView `json:"notifications"`
+}
+
+func (e NotificationsCreated) EventName() string { return "notifications.created" }
+func (e NotificationsCreated) EventTenant() uint { return e.TenantID }
+
+type TenantSuspended struct {
+	TenantID uint   `json:"tenant_id"`
+	Reason   string `json:"reason"`
+}
+
+func (e TenantSuspended) EventName() string { return "tenant.suspended" }
+func (e TenantSuspended) EventTenant() uint { return e.TenantID }
+
+var eventBus *events.Bus
+
+// InitEventBus starts the domain event bus and registers the subscribers.
+// Redis must be initialised first for the redis and auto fan-out settings.
+func InitEventBus(cfg *config.Config) error {
+	transport, err := newEventTransport(cfg)
+	if err != nil {
+		return err
+	}
+	eventBus = events.New(events.Options{Workers: cfg.EventWorkers})
+	if transport != nil {
+		eventBus.Connect(transport)
+		log.Printf("Events: fanning out to other instances over %s", transport.Name())
+	}
+	registerEventSubscribers(eventBus)
+	return nil
+}
+
+func newEventTransport(cfg *config.Config) (events.Transport, error) {
+	switch cfg.EventFanout {
+	case "off":
+		return nil, nil
+	case "redis":
+		if config.RedisClient == nil {
+			return nil, errors.New("EVENT_FANOUT is redis but Redis is not configured")
+		}
+		return events.NewRedisTransport(config.RedisClient, "events"), nil
+	case "auto", "":
+		if config.RedisClient != nil && config.RedisClient.Ping(config.Ctx).Err() == nil {
+			return events.NewRedisTransport(config.RedisClient, "events"), nil
+		}
+		log.Println("Events: Redis unavailable, events stay on this instance")
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown EVENT_FANOUT %q", cfg.EventFanout)
+	}
+}
+
+// ShutdownEventBus waits for async subscribers until ctx expires.
+func ShutdownEventBus(ctx context.Context) error {
+	if eventBus == nil {
+		return nil
+	}
+	return eventBus.Close(ctx)
+}
+
+// publishEvent publishes a committed change. Before InitEventBus, e.g. in
+// command-line tools, events have no subscribers and are dropped.
+func publishEvent(event events.Event) {
+	if eventBus != nil {
+		eventBus.Publish(context.Background(), event)
+	}
+}
+
+func registerEventSubscribers(bus *events.Bus) {
+	// Cached user lists and permissions.
+	events.Subscribe(bus, func(ctx context.Context, e UserCreated) { clearUserCache(e.TenantID) })
+	events.Subscribe(bus, func(ctx context.Context, e UserUpdated) { clearUserCache(e.TenantID) })
+	events.Subscribe(bus, func(ctx context.Context, e UserDeleted) {
+		clearUserCache(e.TenantID)
+		_ = NewCacheService().Delete(fmt.Sprintf("user_perms:%d:%d", e.TenantID, e.UserID))
+	})
+	events.Subscribe(bus, func(ctx context.Context, e RoleChanged) {
+		clearUserCache(e.TenantID)
+		if e.UserID != 0 {
+			_ = NewCacheService().Delete(fmt.Sprintf("user_perms:%d:%d", e.TenantID, e.UserID))
+		} else {
+			_ = NewCacheService().ClearPattern(fmt.Sprintf("user_perms:%d:*", e.TenantID))
+		}
+	})
+
+	events.Subscribe(bus, func(ctx context.Context, e TenantSuspended) {
+		_ = NewCacheService().Delete(fmt.Sprintf("tenant_info:%d", e.TenantID))
+	})
+	// Every instance holds its own connection pools.
+	events.Subscribe(bus, func(ctx context.Context, e TenantSuspended) {
+		config.TenantManager.Evict(e.TenantID)
+	}, events.AllInstances())
+
+	subscribeWebhooks(bus)
+	subscribeNotifications(bus)
+	subscribeStream(bus)
+}
@@ -0,0 +1,279 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-multi-tenant/config"
+	"go-multi-tenant/models"
+	"go-multi-tenant/utils"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	domainVerificationPrefix = "_saas-verification"
+	domainVerificationValue  = "saas-verification="
+)
+
+var (
+	subdomainRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+	slugStripRegex = regexp.MustCompile(`[^a-z0-9]+`)
+	domainRegex    = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,}$`)
+
+	reservedSubdomains = map[string]bool{"www": true, "api": true, "admin": true, "app": true, "mail": true}
+
+	// lookupTXT is swapped out where DNS is not reachable.
+	lookupTXT = net.LookupTXT
+
+	ErrHostTenantNotFound = errors.New("no tenant is served from this host")
+)
+
+type DomainService struct {
+	settingsService *SettingsService
+}
+
+func NewDomainService() *DomainService {
+	return &DomainService{settingsService: NewSettingsService()}
+}
+
+type LoginContext struct {
+	TenantID  uint             `json:"tenant_id"`
+	Name      string           `json:"name"`
+	Subdomain string           `json:"subdomain,omitempty"`
+	Host      string           `json:"host"`
+	Branding  BrandingSettings `json:"branding"`
+
+	// Set while the workspace is not in normal mode or a maintenance window is scheduled.
+	Maintenance *MaintenanceNotice `json:"maintenance,omitempty"`
+}
+
+type MaintenanceNotice struct {
+	Mode     models.TenantMode `json:"mode"`
+	Message  string            `json:"message,omitempty"`
+	StartsAt *time.Time        `json:"starts_at,omitempty"`
+	EndsAt   *time.Time        `json:"ends_at,omitempty"`
+}
+
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+// NormalizeSubdomain validates a requested subdomain, or derives one from the tenant name.
+func NormalizeSubdomain(requested, tenantName string) (string, error) {
+	sub := strings.ToLower(strings.TrimSpace(requested))
+	if sub == "" {
+		sub = strings.Trim(slugStripRegex.ReplaceAllString(strings.ToLower(tenantName), "-"), "-")
+		if len(sub) > 63 {
+			sub = strings.Trim(sub[:63], "-")
+		}
+	}
+	if !subdomainRegex.MatchString(sub) {
+		return "", fmt.Errorf("invalid subdomain %q", sub)
+	}
+	if reservedSubdomains[sub] {
+		return "", fmt.Errorf("subdomain %q is reserved", sub)
+	}
+	return sub, nil
+}
+
+// ResolveHost maps a request host to a tenant. It returns (nil, nil) for hosts
+// that are not tenant-specific, such as the bare base domain or localhost.
+func (s *DomainService) ResolveHost(rawHost string) (*models.Tenant, error) {
+	host := normalizeHost(rawHost)
+	baseDomain := strings.ToLower(config.AppConfig.AppBaseDomain)
+	if host == "" || host == baseDomain || host == "localhost" || net.ParseIP(host) != nil {
+		return nil, nil
+	}
+
+	var sub string
+	if baseDomain != "" && strings.HasSuffix(host, "."+baseDomain) {
+		sub = strings.TrimSuffix(host, "."+baseDomain)
+		// Reserved names such as api.<base> serve the app itself.
+		if reservedSubdomains[sub] {
+			return nil, nil
+		}
+	}
+
+	// Misses are cached too (as tenant 0), briefly, so unknown hosts do not
+	// reach the master database on every request.
+	cacheService := NewCacheService()
+	cacheKey := fmt.Sprintf("tenant_host:%s", host)
+	var tenantID uint
+	if err := cacheService.Get(cacheKey, &tenantID); err != nil {
+		masterDB := config.GetMasterDB()
+		ttl := 10 * time.Minute
+		if sub != "" {
+			var tenant models.Tenant
+			if err := masterDB.Select("id").Where("subdomain = ?", sub).Limit(1).Find(&tenant).Error; err != nil {
+				return nil, err
+			}
+			tenantID = tenant.ID
+		} else {
+			var domain models.TenantDomain
+			if err := masterDB.Where("verified_domain = ?", host).Limit(1).Find(&domain).Error; err != nil {
+				return nil, err
+			}
+			tenantID = domain.TenantID
+		}
+		if tenantID == 0 {
+			ttl = time.Minute
+		}
+		_ = cacheService.Set(cacheKey, tenantID, ttl)
+	}
+	if tenantID == 0 {
+		if sub != "" {
+			return nil, ErrHostTenantNotFound
+		}
+		// Unknown hosts that aren't under our base domain are treated as generic entry points.
+		return nil, nil
+	}
+
+	tenant, err := CachedTenant(tenantID)
+	if err != nil {
+		return nil, ErrHostTenantNotFound
+	}
+	return tenant, nil
+}
+
+// CachedTenant loads a tenant with its plan through the tenant_info cache
+// that is cleared whenever the tenant changes.
+func CachedTenant(tenantID uint) (*models.Tenant, error) {
+	var tenant models.Tenant
+	cacheService := NewCacheService()
+	cacheKey := fmt.Sprintf("tenant_info:%d", tenantID)
+	if err := cacheService.Get(cacheKey, &tenant); err == nil {
+		return &tenant, nil
+	}
+	if err := config.GetMasterDB().Preload("Plan").First(&tenant, tenantID).Error; err != nil {
+		return nil, err
+	}
+	_ = cacheService.Set(cacheKey, tenant, 30*time.Minute)
+	return &tenant, nil
+}
+
+func (s *DomainService) LoginContext(tenant *models.Tenant, host string) *LoginContext {
+	ctx := &LoginContext{
+		TenantID: tenant.ID,
+		Name:     tenant.Name,
+		Host:     normalizeHost(host),
+		Branding: s.settingsService.Branding(tenant.ID),
+	}
+	if tenant.Subdomain != nil {
+		ctx.Subdomain = *tenant.Subdomain
+	}
+
+	now := time.Now()
+	if mode := tenant.EffectiveMode(now); mode != models.ModeNormal || tenant.UpcomingMaintenance(now) {
+		ctx.Maintenance = &MaintenanceNotice{
+			Mode:     mode,
+			Message:  tenant.MaintenanceMessage,
+			StartsAt: tenant.MaintenanceStartsAt,
+			EndsAt:   tenant.MaintenanceEndsAt,
+		}
+	}
+	return ctx
+}
+
+func (s *DomainService) AddDomain(tenantID uint, domain string) (*models.TenantDomain, error) {
+	domain = normalizeHost(domain)
+	if !domainRegex.MatchString(domain) {
+		return nil, errors.New("invalid domain")
+	}
+	baseDomain := strings.ToLower(config.AppConfig.AppBaseDomain)
+	if domain == baseDomain || strings.HasSuffix(domain, "."+baseDomain) {
+		return nil, errors.New("subdomains of the application domain cannot be registered as custom domains")
+	}
+
+	var inUse int64
+	config.GetMasterDB().Model(&models.TenantDomain{}).Where("verified_domain = ?", domain).Count(&inUse)
+	if inUse > 0 {
+		return nil, errors.New("domain is already in use by another workspace")
+	}
+
+	token, err := utils.GenerateSecureKey()
+	if err != nil {
+		return nil, err
+	}
+	record := &models.TenantDomain{
+		TenantID:          tenantID,
+		Domain:            domain,
+		VerificationToken: token[:32],
+	}
+	if err := config.GetMasterDB().Create(record).Error; err != nil {
+		return nil, errors.New("domain is already registered")
+	}
+	return record, nil
+}
+
+func (s *DomainService) ListDomains(tenantID uint) ([]models.TenantDomain, error) {
+	var domains []models.TenantDomain
+	err := config.GetMasterDB().Where("tenant_id = ?", tenantID).Find(&domains).Error
+	return domains, err
+}
+
+func (s *DomainService) getDomain(tenantID, domainID uint) (*models.TenantDomain, error) {
+	var domain models.TenantDomain
+	if err := config.GetMasterDB().Where("id = ? AND tenant_id = ?", domainID, tenantID).First(&domain).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("domain not found")
+		}
+		return nil, err
+	}
+	return &domain, nil
+}
+
+// VerificationRecord is the TXT record a tenant must publish to prove ownership.
+func VerificationRecord(domain *models.TenantDomain) (name, value string) {
+	return fmt.Sprintf("%s.%s", domainVerificationPrefix, domain.Domain), domainVerificationValue + domain.VerificationToken
+}
+
+func (s *DomainService) VerifyDomain(tenantID, domainID uint) (*models.TenantDomain, error) {
+	domain, err := s.getDomain(tenantID, domainID)
+	if err != nil {
+		return nil, err
+	}
+	if domain.IsVerified() {
+		return domain, nil
+	}
+
+	name, expected := VerificationRecord(domain)
+	records, err := lookupTXT(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not read TXT records for %s: %w", name, err)
+	}
+
+	for _, record := range records {
+		if strings.TrimSpace(record) == expected {
+			now := time.Now()
+			domain.VerifiedAt = &now
+			domain.VerifiedDomain = &domain.Domain
+			if err := config.GetMasterDB().Save(domain).Error; err != nil {
+				// Another workspace proved ownership first.
+				return nil, errors.New("domain is already in use by another workspace")
+			}
+			_ = NewCacheService().Delete(fmt.Sprintf("tenant_host:%s", domain.Domain))
+			return domain, nil
+		}
+	}
+	return nil, fmt.Errorf("TXT record %s with value %s not found", name, expected)
+}
+
+func (s *DomainService) DeleteDomain(tenantID, domainID uint) error {
+	domain, err := s.getDomain(tenantID, domainID)
+	if err != nil {
+		return err
+	}
+	if err := config.GetMasterDB().Delete(domain).Error; err != nil {
+		return err
+	}
+	_ = NewCacheService().Delete(fmt.Sprintf("tenant_host:%s", domain.Domain))
+	return nil
+}
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"fmt"
+	"go-multi-tenant/config"
+	"go-multi-tenant/models"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Billing notice kinds.
+const (
+	NoticeTrialEnding    = "trial_ending"
+	NoticeRenewalDue     = "renewal_due"
+	NoticePaymentOverdue = "payment_overdue"
+	NoticeSuspended      = "suspended"
+	NoticeReactivated    = "reactivated"
+)
+
+// BillingNotice asks for a tenant's admins to be told about its subscription,
+// e.g. a dunning reminder before suspension.
+type BillingNotice struct {
+	TenantID   uint       `json:"tenant_id"`
+	TenantName string     `json:"tenant_name"`
+	Kind       string     `json:"kind"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	SuspendsAt *time.Time `json:"suspends_at,omitempty"`
+	AmountDue  float64    `json:"amount_due"`
+	Currency   string     `json:"currency"`
+}
+
+var (
+	billingNoticeHandlers   []func(BillingNotice)
+	billingNoticeHandlersMu sync.RWMutex
+)
+
+// OnBillingNotice registers a handler called for every billing notice.
+func OnBillingNotice(handler func(BillingNotice)) {
+	billingNoticeHandlersMu.Lock()
+	defer billingNoticeHandlersMu.Unlock()
+	billingNoticeHandlers = append(billingNoticeHandlers, handler)
+}
+
+func emitBillingNotice(notice BillingNotice) {
+	log.Printf("Billing notice: tenant %s %s (amount due %.2f %s)", notice.TenantName, notice.Kind, notice.AmountDue, notice.Currency)
+
+	billingNoticeHandlersMu.RLock()
+	defer billingNoticeHandlersMu.RUnlock()
+	for _, handler := range billingNoticeHandlers {
+		handler(notice)
+	}
+}
+
+type DunningService struct{}
+
+func NewDunningService() *DunningService {
+	return &DunningService{}
+}
+
+// HandlePlanExpiries moves expired, unpaid tenants to past due (read-only),
+// suspends them once the grace period is over and sends the reminders of the
+// dunning schedule along the way.
+func (s *DunningService) HandlePlanExpiries() {
+	masterDB := config.GetMasterDB()
+	now := time.Now()
+
+	var tenants []models.Tenant
+	if err := masterDB.Where("plan_expiry IS NOT NULL AND is_sandbox = ? AND billing_status IN ?", false,
+		[]models.BillingStatus{models.BillingTrialing, models.BillingActive, models.BillingPastDue}).
+		Find(&tenants).Error; err != nil {
+		log.Printf("Error fetching tenants for expiry check: %v", err)
+		return
+	}
+
+	for i := range tenants {
+		tenant := &tenants[i]
+		expiry := *tenant.PlanExpiry
+		graceEnd := expiry.Add(config.AppConfig.GracePeriod)
+
+		switch {
+		case !now.Before(graceEnd):
+			s.suspend(tenant, expiry)
+			continue
+		case !now.Before(expiry) && tenant.BillingStatus != models.BillingPastDue:
+			res := masterDB.Model(&models.Tenant{}).
+				Where("id = ? AND billing_status = ?", tenant.ID, tenant.BillingStatus).
+				Update("billing_status", models.BillingPastDue)
+			if res.Error != nil {
+				log.Printf("Tenant %s: failed to mark past due: %v", tenant.Name, res.Error)
+				continue
+			}
+			if res.RowsAffected > 0 {
+				log.Printf("Tenant %s is past due; read-only until %s", tenant.Name, graceEnd.Format(time.RFC3339))
+				s.clearTenantCache(tenant.ID)
+			}
+		}
+		s.sendReminder(tenant, now, expiry, graceEnd)
+	}
+}
+
+// sendReminder sends the latest reminder of the schedule that has come due.
+// Reminders missed while the worker was down are skipped, not sent in a burst.
+func (s *DunningService) sendReminder(tenant *models.Tenant, now, expiry, graceEnd time.Time) {
+	schedule := config.AppConfig.DunningSchedule
+	step := tenant.DunningStep
+	for step < len(schedule) && !now.Before(expiry.AddDate(0, 0, schedule[step])) {
+		step++
+	}
+	if step == tenant.DunningStep {
+		return
+	}
+
+	// Claim the step so that only one instance sends it.
+	res := config.GetMasterDB().Model(&models.Tenant{}).
+		Where("id = ? AND dunning_step = ?", tenant.ID, tenant.DunningStep).
+		Update("dunning_step", step)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return
+	}
+
+	kind := NoticePaymentOverdue
+	if now.Before(expiry) {
+		kind = NoticeRenewalDue
+		if tenant.BillingStatus == models.BillingTrialing {
+			kind = NoticeTrialEnding
+		}
+	}
+	notice := s.notice(tenant, kind)
+	notice.ExpiresAt = &expiry
+	notice.SuspendsAt = &graceEnd
+	emitBillingNotice(notice)
+}
+
+func (s *DunningService) suspend(tenant *models.Tenant, expiry time.Time) {
+	res := config.GetMasterDB().Model(&models.Tenant{}).
+		Where("id = ? AND billing_status = ?", tenant.ID, tenant.BillingStatus).
+		Updates(map[string]interface{}{"billing_status": models.BillingSuspended, "is_active": false})
+	if res.Error != nil {
+		log.Printf("Tenant %s: failed to suspend: %v", tenant.Name, res.Error)
+		return
+	}
+	if res.RowsAffected == 0 {
+		return
+	}
+	publishEvent(TenantSuspended{TenantID: tenant.ID, Reason: "plan expired and was not paid"})
+	log.Printf("Tenant %s suspended: plan expired %s and was not paid", tenant.Name, expiry.Format(time.RFC3339))
+
+	notice := s.notice(tenant, NoticeSuspended)
+	notice.ExpiresAt = &expiry
+	emitBillingNotice(notice)
+}
+
+func (s *DunningService) notice(tenant *models.Tenant, kind string) BillingNotice {
+	var due struct{ Total float64 }
+	config.GetMasterDB().Model(&models.Invoice{}).Select("COALESCE(SUM(total), 0) AS total").
+		Where("tenant_id = ? AND status = ?", tenant.ID, models.InvoiceOpen).Scan(&due)
+
+	return BillingNotice{
+		TenantID:   tenant.ID,
+		TenantName: tenant.Name,
+		Kind:       kind,
+		AmountDue:  roundMoney(due.Total),
+		Currency:   config.AppConfig.BillingCurrency,
+	}
+}
+
+// clearDelinquency is called after a tenant's expiry moved. Once the plan is
+// paid up (or no longer expires) it returns the tenant to active, re-enabling
+// it if billing suspended it, and restarts the dunning schedule. It reports
+// whether the tenant was reactivated so the caller can notify after committing.
+func clearDelinquency(tx *gorm.DB, tenantID uint) (bool, error) {
+	var tenant models.Tenant
+	if err := tx.Select("id", "plan_expiry", "billing_status", "trial_ends_at").First(&tenant, tenantID).Error; err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if tenant.PlanExpiry != nil && !tenant.PlanExpiry.After(now) {
+		return false, nil
+	}
+	if tenant.BillingStatus == models.BillingTrialing && tenant.TrialEndsAt != nil && now.Before(*tenant.TrialEndsAt) &&
+		tenant.PlanExpiry != nil && !tenant.PlanExpiry.After(*tenant.TrialEndsAt) {
+		return false, nil
+	}
+
+	updates := map[string]interface{}{"billing_status": models.BillingActive, "dunning_step": 0}
+	if tenant.BillingStatus == models.BillingSuspended {
+		updates["is_active"] = true
+	}
+	if err := tx.Model(&models.Tenant{}).Where("id = ?", tenantID).Updates(updates).Error; err != nil {
+		return false, err
+	}
+	return tenant.BillingStatus == models.BillingSuspended, nil
+}
+
+// notifyReactivated announces a tenant that clearDelinquency brought back.
+func notifyReactivated(tenantID uint) {
+	var tenant models.Tenant
+	if err := config.GetMasterDB().First(&tenant, tenantID).Error; err != nil {
+		return
+	}
+	log.Printf("Tenant %s reactivated after payment", tenant.Name)
+	emitBillingNotice(NewDunningService().notice(&tenant, NoticeReactivated))
+}
+
+func (s *DunningService) clearTenantCache(tenantID uint) {
+	_ = NewCacheService().Delete(fmt.Sprintf("tenant_info:%d", tenantID))
+}
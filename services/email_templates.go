@@ -0,0 +1,396 @@
+package services
+
+import (
+	"fmt"
+	"go-multi-tenant/mail"
+	"strings"
+	"time"
+)
+
+// Built-in email templates, by name and then locale. Every template has an
+// "en" version, which is the fallback for locales without a translation.
+// Templates without HTML get one generated from their text.
+type emailTemplateDef struct {
+	description string
+	sample      func() map[string]interface{}
+	locales     map[string]mail.Template
+}
+
+const (
+	EmailTrialEnding     = "billing.trial_ending"
+	EmailRenewalDue      = "billing.renewal_due"
+	EmailPaymentOverdue  = "billing.payment_overdue"
+	EmailSuspended       = "billing.suspended"
+	EmailReactivated     = "billing.reactivated"
+	EmailPaymentReceipt  = "billing.payment_receipt"
+	EmailUsageWarning    = "usage.warning"
+	EmailLowStock        = "inventory.low_stock"
+	EmailTaskReport      = "task.report"
+	EmailWebhookDisabled = "webhook.disabled"
+	EmailPOPending       = "purchase_order.pending"
+	EmailPORejected      = "purchase_order.rejected"
+)
+
+// billingNoticeTemplates maps BillingNotice kinds to their templates.
+var billingNoticeTemplates = map[string]string{
+	NoticeTrialEnding:    EmailTrialEnding,
+	NoticeRenewalDue:     EmailRenewalDue,
+	NoticePaymentOverdue: EmailPaymentOverdue,
+	NoticeSuspended:      EmailSuspended,
+	NoticeReactivated:    EmailReactivated,
+}
+
+func billingNoticeData(notice BillingNotice) map[string]interface{} {
+	return map[string]interface{}{
+		"ExpiresAt":  notice.ExpiresAt,
+		"SuspendsAt": notice.SuspendsAt,
+		"AmountDue":  notice.AmountDue,
+		"Currency":   notice.Currency,
+	}
+}
+
+type lowStockLine struct {
+	Product  string
+	SKU      string
+	Quantity int
+	Location string
+}
+
+func sampleBillingData() map[string]interface{} {
+	expires := time.Now().AddDate(0, 0, 3)
+	suspends := expires.AddDate(0, 0, 7)
+	return map[string]interface{}{
+		"ExpiresAt":  &expires,
+		"SuspendsAt": &suspends,
+		"AmountDue":  49.0,
+		"Currency":   "USD",
+	}
+}
+
+var emailTemplates = map[string]emailTemplateDef{
+	EmailTrialEnding: {
+		description: "Sent before a trial ends",
+		sample:      sampleBillingData,
+		locales: map[string]mail.Template{
+			"en": {
+				Subject: "Your {{.Workspace}} trial ends on {{date .ExpiresAt}}",
+				Text: `Hello,
+
+The free trial of {{.Workspace}} ends on {{date .ExpiresAt}}. Pay the open invoice of {{money .AmountDue .Currency}} before then to keep using the workspace without interruption.`,
+			},
+			"es": {
+				Subject: "La prueba de {{.Workspace}} termina el {{date .ExpiresAt}}",
+				Text: `Hola:
+
+La prueba gratuita de {{.Workspace}} termina el {{date .ExpiresAt}}. Paga la factura pendiente de {{money .AmountDue .Currency}} antes de esa fecha para seguir usando el espacio de trabajo sin interrupciones.`,
+			},
+		},
+	},
+	EmailRenewalDue: {
+		description: "Sent before the plan renews with an unpaid invoice",
+		sample:      sampleBillingData,
+		locales: map[string]mail.Template{
+			"en": {
+				Subject: "{{.Workspace}}: your plan renews on {{date .ExpiresAt}}",
+				Text: `Hello,
+
+The plan of {{.Workspace}} renews on {{date .ExpiresAt}} and {{money .AmountDue .Currency}} is still open. Please pay the invoice before the renewal date.`,
+			},
+			"es": {
+				Subject: "{{.Workspace}}: tu plan se renueva el {{date .ExpiresAt}}",
+				Text: `Hola:
+
+El plan de {{.Workspace}} se renueva el {{date .ExpiresAt}} y quedan {{money .AmountDue .Currency}} pendientes. Paga la factura antes de la fecha de renovación.`,
+			},
+		},
+	},
+	EmailPaymentOverdue: {
+		description: "Sent while the plan has expired unpaid and the workspace is read-only",
+		sample:      sampleBillingData,
+		locales: map[string]mail.Template{
+			"en": {
+				Subject: "Payment overdue for {{.Workspace}}",
+				Text: `Hello,
+
+The plan of {{.Workspace}} expired on {{date .ExpiresAt}} and {{money .AmountDue .Currency}} is overdue. The workspace is read-only{{if .SuspendsAt}} and will be suspended on {{date .SuspendsAt}}{{end}} unless the invoice is paid.`,
+			},
+			"es": {
+				Subject: "Pago vencido de {{.Workspace}}",
+				Text: `Hola:
+
+El plan de {{.Workspace}} venció el {{date .ExpiresAt}} y hay {{money .AmountDue .Currency}} vencidos. El espacio de trabajo está en modo de solo lectura{{if .SuspendsAt}} y se suspenderá el {{date .SuspendsAt}}{{end}} si no se paga la factura.`,
+			},
+		},
+	},
+	EmailSuspended: {
+		description: "Sent when the workspace is suspended for non-payment",
+		sample:      sampleBillingData,
+		locales: map[string]mail.Template{
+			"en": {
+				Subject: "{{.Workspace}} has been suspended",
+				Text: `Hello,
+
+{{.Workspace}} has been suspended because its plan expired on {{date .ExpiresAt}} and was not paid. Pay the open invoice of {{money .AmountDue .Currency}} to reactivate it; no data has been deleted.`,
+			},
+			"es": {
+				Subject: "{{.Workspace}} ha sido suspendido",
+				Text: `Hola:
+
+{{.Workspace}} ha sido suspendido porque su plan venció el {{date .ExpiresAt}} y no se pagó. Paga la factura pendiente de {{money .AmountDue .Currency}} para reactivarlo; no se ha eliminado ningún dato.`,
+			},
+		},
+	},
+	EmailReactivated: {
+		description: "Sent when a payment brings a suspended workspace back",
+		sample:      sampleBillingData,
+		locales: map[string]mail.Template{
+			"en": {
+				Subject: "{{.Workspace}} is active again",
+				Text: `Hello,
+
+Thank you for your payment. {{.Workspace}} is active again and everyone can sign in as before.`,
+			},
+			"es": {
+				Subject: "{{.Workspace}} vuelve a estar activo",
+				Text: `Hola:
+
+Gracias por tu pago. {{.Workspace}} vuelve a estar activo y todos pueden iniciar sesión como antes.`,
+			},
+		},
+	},
+	EmailPaymentReceipt: {
+		description: "Sent when an invoice is paid",
+		sample: func() map[string]interface{} {
+			now := time.Now()
+			return map[string]interface{}{
+				"InvoiceNumber": "INV-202601-0001",
+				"Amount":        49.0,
+				"Currency":      "USD",
+				"PaidAt":        now,
+				"PeriodStart":   now,
+				"PeriodEnd":     now.AddDate(0, 1, 0),
+			}
+		},
+		locales: map[string]mail.Template{
+			"en": {
+				Subject: "Receipt for invoice {{.InvoiceNumber}}",
+				Text: `Hello,
+
+We received your payment of {{money .Amount .Currency}} for invoice {{.InvoiceNumber}} on {{date .PaidAt}}. It covers {{.Workspace}} from {{date .PeriodStart}} to {{date .PeriodEnd}}.`,
+			},
+			"es": {
+				Subject: "Recibo de la factura {{.InvoiceNumber}}",
+				Text: `Hola:
+
+Hemos recibido tu pago de {{money .Amount .Currency}} de la factura {{.InvoiceNumber}} el {{date .PaidAt}}. Cubre {{.Workspace}} del {{date .PeriodStart}} al {{date .PeriodEnd}}.`,
+			},
+		},
+	},
+	EmailUsageWarning: {
+		description: "Sent when a plan limit is nearly or fully used",
+		sample: func() map[string]interface{} {
+			return map[string]interface{}{"Metric": "users", "Used": 8, "Limit": 10, "Level": 80}
+		},
+		locales: map[string]mail.Template{
+			"en": {
+				Subject: `{{.Workspace}}: {{if ge .Level 100}}{{.Metric}} limit reached{{else}}{{.Level}}% of the {{.Metric}} limit used{{end}}`,
+				Text: `Hello,
+
+{{.Workspace}} has used {{.Used}} of the {{.Limit}} {{.Metric}} its plan allows.{{if ge .Level 100}} New {{.Metric}} cannot be added until you upgrade the plan or free some up.{{else}} Consider upgrading the plan before the limit is reached.{{end}}`,
+			},
+			"es": {
+				Subject: `{{.Workspace}}: {{if ge .Level 100}}límite de {{.Metric}} alcanzado{{else}}{{.Level}}% del límite de {{.Metric}} usado{{end}}`,
+				Text: `Hola:
+
+{{.Workspace}} ha usado {{.Used}} de los {{.Limit}} {{.Metric}} que permite su plan.{{if ge .Level 100}} No se podrán añadir más hasta que mejores el plan o liberes algunos.{{else}} Considera mejorar el plan antes de alcanzar el límite.{{end}}`,
+			},
+		},
+	},
+	EmailLowStock: {
+		description: "Sent when items are at or below the low-stock threshold",
+		sample: func() map[string]interface{} {
+			return map[string]interface{}{
+				"Count": 1,
+				"Items": []lowStockLine{{Product: "Widget", SKU: "W-1", Quantity: 2, Location: "Main Warehouse"}},
+			}
+		},
+		locales: map[string]mail.Template{
+			"en": {
+				Subject: "{{.Workspace}}: {{.Count}} items are low on stock",
+				Text: `Hello,
+
+These items in {{.Workspace}} are at or below their low-stock threshold:
+{{range .Items}}
+- {{.Product}} ({{.SKU}}): {{.Quantity}} left in {{.Location}}{{end}}`,
+				HTML: `<p>Hello,</p>
+<p>These items in {{.Workspace}} are at or below their low-stock threshold:</p>
+<table cellpadding="6" style="border-collapse:collapse">
+<tr><th align="left">Product</th><th align="left">SKU</th><th align="right">Quantity</th><th align="left">Location</th></tr>
+{{range .Items}}<tr><td>{{.Product}}</td><td>{{.SKU}}</td><td align="right">{{.Quantity}}</td><td>{{.Location}}</td></tr>
+{{end}}</table>`,
+			},
+			"es": {
+				Subject: "{{.Workspace}}: {{.Count}} artículos con poco stock",
+				Text: `Hola:
+
+Estos artículos de {{.Workspace}} están en o por debajo de su umbral de stock bajo:
+{{range .Items}}
+- {{.Product}} ({{.SKU}}): quedan {{.Quantity}} en {{.Location}}{{end}}`,
+				HTML: `<p>Hola:</p>
+<p>Estos artículos de {{.Workspace}} están en o por debajo de su umbral de stock bajo:</p>
+<table cellpadding="6" style="border-collapse:collapse">
+<tr><th align="left">Producto</th><th align="left">SKU</th><th align="right">Cantidad</th><th align="left">Ubicación</th></tr>
+{{range .Items}}<tr><td>{{.Product}}</td><td>{{.SKU}}</td><td align="right">{{.Quantity}}</td><td>{{.Location}}</td></tr>
+{{end}}</table>`,
+			},
+		},
+	},
+	EmailTaskReport: {
+		description: "The result of a scheduled task",
+		sample: func() map[string]interface{} {
+			return map[string]interface{}{
+				"TaskName":    "Morning stock report",
+				"Title":       "Low stock report",
+				"Summary":     "1 items are at or below the low-stock threshold",
+				"Columns":     []string{"Product", "SKU", "Quantity", "Location"},
+				"Rows":        [][]string{{"Widget", "W-1", "2", "Main Warehouse"}},
+				"GeneratedAt": time.Now(),
+			}
+		},
+		locales: map[string]mail.Template{
+			"en": {
+				Subject: "{{.Title}} for {{.Workspace}}",
+				Text: `{{.Summary}}.
+{{range .Rows}}
+- {{join . " | "}}{{end}}
+
+Generated by the scheduled task "{{.TaskName}}" on {{datetime .GeneratedAt}}.`,
+				HTML: `<p>{{.Summary}}.</p>
+{{if .Rows}}<table cellpadding="6" style="border-collapse:collapse">
+<tr>{{range .Columns}}<th align="left">{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>{{end}}
+<p style="color:#666">Generated by the scheduled task "{{.TaskName}}" on {{datetime .GeneratedAt}}.</p>`,
+			},
+			"es": {
+				Subject: "{{.Title}} de {{.Workspace}}",
+				Text: `{{.Summary}}.
+{{range .Rows}}
+- {{join . " | "}}{{end}}
+
+Generado por la tarea programada "{{.TaskName}}" el {{datetime .GeneratedAt}}.`,
+				HTML: `<p>{{.Summary}}.</p>
+{{if .Rows}}<table cellpadding="6" style="border-collapse:collapse">
+<tr>{{range .Columns}}<th align="left">{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>{{end}}
+<p style="color:#666">Generado por la tarea programada "{{.TaskName}}" el {{datetime .GeneratedAt}}.</p>`,
+			},
+		},
+	},
+	EmailWebhookDisabled: {
+		description: "Sent when a webhook is disabled after repeated delivery failures",
+		sample: func() map[string]interface{} {
+			return map[string]interface{}{"URL": "https://erp.example.com/hooks", "Failures": 20, "LastError": "HTTP 503"}
+		},
+		locales: map[string]mail.Template{
+			"en": {
+				Subject: "{{.Workspace}}: a webhook was disabled",
+				Text: `Hello,
+
+The webhook to {{.URL}} failed {{.Failures}} times in a row (last: {{.LastError}}) and has been disabled. Events are no longer sent to it.
+
+Once the endpoint is fixed, enable the webhook again and redeliver the events it missed from its delivery log.`,
+			},
+			"es": {
+				Subject: "{{.Workspace}}: se desactivó un webhook",
+				Text: `Hola:
+
+El webhook a {{.URL}} falló {{.Failures}} veces seguidas (último error: {{.LastError}}) y se ha desactivado. Ya no se le envían eventos.
+
+Cuando el destino esté corregido, vuelve a activar el webhook y reenvía los eventos perdidos desde su registro de entregas.`,
+			},
+		},
+	},
+	EmailPOPending: {
+		description: "Sent to approvers who chose email for purchase orders waiting for approval",
+		sample:      samplePurchaseOrderData,
+		locales: map[string]mail.Template{
+			"en": {
+				Subject: "{{.Workspace}}: purchase order #{{.OrderID}} is waiting for approval",
+				Text: `Hello,
+
+{{.RequestedBy}} requested {{.Quantity}} x {{.Product}} ({{.SKU}}) at {{printf "%.2f" .BuyPrice}} each in purchase order #{{.OrderID}}. Approve or reject it from the pending purchase orders.`,
+			},
+			"es": {
+				Subject: "{{.Workspace}}: la orden de compra #{{.OrderID}} espera aprobación",
+				Text: `Hola:
+
+{{.RequestedBy}} pidió {{.Quantity}} x {{.Product}} ({{.SKU}}) a {{printf "%.2f" .BuyPrice}} cada uno en la orden de compra #{{.OrderID}}. Apruébala o recházala desde las órdenes de compra pendientes.`,
+			},
+		},
+	},
+	EmailPORejected: {
+		description: "Sent to the requester, if they chose email, when a purchase order is rejected",
+		sample:      samplePurchaseOrderData,
+		locales: map[string]mail.Template{
+			"en": {
+				Subject: "{{.Workspace}}: purchase order #{{.OrderID}} was rejected",
+				Text: `Hello,
+
+Your purchase order #{{.OrderID}} for {{.Quantity}} x {{.Product}} ({{.SKU}}) was rejected by {{.ActorName}}. You can change the quantity or price and send it again.`,
+			},
+			"es": {
+				Subject: "{{.Workspace}}: se rechazó la orden de compra #{{.OrderID}}",
+				Text: `Hola:
+
+{{.ActorName}} rechazó tu orden de compra #{{.OrderID}} de {{.Quantity}} x {{.Product}} ({{.SKU}}). Puedes cambiar la cantidad o el precio y enviarla de nuevo.`,
+			},
+		},
+	},
+}
+
+func samplePurchaseOrderData() map[string]interface{} {
+	return map[string]interface{}{
+		"OrderID":     42,
+		"Product":     "Widget",
+		"SKU":         "W-1",
+		"Quantity":    100,
+		"BuyPrice":    2.5,
+		"RequestedBy": "jane",
+		"ActorName":   "sam",
+	}
+}
+
+// emailFuncs are available to every template; dates are shown in loc.
+func emailFuncs(loc *time.Location) map[string]interface{} {
+	asTime := func(v interface{}) (time.Time, bool) {
+		switch t := v.(type) {
+		case time.Time:
+			return t, !t.IsZero()
+		case *time.Time:
+			if t != nil {
+				return *t, !t.IsZero()
+			}
+		}
+		return time.Time{}, false
+	}
+	return map[string]interface{}{
+		"date": func(v interface{}) string {
+			if t, ok := asTime(v); ok {
+				return t.In(loc).Format("2006-01-02")
+			}
+			return ""
+		},
+		"datetime": func(v interface{}) string {
+			if t, ok := asTime(v); ok {
+				return t.In(loc).Format("2006-01-02 15:04 MST")
+			}
+			return ""
+		},
+		"money": func(amount float64, currency string) string {
+			return fmt.Sprintf("%.2f %s", amount, currency)
+		},
+		"join": strings.Join,
+	}
+}
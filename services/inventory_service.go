@@ -7,18 +7,50 @@ import (
 	"gorm.io/gorm"
 )
 
-type InventoryService struct{}
+type InventoryService struct {
+	settingsService *SettingsService
+}
 
 func NewInventoryService() *InventoryService {
-	return &InventoryService{}
+	return &InventoryService{settingsService: NewSettingsService()}
 }
 
 func (s *InventoryService) UpdateStock(tenantDB *gorm.DB, productID uint, tenantID uint, quantity int) error {
+	var before models.Inventory
+	tenantDB.Preload("Product").Where("product_id = ? AND tenant_id = ?", productID, tenantID).Limit(1).Find(&before)
+
 	repo := repositories.NewInventoryRepository(tenantDB)
-	return repo.UpdateStock(productID, tenantID, quantity)
+	if err := repo.UpdateStock(productID, tenantID, quantity); err != nil {
+		return err
+	}
+	publishStockAdjusted(tenantID, &before, quantity)
+	return nil
+}
+
+// publishStockAdjusted announces a quantity change of the inventory row
+// before, read with its product ahead of the change.
+func publishStockAdjusted(tenantID uint, before *models.Inventory, quantity int) {
+	if before.ID == 0 {
+		return
+	}
+	event := StockAdjusted{
+		TenantID:  tenantID,
+		ProductID: before.ProductID,
+		Location:  before.Location,
+		Before:    before.Quantity,
+		After:     quantity,
+	}
+	if before.Product != nil {
+		event.SKU, event.ProductName = before.Product.SKU, before.Product.Name
+	}
+	publishEvent(event)
 }
 
+// GetLowStockAlerts uses the tenant's configured threshold when threshold is not positive.
 func (s *InventoryService) GetLowStockAlerts(tenantDB *gorm.DB, tenantID uint, threshold int) ([]models.Inventory, error) {
+	if threshold <= 0 {
+		threshold = s.settingsService.LowStockThreshold(tenantID)
+	}
 	repo := repositories.NewInventoryRepository(tenantDB)
 	return repo.GetLowStockProducts(tenantID, threshold)
 }
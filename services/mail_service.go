@@ -0,0 +1,650 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-multi-tenant/config"
+	"go-multi-tenant/mail"
+	"go-multi-tenant/models"
+	"go-multi-tenant/queue"
+	"html"
+	htmltemplate "html/template"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	mailAttempts = 5
+	// A row claimed for the queue but never given a job is retried after this.
+	outboxClaimTimeout = 5 * time.Minute
+)
+
+var (
+	mailer mail.Mailer
+
+	ErrEmailTemplateNotFound = errors.New("email template not found")
+)
+
+// InitMailer sets up the configured transport and subscribes to the
+// notifications that are sent by email.
+func InitMailer(cfg *config.Config) error {
+	m, err := newMailer(cfg)
+	if err != nil {
+		return err
+	}
+	mailer = m
+	registerMailHooks()
+	log.Printf("Mail: using the %s transport", m.Name())
+	return nil
+}
+
+func newMailer(cfg *config.Config) (mail.Mailer, error) {
+	switch cfg.MailTransport {
+	case "smtp":
+		if cfg.SMTPHost == "" {
+			return nil, errors.New("MAIL_TRANSPORT is smtp but SMTP_HOST is not set")
+		}
+		return mail.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUser, cfg.SMTPPassword), nil
+	case "file", "":
+		return mail.NewFileMailer(cfg.MailDir)
+	case "capture":
+		return mail.NewCaptureMailer(200), nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_TRANSPORT %q", cfg.MailTransport)
+	}
+}
+
+// CapturedMail returns the messages held by the capture transport.
+func CapturedMail() ([]mail.Message, error) {
+	capture, ok := mailer.(*mail.CaptureMailer)
+	if !ok {
+		return nil, errors.New("the capture mail transport is not in use")
+	}
+	return capture.Messages(), nil
+}
+
+type MailService struct{}
+
+func NewMailService() *MailService {
+	return &MailService{}
+}
+
+// Queue renders a template for a tenant and writes it to the outbox through
+// db, which must be a master database handle and may be a transaction: the
+// email exists only if the transaction commits. A failed write leaves a
+// Postgres transaction aborted, so callers that carry on after an error run
+// Queue in a savepoint (a nested tx.Transaction). Pass the result to Dispatch
+// after committing to send it right away; otherwise the outbox relay sends it
+// within a minute. Email for sandbox tenants is recorded as suppressed and
+// never sent.
+func (s *MailService) Queue(db *gorm.DB, tenantID uint, name string, to []string, data map[string]interface{}) (*models.OutboxEmail, error) {
+	if len(to) == 0 {
+		return nil, errors.New("no recipients")
+	}
+	var tenant models.Tenant
+	if err := db.Select("id", "name", "is_sandbox").First(&tenant, tenantID).Error; err != nil {
+		return nil, fmt.Errorf("tenant %d not found", tenantID)
+	}
+
+	locale := NewSettingsService().Locale(tenantID)
+	rendered, locale, err := s.render(&tenant, name, locale, data)
+	if err != nil {
+		return nil, err
+	}
+
+	email := &models.OutboxEmail{
+		TenantID: tenantID,
+		Template: name,
+		Locale:   locale,
+		To:       strings.Join(to, ","),
+		Subject:  rendered.Subject,
+		Text:     rendered.Text,
+		HTML:     rendered.HTML,
+		Status:   models.OutboxPending,
+	}
+	if !tenant.AllowsOutboundDelivery() {
+		email.Status = models.OutboxSuppressed
+	}
+	if err := db.Create(email).Error; err != nil {
+		return nil, err
+	}
+	return email, nil
+}
+
+// Send queues an email outside any transaction and dispatches it at once.
+func (s *MailService) Send(tenantID uint, name string, to []string, data map[string]interface{}) error {
+	email, err := s.Queue(config.GetMasterDB(), tenantID, name, to, data)
+	if err != nil {
+		return err
+	}
+	s.Dispatch(email)
+	return nil
+}
+
+// Dispatch hands a committed outbox email to the job queue. Failures are
+// logged and left to the relay.
+func (s *MailService) Dispatch(email *models.OutboxEmail) {
+	if email == nil || email.Status != models.OutboxPending {
+		return
+	}
+	if err := s.dispatch(email.ID); err != nil {
+		log.Printf("Mail: outbox email %d not queued yet: %v", email.ID, err)
+	}
+}
+
+type sendEmailJob struct {
+	EmailID uint `json:"email_id"`
+}
+
+// dispatch claims an outbox row so only one relay or request queues it, then
+// enqueues the job that sends it.
+func (s *MailService) dispatch(emailID uint) error {
+	masterDB := config.GetMasterDB()
+
+	res := masterDB.Model(&models.OutboxEmail{}).
+		Where("id = ? AND (status = ? OR (status = ? AND job_id = 0 AND updated_at < ?))",
+			emailID, models.OutboxPending, models.OutboxQueued, time.Now().Add(-outboxClaimTimeout)).
+		Updates(map[string]interface{}{"status": models.OutboxQueued, "updated_at": time.Now()})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return res.Error
+	}
+
+	var email models.OutboxEmail
+	if err := masterDB.Select("id", "tenant_id").First(&email, emailID).Error; err != nil {
+		return err
+	}
+	job, err := NewQueueService().Enqueue(JobSendEmail, sendEmailJob{EmailID: emailID}, queue.EnqueueOptions{
+		TenantID:    email.TenantID,
+		MaxAttempts: mailAttempts,
+	})
+	if err != nil {
+		masterDB.Model(&email).Update("status", models.OutboxPending)
+		return err
+	}
+	return masterDB.Model(&email).Update("job_id", job.ID).Error
+}
+
+// RelayOutbox queues outbox emails that were committed but not dispatched,
+// e.g. because they were written in a transaction or the process stopped.
+func (s *MailService) RelayOutbox(ctx context.Context) (string, error) {
+	var ids []uint
+	err := config.GetMasterDB().Model(&models.OutboxEmail{}).
+		Where("status = ? OR (status = ? AND job_id = 0 AND updated_at < ?)",
+			models.OutboxPending, models.OutboxQueued, time.Now().Add(-outboxClaimTimeout)).
+		Order("id").Limit(500).Pluck("id", &ids).Error
+	if err != nil {
+		return "", err
+	}
+
+	queued := 0
+	var errs []error
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			break
+		}
+		if err := s.dispatch(id); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		queued++
+	}
+	return fmt.Sprintf("queued %d of %d outbox emails", queued, len(ids)), errors.Join(errs...)
+}
+
+// runSendJob delivers one outbox email. The row stays queued between retries
+// and is marked failed once the job runs out of attempts.
+func (s *MailService) runSendJob(ctx context.Context, job *models.Job, payload sendEmailJob) (interface{}, error) {
+	masterDB := config.GetMasterDB()
+
+	var email models.OutboxEmail
+	if err := masterDB.First(&email, payload.EmailID).Error; err != nil {
+		return nil, queue.Permanent(fmt.Errorf("outbox email %d not found", payload.EmailID))
+	}
+	if email.Status != models.OutboxQueued {
+		return map[string]interface{}{"email_id": email.ID, "status": email.Status}, nil
+	}
+	if mailer == nil {
+		return nil, errors.New("mailer is not configured")
+	}
+
+	err := mailer.Send(ctx, &mail.Message{
+		From:    config.AppConfig.MailFrom,
+		To:      strings.Split(email.To, ","),
+		Subject: email.Subject,
+		Text:    email.Text,
+		HTML:    email.HTML,
+	})
+	if err != nil {
+		updates := map[string]interface{}{"error": err.Error()}
+		if job.Attempts >= job.MaxAttempts {
+			updates["status"] = models.OutboxFailed
+		}
+		masterDB.Model(&email).Updates(updates)
+		return nil, err
+	}
+
+	now := time.Now()
+	masterDB.Model(&email).Updates(map[string]interface{}{"status": models.OutboxSent, "error": "", "sent_at": &now})
+	return map[string]interface{}{"email_id": email.ID, "transport": mailer.Name()}, nil
+}
+
+var outboxStatuses = []string{models.OutboxPending, models.OutboxQueued, models.OutboxSent, models.OutboxFailed, models.OutboxSuppressed}
+
+// ListOutbox lists outbox emails, newest first. A nil tenantID lists every tenant's.
+func (s *MailService) ListOutbox(tenantID *uint, status string, page, pageSize int) ([]models.OutboxEmail, int64, error) {
+	query := config.GetMasterDB().Model(&models.OutboxEmail{})
+	if tenantID != nil {
+		query = query.Where("tenant_id = ?", *tenantID)
+	}
+	if status != "" {
+		if !slices.Contains(outboxStatuses, status) {
+			return nil, 0, fmt.Errorf("invalid status %q", status)
+		}
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var emails []models.OutboxEmail
+	err := query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&emails).Error
+	return emails, total, err
+}
+
+// localeChain lists the locales to try for locale, most specific first,
+// ending with the "en" fallback: "pt-BR" gives pt-BR, pt, en.
+func localeChain(locale string) []string {
+	chain := []string{}
+	if locale != "" {
+		chain = append(chain, locale)
+		if lang, _, ok := strings.Cut(locale, "-"); ok {
+			chain = append(chain, lang)
+		}
+	}
+	if !slices.Contains(chain, "en") {
+		chain = append(chain, "en")
+	}
+	return chain
+}
+
+// render picks the most specific template for locale, preferring the
+// tenant's override over the built-in one in each locale, and returns the
+// rendered email with the locale used. An override that fails to render is
+// skipped so a broken override never stops an email.
+func (s *MailService) render(tenant *models.Tenant, name, locale string, data map[string]interface{}) (*mail.Rendered, string, error) {
+	def, ok := emailTemplates[name]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown email template %q", name)
+	}
+	chain := localeChain(locale)
+
+	var overrides []models.EmailTemplate
+	if err := config.GetMasterDB().Where("tenant_id = ? AND name = ? AND locale IN ?", tenant.ID, name, chain).
+		Find(&overrides).Error; err != nil {
+		return nil, "", err
+	}
+
+	settings := NewSettingsService()
+	brand := settings.Branding(tenant.ID)
+	vars := map[string]interface{}{"Workspace": tenant.Name}
+	if brand.DisplayName != "" {
+		vars["Workspace"] = brand.DisplayName
+	}
+	for k, v := range data {
+		vars[k] = v
+	}
+	funcs := emailFuncs(settings.Location(tenant.ID))
+
+	for _, loc := range chain {
+		for _, o := range overrides {
+			if o.Locale != loc {
+				continue
+			}
+			tmpl := mail.Template{Subject: o.Subject, Text: o.Text, HTML: o.HTML}
+			rendered, err := tmpl.Render(vars, funcs)
+			if err != nil {
+				log.Printf("Mail: tenant %d's %s template (%s) failed, using the default: %v", tenant.ID, name, loc, err)
+				break
+			}
+			return s.finish(rendered, brand, vars), loc, nil
+		}
+		if tmpl, ok := def.locales[loc]; ok {
+			rendered, err := tmpl.Render(vars, funcs)
+			if err != nil {
+				return nil, "", fmt.Errorf("template %s (%s): %w", name, loc, err)
+			}
+			return s.finish(rendered, brand, vars), loc, nil
+		}
+	}
+	return nil, "", fmt.Errorf("template %s has no %s version", name, locale)
+}
+
+var emailLayout = htmltemplate.Must(htmltemplate.New("layout").Parse(`<!DOCTYPE html>
+<html><body style="margin:0;padding:24px;font-family:Helvetica,Arial,sans-serif;color:#1f2937">
+<div style="max-width:600px;margin:0 auto;border-top:4px solid {{.Color}};padding-top:16px">
+{{if .LogoURL}}<p><img src="{{.LogoURL}}" alt="{{.Workspace}}" style="max-height:40px"></p>
+{{end}}{{.Content}}
+</div>
+</body></html>`))
+
+// finish wraps the HTML body, or one made from the text, in the branded layout.
+func (s *MailService) finish(rendered *mail.Rendered, brand BrandingSettings, vars map[string]interface{}) *mail.Rendered {
+	content := rendered.HTML
+	if content == "" {
+		content = textToHTML(rendered.Text)
+	}
+	color := brand.PrimaryColor
+	if color == "" {
+		color = "#2563eb"
+	}
+
+	var buf strings.Builder
+	err := emailLayout.Execute(&buf, map[string]interface{}{
+		"Color":     color,
+		"LogoURL":   brand.LogoURL,
+		"Workspace": vars["Workspace"],
+		"Content":   htmltemplate.HTML(content),
+	})
+	if err == nil {
+		rendered.HTML = buf.String()
+	}
+	return rendered
+}
+
+// textToHTML turns blank-line separated paragraphs into HTML paragraphs.
+func textToHTML(text string) string {
+	var b strings.Builder
+	for _, para := range strings.Split(strings.TrimSpace(text), "\n\n") {
+		lines := strings.Split(strings.TrimSpace(para), "\n")
+		for i := range lines {
+			lines[i] = html.EscapeString(lines[i])
+		}
+		b.WriteString("<p>" + strings.Join(lines, "<br>\n") + "</p>\n")
+	}
+	return b.String()
+}
+
+// EmailTemplateInfo describes a template for the template catalogue.
+type EmailTemplateInfo struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Locales     []string `json:"locales"`    // Built-in translations
+	Overridden  []string `json:"overridden"` // Locales the tenant has customised
+}
+
+func (s *MailService) ListTemplates(tenantID uint) ([]EmailTemplateInfo, error) {
+	var overrides []models.EmailTemplate
+	if err := config.GetMasterDB().Select("name", "locale").Where("tenant_id = ?", tenantID).
+		Order("locale").Find(&overrides).Error; err != nil {
+		return nil, err
+	}
+
+	infos := make([]EmailTemplateInfo, 0, len(emailTemplates))
+	for name, def := range emailTemplates {
+		info := EmailTemplateInfo{Name: name, Description: def.description, Overridden: []string{}}
+		for loc := range def.locales {
+			info.Locales = append(info.Locales, loc)
+		}
+		sort.Strings(info.Locales)
+		for _, o := range overrides {
+			if o.Name == name {
+				info.Overridden = append(info.Overridden, o.Locale)
+			}
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// EmailTemplateView is the template used for a name and locale, with the
+// sample data its fields can use.
+type EmailTemplateView struct {
+	Name       string                 `json:"name"`
+	Locale     string                 `json:"locale"`
+	Overridden bool                   `json:"overridden"`
+	Template   mail.Template          `json:"template"`
+	Sample     map[string]interface{} `json:"sample"`
+}
+
+// GetTemplate returns the tenant's override for exactly this locale, or the
+// built-in template the locale falls back to.
+func (s *MailService) GetTemplate(tenantID uint, name, locale string) (*EmailTemplateView, error) {
+	def, ok := emailTemplates[name]
+	if !ok {
+		return nil, ErrEmailTemplateNotFound
+	}
+	if locale == "" {
+		locale = NewSettingsService().Locale(tenantID)
+	}
+	view := &EmailTemplateView{Name: name, Locale: locale, Sample: def.sample()}
+
+	var override models.EmailTemplate
+	err := config.GetMasterDB().Where("tenant_id = ? AND name = ? AND locale = ?", tenantID, name, locale).
+		Limit(1).Find(&override).Error
+	if err != nil {
+		return nil, err
+	}
+	if override.ID != 0 {
+		view.Overridden = true
+		view.Template = mail.Template{Subject: override.Subject, Text: override.Text, HTML: override.HTML}
+		return view, nil
+	}
+	for _, loc := range localeChain(locale) {
+		if tmpl, ok := def.locales[loc]; ok {
+			view.Template = tmpl
+			break
+		}
+	}
+	return view, nil
+}
+
+type EmailTemplateRequest struct {
+	Locale  string `json:"locale" binding:"required"`
+	Subject string `json:"subject" binding:"required"`
+	Text    string `json:"text" binding:"required"`
+	HTML    string `json:"html"`
+}
+
+// SaveTemplate stores the tenant's override of a template in one locale. It
+// must render with the template's sample data.
+func (s *MailService) SaveTemplate(tenantID, userID uint, name string, req *EmailTemplateRequest) (*EmailTemplateView, error) {
+	if _, ok := emailTemplates[name]; !ok {
+		return nil, ErrEmailTemplateNotFound
+	}
+	if !localeRegex.MatchString(req.Locale) {
+		return nil, fmt.Errorf("locale must look like \"en\" or \"pt-BR\", got %q", req.Locale)
+	}
+	tmpl := mail.Template{Subject: req.Subject, Text: req.Text, HTML: req.HTML}
+	if _, err := s.Preview(tenantID, name, &tmpl); err != nil {
+		return nil, err
+	}
+
+	masterDB := config.GetMasterDB()
+	var override models.EmailTemplate
+	if err := masterDB.Where("tenant_id = ? AND name = ? AND locale = ?", tenantID, name, req.Locale).
+		Limit(1).Find(&override).Error; err != nil {
+		return nil, err
+	}
+	override.TenantID, override.Name, override.Locale = tenantID, name, req.Locale
+	override.Subject, override.Text, override.HTML = req.Subject, req.Text, req.HTML
+	override.UpdatedBy = userID
+	if err := masterDB.Save(&override).Error; err != nil {
+		return nil, err
+	}
+	return s.GetTemplate(tenantID, name, req.Locale)
+}
+
+func (s *MailService) DeleteTemplate(tenantID uint, name, locale string) error {
+	res := config.GetMasterDB().Where("tenant_id = ? AND name = ? AND locale = ?", tenantID, name, locale).
+		Delete(&models.EmailTemplate{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrEmailTemplateNotFound
+	}
+	return nil
+}
+
+// EmailPreview is a template rendered with its sample data.
+type EmailPreview struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+// Preview renders tmpl with the template's sample data, in the tenant's
+// branding and timezone.
+func (s *MailService) Preview(tenantID uint, name string, tmpl *mail.Template) (*EmailPreview, error) {
+	def, ok := emailTemplates[name]
+	if !ok {
+		return nil, ErrEmailTemplateNotFound
+	}
+	var tenant models.Tenant
+	if err := config.GetMasterDB().First(&tenant, tenantID).Error; err != nil {
+		return nil, errors.New("tenant not found")
+	}
+
+	settings := NewSettingsService()
+	brand := settings.Branding(tenantID)
+	vars := def.sample()
+	vars["Workspace"] = tenant.Name
+	if brand.DisplayName != "" {
+		vars["Workspace"] = brand.DisplayName
+	}
+	rendered, err := tmpl.Render(vars, emailFuncs(settings.Location(tenantID)))
+	if err != nil {
+		return nil, fmt.Errorf("template does not render: %w", err)
+	}
+	rendered = s.finish(rendered, brand, vars)
+	return &EmailPreview{Subject: rendered.Subject, Text: rendered.Text, HTML: rendered.HTML}, nil
+}
+
+// openTenantDB returns the database of a tenant, scoped to it.
+func openTenantDB(tenantID uint) (*gorm.DB, error) {
+	var tenant models.Tenant
+	if err := config.GetMasterDB().First(&tenant, tenantID).Error; err != nil {
+		return nil, err
+	}
+	tenantDB, err := config.TenantManager.GetTenantDB(&tenant)
+	if err != nil {
+		return nil, err
+	}
+	return config.ScopeToTenant(tenantDB, tenantID), nil
+}
+
+// recipientsWith returns the emails of a tenant's active users holding permission.
+func recipientsWith(tenantID uint, permission string) ([]string, error) {
+	_, users, err := usersWith(tenantID, permission)
+	if err != nil {
+		return nil, err
+	}
+	emails := make([]string, len(users))
+	for i, user := range users {
+		emails[i] = user.Email
+	}
+	return emails, nil
+}
+
+// usersWith returns the tenant's active users holding permission, and the
+// tenant database scoped to the tenant.
+func usersWith(tenantID uint, permission string) (*gorm.DB, []models.User, error) {
+	tenantDB, err := openTenantDB(tenantID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var users []models.User
+	if err := tenantDB.Preload("Roles.Permissions").Where("is_active = ?", true).Find(&users).Error; err != nil {
+		return nil, nil, err
+	}
+	var holders []models.User
+	for _, user := range users {
+		if user.HasPermission(permission) {
+			holders = append(holders, user)
+		}
+	}
+	return tenantDB, holders, nil
+}
+
+// notify emails a template to the tenant's users holding permission.
+func (s *MailService) notify(tenantID uint, permission, name string, data map[string]interface{}) {
+	to, err := recipientsWith(tenantID, permission)
+	if err != nil {
+		log.Printf("Mail: no recipients for %s to tenant %d: %v", name, tenantID, err)
+		return
+	}
+	if len(to) == 0 {
+		return
+	}
+	if err := s.Send(tenantID, name, to, data); err != nil {
+		log.Printf("Mail: failed to queue %s for tenant %d: %v", name, tenantID, err)
+	}
+}
+
+func registerMailHooks() {
+	s := NewMailService()
+
+	// Notices about the plan expiring and low stock go through the
+	// notification center, which honours each user's preferences.
+	OnBillingNotice(func(notice BillingNotice) {
+		name, ok := billingNoticeTemplates[notice.Kind]
+		if !ok || planExpiringNotices[notice.Kind] {
+			return
+		}
+		s.notify(notice.TenantID, "subscription:manage", name, billingNoticeData(notice))
+	})
+
+	OnUsageWarning(func(warning UsageWarning) {
+		s.notify(warning.TenantID, "subscription:manage", EmailUsageWarning, map[string]interface{}{
+			"Metric": strings.ReplaceAll(warning.Metric, "_", " "),
+			"Used":   warning.Used,
+			"Limit":  warning.Limit,
+			"Level":  warning.Level,
+		})
+	})
+
+	OnTaskReport(func(report TaskReport) {
+		to := slices.Clone(report.Recipients)
+		if creator, err := taskCreatorEmail(report.TenantID, report.UserID); err == nil && !slices.Contains(to, creator) {
+			to = append(to, creator)
+		}
+		if len(to) == 0 {
+			return
+		}
+		err := s.Send(report.TenantID, EmailTaskReport, to, map[string]interface{}{
+			"TaskName":    report.TaskName,
+			"Title":       report.Title,
+			"Summary":     report.Summary,
+			"Columns":     report.Columns,
+			"Rows":        report.Rows,
+			"GeneratedAt": report.GeneratedAt,
+		})
+		if err != nil {
+			log.Printf("Mail: failed to queue the report of task %d: %v", report.TaskID, err)
+		}
+	})
+}
+
+func taskCreatorEmail(tenantID, userID uint) (string, error) {
+	var tenant models.Tenant
+	if err := config.GetMasterDB().First(&tenant, tenantID).Error; err != nil {
+		return "", err
+	}
+	tenantDB, err := config.TenantManager.GetTenantDB(&tenant)
+	if err != nil {
+		return "", err
+	}
+	var user models.User
+	if err := config.ScopeToTenant(tenantDB, tenantID).Where("is_active = ?", true).First(&user, userID).Error; err != nil {
+		return "", err
+	}
+	return user.Email, nil
+}
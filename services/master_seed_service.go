@@ -1,6 +1,7 @@
 package services
 
 import (
+	"go-multi-tenant/config"
 	"go-multi-tenant/models"
 	"go-multi-tenant/utils"
 	"log"
@@ -19,15 +20,24 @@ func SeedMasterData(db *gorm.DB, username, email, password string) error {
 		{
 			Name: "Pro Monthly", Type: models.PlanStandard,
 			Price: 29.99, MaxUsers: 10, MaxProducts: 100, StorageLimit: 5000, IsActive: true,
+			TrialDays: 14, MaxSandboxes: 1,
 		},
 		{
 			Name: "Pro Yearly", Type: models.PlanPremium,
 			Price: 299.99, MaxUsers: 10, MaxProducts: 100, StorageLimit: 5000, IsActive: true,
+			BillingCycleDays: 365, MaxSandboxes: 3,
 		},
 	}
 
+	// Plans seeded before a limit existed get its value; fields left zero here
+	// are not assigned, so they keep what was set on the plan.
 	for _, p := range plans {
-		if err := db.Where("name = ?", p.Name).FirstOrCreate(&p).Error; err != nil {
+		limits := models.Plan{
+			MaxSandboxes:     p.MaxSandboxes,
+			BillingCycleDays: p.BillingCycleDays,
+			TrialDays:        p.TrialDays,
+		}
+		if err := db.Where("name = ?", p.Name).Assign(limits).FirstOrCreate(&p).Error; err != nil {
 			log.Printf("Error seeding plan %s: %v", p.Name, err)
 		}
 	}
@@ -64,6 +74,12 @@ func SeedMasterData(db *gorm.DB, username, email, password string) error {
 		{Name: "Reporting", Description: "View sales and audit logs"},
 		{Name: "System Admin", Description: "Super Admin only features"},
 		{Name: "Purchase Management", Description: "Handle purchase orders and stock intake"},
+		{Name: "Sandbox Management", Description: "Clone the workspace into disposable sandboxes"},
+		{Name: "Workspace Settings", Description: "Domains, branding and workspace preferences"},
+		{Name: "Data Privacy", Description: "Data exports and erasure requests"},
+		{Name: "Billing", Description: "Subscription plan and billing"},
+		{Name: "Background Jobs", Description: "Track and manage background jobs"},
+		{Name: "Integrations", Description: "Webhooks to external systems"},
 	}
 
 	for i := range modules {
@@ -103,16 +119,33 @@ func SeedMasterData(db *gorm.DB, username, email, password string) error {
 		{Name: "purchase:view", Category: "purchase", ModuleID: &modules[6].ID},    // Purchaser & Manager
 		{Name: "purchase:action", Category: "purchase", ModuleID: &modules[6].ID},  // Purchaser (Approve/Reject)
 		{Name: "purchase:receive", Category: "purchase", ModuleID: &modules[6].ID}, // Stock Manager
+
+		{Name: "sandbox:manage", Category: "sandbox", ModuleID: &modules[7].ID},
+
+		{Name: "domain:manage", Category: "workspace", ModuleID: &modules[8].ID},
+		{Name: "settings:manage", Category: "workspace", ModuleID: &modules[8].ID},
+
+		{Name: "data:export", Category: "privacy", ModuleID: &modules[9].ID},
+		{Name: "data:erase", Category: "privacy", ModuleID: &modules[9].ID},
+
+		{Name: "subscription:manage", Category: "billing", ModuleID: &modules[10].ID},
+
+		{Name: "job:view", Category: "jobs", ModuleID: &modules[11].ID},
+		{Name: "job:manage", Category: "jobs", ModuleID: &modules[11].ID},
+		{Name: "task:manage", Category: "jobs", ModuleID: &modules[11].ID},
+
+		{Name: "webhook:manage", Category: "integrations", ModuleID: &modules[12].ID},
 	}
 
 	for i := range permissions {
-		if err := db.FirstOrCreate(&permissions[i], models.Permission{Name: permissions[i].Name}).Error; err != nil {
+		placement := models.Permission{Category: permissions[i].Category, ModuleID: permissions[i].ModuleID}
+		if err := db.Where(models.Permission{Name: permissions[i].Name}).Assign(placement).FirstOrCreate(&permissions[i]).Error; err != nil {
 			log.Printf("Error seeding permission %s: %v", permissions[i].Name, err)
 		}
 	}
 
 	superAdminRole := models.Role{
-		Name:         "Super Administrator",
+		Name:         models.SuperAdminRoleName,
 		Description:  "System Owner - Full Access",
 		IsSystemRole: true,
 		TenantID:     masterTenant.ID,
@@ -125,6 +158,7 @@ func SeedMasterData(db *gorm.DB, username, email, password string) error {
 	var allPermissions []models.Permission
 	db.Find(&allPermissions)
 	db.Model(&superAdminRole).Association("Permissions").Replace(&allPermissions)
+	backfillTenantAdminPermissions(db)
 
 	if username == "" {
 		username = os.Getenv("SUPERADMIN_USERNAME")
@@ -194,3 +228,59 @@ func SeedMasterData(db *gorm.DB, username, email, password string) error {
 
 	return nil
 }
+
+// backfillTenantAdminPermissions gives the Tenant Admin role of every existing
+// workspace the workspace permissions added since it was created. Appending
+// is idempotent, so this runs on every start.
+func backfillTenantAdminPermissions(db *gorm.DB) {
+	var names []string
+	if err := db.Model(&models.Permission{}).Where("category NOT IN ?", tenantExcludedCategories).
+		Pluck("name", &names).Error; err != nil {
+		log.Printf("Error loading tenant permissions: %v", err)
+		return
+	}
+	var tenants []models.Tenant
+	if err := db.Find(&tenants).Error; err != nil {
+		log.Printf("Error loading tenants for the permission backfill: %v", err)
+		return
+	}
+
+	for i := range tenants {
+		tenant := &tenants[i]
+		tenantDB, err := config.TenantManager.GetTenantDB(tenant)
+		if err != nil {
+			log.Printf("Permission backfill: skipping tenant %d: %v", tenant.ID, err)
+			continue
+		}
+		var roles []models.Role
+		if err := tenantDB.Where("tenant_id = ? AND name = ? AND is_system_role = ?", tenant.ID, tenantAdminRoleName, true).
+			Find(&roles).Error; err != nil || len(roles) == 0 {
+			continue
+		}
+		var perms []models.Permission
+		if err := ensureTenantPermissions(db, tenantDB, names, &perms); err != nil {
+			log.Printf("Permission backfill: skipping tenant %d: %v", tenant.ID, err)
+			continue
+		}
+		for j := range roles {
+			if err := tenantDB.Model(&roles[j]).Association("Permissions").Append(&perms); err != nil {
+				log.Printf("Permission backfill: tenant %d: %v", tenant.ID, err)
+			}
+		}
+	}
+}
+
+// ensureTenantPermissions copies the named master permissions into a tenant
+// database that keeps its own, and loads them from there.
+func ensureTenantPermissions(masterDB, tenantDB *gorm.DB, names []string, perms *[]models.Permission) error {
+	var master []models.Permission
+	if err := masterDB.Where("name IN ?", names).Find(&master).Error; err != nil {
+		return err
+	}
+	for _, p := range master {
+		if err := tenantDB.FirstOrCreate(&models.Permission{Name: p.Name}, p).Error; err != nil {
+			return err
+		}
+	}
+	return tenantDB.Where("name IN ?", names).Find(perms).Error
+}
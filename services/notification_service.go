@@ -0,0 +1,519 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-multi-tenant/config"
+	"go-multi-tenant/events"
+	"go-multi-tenant/models"
+	"log"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Notification types. Each user chooses per type whether it is shown in the
+// app, emailed, or both.
+const (
+	NotifyPOPending    = "purchase_order.pending"
+	NotifyPORejected   = "purchase_order.rejected"
+	NotifyLowStock     = "inventory.low_stock"
+	NotifyPlanExpiring = "billing.plan_expiring"
+)
+
+type notificationType struct {
+	description string
+	email       bool // Emailed unless the user turns it off
+}
+
+var notificationTypes = map[string]notificationType{
+	NotifyPOPending:    {description: "A purchase order is waiting for your approval"},
+	NotifyPORejected:   {description: "A purchase order you requested was rejected"},
+	NotifyLowStock:     {description: "Stock fell to the low-stock threshold; emails are a daily digest", email: true},
+	NotifyPlanExpiring: {description: "The plan is about to expire or has expired unpaid", email: true},
+}
+
+// planExpiringNotices are the billing notices sent as NotifyPlanExpiring.
+var planExpiringNotices = map[string]bool{
+	NoticeTrialEnding:    true,
+	NoticeRenewalDue:     true,
+	NoticePaymentOverdue: true,
+}
+
+var ErrNotificationNotFound = errors.New("notification not found")
+
+type NotificationService struct{}
+
+func NewNotificationService() *NotificationService {
+	return &NotificationService{}
+}
+
+type NotificationView struct {
+	models.Notification
+	Data json.RawMessage `json:"data"`
+}
+
+func notificationView(n models.Notification) NotificationView {
+	view := NotificationView{Notification: n, Data: json.RawMessage("{}")}
+	if n.Data != "" {
+		view.Data = json.RawMessage(n.Data)
+	}
+	return view
+}
+
+// List returns a user's notifications, newest first, and how many are unread.
+func (s *NotificationService) List(tenantDB *gorm.DB, tenantID, userID uint, unreadOnly bool, page, pageSize int) ([]NotificationView, int64, int64, error) {
+	mine := func() *gorm.DB {
+		return tenantDB.Model(&models.Notification{}).Where("tenant_id = ? AND user_id = ?", tenantID, userID)
+	}
+
+	var unread int64
+	if err := mine().Where("read_at IS NULL").Count(&unread).Error; err != nil {
+		return nil, 0, 0, err
+	}
+	query := mine()
+	if unreadOnly {
+		query = query.Where("read_at IS NULL")
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, 0, err
+	}
+
+	var rows []models.Notification
+	if err := query.Order("created_at DESC, id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&rows).Error; err != nil {
+		return nil, 0, 0, err
+	}
+	views := make([]NotificationView, len(rows))
+	for i, row := range rows {
+		views[i] = notificationView(row)
+	}
+	return views, total, unread, nil
+}
+
+func (s *NotificationService) MarkRead(tenantDB *gorm.DB, tenantID, userID, id uint) (*NotificationView, error) {
+	var n models.Notification
+	err := tenantDB.Where("id = ? AND tenant_id = ? AND user_id = ?", id, tenantID, userID).First(&n).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotificationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n.ReadAt == nil {
+		now := time.Now()
+		if err := tenantDB.Model(&n).Update("read_at", &now).Error; err != nil {
+			return nil, err
+		}
+		n.ReadAt = &now
+	}
+	view := notificationView(n)
+	return &view, nil
+}
+
+// MarkAllRead marks every unread notification of the user read and returns
+// how many there were.
+func (s *NotificationService) MarkAllRead(tenantDB *gorm.DB, tenantID, userID uint) (int64, error) {
+	res := tenantDB.Model(&models.Notification{}).
+		Where("tenant_id = ? AND user_id = ? AND read_at IS NULL", tenantID, userID).
+		Update("read_at", time.Now())
+	return res.RowsAffected, res.Error
+}
+
+type NotificationPreferenceView struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	InApp       bool   `json:"in_app"`
+	Email       bool   `json:"email"`
+}
+
+// Preferences returns the user's channels for every notification type.
+func (s *NotificationService) Preferences(tenantDB *gorm.DB, tenantID, userID uint) ([]NotificationPreferenceView, error) {
+	var rows []models.NotificationPreference
+	if err := tenantDB.Where("tenant_id = ? AND user_id = ?", tenantID, userID).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	saved := make(map[string]models.NotificationPreference, len(rows))
+	for _, row := range rows {
+		saved[row.Type] = row
+	}
+
+	names := make([]string, 0, len(notificationTypes))
+	for name := range notificationTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	views := make([]NotificationPreferenceView, len(names))
+	for i, name := range names {
+		pref, ok := saved[name]
+		if !ok {
+			pref = defaultPreference(name)
+		}
+		views[i] = NotificationPreferenceView{
+			Type:        name,
+			Description: notificationTypes[name].description,
+			InApp:       pref.InApp,
+			Email:       pref.Email,
+		}
+	}
+	return views, nil
+}
+
+type NotificationPreferenceRequest struct {
+	Type  string `json:"type" binding:"required"`
+	InApp *bool  `json:"in_app"`
+	Email *bool  `json:"email"`
+}
+
+// UpdatePreferences changes the channels of the given types; fields left out
+// keep their current value.
+func (s *NotificationService) UpdatePreferences(tenantDB *gorm.DB, tenantID, userID uint, reqs []NotificationPreferenceRequest) ([]NotificationPreferenceView, error) {
+	for _, req := range reqs {
+		if _, ok := notificationTypes[req.Type]; !ok {
+			return nil, fmt.Errorf("unknown notification type %q", req.Type)
+		}
+	}
+
+	err := tenantDB.Transaction(func(tx *gorm.DB) error {
+		for _, req := range reqs {
+			var pref models.NotificationPreference
+			if err := tx.Where("tenant_id = ? AND user_id = ? AND type = ?", tenantID, userID, req.Type).
+				Limit(1).Find(&pref).Error; err != nil {
+				return err
+			}
+			if pref.ID == 0 {
+				pref = defaultPreference(req.Type)
+				pref.TenantID, pref.UserID = tenantID, userID
+			}
+			if req.InApp != nil {
+				pref.InApp = *req.InApp
+			}
+			if req.Email != nil {
+				pref.Email = *req.Email
+			}
+			if err := tx.Save(&pref).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.Preferences(tenantDB, tenantID, userID)
+}
+
+func defaultPreference(name string) models.NotificationPreference {
+	return models.NotificationPreference{Type: name, InApp: true, Email: notificationTypes[name].email}
+}
+
+// PurgeRead deletes notifications read before cutoff in every tenant.
+func (s *NotificationService) PurgeRead(cutoff time.Time) (int64, error) {
+	var tenants []models.Tenant
+	if err := config.GetMasterDB().Where("is_active = ?", true).Find(&tenants).Error; err != nil {
+		return 0, err
+	}
+	var removed int64
+	var errs []error
+	for i := range tenants {
+		tenant := &tenants[i]
+		if tenant.GetActualDBName() == "master_db" {
+			continue
+		}
+		tenantDB, err := config.TenantManager.GetTenantDB(tenant)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", tenant.Name, err))
+			continue
+		}
+		res := tenantDB.Where("tenant_id = ? AND read_at < ?", tenant.ID, cutoff).Delete(&models.Notification{})
+		if res.Error != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", tenant.Name, res.Error))
+		}
+		removed += res.RowsAffected
+	}
+	return removed, errors.Join(errs...)
+}
+
+// notice is one notification for a group of users. The in-app copy is only
+// created when InApp is set, and the email only sent when Email names a
+// template; each user's preferences then pick among them.
+type notice struct {
+	Type      string
+	Title     string
+	Body      string
+	Link      string
+	Data      map[string]interface{}
+	InApp     bool
+	Email     string
+	EmailData map[string]interface{}
+}
+
+func (s *NotificationService) deliver(tenantDB *gorm.DB, tenantID uint, users []models.User, n notice) {
+	if len(users) == 0 {
+		return
+	}
+	ids := make([]uint, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+	var rows []models.NotificationPreference
+	if err := tenantDB.Where("tenant_id = ? AND type = ? AND user_id IN ?", tenantID, n.Type, ids).Find(&rows).Error; err != nil {
+		log.Printf("Notifications: failed to load preferences for %s in tenant %d: %v", n.Type, tenantID, err)
+		return
+	}
+	prefs := make(map[uint]models.NotificationPreference, len(rows))
+	for _, row := range rows {
+		prefs[row.UserID] = row
+	}
+
+	data, _ := json.Marshal(n.Data)
+	var inApp []models.Notification
+	var to []string
+	for _, user := range users {
+		pref, ok := prefs[user.ID]
+		if !ok {
+			pref = defaultPreference(n.Type)
+		}
+		if n.InApp && pref.InApp {
+			inApp = append(inApp, models.Notification{
+				TenantID: tenantID,
+				UserID:   user.ID,
+				Type:     n.Type,
+				Title:    n.Title,
+				Body:     n.Body,
+				Link:     n.Link,
+				Data:     string(data),
+			})
+		}
+		if n.Email != "" && pref.Email {
+			to = append(to, user.Email)
+		}
+	}
+
+	if len(inApp) > 0 {
+		if err := tenantDB.Create(&inApp).Error; err != nil {
+			log.Printf("Notifications: failed to store %s for tenant %d: %v", n.Type, tenantID, err)
+		} else {
+			views := make([]NotificationView, len(inApp))
+			for i, row := range inApp {
+				views[i] = notificationView(row)
+			}
+			publishEvent(NotificationsCreated{TenantID: tenantID, Notifications: views})
+		}
+	}
+	if len(to) > 0 {
+		if err := NewMailService().Send(tenantID, n.Email, to, n.EmailData); err != nil {
+			log.Printf("Notifications: failed to queue %s email for tenant %d: %v", n.Type, tenantID, err)
+		}
+	}
+}
+
+// notifyHolders delivers n to the tenant's users holding permission, except
+// the one who caused it.
+func (s *NotificationService) notifyHolders(tenantID uint, permission string, except uint, n notice) {
+	tenantDB, users, err := usersWith(tenantID, permission)
+	if err != nil {
+		log.Printf("Notifications: no recipients for %s in tenant %d: %v", n.Type, tenantID, err)
+		return
+	}
+	recipients := users[:0]
+	for _, user := range users {
+		if user.ID != except {
+			recipients = append(recipients, user)
+		}
+	}
+	s.deliver(tenantDB, tenantID, recipients, n)
+}
+
+// orderNotice fills in what every purchase order notice says about order.
+func orderNotice(tenantDB *gorm.DB, order *models.PurchaseOrder, n notice) notice {
+	product := order.Product
+	if product == nil {
+		product = &models.Product{}
+		tenantDB.Where("id = ?", order.ProductID).Limit(1).Find(product)
+	}
+	n.Data = map[string]interface{}{
+		"order_id":   order.ID,
+		"product_id": order.ProductID,
+		"quantity":   order.Quantity,
+		"buy_price":  order.BuyPrice,
+	}
+	n.EmailData = map[string]interface{}{
+		"OrderID":  order.ID,
+		"Product":  product.Name,
+		"SKU":      product.SKU,
+		"Quantity": order.Quantity,
+		"BuyPrice": order.BuyPrice,
+	}
+	n.Title = fmt.Sprintf(n.Title, order.ID)
+	n.Body = fmt.Sprintf(n.Body, order.Quantity, product.Name)
+	return n
+}
+
+func usernameOf(tenantDB *gorm.DB, userID uint) string {
+	var user models.User
+	if userID == 0 || tenantDB.Unscoped().Where("id = ?", userID).Limit(1).Find(&user).Error != nil || user.ID == 0 {
+		return "someone"
+	}
+	return user.Username
+}
+
+func (s *NotificationService) purchaseOrderPending(e PurchaseOrderStatusChanged) {
+	tenantDB, users, err := usersWith(e.TenantID, "purchase:action")
+	if err != nil {
+		log.Printf("Notifications: no approvers for purchase order %d: %v", e.Order.ID, err)
+		return
+	}
+	approvers := users[:0]
+	for _, user := range users {
+		if user.ID != e.ActorID {
+			approvers = append(approvers, user)
+		}
+	}
+	if len(approvers) == 0 {
+		return
+	}
+
+	n := orderNotice(tenantDB, &e.Order, notice{
+		Type:  NotifyPOPending,
+		Title: "Purchase order #%d is waiting for approval",
+		Body:  "%d x %s",
+		Link:  "/purchase-orders?status=" + models.POPending,
+		InApp: true,
+		Email: EmailPOPending,
+	})
+	requester := usernameOf(tenantDB, e.Order.RequestedBy)
+	n.Body = requester + " requested " + n.Body
+	n.EmailData["RequestedBy"] = requester
+	s.deliver(tenantDB, e.TenantID, approvers, n)
+}
+
+func (s *NotificationService) purchaseOrderRejected(e PurchaseOrderStatusChanged) {
+	tenantDB, err := openTenantDB(e.TenantID)
+	if err != nil {
+		log.Printf("Notifications: no requester for purchase order %d: %v", e.Order.ID, err)
+		return
+	}
+	var requester models.User
+	if err := tenantDB.Where("id = ? AND is_active = ?", e.Order.RequestedBy, true).Limit(1).Find(&requester).Error; err != nil ||
+		requester.ID == 0 || requester.ID == e.ActorID {
+		return
+	}
+
+	n := orderNotice(tenantDB, &e.Order, notice{
+		Type:  NotifyPORejected,
+		Title: "Purchase order #%d was rejected",
+		Body:  "Your request for %d x %s was rejected",
+		Link:  "/purchase-orders?status=" + models.PORejected,
+		InApp: true,
+		Email: EmailPORejected,
+	})
+	actor := usernameOf(tenantDB, e.ActorID)
+	n.Body += " by " + actor
+	n.EmailData["ActorName"] = actor
+	s.deliver(tenantDB, e.TenantID, []models.User{requester}, n)
+}
+
+func (s *NotificationService) stockLow(e StockAdjusted, threshold int) {
+	s.notifyHolders(e.TenantID, "inventory:update", 0, notice{
+		Type:  NotifyLowStock,
+		Title: fmt.Sprintf("%s is low on stock", e.ProductName),
+		Body:  fmt.Sprintf("%s (%s): %d left in %s, threshold %d", e.ProductName, e.SKU, e.After, e.Location, threshold),
+		Link:  "/inventory/alerts",
+		Data: map[string]interface{}{
+			"product_id": e.ProductID,
+			"sku":        e.SKU,
+			"quantity":   e.After,
+			"threshold":  threshold,
+			"location":   e.Location,
+		},
+		InApp: true,
+	})
+}
+
+// lowStockDigest emails the daily low-stock check to the users who chose
+// email; they were notified in the app as each item ran low.
+func (s *NotificationService) lowStockDigest(alert LowStockAlert) {
+	items := make([]lowStockLine, len(alert.Items))
+	for i, item := range alert.Items {
+		items[i] = lowStockLine{Quantity: item.Quantity, Location: item.Location}
+		if item.Product != nil {
+			items[i].Product, items[i].SKU = item.Product.Name, item.Product.SKU
+		}
+	}
+	s.notifyHolders(alert.TenantID, "inventory:update", 0, notice{
+		Type:      NotifyLowStock,
+		Email:     EmailLowStock,
+		EmailData: map[string]interface{}{"Count": len(items), "Items": items},
+	})
+}
+
+func (s *NotificationService) planExpiring(billing BillingNotice) {
+	var title string
+	switch billing.Kind {
+	case NoticeTrialEnding:
+		title = "Your trial is ending"
+	case NoticeRenewalDue:
+		title = "Your plan renews with an unpaid invoice"
+	default:
+		title = "Payment overdue: the workspace is read-only"
+	}
+	body := fmt.Sprintf("%.2f %s is due", billing.AmountDue, billing.Currency)
+	if billing.Kind == NoticePaymentOverdue {
+		if billing.ExpiresAt != nil {
+			body += fmt.Sprintf("; the plan expired on %s", billing.ExpiresAt.Format("2006-01-02"))
+		}
+		if billing.SuspendsAt != nil {
+			body += fmt.Sprintf(" and the workspace is suspended on %s", billing.SuspendsAt.Format("2006-01-02"))
+		}
+	} else if billing.ExpiresAt != nil {
+		body += fmt.Sprintf("; the plan expires on %s", billing.ExpiresAt.Format("2006-01-02"))
+	}
+
+	s.notifyHolders(billing.TenantID, "subscription:manage", 0, notice{
+		Type:  NotifyPlanExpiring,
+		Title: title,
+		Body:  body,
+		Link:  "/invoices",
+		Data: map[string]interface{}{
+			"kind":        billing.Kind,
+			"expires_at":  billing.ExpiresAt,
+			"suspends_at": billing.SuspendsAt,
+			"amount_due":  billing.AmountDue,
+			"currency":    billing.Currency,
+		},
+		InApp:     true,
+		Email:     billingNoticeTemplates[billing.Kind],
+		EmailData: billingNoticeData(billing),
+	})
+}
+
+func subscribeNotifications(bus *events.Bus) {
+	s := NewNotificationService()
+
+	events.Subscribe(bus, func(ctx context.Context, e PurchaseOrderStatusChanged) {
+		switch e.To {
+		case models.POPending:
+			s.purchaseOrderPending(e)
+		case models.PORejected:
+			s.purchaseOrderRejected(e)
+		}
+	}, events.Async())
+
+	events.Subscribe(bus, func(ctx context.Context, e StockAdjusted) {
+		if threshold := NewSettingsService().LowStockThreshold(e.TenantID); e.FellBelow(threshold) {
+			s.stockLow(e, threshold)
+		}
+	}, events.Async())
+
+	OnLowStock(s.lowStockDigest)
+	OnBillingNotice(func(billing BillingNotice) {
+		if planExpiringNotices[billing.Kind] {
+			s.planExpiring(billing)
+		}
+	})
+}
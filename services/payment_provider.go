@@ -0,0 +1,123 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-multi-tenant/config"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type ChargeRequest struct {
+	TenantID  uint
+	InvoiceID uint
+	Amount    float64
+	Currency  string
+	// PaymentMethod is a provider-specific token (card token, mandate id, ...).
+	// Providers that record money collected elsewhere take their reference here.
+	PaymentMethod string
+}
+
+type ChargeResult struct {
+	Succeeded     bool
+	Reference     string
+	FailureReason string
+}
+
+// PaymentProvider collects money for invoices. A declined charge is a
+// result with Succeeded false; an error means the provider could not be reached.
+type PaymentProvider interface {
+	Name() string
+	Charge(req ChargeRequest) (*ChargeResult, error)
+}
+
+var (
+	paymentProviders   = map[string]PaymentProvider{}
+	paymentProvidersMu sync.RWMutex
+)
+
+func RegisterPaymentProvider(provider PaymentProvider) {
+	paymentProvidersMu.Lock()
+	defer paymentProvidersMu.Unlock()
+	paymentProviders[provider.Name()] = provider
+}
+
+func GetPaymentProvider(name string) (PaymentProvider, error) {
+	paymentProvidersMu.RLock()
+	defer paymentProvidersMu.RUnlock()
+
+	provider, ok := paymentProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown payment provider %q", name)
+	}
+	return provider, nil
+}
+
+func init() {
+	RegisterPaymentProvider(manualPaymentProvider{})
+}
+
+// ErrPaymentsDisabled is returned when tenants try to pay an invoice but no
+// provider is configured for it; an operator records the payment instead.
+var ErrPaymentsDisabled = errors.New("online payment is not available; contact support to pay this invoice")
+
+// InitPayments checks the provider tenants pay their invoices with. The fake
+// provider approves every charge, so it only exists in development.
+func InitPayments(cfg *config.Config) error {
+	if cfg.AppEnv == "development" {
+		RegisterPaymentProvider(&FakePaymentProvider{})
+	}
+	switch cfg.PaymentProvider {
+	case "":
+		log.Println("Payments: no provider configured, invoices are settled by an operator")
+		return nil
+	case "fake":
+		if cfg.AppEnv != "development" {
+			return errors.New("PAYMENT_PROVIDER=fake approves every charge and is only allowed with APP_ENV=development")
+		}
+	case "manual":
+		return errors.New("PAYMENT_PROVIDER=manual would let tenants mark their own invoices paid; leave it empty instead")
+	}
+	if _, err := GetPaymentProvider(cfg.PaymentProvider); err != nil {
+		return err
+	}
+	log.Printf("Payments: tenants pay through the %s provider", cfg.PaymentProvider)
+	return nil
+}
+
+// FakeDeclineMethod makes the fake provider decline the charge.
+const FakeDeclineMethod = "fake_decline"
+
+// FakePaymentProvider approves every charge except those made with
+// FakeDeclineMethod. It is only registered with APP_ENV=development.
+type FakePaymentProvider struct {
+	seq uint64
+}
+
+func (p *FakePaymentProvider) Name() string { return "fake" }
+
+func (p *FakePaymentProvider) Charge(req ChargeRequest) (*ChargeResult, error) {
+	if req.PaymentMethod == FakeDeclineMethod {
+		return &ChargeResult{FailureReason: "card declined"}, nil
+	}
+	n := atomic.AddUint64(&p.seq, 1)
+	return &ChargeResult{
+		Succeeded: true,
+		Reference: fmt.Sprintf("fake_%d_%d", time.Now().Unix(), n),
+	}, nil
+}
+
+// manualPaymentProvider records money received outside the system (bank
+// transfer, cheque). The payment method is the operator's reference.
+type manualPaymentProvider struct{}
+
+func (manualPaymentProvider) Name() string { return "manual" }
+
+func (manualPaymentProvider) Charge(req ChargeRequest) (*ChargeResult, error) {
+	if req.PaymentMethod == "" {
+		return &ChargeResult{FailureReason: "a reference is required for manual payments"}, nil
+	}
+	return &ChargeResult{Succeeded: true, Reference: req.PaymentMethod}, nil
+}
@@ -0,0 +1,488 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-multi-tenant/config"
+	"go-multi-tenant/models"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const systemPlanType models.PlanType = "system_internal"
+
+type PlanService struct{}
+
+func NewPlanService() *PlanService {
+	return &PlanService{}
+}
+
+type PlanRequest struct {
+	Name              string          `json:"name" binding:"required"`
+	Type              models.PlanType `json:"type" binding:"required"`
+	Price             float64         `json:"price"`
+	MaxUsers          int             `json:"max_users"`
+	MaxProducts       int             `json:"max_products"`
+	StorageLimit      int             `json:"storage_limit"`
+	MaxAPICalls       int             `json:"max_api_calls"`
+	MaxPurchaseOrders int             `json:"max_purchase_orders"`
+	MaxSandboxes      int             `json:"max_sandboxes"`
+	BillingCycleDays  *int            `json:"billing_cycle_days"`
+	TrialDays         int             `json:"trial_days"`
+	IsActive          *bool           `json:"is_active"`
+}
+
+func (req *PlanRequest) apply(plan *models.Plan) error {
+	switch req.Type {
+	case models.PlanFree, models.PlanStandard, models.PlanPremium:
+	default:
+		return fmt.Errorf("invalid plan type %q", req.Type)
+	}
+	if req.Price < 0 || req.MaxUsers < 0 || req.MaxProducts < 0 || req.StorageLimit < 0 ||
+		req.MaxAPICalls < 0 || req.MaxPurchaseOrders < 0 || req.MaxSandboxes < 0 || req.TrialDays < 0 {
+		return errors.New("price and limits cannot be negative")
+	}
+
+	plan.Name = strings.TrimSpace(req.Name)
+	plan.Type = req.Type
+	plan.Price = req.Price
+	plan.MaxUsers = req.MaxUsers
+	plan.MaxProducts = req.MaxProducts
+	plan.StorageLimit = req.StorageLimit
+	plan.MaxAPICalls = req.MaxAPICalls
+	plan.MaxPurchaseOrders = req.MaxPurchaseOrders
+	plan.MaxSandboxes = req.MaxSandboxes
+	plan.TrialDays = req.TrialDays
+	if req.BillingCycleDays != nil {
+		if *req.BillingCycleDays < 0 {
+			return errors.New("billing_cycle_days cannot be negative")
+		}
+		plan.BillingCycleDays = *req.BillingCycleDays
+	}
+	if req.IsActive != nil {
+		plan.IsActive = *req.IsActive
+	}
+	return nil
+}
+
+func (s *PlanService) CreatePlan(req *PlanRequest) (*models.Plan, error) {
+	plan := &models.Plan{IsActive: true, BillingCycleDays: 30}
+	if err := req.apply(plan); err != nil {
+		return nil, err
+	}
+
+	var count int64
+	config.GetMasterDB().Model(&models.Plan{}).Where("name = ?", plan.Name).Count(&count)
+	if count > 0 {
+		return nil, fmt.Errorf("a plan named %s already exists", plan.Name)
+	}
+
+	if err := config.GetMasterDB().Create(plan).Error; err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// ListPlans hides the internal system plan; inactive plans are included only on request.
+func (s *PlanService) ListPlans(includeInactive bool) ([]models.Plan, error) {
+	query := config.GetMasterDB().Where("type <> ?", systemPlanType).Order("price")
+	if !includeInactive {
+		query = query.Where("is_active = ?", true)
+	}
+	var plans []models.Plan
+	err := query.Find(&plans).Error
+	return plans, err
+}
+
+func (s *PlanService) GetPlan(id uint) (*models.Plan, error) {
+	var plan models.Plan
+	if err := config.GetMasterDB().First(&plan, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("plan not found")
+		}
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// UpdatePlan changes a plan in place. Limit changes apply to every tenant on
+// the plan immediately, so tenant caches are dropped.
+func (s *PlanService) UpdatePlan(id uint, req *PlanRequest) (*models.Plan, error) {
+	plan, err := s.GetPlan(id)
+	if err != nil {
+		return nil, err
+	}
+	if plan.Type == systemPlanType {
+		return nil, errors.New("the system plan cannot be edited")
+	}
+	if err := req.apply(plan); err != nil {
+		return nil, err
+	}
+	if err := config.GetMasterDB().Save(plan).Error; err != nil {
+		return nil, err
+	}
+
+	_ = NewCacheService().ClearPattern("tenant_info:*")
+	return plan, nil
+}
+
+func (s *PlanService) DeletePlan(id uint) error {
+	plan, err := s.GetPlan(id)
+	if err != nil {
+		return err
+	}
+	if plan.Type == systemPlanType {
+		return errors.New("the system plan cannot be deleted")
+	}
+
+	var count int64
+	config.GetMasterDB().Model(&models.Tenant{}).Where("plan_id = ? OR pending_plan_id = ?", id, id).Count(&count)
+	if count > 0 {
+		return fmt.Errorf("%d tenants are on or moving to this plan; deactivate it instead", count)
+	}
+	return config.GetMasterDB().Delete(plan).Error
+}
+
+// PlanViolation is a limit of the target plan that current usage exceeds.
+type PlanViolation struct {
+	Resource   string `json:"resource"`
+	Current    int64  `json:"current"`
+	Limit      int    `json:"limit"`
+	MustRemove int64  `json:"must_remove"`
+}
+
+// DowngradeBlockedError lists everything that must be removed before a plan change.
+type DowngradeBlockedError struct {
+	PlanName   string
+	Violations []PlanViolation
+}
+
+func (e *DowngradeBlockedError) Error() string {
+	parts := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		parts[i] = fmt.Sprintf("remove %d %s (using %d, %s allows %d)", v.MustRemove, v.Resource, v.Current, e.PlanName, v.Limit)
+	}
+	return "current usage exceeds the target plan: " + strings.Join(parts, "; ")
+}
+
+// Subscription is a tenant's current plan together with any scheduled change.
+type Subscription struct {
+	TenantID    uint         `json:"tenant_id"`
+	Plan        *models.Plan `json:"plan"`
+	PlanExpiry  *time.Time   `json:"plan_expiry,omitempty"`
+	PendingPlan *models.Plan `json:"pending_plan,omitempty"`
+	Usage       PlanUsage    `json:"usage"`
+
+	BillingStatus models.BillingStatus `json:"billing_status"`
+	TrialEndsAt   *time.Time           `json:"trial_ends_at,omitempty"`
+	CreditBalance float64              `json:"credit_balance"`
+	// SuspendsAt is when a past-due tenant loses access unless it pays.
+	SuspendsAt *time.Time `json:"suspends_at,omitempty"`
+
+	// PendingViolations lists what must be removed before the scheduled change can apply.
+	PendingViolations []PlanViolation `json:"pending_violations,omitempty"`
+	// PendingChange is the open invoice of a plan change that applies once paid.
+	PendingChange *models.Invoice `json:"pending_change,omitempty"`
+}
+
+type PlanUsage struct {
+	Users    int64 `json:"users"`
+	Products int64 `json:"products"`
+}
+
+type ChangePlanRequest struct {
+	PlanID uint `json:"plan_id" binding:"required"`
+	// AtRenewal defers the change to the current PlanExpiry instead of applying it now.
+	AtRenewal bool `json:"at_renewal"`
+	// CouponCode is redeemed for the new plan and discounts its invoices.
+	CouponCode string `json:"coupon_code"`
+}
+
+func (s *PlanService) loadTenant(tenantID uint) (*models.Tenant, error) {
+	var tenant models.Tenant
+	if err := config.GetMasterDB().Preload("Plan").First(&tenant, tenantID).Error; err != nil {
+		return nil, errors.New("tenant not found")
+	}
+	if tenant.Plan != nil && tenant.Plan.Type == systemPlanType {
+		return nil, errors.New("the system tenant has no subscription")
+	}
+	return &tenant, nil
+}
+
+func (s *PlanService) usageOf(tenant *models.Tenant) (PlanUsage, error) {
+	tenantDB, err := config.TenantManager.GetTenantDB(tenant)
+	if err != nil {
+		return PlanUsage{}, err
+	}
+	db := config.ScopeToTenant(tenantDB, tenant.ID)
+
+	var usage PlanUsage
+	if err := db.Model(&models.User{}).Count(&usage.Users).Error; err != nil {
+		return usage, err
+	}
+	if err := db.Model(&models.Product{}).Count(&usage.Products).Error; err != nil {
+		return usage, err
+	}
+	return usage, nil
+}
+
+// CheckFits returns a *DowngradeBlockedError if the tenant's usage does not fit plan.
+func (s *PlanService) CheckFits(tenant *models.Tenant, plan *models.Plan) error {
+	usage, err := s.usageOf(tenant)
+	if err != nil {
+		return err
+	}
+
+	var violations []PlanViolation
+	check := func(resource string, current int64, limit int) {
+		if limit > 0 && current > int64(limit) {
+			violations = append(violations, PlanViolation{
+				Resource:   resource,
+				Current:    current,
+				Limit:      limit,
+				MustRemove: current - int64(limit),
+			})
+		}
+	}
+	check("users", usage.Users, plan.MaxUsers)
+	check("products", usage.Products, plan.MaxProducts)
+
+	if len(violations) > 0 {
+		return &DowngradeBlockedError{PlanName: plan.Name, Violations: violations}
+	}
+	return nil
+}
+
+func (s *PlanService) GetSubscription(tenantID uint) (*Subscription, error) {
+	tenant, err := s.loadTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := s.usageOf(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &Subscription{
+		TenantID:      tenant.ID,
+		Plan:          tenant.Plan,
+		PlanExpiry:    tenant.PlanExpiry,
+		Usage:         usage,
+		BillingStatus: tenant.BillingStatus,
+		TrialEndsAt:   tenant.TrialEndsAt,
+		CreditBalance: tenant.CreditBalance,
+	}
+	if tenant.BillingStatus == models.BillingPastDue && tenant.PlanExpiry != nil {
+		suspendsAt := tenant.PlanExpiry.Add(config.AppConfig.GracePeriod)
+		sub.SuspendsAt = &suspendsAt
+	}
+	var change models.Invoice
+	if err := config.GetMasterDB().Preload("Lines").
+		Where("tenant_id = ? AND reason = ? AND status = ?", tenant.ID, invoiceReasonPlanChange, models.InvoiceOpen).
+		Order("id DESC").Limit(1).Find(&change).Error; err == nil && change.ID != 0 {
+		sub.PendingChange = &change
+	}
+	if tenant.PendingPlanID != nil {
+		if pending, err := s.GetPlan(*tenant.PendingPlanID); err == nil {
+			sub.PendingPlan = pending
+			var blocked *DowngradeBlockedError
+			if errors.As(s.CheckFits(tenant, pending), &blocked) {
+				sub.PendingViolations = blocked.Violations
+			}
+		}
+	}
+	return sub, nil
+}
+
+// ChangePlan upgrades or downgrades a tenant. An immediate change must fit the
+// new plan's limits and is prorated; it takes effect once its invoice is paid,
+// at once when nothing is due. A change at renewal is only recorded and
+// re-checked when it comes due.
+func (s *PlanService) ChangePlan(tenantID uint, req *ChangePlanRequest) (*Subscription, error) {
+	tenant, err := s.loadTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := s.GetPlan(req.PlanID)
+	if err != nil {
+		return nil, err
+	}
+	if !plan.IsActive || plan.Type == systemPlanType {
+		return nil, errors.New("plan is not available")
+	}
+	billingService := NewBillingService()
+	redeemCoupon := func(tx *gorm.DB) error {
+		if req.CouponCode == "" {
+			return nil
+		}
+		_, err := NewCouponService().redeem(tx, tenant.ID, req.CouponCode, plan.ID)
+		return err
+	}
+
+	if plan.ID == tenant.PlanID || req.AtRenewal {
+		if req.AtRenewal && tenant.PlanExpiry == nil {
+			return nil, errors.New("the current plan never renews; change it immediately instead")
+		}
+		if billingService.renewalPrepaid(tenant.ID) {
+			return nil, errors.New("the next period is already paid; change the plan immediately instead")
+		}
+		// Choosing the current plan again cancels a scheduled change.
+		var pendingPlanID *uint
+		if plan.ID != tenant.PlanID {
+			pendingPlanID = &plan.ID
+		}
+		// The renewal invoice, if already open, is reissued for the new plan.
+		err := config.GetMasterDB().Transaction(func(tx *gorm.DB) error {
+			if err := billingService.voidOpenRenewals(tx, tenant.ID); err != nil {
+				return err
+			}
+			if err := redeemCoupon(tx); err != nil {
+				return err
+			}
+			return tx.Model(&models.Tenant{}).Where("id = ?", tenant.ID).Update("pending_plan_id", pendingPlanID).Error
+		})
+		if err != nil {
+			return nil, err
+		}
+		s.clearTenantCache(tenantID)
+		return s.GetSubscription(tenantID)
+	}
+
+	if tenant.BillingStatus == models.BillingPastDue && plan.Price > 0 {
+		return nil, errors.New("settle the overdue invoice before switching to another paid plan")
+	}
+	if err := s.CheckFits(tenant, plan); err != nil {
+		return nil, err
+	}
+	err = config.GetMasterDB().Transaction(func(tx *gorm.DB) error {
+		if err := redeemCoupon(tx); err != nil {
+			return err
+		}
+		expiry, invoice, err := billingService.prorateChange(tx, tenant, plan, time.Now())
+		if err != nil {
+			return err
+		}
+		if invoice != nil {
+			// The plan switches when the invoice is paid.
+			return nil
+		}
+		if err := tx.Model(&models.Tenant{}).Where("id = ?", tenant.ID).Updates(map[string]interface{}{
+			"plan_id":         plan.ID,
+			"pending_plan_id": nil,
+			"plan_expiry":     expiry,
+		}).Error; err != nil {
+			return err
+		}
+		if tenant.BillingStatus != models.BillingActive {
+			_, err = clearDelinquency(tx, tenant.ID)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.clearTenantCache(tenantID)
+	return s.GetSubscription(tenantID)
+}
+
+// SetPlanExpiry moves a tenant's renewal date; nil makes the plan lifetime.
+func (s *PlanService) SetPlanExpiry(tenantID uint, expiry *time.Time) (*Subscription, error) {
+	tenant, err := s.loadTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	updates := map[string]interface{}{"plan_expiry": expiry}
+	if expiry == nil {
+		updates["pending_plan_id"] = nil
+	}
+	reactivated := false
+	err = config.GetMasterDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Tenant{}).Where("id = ?", tenant.ID).Updates(updates).Error; err != nil {
+			return err
+		}
+		reactivated, err = clearDelinquency(tx, tenant.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.clearTenantCache(tenantID)
+	if reactivated {
+		notifyReactivated(tenantID)
+	}
+	return s.GetSubscription(tenantID)
+}
+
+// ApplyScheduledChanges switches tenants whose renewal has come to their
+// pending plan, including those that paid for the new period in advance.
+// Changes that still don't fit stay pending and are logged.
+func (s *PlanService) ApplyScheduledChanges() {
+	now := time.Now()
+	billingService := NewBillingService()
+
+	var due []models.Tenant
+	if err := config.GetMasterDB().Preload("Plan").
+		Where("pending_plan_id IS NOT NULL AND plan_expiry IS NOT NULL").
+		Find(&due).Error; err != nil {
+		log.Printf("Error fetching scheduled plan changes: %v", err)
+		return
+	}
+
+	for i := range due {
+		tenant := &due[i]
+		if tenant.PlanExpiry.After(now) && !billingService.pendingPeriodStarted(tenant, now) {
+			continue
+		}
+		plan, err := s.GetPlan(*tenant.PendingPlanID)
+		if err != nil {
+			log.Printf("Tenant %s: scheduled plan %d is gone: %v", tenant.Name, *tenant.PendingPlanID, err)
+			continue
+		}
+		if err := s.CheckFits(tenant, plan); err != nil {
+			log.Printf("Tenant %s: scheduled change to %s postponed: %v", tenant.Name, plan.Name, err)
+			continue
+		}
+		err = config.GetMasterDB().Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&models.Tenant{}).Where("id = ?", tenant.ID).Updates(map[string]interface{}{
+				"plan_id":         plan.ID,
+				"pending_plan_id": nil,
+				"plan_expiry":     renewalExpiry(tenant, plan),
+			}).Error; err != nil {
+				return err
+			}
+			_, err := clearDelinquency(tx, tenant.ID)
+			return err
+		})
+		if err != nil {
+			log.Printf("Tenant %s: failed to apply scheduled plan change: %v", tenant.Name, err)
+			continue
+		}
+		s.clearTenantCache(tenant.ID)
+		log.Printf("Tenant %s moved to plan %s at renewal", tenant.Name, plan.Name)
+	}
+}
+
+func (s *PlanService) clearTenantCache(tenantID uint) {
+	_ = NewCacheService().Delete(fmt.Sprintf("tenant_info:%d", tenantID))
+}
+
+func nextExpiry(from time.Time, plan *models.Plan) *time.Time {
+	if plan.BillingCycleDays == 0 {
+		return nil
+	}
+	expiry := from.AddDate(0, 0, plan.BillingCycleDays)
+	return &expiry
+}
+
+// renewalExpiry keeps the renewal date for a paid plan, which the next invoice
+// extends once paid; free plans simply stop expiring.
+func renewalExpiry(tenant *models.Tenant, plan *models.Plan) *time.Time {
+	if plan.Price == 0 {
+		return nil
+	}
+	return tenant.PlanExpiry
+}
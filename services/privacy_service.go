@@ -0,0 +1,469 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-multi-tenant/config"
+	"go-multi-tenant/models"
+	"go-multi-tenant/queue"
+	"go-multi-tenant/utils"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const exportRetention = 7 * 24 * time.Hour
+
+type PrivacyService struct{}
+
+func NewPrivacyService() *PrivacyService {
+	return &PrivacyService{}
+}
+
+// Export rows never carry password hashes, unlike backups.
+type exportUser struct {
+	models.User
+	DeletedAt gorm.DeletedAt `json:"deleted_at"`
+}
+
+// RequestExport records an export and queues a job to build the bundle.
+func (s *PrivacyService) RequestExport(tenantID, userID uint) (*models.DataExport, error) {
+	masterDB := config.GetMasterDB()
+
+	var running int64
+	masterDB.Model(&models.DataExport{}).
+		Where("tenant_id = ? AND status IN ?", tenantID, []string{models.ExportPending, models.ExportRunning}).
+		Count(&running)
+	if running > 0 {
+		return nil, errors.New("an export is already in progress")
+	}
+
+	export := &models.DataExport{
+		TenantID:    tenantID,
+		RequestedBy: userID,
+		Status:      models.ExportPending,
+	}
+	if err := masterDB.Create(export).Error; err != nil {
+		return nil, err
+	}
+
+	_, err := NewQueueService().Enqueue(JobPrivacyExport, exportJob{ExportID: export.ID}, queue.EnqueueOptions{
+		TenantID:    tenantID,
+		CreatedBy:   userID,
+		MaxAttempts: exportAttempts,
+	})
+	if err != nil {
+		masterDB.Model(export).Updates(map[string]interface{}{"status": models.ExportFailed, "error": err.Error()})
+		return nil, fmt.Errorf("failed to queue export: %w", err)
+	}
+	return export, nil
+}
+
+const exportAttempts = 3
+
+type exportJob struct {
+	ExportID uint `json:"export_id"`
+}
+
+// runExportJob builds an export. The export stays pending between retries and
+// is only marked failed once the job runs out of attempts.
+func (s *PrivacyService) runExportJob(ctx context.Context, job *models.Job, payload exportJob) (interface{}, error) {
+	masterDB := config.GetMasterDB()
+
+	var export models.DataExport
+	if err := masterDB.First(&export, payload.ExportID).Error; err != nil {
+		return nil, queue.Permanent(fmt.Errorf("export %d not found", payload.ExportID))
+	}
+	if export.Status == models.ExportCompleted {
+		return map[string]interface{}{"export_id": export.ID}, nil
+	}
+	masterDB.Model(&export).Update("status", models.ExportRunning)
+
+	path, size, err := s.buildExport(&export)
+	now := time.Now()
+	if err != nil {
+		log.Printf("Export %d for tenant %d failed (attempt %d/%d): %v", export.ID, export.TenantID, job.Attempts, job.MaxAttempts, err)
+		updates := map[string]interface{}{"status": models.ExportPending, "error": err.Error()}
+		if job.Attempts >= job.MaxAttempts {
+			updates["status"] = models.ExportFailed
+			updates["completed_at"] = &now
+		}
+		masterDB.Model(&export).Updates(updates)
+		return nil, err
+	}
+
+	expiresAt := now.Add(exportRetention)
+	masterDB.Model(&export).Updates(map[string]interface{}{
+		"status":       models.ExportCompleted,
+		"error":        "",
+		"file_path":    path,
+		"size_bytes":   size,
+		"completed_at": &now,
+		"expires_at":   &expiresAt,
+	})
+	return map[string]interface{}{"export_id": export.ID, "size_bytes": size}, nil
+}
+
+func (s *PrivacyService) buildExport(export *models.DataExport) (string, int64, error) {
+	masterDB := config.GetMasterDB()
+
+	var tenant models.Tenant
+	if err := masterDB.Preload("Plan").First(&tenant, export.TenantID).Error; err != nil {
+		return "", 0, fmt.Errorf("tenant not found: %w", err)
+	}
+	tenantDB, err := config.TenantManager.GetTenantDB(&tenant)
+	if err != nil {
+		return "", 0, err
+	}
+	data, err := exportTenantData(config.ScopeToTenant(tenantDB, tenant.ID), tenant.ID)
+	if err != nil {
+		return "", 0, err
+	}
+
+	users := make([]exportUser, len(data.Users))
+	for i, u := range data.Users {
+		users[i] = exportUser{User: u.User, DeletedAt: u.DeletedAt}
+		users[i].Roles = nil
+	}
+
+	settings, err := NewSettingsService().Get(tenant.ID)
+	if err != nil {
+		return "", 0, err
+	}
+	domains, err := NewDomainService().ListDomains(tenant.ID)
+	if err != nil {
+		return "", 0, err
+	}
+	tenant.APIKey = ""
+
+	datasets := []struct {
+		name string
+		rows interface{}
+	}{
+		{"users", users},
+		{"roles", data.Roles},
+		{"user_roles", data.UserRoles},
+		{"role_permissions", data.RolePermissions},
+		{"categories", data.Categories},
+		{"products", data.Products},
+		{"inventories", data.Inventories},
+		{"purchase_orders", data.PurchaseOrders},
+		{"notifications", data.Notifications},
+		{"notification_preferences", data.NotificationPreferences},
+		{"domains", domains},
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	summary := map[string]interface{}{
+		"tenant":       tenant,
+		"settings":     settings,
+		"generated_at": time.Now(),
+	}
+	payload, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return "", 0, err
+	}
+	if err := writeZipFile(zw, "tenant.json", payload); err != nil {
+		return "", 0, err
+	}
+
+	for _, ds := range datasets {
+		payload, err := json.MarshalIndent(ds.rows, "", "  ")
+		if err != nil {
+			return "", 0, err
+		}
+		if err := writeZipFile(zw, "json/"+ds.name+".json", payload); err != nil {
+			return "", 0, err
+		}
+		csvPayload, err := rowsToCSV(payload)
+		if err != nil {
+			return "", 0, fmt.Errorf("%s: %w", ds.name, err)
+		}
+		if err := writeZipFile(zw, "csv/"+ds.name+".csv", csvPayload); err != nil {
+			return "", 0, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return "", 0, err
+	}
+
+	dir := filepath.Join(config.AppConfig.ExportDir, strconv.Itoa(int(tenant.ID)))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", 0, err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("export-%d-%s.zip", export.ID, time.Now().Format("20060102-150405")))
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		return "", 0, err
+	}
+	return path, int64(buf.Len()), nil
+}
+
+// rowsToCSV flattens a JSON array of objects into CSV. Columns are the union
+// of all keys; nested values are written as JSON.
+func rowsToCSV(payload []byte) ([]byte, error) {
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(payload, &rows); err != nil {
+		return nil, err
+	}
+
+	columnSet := map[string]bool{}
+	for _, row := range rows {
+		for key := range row {
+			columnSet[key] = true
+		}
+	}
+	columns := make([]string, 0, len(columnSet))
+	for key := range columnSet {
+		columns = append(columns, key)
+	}
+	sort.Strings(columns)
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(columns); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		record := make([]string, len(columns))
+		for i, key := range columns {
+			switch v := row[key].(type) {
+			case nil:
+			case string:
+				record[i] = v
+			case float64:
+				record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+			case bool:
+				record[i] = strconv.FormatBool(v)
+			default:
+				nested, _ := json.Marshal(v)
+				record[i] = string(nested)
+			}
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func (s *PrivacyService) ListExports(tenantID uint) ([]models.DataExport, error) {
+	var exports []models.DataExport
+	err := config.GetMasterDB().Where("tenant_id = ?", tenantID).Order("id DESC").Find(&exports).Error
+	return exports, err
+}
+
+func (s *PrivacyService) GetExport(tenantID, exportID uint) (*models.DataExport, error) {
+	var export models.DataExport
+	if err := config.GetMasterDB().Where("id = ? AND tenant_id = ?", exportID, tenantID).First(&export).Error; err != nil {
+		return nil, errors.New("export not found")
+	}
+	return &export, nil
+}
+
+// ExportFile returns the path of a finished export that has not expired yet.
+func (s *PrivacyService) ExportFile(tenantID, exportID uint) (string, error) {
+	export, err := s.GetExport(tenantID, exportID)
+	if err != nil {
+		return "", err
+	}
+	if export.Status != models.ExportCompleted {
+		return "", fmt.Errorf("export is %s", export.Status)
+	}
+	if export.ExpiresAt != nil && time.Now().After(*export.ExpiresAt) {
+		return "", errors.New("export has expired")
+	}
+	return export.FilePath, nil
+}
+
+// PurgeExpiredExports deletes export files past their retention and returns how many were removed.
+func (s *PrivacyService) PurgeExpiredExports() int {
+	masterDB := config.GetMasterDB()
+
+	var expired []models.DataExport
+	masterDB.Where("expires_at IS NOT NULL AND expires_at < ?", time.Now()).Find(&expired)
+	for _, export := range expired {
+		if err := os.Remove(export.FilePath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove export file %s: %v", export.FilePath, err)
+			continue
+		}
+		masterDB.Delete(&export)
+	}
+	return len(expired)
+}
+
+// EraseUser anonymizes a user's personal data for a right-to-erasure request.
+// The row itself is kept (soft-deleted) so purchase orders that reference it
+// through RequestedBy/ApprovedBy stay intact; only the PII is replaced, in
+// the row and wherever the email address or username was copied into
+// notifications, outbox emails, webhook payloads and import results.
+func (s *PrivacyService) EraseUser(tenantDB *gorm.DB, tenantID, userID, actorID uint) error {
+	if userID == actorID {
+		return errors.New("cannot erase your own account")
+	}
+
+	var user models.User
+	if err := tenantDB.Unscoped().Where("id = ? AND tenant_id = ?", userID, tenantID).First(&user).Error; err != nil {
+		return errors.New("user not found")
+	}
+	originalEmail := user.Email
+	erasedUsername := fmt.Sprintf("erased-user-%d", user.ID)
+	erasedEmail := fmt.Sprintf("erased-%d@erased.invalid", user.ID)
+	mentions := newMentionEraser(&user, erasedUsername, erasedEmail)
+
+	randomPassword, err := utils.GenerateSecureKey()
+	if err != nil {
+		return err
+	}
+	hashedPassword, err := utils.HashPassword(randomPassword)
+	if err != nil {
+		return err
+	}
+
+	err = tenantDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Association("Roles").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Where("tenant_id = ? AND user_id = ?", tenantID, user.ID).Delete(&models.Notification{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("tenant_id = ? AND user_id = ?", tenantID, user.ID).Delete(&models.NotificationPreference{}).Error; err != nil {
+			return err
+		}
+		if err := mentions.rewrite(tx, &models.Notification{}, "id", "title", "body", "data"); err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Model(&user).Updates(map[string]interface{}{
+			"username":  erasedUsername,
+			"email":     erasedEmail,
+			"password":  hashedPassword,
+			"is_active": false,
+		}).Error; err != nil {
+			return err
+		}
+		if !user.DeletedAt.Valid {
+			return tx.Delete(&user).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to erase user: %w", err)
+	}
+	if !user.DeletedAt.Valid {
+		NewUsageService().Release(tenantID, models.MetricUsers, 1)
+	}
+
+	if err := config.GetMasterDB().Unscoped().
+		Where("email = ? AND tenant_id = ?", originalEmail, tenantID).
+		Delete(&models.GlobalIdentity{}).Error; err != nil {
+		return fmt.Errorf("user anonymized but global identity removal failed: %w", err)
+	}
+	masterDB := config.GetMasterDB().Where("tenant_id = ?", tenantID).Session(&gorm.Session{})
+	for _, table := range []struct {
+		model   interface{}
+		columns []string
+	}{
+		{&models.OutboxEmail{}, []string{"to", "subject", "text", "html"}},
+		{&models.WebhookDelivery{}, []string{"payload"}},
+		{&models.UserImport{}, []string{"results"}},
+	} {
+		if err := mentions.rewrite(masterDB, table.model, "id", table.columns...); err != nil {
+			return fmt.Errorf("user anonymized but copies of their details remain: %w", err)
+		}
+	}
+
+	publishEvent(UserDeleted{TenantID: tenantID, UserID: userID})
+	return nil
+}
+
+// mentionEraser replaces a user's email address and username in text copied
+// from the user row at the time.
+type mentionEraser struct {
+	email, username       *regexp.Regexp
+	erasedEmail, erasedAs string
+	like                  []string
+}
+
+// Usernames shorter than this are too likely to be ordinary words to be
+// replaced in free text.
+const minErasedUsernameLen = 3
+
+func newMentionEraser(user *models.User, erasedUsername, erasedEmail string) *mentionEraser {
+	m := &mentionEraser{
+		email:       regexp.MustCompile(`(?i)` + regexp.QuoteMeta(user.Email)),
+		erasedEmail: erasedEmail,
+		erasedAs:    erasedUsername,
+		like:        []string{"%" + strings.ToLower(user.Email) + "%"},
+	}
+	if len(user.Username) >= minErasedUsernameLen {
+		m.username = regexp.MustCompile(`\b` + regexp.QuoteMeta(user.Username) + `\b`)
+		m.like = append(m.like, "%"+strings.ToLower(user.Username)+"%")
+	}
+	return m
+}
+
+func (m *mentionEraser) erase(text string) string {
+	// The email first, since it may contain the username.
+	text = m.email.ReplaceAllLiteralString(text, m.erasedEmail)
+	if m.username == nil {
+		return text
+	}
+	return m.username.ReplaceAllLiteralString(text, m.erasedAs)
+}
+
+// rewrite erases mentions in columns of the rows of model that db selects.
+// The LIKE filter only narrows the rows down; the patterns decide.
+func (m *mentionEraser) rewrite(db *gorm.DB, model interface{}, key string, columns ...string) error {
+	var conditions []string
+	var args []interface{}
+	for _, column := range columns {
+		quoted := db.Statement.Quote(column)
+		for _, pattern := range m.like {
+			conditions = append(conditions, "LOWER("+quoted+") LIKE ?")
+			args = append(args, pattern)
+		}
+	}
+	var rows []map[string]interface{}
+	if err := db.Model(model).Select(append([]string{key}, columns...)).
+		Where(strings.Join(conditions, " OR "), args...).Find(&rows).Error; err != nil {
+		return err
+	}
+	for _, row := range rows {
+		updates := map[string]interface{}{}
+		for _, column := range columns {
+			text, ok := row[column].(string)
+			if !ok {
+				if raw, isBytes := row[column].([]byte); isBytes {
+					text, ok = string(raw), true
+				}
+			}
+			if ok {
+				if erased := m.erase(text); erased != text {
+					updates[column] = erased
+				}
+			}
+		}
+		if len(updates) == 0 {
+			continue
+		}
+		if err := db.Session(&gorm.Session{NewDB: true}).Model(model).
+			Where(db.Statement.Quote(key)+" = ?", row[key]).UpdateColumns(updates).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -20,11 +20,21 @@ func (s *PurchaseService) CreateRequest(tenantDB *gorm.DB, tenantID uint, userID
 	req.RequestedBy = userID
 	req.Status = models.POPending
 
+	usageService := NewUsageService()
+	if err := usageService.Reserve(tenantDB, tenantID, models.MetricPurchaseOrders, 1); err != nil {
+		return err
+	}
+
 	repo := repositories.NewPurchaseRepository(tenantDB)
-	return repo.Create(req)
+	if err := repo.Create(req); err != nil {
+		usageService.Release(tenantID, models.MetricPurchaseOrders, 1)
+		return err
+	}
+	publishEvent(PurchaseOrderStatusChanged{TenantID: tenantID, Order: *req, To: req.Status, ActorID: userID})
+	return nil
 }
 
-func (s *PurchaseService) UpdateRequest(tenantDB *gorm.DB, tenantID uint, orderID uint, quantity int, price float64) error {
+func (s *PurchaseService) UpdateRequest(tenantDB *gorm.DB, tenantID uint, orderID uint, userID uint, quantity int, price float64) error {
 	repo := repositories.NewPurchaseRepository(tenantDB)
 
 	order, err := repo.GetByID(orderID, tenantID)
@@ -39,7 +49,11 @@ func (s *PurchaseService) UpdateRequest(tenantDB *gorm.DB, tenantID uint, orderI
 	order.Quantity = quantity
 	order.BuyPrice = price
 	order.Status = models.POPending
-	return repo.Update(order)
+	if err := repo.Update(order); err != nil {
+		return err
+	}
+	publishEvent(PurchaseOrderStatusChanged{TenantID: tenantID, Order: *order, From: models.PORejected, To: order.Status, ActorID: userID})
+	return nil
 }
 
 // 3. Purchaser Action (Mistake 2 Fixed: Added tenantID)
@@ -66,14 +80,21 @@ func (s *PurchaseService) PurchaserAction(tenantDB *gorm.DB, tenantID uint, orde
 		return errors.New("invalid action")
 	}
 
-	return repo.Update(order)
+	if err := repo.Update(order); err != nil {
+		return err
+	}
+	publishEvent(PurchaseOrderStatusChanged{TenantID: tenantID, Order: *order, From: models.POPending, To: order.Status, ActorID: purchaserID})
+	return nil
 }
 
-func (s *PurchaseService) ReceiveOrder(tenantDB *gorm.DB, tenantID uint, orderID uint) error {
+func (s *PurchaseService) ReceiveOrder(tenantDB *gorm.DB, tenantID uint, orderID uint, userID uint) error {
+	var order *models.PurchaseOrder
+	var before models.Inventory
 
-	return tenantDB.Transaction(func(tx *gorm.DB) error {
+	err := tenantDB.Transaction(func(tx *gorm.DB) error {
 		txRepo := repositories.NewPurchaseRepository(tx)
-		order, err := txRepo.GetByID(orderID, tenantID)
+		var err error
+		order, err = txRepo.GetByID(orderID, tenantID)
 		if err != nil {
 			return err
 		}
@@ -87,9 +108,17 @@ func (s *PurchaseService) ReceiveOrder(tenantDB *gorm.DB, tenantID uint, orderID
 			return err
 		}
 
+		tx.Preload("Product").Where("product_id = ? AND tenant_id = ?", order.ProductID, tenantID).Limit(1).Find(&before)
 		invRepo := repositories.NewInventoryRepository(tx)
 		return invRepo.UpdateStock(order.ProductID, tenantID, order.Quantity)
 	})
+	if err != nil {
+		return err
+	}
+
+	publishEvent(PurchaseOrderStatusChanged{TenantID: tenantID, Order: *order, From: models.PODispatched, To: order.Status, ActorID: userID})
+	publishStockAdjusted(tenantID, &before, order.Quantity)
+	return nil
 }
 
 func (s *PurchaseService) ListOrders(tenantDB *gorm.DB, tenantID uint, status string) ([]models.PurchaseOrder, error) {
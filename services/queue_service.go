@@ -1,155 +1,219 @@
-// package services
-
-// import (
-// 	"encoding/json"
-// 	"fmt"
-// 	"go-multi-tenant/config"
-// 	"go-multi-tenant/models"
-// 	"go-multi-tenant/queue"
-// 	"go-multi-tenant/repositories"
-// 	"log"
-// 	"time"
-// )
-
-// type QueueService struct {
-// 	userQueue   *queue.Queue
-// 	workerCount int
-// 	userService *UserService
-// }
-
-// func NewQueueService(userService *UserService, workerCount int) *QueueService {
-// 	return &QueueService{
-// 		userQueue:   queue.NewQueue(),
-// 		workerCount: workerCount,
-// 		userService: userService,
-// 	}
-// }
-
-// type CreateUserJobData struct {
-// 	TenantID    uint               `json:"tenant_id"`
-// 	Request     *CreateUserRequest `json:"request"`
-// 	CurrentUser *models.User       `json:"current_user"`
-// }
-
-// type CreateUserResponse struct {
-// 	User  *models.User `json:"user,omitempty"`
-// 	Error string       `json:"error,omitempty"`
-// 	JobID string       `json:"job_id"`
-// }
-
-// func (qs *QueueService) StartWorkers() {
-// 	for i := 0; i < qs.workerCount; i++ {
-// 		go qs.worker(i + 1)
-// 	}
-// 	log.Printf("Started %d queue workers", qs.workerCount)
-// }
-
-// func (qs *QueueService) worker(workerID int) {
-// 	for {
-// 		job, ok := qs.userQueue.Dequeue()
-// 		if !ok {
-// 			continue
-// 		}
-
-// 		log.Printf("Worker %d processing job %s", workerID, job.ID)
-
-// 		var jobData CreateUserJobData
-// 		if err := json.Unmarshal([]byte(job.Data), &jobData); err != nil {
-// 			log.Printf("Worker %d: Error unmarshaling job data: %v", workerID, err)
-// 			qs.userQueue.MarkCompleted(job.ID, "", err)
-// 			continue
-// 		}
-
-// 		masterDB := config.GetMasterDB()
-// 		tenantRepo := repositories.NewTenantRepository(masterDB)
-
-// 		tenant, err := tenantRepo.GetByID(jobData.TenantID)
-// 		if err != nil {
-// 			log.Printf("Worker %d: Failed to find tenant: %v", workerID, err)
-// 			qs.userQueue.MarkCompleted(job.ID, "", err)
-// 			continue
-// 		}
-
-// 		tenantDB, err := config.TenantManager.GetTenantDB(tenant)
-// 		if err != nil {
-// 			log.Printf("Worker %d: Failed to connect to tenant DB: %v", workerID, err)
-// 			qs.userQueue.MarkCompleted(job.ID, "", err)
-// 			continue
-// 		}
-
-// 		user, err := qs.userService.CreateUser(
-// 			tenantDB,
-// 			jobData.TenantID,
-// 			jobData.Request,
-// 			jobData.CurrentUser,
-// 		)
-
-// 		// Store result
-// 		var result string
-// 		if err == nil && user != nil {
-// 			result = fmt.Sprintf("User created successfully: %s (ID: %d)", user.Username, user.ID)
-// 		}
-
-// 		qs.userQueue.MarkCompleted(job.ID, result, err)
-
-// 		if err != nil {
-// 			log.Printf("Worker %d: User creation failed for job %s: %v", workerID, job.ID, err)
-// 		} else {
-// 			log.Printf("Worker %d: User created successfully for job %s: %s", workerID, job.ID, user.Username)
-// 		}
-// 	}
-// }
-
-// func (qs *QueueService) EnqueueUserCreation(tenantID uint, req *CreateUserRequest, currentUser *models.User) (string, error) {
-// 	jobData := CreateUserJobData{
-// 		TenantID:    tenantID,
-// 		Request:     req,
-// 		CurrentUser: currentUser,
-// 	}
-
-// 	jsonData, err := json.Marshal(jobData)
-// 	if err != nil {
-// 		return "", err
-// 	}
-
-// 	jobID := fmt.Sprintf("user_create_%d_%d", tenantID, time.Now().UnixNano())
-
-// 	job := queue.Job{
-// 		ID:        jobID,
-// 		TenantID:  tenantID,
-// 		Data:      string(jsonData),
-// 		CreatedAt: time.Now(),
-// 	}
-
-// 	qs.userQueue.Enqueue(job)
-
-// 	log.Printf("Job enqueued: %s, Queue size: %d", jobID, qs.userQueue.Size())
-
-// 	return jobID, nil
-// }
-
-// func (qs *QueueService) GetQueueStats() map[string]interface{} {
-// 	allJobs := qs.userQueue.GetAllJobs()
-
-// 	return map[string]interface{}{
-// 		"queue_size":     qs.userQueue.Size(),
-// 		"completed_size": qs.userQueue.CompletedSize(),
-// 		"is_empty":       qs.userQueue.IsEmpty(),
-// 		"worker_count":   qs.workerCount,
-// 		"pending_jobs":   len(allJobs["pending"]),
-// 		"completed_jobs": len(allJobs["completed"]),
-// 	}
-// }
-
-// func (qs *QueueService) GetJobStatus(jobID string) (queue.Job, bool) {
-// 	return qs.userQueue.GetJobStatus(jobID)
-// }
-
-// func (qs *QueueService) GetAllJobs() map[string][]queue.Job {
-// 	return qs.userQueue.GetAllJobs()
-// }
-
-//	func (qs *QueueService) CleanupCompletedJobs() int {
-//		return qs.userQueue.CleanupCompletedJobs()
-//	}
 package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-multi-tenant/config"
+	"go-multi-tenant/models"
+	"go-multi-tenant/queue"
+	"log"
+	"slices"
+	"time"
+)
+
+// Job types. Handlers are registered in registerJobHandlers.
+const (
+	JobPrivacyExport   = "privacy.export"
+	JobScheduledTask   = "scheduled_task.run"
+	JobUserImport      = "users.import"
+	JobSendEmail       = "mail.send"
+	JobWebhookDelivery = "webhook.deliver"
+)
+
+var jobQueue *queue.Queue
+
+// InitJobQueue starts the background job workers on the configured broker.
+// Redis must be initialised first for the redis and auto backends.
+func InitJobQueue(cfg *config.Config) error {
+	broker, err := newJobBroker(cfg)
+	if err != nil {
+		return err
+	}
+
+	jobQueue = queue.New(config.GetMasterDB(), broker, queue.Options{
+		Workers:           cfg.QueueWorkers,
+		VisibilityTimeout: cfg.JobVisibilityTimeout,
+	})
+	registerJobHandlers(jobQueue)
+	jobQueue.Start()
+	return nil
+}
+
+func newJobBroker(cfg *config.Config) (queue.Broker, error) {
+	switch cfg.QueueBackend {
+	case "database":
+		return queue.NewDatabaseBroker(config.GetMasterDB()), nil
+	case "redis":
+		if config.RedisClient == nil {
+			return nil, errors.New("QUEUE_BACKEND is redis but Redis is not configured")
+		}
+		return queue.NewRedisBroker(config.Ctx, config.RedisClient), nil
+	case "auto", "":
+		if config.RedisClient != nil && config.RedisClient.Ping(config.Ctx).Err() == nil {
+			return queue.NewRedisBroker(config.Ctx, config.RedisClient), nil
+		}
+		log.Println("Queue: Redis unavailable, using the database broker")
+		return queue.NewDatabaseBroker(config.GetMasterDB()), nil
+	default:
+		return nil, fmt.Errorf("unknown QUEUE_BACKEND %q", cfg.QueueBackend)
+	}
+}
+
+func registerJobHandlers(q *queue.Queue) {
+	queue.Handle(q, JobPrivacyExport, NewPrivacyService().runExportJob)
+	queue.Handle(q, JobScheduledTask, NewScheduledTaskService().runTaskJob)
+	queue.Handle(q, JobUserImport, NewUserImportService().runImportJob)
+	queue.Handle(q, JobSendEmail, NewMailService().runSendJob)
+	queue.Handle(q, JobWebhookDelivery, NewWebhookService().runDeliveryJob)
+}
+
+// ShutdownJobQueue waits for running jobs until ctx expires.
+func ShutdownJobQueue(ctx context.Context) error {
+	if jobQueue == nil {
+		return nil
+	}
+	return jobQueue.Shutdown(ctx)
+}
+
+type QueueService struct{}
+
+func NewQueueService() *QueueService {
+	return &QueueService{}
+}
+
+// Enqueue schedules a job of a registered type. payload is stored as JSON.
+func (s *QueueService) Enqueue(jobType string, payload interface{}, opts queue.EnqueueOptions) (*models.Job, error) {
+	if jobQueue == nil {
+		return nil, errors.New("job queue is not running")
+	}
+	return jobQueue.Enqueue(jobType, payload, opts)
+}
+
+// JobStatus is a job as returned by the API, with its payload and result
+// decoded from their stored JSON.
+type JobStatus struct {
+	models.Job
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+}
+
+func newJobStatus(job models.Job) JobStatus {
+	status := JobStatus{Job: job}
+	if job.Payload != "" {
+		status.Payload = json.RawMessage(job.Payload)
+	}
+	if job.Result != "" {
+		status.Result = json.RawMessage(job.Result)
+	}
+	return status
+}
+
+// JobFilter narrows a job listing. A nil TenantID lists every tenant's jobs.
+type JobFilter struct {
+	TenantID *uint
+	Type     string
+	State    string
+}
+
+var ErrJobNotFound = errors.New("job not found")
+
+var jobStates = []string{models.JobPending, models.JobRunning, models.JobCompleted, models.JobDead, models.JobCancelled}
+
+func (s *QueueService) ListJobs(filter JobFilter, page, pageSize int) ([]JobStatus, int64, error) {
+	query := config.GetMasterDB().Model(&models.Job{})
+	if filter.TenantID != nil {
+		query = query.Where("tenant_id = ?", *filter.TenantID)
+	}
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if filter.State != "" {
+		if !slices.Contains(jobStates, filter.State) {
+			return nil, 0, fmt.Errorf("invalid state %q", filter.State)
+		}
+		query = query.Where("state = ?", filter.State)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var jobs []models.Job
+	if err := query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&jobs).Error; err != nil {
+		return nil, 0, err
+	}
+
+	statuses := make([]JobStatus, len(jobs))
+	for i, job := range jobs {
+		statuses[i] = newJobStatus(job)
+	}
+	return statuses, total, nil
+}
+
+// GetJob loads a job, limited to tenantID's jobs unless it is nil.
+func (s *QueueService) GetJob(tenantID *uint, id uint) (*JobStatus, error) {
+	query := config.GetMasterDB()
+	if tenantID != nil {
+		query = query.Where("tenant_id = ?", *tenantID)
+	}
+	var job models.Job
+	if err := query.First(&job, id).Error; err != nil {
+		return nil, ErrJobNotFound
+	}
+	status := newJobStatus(job)
+	return &status, nil
+}
+
+func (s *QueueService) CancelJob(tenantID *uint, id uint) (*JobStatus, error) {
+	if _, err := s.GetJob(tenantID, id); err != nil {
+		return nil, err
+	}
+	if jobQueue == nil {
+		return nil, errors.New("job queue is not running")
+	}
+	ok, err := jobQueue.Cancel(id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("only pending jobs can be cancelled")
+	}
+	return s.GetJob(tenantID, id)
+}
+
+// RetryJob re-queues a dead-lettered job with a fresh set of attempts.
+func (s *QueueService) RetryJob(tenantID *uint, id uint) (*JobStatus, error) {
+	if _, err := s.GetJob(tenantID, id); err != nil {
+		return nil, err
+	}
+	if jobQueue == nil {
+		return nil, errors.New("job queue is not running")
+	}
+	ok, err := jobQueue.Retry(id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("only dead-lettered jobs can be retried")
+	}
+	return s.GetJob(tenantID, id)
+}
+
+// GetQueueStats reports queue depth, throughput and workers for operators.
+func (s *QueueService) GetQueueStats() (*queue.Stats, error) {
+	if jobQueue == nil {
+		return nil, errors.New("job queue is not running")
+	}
+	return jobQueue.Stats()
+}
+
+// PurgeFinishedJobs deletes completed and cancelled jobs that finished before
+// cutoff. Dead-lettered jobs are kept for inspection and retry.
+func (s *QueueService) PurgeFinishedJobs(cutoff time.Time) (int64, error) {
+	res := config.GetMasterDB().
+		Where("state IN ? AND finished_at < ?", []string{models.JobCompleted, models.JobCancelled}, cutoff).
+		Delete(&models.Job{})
+	return res.RowsAffected, res.Error
+}
@@ -46,5 +46,9 @@ func (s *RoleService) UpdateRolePermissions(tenantDB *gorm.DB, roleID uint, perm
 		return errors.New("cannot modify system roles")
 	}
 
-	return repo.AssignPermissions(roleID, permissionIDs)
+	if err := repo.AssignPermissions(roleID, permissionIDs); err != nil {
+		return err
+	}
+	publishEvent(RoleChanged{TenantID: role.TenantID, RoleID: role.ID})
+	return nil
 }
@@ -0,0 +1,365 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-multi-tenant/config"
+	"go-multi-tenant/models"
+	"go-multi-tenant/repositories"
+	"go-multi-tenant/utils"
+	"log"
+	"os"
+	"regexp"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultSandboxTTL = 14 * 24 * time.Hour
+	maxSandboxTTLDays = 30
+)
+
+// Sandbox names are also used for their dedicated database, so they are kept
+// to characters every driver accepts unquoted.
+var sandboxNameRegex = regexp.MustCompile(`^[a-z0-9-]{1,40}$`)
+
+type SandboxService struct{}
+
+func NewSandboxService() *SandboxService {
+	return &SandboxService{}
+}
+
+type CreateSandboxRequest struct {
+	Name          string              `json:"name"`
+	DatabaseType  models.DatabaseType `json:"database_type"`
+	TTLDays       int                 `json:"ttl_days"`
+	AdminUsername string              `json:"admin_username"`
+	AdminEmail    string              `json:"admin_email"`
+	AdminPassword string              `json:"admin_password"`
+}
+
+// CreateSandbox clones a tenant's data into a new sandbox tenant. Copied users get
+// anonymized usernames, emails and unusable passwords; the requester signs in
+// with the admin account created from the request. The source's plan decides
+// how many sandboxes it may keep.
+func (s *SandboxService) CreateSandbox(sourceTenantID uint, req *CreateSandboxRequest) (*models.Tenant, error) {
+	masterDB := config.GetMasterDB()
+
+	var source models.Tenant
+	if err := masterDB.Preload("Plan").First(&source, sourceTenantID).Error; err != nil {
+		return nil, errors.New("tenant not found")
+	}
+	if source.IsSandbox {
+		return nil, errors.New("cannot create a sandbox from another sandbox")
+	}
+	if source.GetActualDBName() == "master_db" {
+		return nil, errors.New("the master tenant cannot be cloned")
+	}
+	if req.AdminEmail == "" || req.AdminPassword == "" {
+		return nil, errors.New("admin_email and admin_password are required")
+	}
+	if !isValidEmail(req.AdminEmail) {
+		return nil, errors.New("invalid email format")
+	}
+	if _, err := repositories.NewTenantRepository(masterDB).GetGlobalIdentity(req.AdminEmail); err == nil {
+		return nil, fmt.Errorf("email %s is already registered globally", req.AdminEmail)
+	}
+
+	if source.Plan == nil || source.Plan.MaxSandboxes == 0 {
+		return nil, errors.New("the current plan does not include sandboxes")
+	}
+	var existing int64
+	if err := masterDB.Model(&models.Tenant{}).
+		Where("is_sandbox = ? AND sandbox_source_id = ?", true, source.ID).
+		Count(&existing).Error; err != nil {
+		return nil, err
+	}
+	if existing >= int64(source.Plan.MaxSandboxes) {
+		return nil, fmt.Errorf("the current plan allows %d sandbox(es); delete one first", source.Plan.MaxSandboxes)
+	}
+
+	name := req.Name
+	if name == "" {
+		name = fmt.Sprintf("sandbox-%d-%d", source.ID, time.Now().Unix())
+	}
+	if !sandboxNameRegex.MatchString(name) {
+		return nil, errors.New("name must be 1-40 lowercase letters, digits or hyphens")
+	}
+	dbType := req.DatabaseType
+	if dbType == "" {
+		dbType = models.SharedDB
+	}
+	if dbType != models.SharedDB && dbType != models.DedicatedDB {
+		return nil, fmt.Errorf("invalid database_type %q", dbType)
+	}
+	// A dedicated database is a server-side cost the tenant only pays for itself.
+	if dbType == models.DedicatedDB && source.DatabaseType != models.DedicatedDB {
+		return nil, errors.New("only tenants with a dedicated database can create dedicated sandboxes")
+	}
+	if source.DBServerID != nil && dbType != models.DedicatedDB {
+		return nil, errors.New("sandboxes of region-pinned tenants must use a dedicated database")
+	}
+	if req.TTLDays < 0 || req.TTLDays > maxSandboxTTLDays {
+		return nil, fmt.Errorf("ttl_days must be between 1 and %d, or 0 for the default", maxSandboxTTLDays)
+	}
+	ttl := defaultSandboxTTL
+	if req.TTLDays > 0 {
+		ttl = time.Duration(req.TTLDays) * 24 * time.Hour
+	}
+	expiresAt := time.Now().Add(ttl)
+
+	sourceDB, err := config.TenantManager.GetTenantDB(&source)
+	if err != nil {
+		return nil, err
+	}
+	data, err := exportTenantData(sourceDB, source.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source tenant: %w", err)
+	}
+
+	apiKey, err := utils.GenerateSecureKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate api key: %v", err)
+	}
+	sandbox := &models.Tenant{
+		Name:             name,
+		DatabaseType:     dbType,
+		DBName:           tenantDBName(name, dbType),
+		IsActive:         true,
+		APIKey:           apiKey,
+		PlanID:           source.PlanID,
+		PlanExpiry:       source.PlanExpiry,
+		IsSandbox:        true,
+		SandboxSourceID:  &source.ID,
+		SandboxExpiresAt: &expiresAt,
+	}
+	// A dedicated sandbox holds a copy of customer data, so it stays on the source's server.
+	if dbType == models.DedicatedDB && source.DBServerID != nil {
+		sandbox.DBServerID = source.DBServerID
+		sandbox.DataRegion = source.DataRegion
+	}
+	if err := masterDB.Create(sandbox).Error; err != nil {
+		return nil, err
+	}
+
+	if dbType == models.DedicatedDB {
+		if err := config.TenantManager.CreateDedicatedDatabase(sandbox); err != nil {
+			masterDB.Unscoped().Delete(sandbox)
+			return nil, err
+		}
+	}
+
+	if err := anonymizeBackupUsers(data, sandbox.ID); err != nil {
+		s.DeleteSandbox(sandbox.ID)
+		return nil, err
+	}
+
+	sandboxDB, err := config.TenantManager.GetTenantDB(sandbox)
+	if err != nil {
+		s.DeleteSandbox(sandbox.ID)
+		return nil, err
+	}
+
+	err = sandboxDB.Transaction(func(tx *gorm.DB) error {
+		if err := importTenantData(tx, sandbox.ID, data, false); err != nil {
+			return err
+		}
+
+		var adminRole models.Role
+		if err := tx.Where("tenant_id = ? AND name = ?", sandbox.ID, tenantAdminRoleName).First(&adminRole).Error; err != nil {
+			return errors.New("source tenant has no Tenant Admin role")
+		}
+
+		hashedPassword, _ := utils.HashPassword(req.AdminPassword)
+		admin := &models.User{
+			TenantID: sandbox.ID,
+			Username: req.AdminUsername,
+			Email:    req.AdminEmail,
+			Password: hashedPassword,
+			IsActive: true,
+		}
+		if err := tx.Create(admin).Error; err != nil {
+			return err
+		}
+		return tx.Model(admin).Association("Roles").Append(&adminRole)
+	})
+	if err != nil {
+		s.DeleteSandbox(sandbox.ID)
+		return nil, fmt.Errorf("failed to clone tenant data: %w", err)
+	}
+
+	if err := masterDB.Create(&models.GlobalIdentity{Email: req.AdminEmail, TenantID: sandbox.ID}).Error; err != nil {
+		s.DeleteSandbox(sandbox.ID)
+		return nil, err
+	}
+	if err := NewSettingsService().CopySettings(source.ID, sandbox.ID); err != nil {
+		s.DeleteSandbox(sandbox.ID)
+		return nil, err
+	}
+
+	return sandbox, nil
+}
+
+func anonymizeBackupUsers(data *tenantBackupData, sandboxID uint) error {
+	// Notifications quote names and emails of the source tenant.
+	data.Notifications = nil
+
+	for i := range data.Users {
+		randomPassword, err := utils.GenerateSecureKey()
+		if err != nil {
+			return err
+		}
+		hashed, err := utils.HashPassword(randomPassword)
+		if err != nil {
+			return err
+		}
+		data.Users[i].Username = fmt.Sprintf("user%d", data.Users[i].ID)
+		data.Users[i].Email = fmt.Sprintf("user%d@sandbox-%d.invalid", data.Users[i].ID, sandboxID)
+		data.Users[i].Password = hashed
+	}
+	return nil
+}
+
+func (s *SandboxService) ListSandboxes(sourceTenantID uint) ([]models.Tenant, error) {
+	var sandboxes []models.Tenant
+	err := config.GetMasterDB().
+		Where("is_sandbox = ? AND sandbox_source_id = ?", true, sourceTenantID).
+		Find(&sandboxes).Error
+	return sandboxes, err
+}
+
+// GetSandbox returns a sandbox only if it was cloned from sourceTenantID.
+func (s *SandboxService) GetSandbox(sourceTenantID, sandboxID uint) (*models.Tenant, error) {
+	var sandbox models.Tenant
+	err := config.GetMasterDB().
+		Where("id = ? AND is_sandbox = ? AND sandbox_source_id = ?", sandboxID, true, sourceTenantID).
+		First(&sandbox).Error
+	if err != nil {
+		return nil, errors.New("sandbox not found")
+	}
+	return &sandbox, nil
+}
+
+// DeleteSandbox removes a sandbox tenant together with all of its data.
+func (s *SandboxService) DeleteSandbox(sandboxID uint) error {
+	masterDB := config.GetMasterDB()
+
+	var sandbox models.Tenant
+	if err := masterDB.Unscoped().First(&sandbox, sandboxID).Error; err != nil {
+		return err
+	}
+	if !sandbox.IsSandbox {
+		return errors.New("tenant is not a sandbox")
+	}
+
+	if sandbox.DatabaseType == models.DedicatedDB {
+		if err := config.TenantManager.DropDedicatedDatabase(&sandbox); err != nil {
+			return err
+		}
+	} else {
+		tenantDB, err := config.TenantManager.GetTenantDB(&sandbox)
+		if err != nil {
+			return err
+		}
+		if err := tenantDB.Transaction(func(tx *gorm.DB) error {
+			return wipeTenantData(tx, sandbox.ID)
+		}); err != nil {
+			return err
+		}
+		config.TenantManager.Evict(sandbox.ID)
+	}
+
+	var exports []models.DataExport
+	if err := masterDB.Where("tenant_id = ?", sandbox.ID).Find(&exports).Error; err != nil {
+		return err
+	}
+	var domains []models.TenantDomain
+	if err := masterDB.Where("tenant_id = ?", sandbox.ID).Find(&domains).Error; err != nil {
+		return err
+	}
+
+	err := masterDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("tenant_id = ?", sandbox.ID).Delete(&models.GlobalIdentity{}).Error; err != nil {
+			return err
+		}
+		if err := NewSettingsService().DeleteSettings(tx, sandbox.ID); err != nil {
+			return err
+		}
+		if err := tx.Where("tenant_id = ?", sandbox.ID).Delete(&models.ScheduledTaskRun{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("tenant_id = ?", sandbox.ID).Delete(&models.ScheduledTask{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("tenant_id = ?", sandbox.ID).Delete(&models.EmailTemplate{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("tenant_id = ?", sandbox.ID).Delete(&models.OutboxEmail{}).Error; err != nil {
+			return err
+		}
+		deliveries := tx.Model(&models.WebhookDelivery{}).Select("id").Where("tenant_id = ?", sandbox.ID)
+		if err := tx.Where("delivery_id IN (?)", deliveries).Delete(&models.WebhookAttempt{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("tenant_id = ?", sandbox.ID).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("tenant_id = ?", sandbox.ID).Delete(&models.WebhookSubscription{}).Error; err != nil {
+			return err
+		}
+		invoices := tx.Model(&models.Invoice{}).Select("id").Where("tenant_id = ?", sandbox.ID)
+		if err := tx.Where("invoice_id IN (?)", invoices).Delete(&models.InvoiceLine{}).Error; err != nil {
+			return err
+		}
+		for _, model := range []interface{}{
+			&models.Payment{}, &models.Invoice{}, &models.CouponRedemption{},
+			&models.TenantUsage{}, &models.TenantDomain{}, &models.DataExport{},
+			&models.UserImport{}, &models.Job{}, &models.StreamTicket{},
+		} {
+			if err := tx.Where("tenant_id = ?", sandbox.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Delete(&sandbox).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, export := range exports {
+		if err := os.Remove(export.FilePath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove export file %s: %v", export.FilePath, err)
+		}
+	}
+
+	cacheService := NewCacheService()
+	_ = cacheService.Delete(fmt.Sprintf("tenant_info:%d", sandbox.ID))
+	for _, domain := range domains {
+		_ = cacheService.Delete(fmt.Sprintf("tenant_host:%s", domain.Domain))
+	}
+	_ = cacheService.ClearPattern(fmt.Sprintf("user_perms:%d:*", sandbox.ID))
+	clearUserCache(sandbox.ID)
+	return nil
+}
+
+// ExpireSandboxes removes sandboxes past their expiry and returns how many were removed.
+func (s *SandboxService) ExpireSandboxes() (int, error) {
+	var expired []models.Tenant
+	if err := config.GetMasterDB().
+		Where("is_sandbox = ? AND sandbox_expires_at IS NOT NULL AND sandbox_expires_at < ?", true, time.Now()).
+		Find(&expired).Error; err != nil {
+		return 0, fmt.Errorf("error fetching expired sandboxes: %w", err)
+	}
+
+	removed := 0
+	for _, sandbox := range expired {
+		if err := s.DeleteSandbox(sandbox.ID); err != nil {
+			log.Printf("Failed to remove expired sandbox %s: %v", sandbox.Name, err)
+			continue
+		}
+		log.Printf("Expired sandbox %s removed", sandbox.Name)
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,756 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-multi-tenant/config"
+	"go-multi-tenant/cron"
+	"go-multi-tenant/models"
+	"go-multi-tenant/queue"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	maxScheduledTasks  = 25
+	scheduledTaskTries = 3
+	maxTaskRecipients  = 10
+	// minTaskInterval is the shortest time allowed between two runs of a task.
+	minTaskInterval = time.Hour
+	// maxManualRunsPerHour caps RunNow across a workspace's tasks.
+	maxManualRunsPerHour = 10
+)
+
+var (
+	ErrScheduledTaskNotFound = errors.New("scheduled task not found")
+	ErrTaskRunLimited        = fmt.Errorf("a workspace can run tasks by hand at most %d times an hour", maxManualRunsPerHour)
+)
+
+// TaskReport is what a scheduled action produced, for delivery to the task's
+// creator and recipients.
+type TaskReport struct {
+	TenantID    uint       `json:"tenant_id"`
+	TaskID      uint       `json:"task_id"`
+	TaskName    string     `json:"task_name"`
+	Action      string     `json:"action"`
+	Title       string     `json:"title"`
+	Summary     string     `json:"summary"`
+	Columns     []string   `json:"columns,omitempty"`
+	Rows        [][]string `json:"rows,omitempty"`
+	UserID      uint       `json:"user_id"`
+	Recipients  []string   `json:"recipients,omitempty"`
+	GeneratedAt time.Time  `json:"generated_at"`
+}
+
+var (
+	taskReportHandlers   []func(TaskReport)
+	taskReportHandlersMu sync.RWMutex
+)
+
+// OnTaskReport registers a handler called with every report a scheduled task produces.
+func OnTaskReport(handler func(TaskReport)) {
+	taskReportHandlersMu.Lock()
+	defer taskReportHandlersMu.Unlock()
+	taskReportHandlers = append(taskReportHandlers, handler)
+}
+
+func emitTaskReport(report TaskReport) {
+	taskReportHandlersMu.RLock()
+	defer taskReportHandlersMu.RUnlock()
+	for _, handler := range taskReportHandlers {
+		handler(report)
+	}
+}
+
+// taskContext is what an action runs against: the tenant's scoped database.
+type taskContext struct {
+	db     *gorm.DB
+	tenant *models.Tenant
+}
+
+// taskAction is an entry in the fixed catalogue of schedulable actions.
+// permission is what the task's creator and recipients must hold to see its
+// output. newParams returns the action's parameters with their defaults set;
+// run returns nil when there is nothing to report.
+type taskAction struct {
+	description string
+	permission  string
+	newParams   func() taskParams
+	run         func(tc *taskContext, params taskParams) (*TaskReport, error)
+}
+
+type taskParams interface {
+	validate() error
+}
+
+type noParams struct{}
+
+func (noParams) validate() error { return nil }
+
+type stockParams struct {
+	Threshold int `json:"threshold"` // 0 uses the tenant's low-stock setting
+}
+
+func (p *stockParams) validate() error {
+	if p.Threshold < 0 {
+		return errors.New("threshold cannot be negative")
+	}
+	return nil
+}
+
+type purchaseReminderParams struct {
+	OlderThanDays int `json:"older_than_days"`
+}
+
+func (p *purchaseReminderParams) validate() error {
+	if p.OlderThanDays < 0 || p.OlderThanDays > 365 {
+		return errors.New("older_than_days must be between 0 and 365")
+	}
+	return nil
+}
+
+var taskActions = map[string]taskAction{
+	"inventory_report": {
+		description: "Report of current stock levels for every product",
+		permission:  "inventory:read",
+		newParams:   func() taskParams { return &noParams{} },
+		run:         runInventoryReport,
+	},
+	"low_stock_report": {
+		description: "Report of items at or below the low-stock threshold, sent even when none are",
+		permission:  "inventory:read",
+		newParams:   func() taskParams { return &stockParams{} },
+		run:         runLowStockReport,
+	},
+	"stock_check": {
+		description: "Raise a low-stock alert when any item is at or below the threshold",
+		permission:  "inventory:read",
+		newParams:   func() taskParams { return &stockParams{} },
+		run:         runStockCheck,
+	},
+	"purchase_order_reminder": {
+		description: "Remind about purchase orders still awaiting approval or receipt",
+		permission:  "purchase:view",
+		newParams:   func() taskParams { return &purchaseReminderParams{OlderThanDays: 3} },
+		run:         runPurchaseOrderReminder,
+	},
+}
+
+func runInventoryReport(tc *taskContext, _ taskParams) (*TaskReport, error) {
+	var items []models.Inventory
+	if err := tc.db.Preload("Product").Order("product_id").Find(&items).Error; err != nil {
+		return nil, err
+	}
+	return &TaskReport{
+		Title:   "Inventory report",
+		Summary: fmt.Sprintf("%d products in stock records", len(items)),
+		Columns: []string{"Product", "SKU", "Quantity", "Location"},
+		Rows:    inventoryRows(items),
+	}, nil
+}
+
+func runLowStockReport(tc *taskContext, params taskParams) (*TaskReport, error) {
+	items, err := NewInventoryService().GetLowStockAlerts(tc.db, tc.tenant.ID, params.(*stockParams).Threshold)
+	if err != nil {
+		return nil, err
+	}
+	summary := "No items are low on stock"
+	if len(items) > 0 {
+		summary = fmt.Sprintf("%d items are at or below the low-stock threshold", len(items))
+	}
+	return &TaskReport{
+		Title:   "Low stock report",
+		Summary: summary,
+		Columns: []string{"Product", "SKU", "Quantity", "Location"},
+		Rows:    inventoryRows(items),
+	}, nil
+}
+
+func runStockCheck(tc *taskContext, params taskParams) (*TaskReport, error) {
+	items, err := NewInventoryService().GetLowStockAlerts(tc.db, tc.tenant.ID, params.(*stockParams).Threshold)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+	emitLowStock(LowStockAlert{TenantID: tc.tenant.ID, TenantName: tc.tenant.Name, Items: items})
+	return nil, nil
+}
+
+func runPurchaseOrderReminder(tc *taskContext, params taskParams) (*TaskReport, error) {
+	days := params.(*purchaseReminderParams).OlderThanDays
+	var orders []models.PurchaseOrder
+	err := tc.db.Preload("Product").
+		Where("status IN ? AND created_at <= ?", []string{models.POPending, models.PODispatched}, time.Now().AddDate(0, 0, -days)).
+		Order("created_at").Find(&orders).Error
+	if err != nil || len(orders) == 0 {
+		return nil, err
+	}
+
+	rows := make([][]string, len(orders))
+	for i, order := range orders {
+		product := ""
+		if order.Product != nil {
+			product = order.Product.Name
+		}
+		rows[i] = []string{strconv.Itoa(int(order.ID)), product, strconv.Itoa(order.Quantity), order.Status, order.CreatedAt.Format("2006-01-02")}
+	}
+	return &TaskReport{
+		Title:   "Purchase orders awaiting action",
+		Summary: fmt.Sprintf("%d purchase orders have been waiting for more than %d days", len(orders), days),
+		Columns: []string{"Order", "Product", "Quantity", "Status", "Created"},
+		Rows:    rows,
+	}, nil
+}
+
+func inventoryRows(items []models.Inventory) [][]string {
+	rows := make([][]string, len(items))
+	for i, item := range items {
+		name, sku := "", ""
+		if item.Product != nil {
+			name, sku = item.Product.Name, item.Product.SKU
+		}
+		rows[i] = []string{name, sku, strconv.Itoa(item.Quantity), item.Location}
+	}
+	return rows
+}
+
+type ScheduledTaskService struct{}
+
+func NewScheduledTaskService() *ScheduledTaskService {
+	return &ScheduledTaskService{}
+}
+
+type TaskActionInfo struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Permission  string     `json:"permission"`
+	Params      taskParams `json:"params"` // Defaults
+}
+
+// Actions lists the catalogue of schedulable actions.
+func (s *ScheduledTaskService) Actions() []TaskActionInfo {
+	infos := make([]TaskActionInfo, 0, len(taskActions))
+	for name, action := range taskActions {
+		infos = append(infos, TaskActionInfo{Name: name, Description: action.description, Permission: action.permission, Params: action.newParams()})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+type ScheduledTaskRequest struct {
+	Name       string          `json:"name" binding:"required"`
+	Action     string          `json:"action" binding:"required"`
+	Params     json.RawMessage `json:"params"`
+	Cron       string          `json:"cron" binding:"required"`
+	Timezone   string          `json:"timezone"` // Defaults to the workspace timezone
+	Recipients []string        `json:"recipients"`
+}
+
+// ScheduledTaskView is a task as returned by the API.
+type ScheduledTaskView struct {
+	models.ScheduledTask
+	Params     json.RawMessage `json:"params"`
+	Recipients []string        `json:"recipients"`
+}
+
+func newTaskView(task models.ScheduledTask) ScheduledTaskView {
+	view := ScheduledTaskView{ScheduledTask: task, Params: json.RawMessage("{}"), Recipients: []string{}}
+	if task.Params != "" {
+		view.Params = json.RawMessage(task.Params)
+	}
+	_ = json.Unmarshal([]byte(task.Recipients), &view.Recipients)
+	return view
+}
+
+// apply validates req and copies it onto task. The task's creator and every
+// recipient must hold the action's permission.
+func (req *ScheduledTaskRequest) apply(tenantID uint, task *models.ScheduledTask) error {
+	action, ok := taskActions[req.Action]
+	if !ok {
+		return fmt.Errorf("unknown action %q", req.Action)
+	}
+	params := action.newParams()
+	if len(req.Params) > 0 && string(req.Params) != "null" {
+		dec := json.NewDecoder(bytes.NewReader(req.Params))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(params); err != nil {
+			return fmt.Errorf("invalid params for %s: %w", req.Action, err)
+		}
+	}
+	if err := params.validate(); err != nil {
+		return err
+	}
+	allowed, err := holdsPermission(tenantID, task.CreatedBy, action.permission)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fmt.Errorf("the %s action needs the %s permission", req.Action, action.permission)
+	}
+	schedule, err := cron.Parse(req.Cron)
+	if err != nil {
+		return err
+	}
+	if err := checkTaskInterval(schedule, time.Now()); err != nil {
+		return err
+	}
+	timezone := req.Timezone
+	if timezone == "" {
+		timezone = NewSettingsService().Location(tenantID).String()
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return fmt.Errorf("invalid timezone %q", timezone)
+	}
+	if len(req.Recipients) > maxTaskRecipients {
+		return fmt.Errorf("at most %d recipients are allowed", maxTaskRecipients)
+	}
+	members, err := workspaceEmails(tenantID, action.permission, req.Recipients)
+	if err != nil {
+		return err
+	}
+	for _, email := range req.Recipients {
+		if !slices.Contains(members, strings.ToLower(strings.TrimSpace(email))) {
+			return fmt.Errorf("recipient %q is not an active user of this workspace with the %s permission", email, action.permission)
+		}
+	}
+
+	paramsJSON, _ := json.Marshal(params)
+	recipients := req.Recipients
+	if recipients == nil {
+		recipients = []string{}
+	}
+	recipientsJSON, _ := json.Marshal(recipients)
+
+	task.Name = req.Name
+	task.Action = req.Action
+	task.Params = string(paramsJSON)
+	task.CronExpr = req.Cron
+	task.Timezone = timezone
+	task.Recipients = string(recipientsJSON)
+	return nil
+}
+
+// checkTaskInterval rejects schedules that fire more often than
+// minTaskInterval. The gaps between the next few hundred runs are enough: a
+// schedule that fires too often does so within its first matching hour.
+func checkTaskInterval(schedule cron.Schedule, now time.Time) error {
+	prev := schedule.Next(now.UTC())
+	for i := 0; i < 500 && !prev.IsZero(); i++ {
+		next := schedule.Next(prev)
+		if next.IsZero() {
+			break
+		}
+		if next.Sub(prev) < minTaskInterval {
+			return errors.New("a task can run at most once an hour")
+		}
+		prev = next
+	}
+	return nil
+}
+
+// workspaceEmails returns which of emails belong to the tenant's active users
+// holding permission, lowercased.
+func workspaceEmails(tenantID uint, permission string, emails []string) ([]string, error) {
+	if len(emails) == 0 {
+		return nil, nil
+	}
+	lowered := make([]string, len(emails))
+	for i, email := range emails {
+		lowered[i] = strings.ToLower(strings.TrimSpace(email))
+	}
+	tenantDB, err := openTenantDB(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err := tenantDB.Preload("Roles.Permissions").
+		Where("is_active = ? AND LOWER(email) IN ?", true, lowered).
+		Find(&users).Error; err != nil {
+		return nil, err
+	}
+	var members []string
+	for _, user := range users {
+		if user.HasPermission(permission) {
+			members = append(members, strings.ToLower(user.Email))
+		}
+	}
+	return members, nil
+}
+
+// holdsPermission reports whether the tenant's user is active and holds permission.
+func holdsPermission(tenantID, userID uint, permission string) (bool, error) {
+	tenantDB, err := openTenantDB(tenantID)
+	if err != nil {
+		return false, err
+	}
+	var user models.User
+	err = tenantDB.Preload("Roles.Permissions").Where("is_active = ?", true).First(&user, userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return user.HasPermission(permission), nil
+}
+
+// nextRun is the task's next slot after now in its own timezone.
+func nextRun(task *models.ScheduledTask, now time.Time) (*time.Time, error) {
+	schedule, err := cron.Parse(task.CronExpr)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(task.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	next := schedule.Next(now.In(loc))
+	if next.IsZero() {
+		return nil, errors.New("schedule never fires")
+	}
+	next = next.UTC()
+	return &next, nil
+}
+
+func (s *ScheduledTaskService) CreateTask(tenantID, userID uint, req *ScheduledTaskRequest) (*ScheduledTaskView, error) {
+	masterDB := config.GetMasterDB()
+
+	var count int64
+	masterDB.Model(&models.ScheduledTask{}).Where("tenant_id = ?", tenantID).Count(&count)
+	if count >= maxScheduledTasks {
+		return nil, fmt.Errorf("a workspace can have at most %d scheduled tasks", maxScheduledTasks)
+	}
+
+	task := &models.ScheduledTask{TenantID: tenantID, CreatedBy: userID, Status: models.TaskActive}
+	if err := req.apply(tenantID, task); err != nil {
+		return nil, err
+	}
+	next, err := nextRun(task, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	task.NextRunAt = next
+
+	if err := masterDB.Create(task).Error; err != nil {
+		return nil, err
+	}
+	view := newTaskView(*task)
+	return &view, nil
+}
+
+func (s *ScheduledTaskService) ListTasks(tenantID uint) ([]ScheduledTaskView, error) {
+	var tasks []models.ScheduledTask
+	if err := config.GetMasterDB().Where("tenant_id = ?", tenantID).Order("id").Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	views := make([]ScheduledTaskView, len(tasks))
+	for i, task := range tasks {
+		views[i] = newTaskView(task)
+	}
+	return views, nil
+}
+
+func (s *ScheduledTaskService) getTask(tenantID, id uint) (*models.ScheduledTask, error) {
+	var task models.ScheduledTask
+	if err := config.GetMasterDB().Where("tenant_id = ?", tenantID).First(&task, id).Error; err != nil {
+		return nil, ErrScheduledTaskNotFound
+	}
+	return &task, nil
+}
+
+func (s *ScheduledTaskService) GetTask(tenantID, id uint) (*ScheduledTaskView, error) {
+	task, err := s.getTask(tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	view := newTaskView(*task)
+	return &view, nil
+}
+
+func (s *ScheduledTaskService) UpdateTask(tenantID, id uint, req *ScheduledTaskRequest) (*ScheduledTaskView, error) {
+	task, err := s.getTask(tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.apply(tenantID, task); err != nil {
+		return nil, err
+	}
+	if task.Status == models.TaskActive {
+		if task.NextRunAt, err = nextRun(task, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := config.GetMasterDB().Model(&models.ScheduledTask{}).Where("id = ?", task.ID).Updates(map[string]interface{}{
+		"name":        task.Name,
+		"action":      task.Action,
+		"params":      task.Params,
+		"cron_expr":   task.CronExpr,
+		"timezone":    task.Timezone,
+		"recipients":  task.Recipients,
+		"next_run_at": task.NextRunAt,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return s.GetTask(tenantID, id)
+}
+
+func (s *ScheduledTaskService) DeleteTask(tenantID, id uint) error {
+	task, err := s.getTask(tenantID, id)
+	if err != nil {
+		return err
+	}
+	return config.GetMasterDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("task_id = ?", task.ID).Delete(&models.ScheduledTaskRun{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(task).Error
+	})
+}
+
+func (s *ScheduledTaskService) PauseTask(tenantID, id uint) (*ScheduledTaskView, error) {
+	task, err := s.getTask(tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if task.Status == models.TaskPaused {
+		return nil, errors.New("task is already paused")
+	}
+	if err := config.GetMasterDB().Model(task).Updates(map[string]interface{}{
+		"status":      models.TaskPaused,
+		"next_run_at": nil,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return s.GetTask(tenantID, id)
+}
+
+// ResumeTask restarts a paused task from its next slot; runs missed while
+// paused are not made up.
+func (s *ScheduledTaskService) ResumeTask(tenantID, id uint) (*ScheduledTaskView, error) {
+	task, err := s.getTask(tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if task.Status == models.TaskActive {
+		return nil, errors.New("task is not paused")
+	}
+	next, err := nextRun(task, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if err := config.GetMasterDB().Model(task).Updates(map[string]interface{}{
+		"status":      models.TaskActive,
+		"next_run_at": next,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return s.GetTask(tenantID, id)
+}
+
+// RunNow queues an immediate run outside the schedule, up to
+// maxManualRunsPerHour per workspace.
+func (s *ScheduledTaskService) RunNow(tenantID, id, userID uint) (*models.ScheduledTaskRun, error) {
+	task, err := s.getTask(tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	var recent int64
+	if err := config.GetMasterDB().Model(&models.ScheduledTaskRun{}).
+		Where("tenant_id = ? AND manual = ? AND created_at > ?", tenantID, true, time.Now().Add(-time.Hour)).
+		Count(&recent).Error; err != nil {
+		return nil, err
+	}
+	if recent >= maxManualRunsPerHour {
+		return nil, ErrTaskRunLimited
+	}
+	return s.enqueueRun(task, time.Now(), true, userID)
+}
+
+func (s *ScheduledTaskService) ListRuns(tenantID, taskID uint, page, pageSize int) ([]models.ScheduledTaskRun, int64, error) {
+	if _, err := s.getTask(tenantID, taskID); err != nil {
+		return nil, 0, err
+	}
+	query := config.GetMasterDB().Model(&models.ScheduledTaskRun{}).Where("task_id = ?", taskID)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var runs []models.ScheduledTaskRun
+	err := query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&runs).Error
+	return runs, total, err
+}
+
+// DispatchDue queues a run for every active task whose slot has come. Each
+// task's next slot is claimed with a conditional update, so a slot is queued
+// once even if two dispatchers overlap.
+func (s *ScheduledTaskService) DispatchDue(ctx context.Context) (string, error) {
+	masterDB := config.GetMasterDB()
+	now := time.Now()
+
+	var due []models.ScheduledTask
+	if err := masterDB.Select("scheduled_tasks.*").
+		Joins("JOIN tenants ON tenants.id = scheduled_tasks.tenant_id AND tenants.deleted_at IS NULL").
+		Where("scheduled_tasks.status = ? AND scheduled_tasks.next_run_at <= ? AND tenants.is_active = ?", models.TaskActive, now, true).
+		Find(&due).Error; err != nil {
+		return "", err
+	}
+
+	queued := 0
+	for i := range due {
+		task := &due[i]
+		slot := *task.NextRunAt
+		next, err := nextRun(task, now)
+		if err != nil {
+			log.Printf("Scheduled task %d has an invalid schedule, pausing it: %v", task.ID, err)
+			masterDB.Model(task).Updates(map[string]interface{}{"status": models.TaskPaused, "next_run_at": nil})
+			continue
+		}
+
+		res := masterDB.Model(&models.ScheduledTask{}).
+			Where("id = ? AND status = ? AND next_run_at = ?", task.ID, models.TaskActive, slot).
+			Updates(map[string]interface{}{"next_run_at": next, "last_run_at": now})
+		if res.Error != nil || res.RowsAffected == 0 {
+			continue
+		}
+		if _, err := s.enqueueRun(task, slot, false, task.CreatedBy); err != nil {
+			log.Printf("Scheduled task %d: failed to queue run: %v", task.ID, err)
+			continue
+		}
+		queued++
+	}
+	return fmt.Sprintf("queued %d of %d due tasks", queued, len(due)), nil
+}
+
+type scheduledTaskJob struct {
+	RunID uint `json:"run_id"`
+}
+
+func (s *ScheduledTaskService) enqueueRun(task *models.ScheduledTask, slot time.Time, manual bool, userID uint) (*models.ScheduledTaskRun, error) {
+	masterDB := config.GetMasterDB()
+
+	run := &models.ScheduledTaskRun{
+		TaskID:       task.ID,
+		TenantID:     task.TenantID,
+		ScheduledFor: slot,
+		Manual:       manual,
+		Status:       models.JobPending,
+	}
+	if err := masterDB.Create(run).Error; err != nil {
+		return nil, err
+	}
+
+	job, err := NewQueueService().Enqueue(JobScheduledTask, scheduledTaskJob{RunID: run.ID}, queue.EnqueueOptions{
+		TenantID:    task.TenantID,
+		CreatedBy:   userID,
+		MaxAttempts: scheduledTaskTries,
+	})
+	if err != nil {
+		masterDB.Model(run).Updates(map[string]interface{}{"status": models.JobDead, "error": err.Error()})
+		return nil, err
+	}
+	masterDB.Model(run).Update("job_id", job.ID)
+	run.JobID = job.ID
+	return run, nil
+}
+
+// runTaskJob executes one run of a scheduled task.
+func (s *ScheduledTaskService) runTaskJob(ctx context.Context, job *models.Job, payload scheduledTaskJob) (interface{}, error) {
+	masterDB := config.GetMasterDB()
+
+	var run models.ScheduledTaskRun
+	if err := masterDB.First(&run, payload.RunID).Error; err != nil {
+		return nil, queue.Permanent(fmt.Errorf("run %d not found", payload.RunID))
+	}
+	var task models.ScheduledTask
+	if err := masterDB.First(&task, run.TaskID).Error; err != nil {
+		return nil, queue.Permanent(fmt.Errorf("task %d was deleted", run.TaskID))
+	}
+	masterDB.Model(&run).Update("status", models.JobRunning)
+
+	report, err := s.execute(ctx, &task)
+	now := time.Now()
+	if err != nil {
+		updates := map[string]interface{}{"status": models.JobPending, "error": err.Error()}
+		if job.Attempts >= job.MaxAttempts || queue.IsPermanent(err) {
+			updates["status"] = models.JobDead
+			updates["finished_at"] = &now
+		}
+		masterDB.Model(&run).Updates(updates)
+		return nil, err
+	}
+
+	summary := "nothing to report"
+	if report != nil {
+		summary = report.Summary
+		recipients := []string{}
+		_ = json.Unmarshal([]byte(task.Recipients), &recipients)
+		// Recipients who have since left the workspace or lost the action's
+		// permission no longer get reports.
+		members, err := workspaceEmails(task.TenantID, taskActions[task.Action].permission, recipients)
+		if err != nil {
+			log.Printf("Scheduled task %d: sending the report to its creator only: %v", task.ID, err)
+		}
+		recipients = members
+		report.TenantID = task.TenantID
+		report.TaskID = task.ID
+		report.TaskName = task.Name
+		report.Action = task.Action
+		report.UserID = task.CreatedBy
+		report.Recipients = recipients
+		report.GeneratedAt = now
+		emitTaskReport(*report)
+	}
+	masterDB.Model(&run).Updates(map[string]interface{}{
+		"status":      models.JobCompleted,
+		"output":      summary,
+		"error":       "",
+		"finished_at": &now,
+	})
+	return map[string]interface{}{"run_id": run.ID, "summary": summary}, nil
+}
+
+func (s *ScheduledTaskService) execute(ctx context.Context, task *models.ScheduledTask) (*TaskReport, error) {
+	action, ok := taskActions[task.Action]
+	if !ok {
+		return nil, queue.Permanent(fmt.Errorf("action %q is no longer available", task.Action))
+	}
+	params := action.newParams()
+	if err := json.Unmarshal([]byte(task.Params), params); err != nil {
+		return nil, queue.Permanent(fmt.Errorf("invalid stored params: %w", err))
+	}
+
+	var tenant models.Tenant
+	if err := config.GetMasterDB().First(&tenant, task.TenantID).Error; err != nil {
+		return nil, queue.Permanent(errors.New("tenant not found"))
+	}
+	if !tenant.IsActive {
+		return nil, queue.Permanent(errors.New("workspace is suspended"))
+	}
+	tenantDB, err := config.TenantManager.GetTenantDB(&tenant)
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := holdsPermission(tenant.ID, task.CreatedBy, action.permission)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, queue.Permanent(fmt.Errorf("the task's creator no longer holds the %s permission", action.permission))
+	}
+
+	return action.run(&taskContext{
+		db:     config.ScopeToTenant(tenantDB.WithContext(ctx), tenant.ID),
+		tenant: &tenant,
+	}, params)
+}
@@ -0,0 +1,262 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-multi-tenant/config"
+	"go-multi-tenant/models"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrSettingsVersionConflict = errors.New("settings were changed by someone else; reload and try again")
+
+	currencyRegex = regexp.MustCompile(`^[A-Z]{3}$`)
+	colorRegex    = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+	localeRegex   = regexp.MustCompile(`^[a-z]{2}(-[A-Z]{2})?$`)
+)
+
+type BrandingSettings struct {
+	DisplayName  string `json:"display_name"`
+	LogoURL      string `json:"logo_url"`
+	PrimaryColor string `json:"primary_color"`
+}
+
+// TenantSettings is the typed schema stored in models.TenantSetting.Data.
+type TenantSettings struct {
+	Timezone          string           `json:"timezone"`
+	Locale            string           `json:"locale"` // Language of emails, e.g. "en" or "pt-BR"
+	Currency          string           `json:"currency"`
+	LowStockThreshold int              `json:"low_stock_threshold"`
+	DefaultWarehouse  string           `json:"default_warehouse"`
+	Branding          BrandingSettings `json:"branding"`
+}
+
+// DefaultTenantSettings are used for tenants that never saved settings and
+// fill in fields added after a tenant last saved.
+func DefaultTenantSettings() TenantSettings {
+	return TenantSettings{
+		Timezone:          "UTC",
+		Locale:            "en",
+		Currency:          "USD",
+		LowStockThreshold: 10,
+		DefaultWarehouse:  "Main Warehouse",
+	}
+}
+
+func (ts *TenantSettings) Validate() error {
+	if _, err := time.LoadLocation(ts.Timezone); err != nil || ts.Timezone == "" {
+		return fmt.Errorf("invalid timezone %q", ts.Timezone)
+	}
+	if !localeRegex.MatchString(ts.Locale) {
+		return fmt.Errorf("locale must look like \"en\" or \"pt-BR\", got %q", ts.Locale)
+	}
+	if !currencyRegex.MatchString(ts.Currency) {
+		return fmt.Errorf("currency must be a 3-letter ISO code, got %q", ts.Currency)
+	}
+	if ts.LowStockThreshold < 0 {
+		return errors.New("low_stock_threshold cannot be negative")
+	}
+	if strings.TrimSpace(ts.DefaultWarehouse) == "" {
+		return errors.New("default_warehouse cannot be empty")
+	}
+	if ts.Branding.PrimaryColor != "" && !colorRegex.MatchString(ts.Branding.PrimaryColor) {
+		return errors.New("branding.primary_color must look like #RRGGBB")
+	}
+	if ts.Branding.LogoURL != "" && !strings.HasPrefix(ts.Branding.LogoURL, "https://") {
+		return errors.New("branding.logo_url must be an https URL")
+	}
+	return nil
+}
+
+// VersionedSettings is what the API returns: the effective settings plus the
+// version a PATCH must quote.
+type VersionedSettings struct {
+	Version   int            `json:"version"`
+	Settings  TenantSettings `json:"settings"`
+	UpdatedAt *time.Time     `json:"updated_at,omitempty"`
+}
+
+type SettingsService struct{}
+
+func NewSettingsService() *SettingsService {
+	return &SettingsService{}
+}
+
+func settingsCacheKey(tenantID uint) string {
+	return fmt.Sprintf("tenant_settings:%d", tenantID)
+}
+
+func (s *SettingsService) Get(tenantID uint) (*VersionedSettings, error) {
+	cacheService := NewCacheService()
+	var cached VersionedSettings
+	if err := cacheService.Get(settingsCacheKey(tenantID), &cached); err == nil {
+		return &cached, nil
+	}
+
+	result := &VersionedSettings{Settings: DefaultTenantSettings()}
+
+	var row models.TenantSetting
+	err := config.GetMasterDB().Where("tenant_id = ?", tenantID).First(&row).Error
+	switch {
+	case err == nil:
+		if err := json.Unmarshal([]byte(row.Data), &result.Settings); err != nil {
+			return nil, fmt.Errorf("stored settings are corrupt: %w", err)
+		}
+		result.Version = row.Version
+		result.UpdatedAt = &row.UpdatedAt
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	}
+
+	_ = cacheService.Set(settingsCacheKey(tenantID), result, 30*time.Minute)
+	return result, nil
+}
+
+// Update merges a partial JSON document into the current settings. When
+// expectedVersion is non-zero it must match the stored version.
+func (s *SettingsService) Update(tenantID, userID uint, patch []byte, expectedVersion int) (*VersionedSettings, error) {
+	masterDB := config.GetMasterDB()
+
+	err := masterDB.Transaction(func(tx *gorm.DB) error {
+		var row models.TenantSetting
+		err := tx.Where("tenant_id = ?", tenantID).First(&row).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		exists := err == nil
+
+		if expectedVersion != 0 && expectedVersion != row.Version {
+			return ErrSettingsVersionConflict
+		}
+
+		settings := DefaultTenantSettings()
+		if exists {
+			if err := json.Unmarshal([]byte(row.Data), &settings); err != nil {
+				return fmt.Errorf("stored settings are corrupt: %w", err)
+			}
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(patch))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&settings); err != nil {
+			return fmt.Errorf("invalid settings: %w", err)
+		}
+		if err := settings.Validate(); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(settings)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return tx.Create(&models.TenantSetting{
+				TenantID:  tenantID,
+				Version:   1,
+				Data:      string(data),
+				UpdatedBy: userID,
+			}).Error
+		}
+
+		// The version check in the WHERE clause guards against a concurrent writer
+		// that slipped in between our read and this update.
+		res := tx.Model(&models.TenantSetting{}).
+			Where("id = ? AND version = ?", row.ID, row.Version).
+			Updates(map[string]interface{}{
+				"data":       string(data),
+				"version":    row.Version + 1,
+				"updated_by": userID,
+				"updated_at": time.Now(),
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrSettingsVersionConflict
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.clearCache(tenantID)
+	return s.Get(tenantID)
+}
+
+// CopySettings gives a new tenant (e.g. a sandbox) the settings of another.
+func (s *SettingsService) CopySettings(fromTenantID, toTenantID uint) error {
+	var row models.TenantSetting
+	if err := config.GetMasterDB().Where("tenant_id = ?", fromTenantID).First(&row).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	err := config.GetMasterDB().Create(&models.TenantSetting{
+		TenantID: toTenantID,
+		Version:  1,
+		Data:     row.Data,
+	}).Error
+	s.clearCache(toTenantID)
+	return err
+}
+
+func (s *SettingsService) DeleteSettings(tx *gorm.DB, tenantID uint) error {
+	if err := tx.Where("tenant_id = ?", tenantID).Delete(&models.TenantSetting{}).Error; err != nil {
+		return err
+	}
+	s.clearCache(tenantID)
+	return nil
+}
+
+func (s *SettingsService) clearCache(tenantID uint) {
+	_ = NewCacheService().Delete(settingsCacheKey(tenantID))
+}
+
+// settingsFor never fails: callers that only need a preference fall back to
+// the defaults if settings cannot be loaded.
+func (s *SettingsService) settingsFor(tenantID uint) TenantSettings {
+	current, err := s.Get(tenantID)
+	if err != nil {
+		return DefaultTenantSettings()
+	}
+	return current.Settings
+}
+
+func (s *SettingsService) LowStockThreshold(tenantID uint) int {
+	return s.settingsFor(tenantID).LowStockThreshold
+}
+
+func (s *SettingsService) DefaultWarehouse(tenantID uint) string {
+	return s.settingsFor(tenantID).DefaultWarehouse
+}
+
+func (s *SettingsService) Locale(tenantID uint) string {
+	return s.settingsFor(tenantID).Locale
+}
+
+func (s *SettingsService) Currency(tenantID uint) string {
+	return s.settingsFor(tenantID).Currency
+}
+
+// Location is the tenant's timezone, for rendering dates and scheduling.
+func (s *SettingsService) Location(tenantID uint) *time.Location {
+	loc, err := time.LoadLocation(s.settingsFor(tenantID).Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func (s *SettingsService) Branding(tenantID uint) BrandingSettings {
+	return s.settingsFor(tenantID).Branding
+}
@@ -0,0 +1,433 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-multi-tenant/config"
+	"go-multi-tenant/events"
+	"go-multi-tenant/models"
+	"go-multi-tenant/utils"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Stream event types.
+const (
+	StreamStockAdjusted       = "stock.adjusted"
+	StreamPurchaseOrder       = "purchase_order.status_changed"
+	StreamNotificationCreated = "notification.created"
+	// StreamReset tells a reconnecting client that events were missed and it
+	// should reload what it shows.
+	StreamReset = "reset"
+)
+
+const (
+	streamBuffer    = 64 // Events a slow client may fall behind before it is dropped
+	streamIdleAfter = 10 * time.Minute
+	// streamReconnectGrace is how long after its stream closes a ticket may
+	// reopen it.
+	streamReconnectGrace = time.Minute
+)
+
+// StreamMessage is one event on a tenant's real-time stream. It goes through
+// the event bus so that every instance sees it with the same ID.
+type StreamMessage struct {
+	ID         string          `json:"id"`
+	TenantID   uint            `json:"tenant_id"`
+	Type       string          `json:"type"`
+	Permission string          `json:"permission,omitempty"` // Needed to receive it
+	UserID     uint            `json:"user_id,omitempty"`    // Only this user receives it
+	Data       json.RawMessage `json:"data"`
+}
+
+func (m StreamMessage) EventName() string { return "stream.message" }
+func (m StreamMessage) EventTenant() uint { return m.TenantID }
+
+// StreamClient is one open stream of a user.
+type StreamClient struct {
+	tenantID uint
+	userID   uint
+	messages chan StreamMessage
+	done     chan struct{}
+	stop     sync.Once
+
+	mu          sync.RWMutex
+	permissions []string
+}
+
+// Messages delivers the client's events until Done is closed.
+func (c *StreamClient) Messages() <-chan StreamMessage { return c.messages }
+
+// Done is closed when the server ends the stream: on shutdown, when the user
+// loses access, or when the client falls too far behind.
+func (c *StreamClient) Done() <-chan struct{} { return c.done }
+
+func (c *StreamClient) close() {
+	c.stop.Do(func() { close(c.done) })
+}
+
+func (c *StreamClient) receives(msg *StreamMessage) bool {
+	if msg.UserID != 0 {
+		return msg.UserID == c.userID
+	}
+	if msg.Permission == "" {
+		return true
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return slices.Contains(c.permissions, msg.Permission) || slices.Contains(c.permissions, "admin:full")
+}
+
+// tenantStream holds a tenant's clients on this instance and its recent
+// events, kept for a while after the last client leaves so a client that
+// reconnects can catch up.
+type tenantStream struct {
+	clients   map[*StreamClient]struct{}
+	recent    []StreamMessage
+	idleSince time.Time
+}
+
+type streamHub struct {
+	mu      sync.Mutex
+	tenants map[uint]*tenantStream
+	replay  int
+	closed  bool
+}
+
+var hub *streamHub
+
+func subscribeStream(bus *events.Bus) {
+	replay := 200
+	if config.AppConfig != nil && config.AppConfig.StreamReplay > 0 {
+		replay = config.AppConfig.StreamReplay
+	}
+	hub = &streamHub{tenants: make(map[uint]*tenantStream), replay: replay}
+	go hub.sweep()
+
+	// Turn domain events into stream messages once, on the instance that
+	// published them.
+	events.Subscribe(bus, func(ctx context.Context, e StockAdjusted) {
+		publishStream(e.TenantID, StreamStockAdjusted, "inventory:read", 0, e)
+	})
+	events.Subscribe(bus, func(ctx context.Context, e PurchaseOrderStatusChanged) {
+		publishStream(e.TenantID, StreamPurchaseOrder, "purchase:view", 0, e)
+	})
+	events.Subscribe(bus, func(ctx context.Context, e NotificationsCreated) {
+		for _, n := range e.Notifications {
+			publishStream(e.TenantID, StreamNotificationCreated, "", n.UserID, n)
+		}
+	})
+
+	// Every instance serves its own clients.
+	events.Subscribe(bus, func(ctx context.Context, m StreamMessage) {
+		hub.broadcast(m)
+	}, events.AllInstances())
+	events.Subscribe(bus, func(ctx context.Context, e RoleChanged) {
+		hub.refresh(e.TenantID, e.UserID)
+	}, events.Async(), events.AllInstances())
+	events.Subscribe(bus, func(ctx context.Context, e UserUpdated) {
+		hub.refresh(e.TenantID, e.UserID)
+	}, events.Async(), events.AllInstances())
+	events.Subscribe(bus, func(ctx context.Context, e UserDeleted) {
+		hub.drop(e.TenantID, e.UserID)
+	}, events.AllInstances())
+	events.Subscribe(bus, func(ctx context.Context, e TenantSuspended) {
+		hub.drop(e.TenantID, 0)
+	}, events.AllInstances())
+}
+
+func publishStream(tenantID uint, kind, permission string, userID uint, data interface{}) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Stream: failed to encode %s for tenant %d: %v", kind, tenantID, err)
+		return
+	}
+	publishEvent(StreamMessage{
+		ID:         newStreamID(),
+		TenantID:   tenantID,
+		Type:       kind,
+		Permission: permission,
+		UserID:     userID,
+		Data:       body,
+	})
+}
+
+// newStreamID is unique across instances; IDs are only compared for equality.
+func newStreamID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%d-%s", time.Now().UnixMilli(), hex.EncodeToString(b))
+}
+
+func (h *streamHub) broadcast(msg StreamMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ts, ok := h.tenants[msg.TenantID]
+	if !ok {
+		return // Nobody here follows this tenant
+	}
+	ts.recent = append(ts.recent, msg)
+	if len(ts.recent) > h.replay {
+		ts.recent = slices.Delete(ts.recent, 0, len(ts.recent)-h.replay)
+	}
+	for client := range ts.clients {
+		if !client.receives(&msg) {
+			continue
+		}
+		select {
+		case client.messages <- msg:
+		default:
+			// It reconnects and catches up from the replay buffer.
+			delete(ts.clients, client)
+			client.close()
+		}
+	}
+	if len(ts.clients) == 0 && ts.idleSince.IsZero() {
+		ts.idleSince = time.Now()
+	}
+}
+
+// refresh reloads the permissions of the tenant's clients, or of one user's,
+// and ends the streams of users who were deactivated.
+func (h *streamHub) refresh(tenantID, userID uint) {
+	clients := h.clientsOf(tenantID, userID)
+	if len(clients) == 0 {
+		return
+	}
+	tenantDB, err := openTenantDB(tenantID)
+	if err != nil {
+		log.Printf("Stream: cannot refresh permissions for tenant %d: %v", tenantID, err)
+		return
+	}
+	permissions := make(map[uint][]string)
+	for _, client := range clients {
+		perms, ok := permissions[client.userID]
+		if !ok {
+			perms, err = streamPermissions(tenantDB, client.userID)
+			if errors.Is(err, ErrStreamForbidden) {
+				h.drop(tenantID, client.userID)
+			}
+			if err != nil {
+				continue
+			}
+			permissions[client.userID] = perms
+		}
+		client.mu.Lock()
+		client.permissions = perms
+		client.mu.Unlock()
+	}
+}
+
+func (h *streamHub) clientsOf(tenantID, userID uint) []*StreamClient {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ts, ok := h.tenants[tenantID]
+	if !ok {
+		return nil
+	}
+	var clients []*StreamClient
+	for client := range ts.clients {
+		if userID == 0 || client.userID == userID {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+// drop ends the streams of a user, or of the whole tenant when userID is 0.
+func (h *streamHub) drop(tenantID, userID uint) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ts, ok := h.tenants[tenantID]
+	if !ok {
+		return
+	}
+	for client := range ts.clients {
+		if userID == 0 || client.userID == userID {
+			delete(ts.clients, client)
+			client.close()
+		}
+	}
+	if len(ts.clients) == 0 && ts.idleSince.IsZero() {
+		ts.idleSince = time.Now()
+	}
+}
+
+// sweep forgets the replay buffers of tenants nobody has followed for a while.
+func (h *streamHub) sweep() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.mu.Lock()
+		if h.closed {
+			h.mu.Unlock()
+			return
+		}
+		for id, ts := range h.tenants {
+			if len(ts.clients) == 0 && time.Since(ts.idleSince) > streamIdleAfter {
+				delete(h.tenants, id)
+			}
+		}
+		h.mu.Unlock()
+	}
+}
+
+var (
+	ErrStreamForbidden   = errors.New("user cannot open a stream")
+	ErrStreamUnavailable = errors.New("streaming is not available")
+	ErrStreamTicketUsed  = errors.New("stream ticket has already been used, request a new one")
+)
+
+func streamPermissions(tenantDB *gorm.DB, userID uint) ([]string, error) {
+	var user models.User
+	if err := tenantDB.Preload("Roles.Permissions").Where("id = ?", userID).Limit(1).Find(&user).Error; err != nil {
+		return nil, err
+	}
+	if user.ID == 0 || !user.IsActive {
+		return nil, ErrStreamForbidden
+	}
+	return user.GetPermissions(), nil
+}
+
+type StreamService struct{}
+
+func NewStreamService() *StreamService {
+	return &StreamService{}
+}
+
+// Open starts a stream for the user. With lastEventID it also returns the
+// events the client missed since then, or a reset event when they are no
+// longer known on this instance.
+func (s *StreamService) Open(tenantDB *gorm.DB, tenantID, userID uint, lastEventID string) (*StreamClient, []StreamMessage, error) {
+	if hub == nil {
+		return nil, nil, ErrStreamUnavailable
+	}
+	permissions, err := streamPermissions(tenantDB, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	client := &StreamClient{
+		tenantID:    tenantID,
+		userID:      userID,
+		messages:    make(chan StreamMessage, streamBuffer),
+		done:        make(chan struct{}),
+		permissions: permissions,
+	}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if hub.closed {
+		return nil, nil, ErrStreamUnavailable
+	}
+	ts, ok := hub.tenants[tenantID]
+	if !ok {
+		ts = &tenantStream{clients: make(map[*StreamClient]struct{})}
+		hub.tenants[tenantID] = ts
+	}
+	ts.clients[client] = struct{}{}
+	ts.idleSince = time.Time{}
+
+	if lastEventID == "" {
+		return client, nil, nil
+	}
+	at := slices.IndexFunc(ts.recent, func(m StreamMessage) bool { return m.ID == lastEventID })
+	if at < 0 {
+		return client, []StreamMessage{{ID: newStreamID(), TenantID: tenantID, Type: StreamReset, Data: json.RawMessage("{}")}}, nil
+	}
+	var missed []StreamMessage
+	for _, msg := range ts.recent[at+1:] {
+		if client.receives(&msg) {
+			missed = append(missed, msg)
+		}
+	}
+	return client, missed, nil
+}
+
+// ClaimTicket lets a stream ticket open one stream. After that the ticket
+// only reopens it, within streamReconnectGrace of the connection closing, so
+// that EventSource can reconnect on its own but a leaked URL cannot be
+// replayed.
+func (s *StreamService) ClaimTicket(claims *utils.Claims) error {
+	now := time.Now()
+	db := config.GetMasterDB()
+	if now.Before(claims.IssuedAt.Add(utils.StreamTicketTTL)) {
+		res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.StreamTicket{
+			ID:        claims.ID,
+			TenantID:  claims.TenantID,
+			UserID:    claims.UserID,
+			OpenedAt:  now,
+			ExpiresAt: claims.ExpiresAt.Time,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 1 {
+			return nil
+		}
+	}
+	res := db.Model(&models.StreamTicket{}).
+		Where("id = ? AND released_at > ?", claims.ID, now.Add(-streamReconnectGrace)).
+		Update("released_at", nil)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrStreamTicketUsed
+	}
+	return nil
+}
+
+// ReleaseTicket marks the stream opened with a ticket as closed, starting its
+// reconnect grace period.
+func (s *StreamService) ReleaseTicket(id string) {
+	if err := config.GetMasterDB().Model(&models.StreamTicket{}).
+		Where("id = ?", id).Update("released_at", time.Now()).Error; err != nil {
+		log.Printf("Stream: failed to release ticket: %v", err)
+	}
+}
+
+// PurgeExpiredTickets removes tickets that can no longer be used.
+func (s *StreamService) PurgeExpiredTickets() (int64, error) {
+	res := config.GetMasterDB().Where("expires_at < ?", time.Now()).Delete(&models.StreamTicket{})
+	return res.RowsAffected, res.Error
+}
+
+func (s *StreamService) Close(client *StreamClient) {
+	if hub == nil {
+		return
+	}
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if ts, ok := hub.tenants[client.tenantID]; ok {
+		delete(ts.clients, client)
+		if len(ts.clients) == 0 {
+			ts.idleSince = time.Now()
+		}
+	}
+	client.close()
+}
+
+// CloseStreams ends every open stream so the HTTP server can shut down.
+func CloseStreams() {
+	if hub == nil {
+		return
+	}
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	hub.closed = true
+	for _, ts := range hub.tenants {
+		for client := range ts.clients {
+			client.close()
+		}
+		ts.clients = make(map[*StreamClient]struct{})
+	}
+}
@@ -1,13 +1,23 @@
 package services
 
 import (
+	"errors"
 	"fmt"
 	"go-multi-tenant/config"
 	"go-multi-tenant/models"
 	"go-multi-tenant/repositories"
 	"go-multi-tenant/utils"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
 )
 
+const tenantAdminRoleName = "Tenant Admin"
+
+// tenantExcludedCategories are the permission categories kept from workspace roles.
+var tenantExcludedCategories = []string{"system", "admin"}
+
 type TenantService struct {
 	tenantRepo repositories.TenantRepository
 }
@@ -20,26 +30,59 @@ type CreateTenantRequest struct {
 	Name          string              `json:"name"`
 	DatabaseType  models.DatabaseType `json:"database_type"`
 	PlanID        uint                `json:"plan_id"`
+	Subdomain     string              `json:"subdomain"`
 	AdminUsername string              `json:"admin_username"`
 	AdminEmail    string              `json:"admin_email"`
 	AdminPassword string              `json:"admin_password"`
+	CouponCode    string              `json:"coupon_code"`
+
+	// Placement of dedicated databases: a registered server, or any server in a region.
+	Region     string `json:"region"`
+	DBServerID *uint  `json:"db_server_id"`
+
+	// Optional connection settings for dedicated databases on another server.
+	DBDriver   string `json:"db_driver"`
+	DBHost     string `json:"db_host"`
+	DBPort     int    `json:"db_port"`
+	DBUser     string `json:"db_user"`
+	DBPassword string `json:"db_password"`
 }
 
 func (s *TenantService) CreateTenant(req *CreateTenantRequest) (*models.Tenant, error) {
+	if (req.Region != "" || req.DBServerID != nil) && req.DatabaseType != models.DedicatedDB {
+		return nil, errors.New("region and db_server_id require a dedicated database")
+	}
 	if _, err := s.tenantRepo.GetGlobalIdentity(req.AdminEmail); err == nil {
 		return nil, fmt.Errorf("email %s is already registered globally", req.AdminEmail)
 	}
 
-	dbName := "shared_tenants_db"
-	if req.DatabaseType == models.DedicatedDB {
-		dbName = fmt.Sprintf("tenant_%s_db", req.Name)
+	subdomain, err := NormalizeSubdomain(req.Subdomain, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	var taken int64
+	config.MasterDB.Model(&models.Tenant{}).Where("subdomain = ?", subdomain).Count(&taken)
+	if taken > 0 {
+		return nil, fmt.Errorf("subdomain %s is already taken", subdomain)
 	}
+
+	dbName := tenantDBName(req.Name, req.DatabaseType)
 	var planID uint = req.PlanID
 	if planID == 0 {
 		var freePlan models.Plan
 		config.MasterDB.Where("type = ?", models.PlanFree).First(&freePlan)
 		planID = freePlan.ID
 	}
+	var plan models.Plan
+	if err := config.MasterDB.First(&plan, planID).Error; err != nil {
+		return nil, errors.New("plan not found")
+	}
+	couponService := NewCouponService()
+	if req.CouponCode != "" {
+		if _, err := couponService.ValidateCoupon(config.MasterDB, req.CouponCode, planID); err != nil {
+			return nil, err
+		}
+	}
 	apiKey, err := utils.GenerateSecureKey()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate api key: %v", err)
@@ -51,6 +94,34 @@ func (s *TenantService) CreateTenant(req *CreateTenantRequest) (*models.Tenant,
 		IsActive:     true,
 		PlanID:       planID,
 		APIKey:       apiKey,
+		Subdomain:    &subdomain,
+	}
+	// Paid plans start with the plan's trial, or else are invoiced right away.
+	if plan.Price > 0 && plan.BillingCycleDays > 0 {
+		now := time.Now()
+		tenant.PlanExpiry = &now
+		if plan.TrialDays > 0 {
+			trialEnd := now.AddDate(0, 0, plan.TrialDays)
+			tenant.PlanExpiry = &trialEnd
+			tenant.TrialEndsAt = &trialEnd
+			tenant.BillingStatus = models.BillingTrialing
+		}
+	}
+	if req.DatabaseType == models.DedicatedDB {
+		server, err := NewDBServerService().PlaceDedicated(req.Region, req.DBServerID)
+		if err != nil {
+			return nil, err
+		}
+		if server != nil {
+			tenant.DBServerID = &server.ID
+			tenant.DataRegion = server.Region
+		}
+
+		tenant.DBDriver = req.DBDriver
+		tenant.DBHost = req.DBHost
+		tenant.DBPort = req.DBPort
+		tenant.DBUser = req.DBUser
+		tenant.DBPassword = req.DBPassword
 	}
 
 	tx := config.MasterDB.Begin()
@@ -58,6 +129,12 @@ func (s *TenantService) CreateTenant(req *CreateTenantRequest) (*models.Tenant,
 		tx.Rollback()
 		return nil, err
 	}
+	if req.CouponCode != "" {
+		if _, err := couponService.redeem(tx, tenant.ID, req.CouponCode, planID); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
 
 	if req.DatabaseType == models.DedicatedDB {
 		if err := config.TenantManager.CreateDedicatedDatabase(tenant); err != nil {
@@ -71,7 +148,7 @@ func (s *TenantService) CreateTenant(req *CreateTenantRequest) (*models.Tenant,
 		return nil, err
 	}
 	adminRole := models.Role{
-		Name:         "Tenant Admin",
+		Name:         tenantAdminRoleName,
 		Description:  "Administrator for this workspace",
 		IsSystemRole: true,
 		TenantID:     tenant.ID,
@@ -80,7 +157,7 @@ func (s *TenantService) CreateTenant(req *CreateTenantRequest) (*models.Tenant,
 
 	var allowedPerms []models.Permission
 
-	config.MasterDB.Where("category NOT IN ?", []string{"system", "admin"}).Find(&allowedPerms)
+	config.MasterDB.Where("category NOT IN ?", tenantExcludedCategories).Find(&allowedPerms)
 
 	if len(allowedPerms) > 0 {
 		for _, p := range allowedPerms {
@@ -112,11 +189,90 @@ func (s *TenantService) CreateTenant(req *CreateTenantRequest) (*models.Tenant,
 	}
 
 	tx.Commit()
+
+	if tenant.PlanExpiry != nil && tenant.BillingStatus != models.BillingTrialing {
+		tenant.Plan = &plan
+		if _, err := NewBillingService().openRenewalInvoice(tenant); err != nil {
+			log.Printf("Tenant %s: failed to open first invoice: %v", tenant.Name, err)
+		}
+	}
 	return tenant, nil
 }
 
+func tenantDBName(name string, dbType models.DatabaseType) string {
+	if dbType == models.DedicatedDB {
+		return fmt.Sprintf("tenant_%s_db", name)
+	}
+	return "shared_tenants_db"
+}
+
 func (s *TenantService) ListTenants() ([]models.Tenant, error) {
 	var tenants []models.Tenant
 	err := config.MasterDB.Preload("Plan").Find(&tenants).Error
 	return tenants, err
 }
+
+type SetTenantModeRequest struct {
+	Mode                models.TenantMode `json:"mode" binding:"required"`
+	MaintenanceMessage  string            `json:"maintenance_message"`
+	MaintenanceStartsAt *time.Time        `json:"maintenance_starts_at"`
+	MaintenanceEndsAt   *time.Time        `json:"maintenance_ends_at"`
+}
+
+// SetMode switches a tenant between normal, read-only and maintenance, and
+// (re)schedules or clears its maintenance window.
+func (s *TenantService) SetMode(tenantID uint, req *SetTenantModeRequest) (*models.Tenant, error) {
+	switch req.Mode {
+	case models.ModeNormal, models.ModeReadOnly, models.ModeMaintenance:
+	default:
+		return nil, fmt.Errorf("invalid mode %q", req.Mode)
+	}
+	if req.MaintenanceEndsAt != nil {
+		if req.MaintenanceStartsAt == nil {
+			return nil, errors.New("maintenance_ends_at requires maintenance_starts_at")
+		}
+		if !req.MaintenanceEndsAt.After(*req.MaintenanceStartsAt) {
+			return nil, errors.New("maintenance window must end after it starts")
+		}
+	}
+
+	tenant, err := s.tenantRepo.GetByID(tenantID)
+	if err != nil {
+		return nil, errors.New("tenant not found")
+	}
+	if tenant.GetActualDBName() == "master_db" && req.Mode != models.ModeNormal {
+		return nil, errors.New("the master tenant cannot leave normal mode")
+	}
+
+	err = config.MasterDB.Model(tenant).Updates(map[string]interface{}{
+		"mode":                  req.Mode,
+		"maintenance_message":   req.MaintenanceMessage,
+		"maintenance_starts_at": req.MaintenanceStartsAt,
+		"maintenance_ends_at":   req.MaintenanceEndsAt,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	_ = NewCacheService().Delete(fmt.Sprintf("tenant_info:%d", tenantID))
+	return s.tenantRepo.GetByID(tenantID)
+}
+
+// RedeemCoupon applies a coupon to a tenant's current plan. The discount shows
+// up on the invoices issued from now on.
+func (s *TenantService) RedeemCoupon(tenantID uint, code string) (*models.CouponRedemption, error) {
+	tenant, err := s.tenantRepo.GetByID(tenantID)
+	if err != nil {
+		return nil, errors.New("tenant not found")
+	}
+
+	var redemption *models.CouponRedemption
+	err = config.MasterDB.Transaction(func(tx *gorm.DB) error {
+		redemption, err = NewCouponService().redeem(tx, tenant.ID, code, tenant.PlanID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return redemption, nil
+}
@@ -0,0 +1,533 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-multi-tenant/config"
+	"go-multi-tenant/models"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Warning thresholds, in percent of a limit.
+const (
+	usageWarnLevel = 80
+	usageFullLevel = 100
+)
+
+// Rough on-disk size of one row per table, used to estimate storage in a way
+// that works the same for every driver and for shared databases.
+var estimatedRowBytes = []struct {
+	model interface{}
+	bytes int64
+}{
+	{&models.User{}, 512},
+	{&models.Role{}, 256},
+	{&models.Category{}, 256},
+	{&models.Product{}, 512},
+	{&models.Inventory{}, 128},
+	{&models.PurchaseOrder{}, 192},
+}
+
+// QuotaExceededError is returned when an operation would take a tenant past a plan limit.
+type QuotaExceededError struct {
+	Metric string
+	Limit  int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	if e.Metric == models.MetricStorageBytes {
+		return fmt.Sprintf("plan limit reached: storage quota of %d MB is used up", e.Limit/(1<<20))
+	}
+	return fmt.Sprintf("plan limit reached: your plan allows max %d %s", e.Limit, e.Metric)
+}
+
+// UsageWarning is emitted once per counter (and period) when usage first
+// crosses 80% and again at 100% of the limit.
+type UsageWarning struct {
+	TenantID uint   `json:"tenant_id"`
+	Metric   string `json:"metric"`
+	Period   string `json:"period,omitempty"`
+	Level    int    `json:"level"`
+	Used     int64  `json:"used"`
+	Limit    int64  `json:"limit"`
+}
+
+var (
+	usageWarningHandlers   []func(UsageWarning)
+	usageWarningHandlersMu sync.RWMutex
+)
+
+// OnUsageWarning registers a handler called for every usage warning.
+func OnUsageWarning(handler func(UsageWarning)) {
+	usageWarningHandlersMu.Lock()
+	defer usageWarningHandlersMu.Unlock()
+	usageWarningHandlers = append(usageWarningHandlers, handler)
+}
+
+type UsageMetric struct {
+	Metric  string  `json:"metric"`
+	Period  string  `json:"period,omitempty"`
+	Used    int64   `json:"used"`
+	Limit   int64   `json:"limit"` // 0 = unlimited
+	Percent float64 `json:"percent"`
+	Warning string  `json:"warning,omitempty"`
+}
+
+type UsageReport struct {
+	TenantID uint          `json:"tenant_id"`
+	PlanName string        `json:"plan_name"`
+	Metrics  []UsageMetric `json:"metrics"`
+}
+
+type UsageService struct{}
+
+func NewUsageService() *UsageService {
+	return &UsageService{}
+}
+
+var meteredResources = []string{
+	models.MetricUsers,
+	models.MetricProducts,
+	models.MetricStorageBytes,
+	models.MetricAPICalls,
+	models.MetricPurchaseOrders,
+}
+
+func isMonthlyMetric(metric string) bool {
+	return metric == models.MetricAPICalls || metric == models.MetricPurchaseOrders
+}
+
+func usagePeriod(metric string, now time.Time) string {
+	if isMonthlyMetric(metric) {
+		return now.UTC().Format("2006-01")
+	}
+	return ""
+}
+
+func usageLimit(plan *models.Plan, metric string) int64 {
+	if plan == nil {
+		return 0
+	}
+	switch metric {
+	case models.MetricUsers:
+		return int64(plan.MaxUsers)
+	case models.MetricProducts:
+		return int64(plan.MaxProducts)
+	case models.MetricStorageBytes:
+		return int64(plan.StorageLimit) << 20
+	case models.MetricAPICalls:
+		return int64(plan.MaxAPICalls)
+	case models.MetricPurchaseOrders:
+		return int64(plan.MaxPurchaseOrders)
+	}
+	return 0
+}
+
+func counterQuery(tenantID uint, metric, period string) *gorm.DB {
+	return config.GetMasterDB().Model(&models.TenantUsage{}).
+		Where("tenant_id = ? AND metric = ? AND period = ?", tenantID, metric, period)
+}
+
+// actualUsage recounts a metric from the tenant's data. Soft-deleted users and
+// products have given up their seat, but their rows still take up storage.
+func (s *UsageService) actualUsage(tenantDB *gorm.DB, tenantID uint, metric, period string) (int64, error) {
+	var count int64
+	switch metric {
+	case models.MetricUsers:
+		err := tenantDB.Model(&models.User{}).Where("tenant_id = ?", tenantID).Count(&count).Error
+		return count, err
+	case models.MetricProducts:
+		err := tenantDB.Model(&models.Product{}).Where("tenant_id = ?", tenantID).Count(&count).Error
+		return count, err
+	case models.MetricPurchaseOrders:
+		start, err := time.Parse("2006-01", period)
+		if err != nil {
+			return 0, err
+		}
+		err = tenantDB.Unscoped().Model(&models.PurchaseOrder{}).
+			Where("tenant_id = ? AND created_at >= ? AND created_at < ?", tenantID, start, start.AddDate(0, 1, 0)).
+			Count(&count).Error
+		return count, err
+	case models.MetricStorageBytes:
+		return s.estimateStorage(tenantDB, tenantID)
+	}
+	return 0, nil
+}
+
+func (s *UsageService) estimateStorage(tenantDB *gorm.DB, tenantID uint) (int64, error) {
+	var total int64
+	for _, table := range estimatedRowBytes {
+		var count int64
+		if err := tenantDB.Unscoped().Model(table.model).Where("tenant_id = ?", tenantID).Count(&count).Error; err != nil {
+			return 0, err
+		}
+		total += count * table.bytes
+	}
+	return total, nil
+}
+
+// ensureCounter creates a missing counter, seeded from the tenant's real data.
+func (s *UsageService) ensureCounter(tenantDB *gorm.DB, tenantID uint, metric, period string) (*models.TenantUsage, error) {
+	var counter models.TenantUsage
+	err := counterQuery(tenantID, metric, period).First(&counter).Error
+	if err == nil {
+		return &counter, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	value, err := s.actualUsage(tenantDB, tenantID, metric, period)
+	if err != nil {
+		return nil, err
+	}
+	counter = models.TenantUsage{TenantID: tenantID, Metric: metric, Period: period, Value: value}
+	// Another request may have seeded the counter meanwhile; theirs wins.
+	if err := config.GetMasterDB().Clauses(clause.OnConflict{DoNothing: true}).Create(&counter).Error; err != nil {
+		return nil, err
+	}
+	if err := counterQuery(tenantID, metric, period).First(&counter).Error; err != nil {
+		return nil, err
+	}
+	return &counter, nil
+}
+
+// Reserve atomically adds n to a metric if the tenant's plan allows it. Callers
+// that fail to create the resource afterwards must Release the reservation.
+// Resources that take up space are also refused once storage is used up.
+func (s *UsageService) Reserve(tenantDB *gorm.DB, tenantID uint, metric string, n int64) error {
+	var tenant models.Tenant
+	if err := config.GetMasterDB().Preload("Plan").First(&tenant, tenantID).Error; err != nil {
+		return errors.New("failed to load tenant info")
+	}
+	return s.ReserveForTenant(tenantDB, &tenant, metric, n)
+}
+
+func (s *UsageService) ReserveForTenant(tenantDB *gorm.DB, tenant *models.Tenant, metric string, n int64) error {
+	if metric == models.MetricAPICalls {
+		if err := s.reserveInRedis(tenantDB, tenant, metric, n); !errors.Is(err, errNoRedisCounter) {
+			return err
+		}
+	} else {
+		if err := s.checkStorage(tenantDB, tenant); err != nil {
+			return err
+		}
+	}
+
+	period := usagePeriod(metric, time.Now())
+	if _, err := s.ensureCounter(tenantDB, tenant.ID, metric, period); err != nil {
+		return err
+	}
+
+	limit := usageLimit(tenant.Plan, metric)
+	query := counterQuery(tenant.ID, metric, period)
+	if limit > 0 {
+		query = query.Where("value + ? <= ?", n, limit)
+	}
+	res := query.UpdateColumn("value", gorm.Expr("value + ?", n))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		s.warn(tenant.ID, metric, period, limit, limit)
+		return &QuotaExceededError{Metric: metric, Limit: limit}
+	}
+
+	if limit > 0 {
+		var counter models.TenantUsage
+		if err := counterQuery(tenant.ID, metric, period).First(&counter).Error; err == nil {
+			s.warn(tenant.ID, metric, period, counter.Value, limit)
+		}
+	}
+	return nil
+}
+
+// API calls are counted in Redis, which every request would otherwise have to
+// wait on the master database for. Redis holds the whole month's count, seeded
+// from the database counter; FlushAPIUsage copies it back every minute. If
+// Redis loses a key it is seeded again from the last flush, so at most a
+// minute of calls goes uncounted. Without Redis, calls are counted in the
+// database.
+const redisUsageTTL = 35 * 24 * time.Hour
+
+var errNoRedisCounter = errors.New("no Redis usage counter")
+
+// reserveUsageScript adds ARGV[1] to a counter unless that takes it past the
+// limit ARGV[2] (0 = unlimited). It returns the new value, -1 when the
+// counter does not exist yet and -2 when the limit would be exceeded.
+var reserveUsageScript = redis.NewScript(`
+local value = redis.call("GET", KEYS[1])
+if not value then
+	return -1
+end
+local n, limit = tonumber(ARGV[1]), tonumber(ARGV[2])
+if limit > 0 and tonumber(value) + n > limit then
+	return -2
+end
+return redis.call("INCRBY", KEYS[1], n)
+`)
+
+const redisAPIUsagePrefix = "usage:" + models.MetricAPICalls + ":"
+
+func redisUsageKey(tenantID uint, metric, period string) string {
+	return fmt.Sprintf("usage:%s:%d:%s", metric, tenantID, period)
+}
+
+// reserveInRedis is ReserveForTenant for counters kept in Redis. It returns
+// errNoRedisCounter when Redis cannot be used.
+func (s *UsageService) reserveInRedis(tenantDB *gorm.DB, tenant *models.Tenant, metric string, n int64) error {
+	if config.RedisClient == nil {
+		return errNoRedisCounter
+	}
+	period := usagePeriod(metric, time.Now())
+	key := redisUsageKey(tenant.ID, metric, period)
+	limit := usageLimit(tenant.Plan, metric)
+
+	for seeded := false; ; seeded = true {
+		value, err := reserveUsageScript.Run(config.Ctx, config.RedisClient, []string{key}, n, limit).Int64()
+		if err != nil {
+			return errNoRedisCounter
+		}
+		switch {
+		case value == -2:
+			return &QuotaExceededError{Metric: metric, Limit: limit}
+		case value == -1 && !seeded:
+			counter, err := s.ensureCounter(tenantDB, tenant.ID, metric, period)
+			if err != nil {
+				return err
+			}
+			if err := config.RedisClient.SetNX(config.Ctx, key, counter.Value, redisUsageTTL).Err(); err != nil {
+				return errNoRedisCounter
+			}
+		case value == -1:
+			return errNoRedisCounter
+		default:
+			// Only the call that crosses a warning level checks the database.
+			crossed := func(level int64) bool { return (value-n)*100 < limit*level && value*100 >= limit*level }
+			if limit > 0 && (crossed(usageWarnLevel) || crossed(usageFullLevel)) {
+				s.warn(tenant.ID, metric, period, value, limit)
+			}
+			return nil
+		}
+	}
+}
+
+// redisUsage returns a counter's value in Redis, if it is kept there.
+func redisUsage(tenantID uint, metric, period string) (int64, bool) {
+	if config.RedisClient == nil || metric != models.MetricAPICalls {
+		return 0, false
+	}
+	value, err := config.RedisClient.Get(config.Ctx, redisUsageKey(tenantID, metric, period)).Int64()
+	return value, err == nil
+}
+
+// FlushAPIUsage copies the API call counts kept in Redis to the database.
+func (s *UsageService) FlushAPIUsage() (int, error) {
+	if config.RedisClient == nil {
+		return 0, nil
+	}
+	flushed := 0
+	iter := config.RedisClient.Scan(config.Ctx, 0, redisAPIUsagePrefix+"*", 100).Iterator()
+	for iter.Next(config.Ctx) {
+		var tenantID uint
+		var period string
+		if _, err := fmt.Sscanf(strings.TrimPrefix(iter.Val(), redisAPIUsagePrefix), "%d:%s", &tenantID, &period); err != nil {
+			continue
+		}
+		value, err := config.RedisClient.Get(config.Ctx, iter.Val()).Int64()
+		if err != nil {
+			continue
+		}
+		// Counters only grow within a period, so an older value never wins.
+		if err := counterQuery(tenantID, models.MetricAPICalls, period).Where("value < ?", value).
+			UpdateColumn("value", value).Error; err != nil {
+			return flushed, err
+		}
+		flushed++
+	}
+	return flushed, iter.Err()
+}
+
+// Remaining reports how many more units of a metric the tenant's plan allows;
+// limited is false when the plan sets no limit. It reserves nothing.
+func (s *UsageService) Remaining(tenantDB *gorm.DB, tenantID uint, metric string) (remaining int64, limited bool, err error) {
+	var tenant models.Tenant
+	if err := config.GetMasterDB().Preload("Plan").First(&tenant, tenantID).Error; err != nil {
+		return 0, false, errors.New("failed to load tenant info")
+	}
+	limit := usageLimit(tenant.Plan, metric)
+	if limit == 0 {
+		return 0, false, nil
+	}
+	counter, err := s.ensureCounter(tenantDB, tenantID, metric, usagePeriod(metric, time.Now()))
+	if err != nil {
+		return 0, false, err
+	}
+	return max(limit-counter.Value, 0), true, nil
+}
+
+// Release gives back n units of a metric, e.g. after a delete or a failed create.
+func (s *UsageService) Release(tenantID uint, metric string, n int64) {
+	period := usagePeriod(metric, time.Now())
+	err := counterQuery(tenantID, metric, period).
+		UpdateColumn("value", gorm.Expr("CASE WHEN value > ? THEN value - ? ELSE 0 END", n, n)).Error
+	if err != nil {
+		log.Printf("Failed to release %d %s for tenant %d: %v", n, metric, tenantID, err)
+		return
+	}
+	// Dropping back below the warning threshold re-arms the warnings.
+	var tenant models.Tenant
+	if config.GetMasterDB().Preload("Plan").First(&tenant, tenantID).Error == nil {
+		if limit := usageLimit(tenant.Plan, metric); limit > 0 {
+			counterQuery(tenantID, metric, period).
+				Where("warned_level > 0 AND value * 100 < ?", limit*usageWarnLevel).
+				UpdateColumn("warned_level", 0)
+		}
+	}
+}
+
+func (s *UsageService) checkStorage(tenantDB *gorm.DB, tenant *models.Tenant) error {
+	limit := usageLimit(tenant.Plan, models.MetricStorageBytes)
+	if limit == 0 {
+		return nil
+	}
+	counter, err := s.ensureCounter(tenantDB, tenant.ID, models.MetricStorageBytes, "")
+	if err != nil {
+		return err
+	}
+	if counter.Value >= limit {
+		return &QuotaExceededError{Metric: models.MetricStorageBytes, Limit: limit}
+	}
+	return nil
+}
+
+// warn emits a warning the first time a counter reaches a new level. The
+// conditional update makes sure only one instance emits it.
+func (s *UsageService) warn(tenantID uint, metric, period string, used, limit int64) {
+	if limit <= 0 {
+		return
+	}
+	level := 0
+	switch {
+	case used >= limit:
+		level = usageFullLevel
+	case used*100 >= limit*usageWarnLevel:
+		level = usageWarnLevel
+	default:
+		return
+	}
+
+	res := counterQuery(tenantID, metric, period).Where("warned_level < ?", level).UpdateColumn("warned_level", level)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return
+	}
+
+	warning := UsageWarning{TenantID: tenantID, Metric: metric, Period: period, Level: level, Used: used, Limit: limit}
+	log.Printf("Usage warning: tenant %d has used %d%% of its %s quota (%d/%d)", tenantID, level, metric, used, limit)
+
+	usageWarningHandlersMu.RLock()
+	defer usageWarningHandlersMu.RUnlock()
+	for _, handler := range usageWarningHandlers {
+		handler(warning)
+	}
+}
+
+// RefreshStorage re-estimates a tenant's storage gauge.
+func (s *UsageService) RefreshStorage(tenantDB *gorm.DB, tenant *models.Tenant) error {
+	value, err := s.estimateStorage(tenantDB, tenant.ID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.ensureCounter(tenantDB, tenant.ID, models.MetricStorageBytes, ""); err != nil {
+		return err
+	}
+	if err := counterQuery(tenant.ID, models.MetricStorageBytes, "").UpdateColumn("value", value).Error; err != nil {
+		return err
+	}
+	s.warn(tenant.ID, models.MetricStorageBytes, "", value, usageLimit(tenant.Plan, models.MetricStorageBytes))
+	return nil
+}
+
+// Recalculate drops a tenant's gauges so they are recounted from its data,
+// for use after bulk changes such as a restore.
+func (s *UsageService) Recalculate(tenantID uint) error {
+	return config.GetMasterDB().
+		Where("tenant_id = ? AND period = ?", tenantID, "").
+		Delete(&models.TenantUsage{}).Error
+}
+
+func (s *UsageService) GetUsage(tenantDB *gorm.DB, tenantID uint) (*UsageReport, error) {
+	var tenant models.Tenant
+	if err := config.GetMasterDB().Preload("Plan").First(&tenant, tenantID).Error; err != nil {
+		return nil, errors.New("tenant not found")
+	}
+	if err := s.RefreshStorage(tenantDB, &tenant); err != nil {
+		return nil, err
+	}
+
+	report := &UsageReport{TenantID: tenant.ID}
+	if tenant.Plan != nil {
+		report.PlanName = tenant.Plan.Name
+	}
+
+	now := time.Now()
+	for _, metric := range meteredResources {
+		period := usagePeriod(metric, now)
+		counter, err := s.ensureCounter(tenantDB, tenant.ID, metric, period)
+		if err != nil {
+			return nil, err
+		}
+
+		used := counter.Value
+		if value, ok := redisUsage(tenant.ID, metric, period); ok {
+			used = max(used, value)
+		}
+		item := UsageMetric{
+			Metric: metric,
+			Period: period,
+			Used:   used,
+			Limit:  usageLimit(tenant.Plan, metric),
+		}
+		if item.Limit > 0 {
+			item.Percent = float64(item.Used) * 100 / float64(item.Limit)
+			switch {
+			case item.Used >= item.Limit:
+				item.Warning = "limit reached"
+			case item.Percent >= usageWarnLevel:
+				item.Warning = "approaching limit"
+			}
+		}
+		report.Metrics = append(report.Metrics, item)
+	}
+	return report, nil
+}
+
+// RefreshAllStorage re-estimates storage for every active tenant.
+func (s *UsageService) RefreshAllStorage() {
+	var tenants []models.Tenant
+	if err := config.GetMasterDB().Preload("Plan").Where("is_active = ?", true).Find(&tenants).Error; err != nil {
+		log.Printf("Error fetching tenants for storage metering: %v", err)
+		return
+	}
+	for i := range tenants {
+		tenant := &tenants[i]
+		if tenant.GetActualDBName() == "master_db" {
+			continue
+		}
+		tenantDB, err := config.TenantManager.GetTenantDB(tenant)
+		if err != nil {
+			log.Printf("Storage metering skipped tenant %s: %v", tenant.Name, err)
+			continue
+		}
+		if err := s.RefreshStorage(config.ScopeToTenant(tenantDB, tenant.ID), tenant); err != nil {
+			log.Printf("Storage metering failed for tenant %s: %v", tenant.Name, err)
+		}
+	}
+}
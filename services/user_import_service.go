@@ -0,0 +1,550 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-multi-tenant/config"
+	"go-multi-tenant/models"
+	"go-multi-tenant/queue"
+	"go-multi-tenant/utils"
+	"io"
+	"log"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"gorm.io/gorm"
+)
+
+const (
+	maxImportRows  = 1000
+	importAttempts = 3
+	// Progress is saved every so many rows, and once at the end.
+	importSaveEvery = 25
+	// importSealPurpose keys the encryption of stored import rows.
+	importSealPurpose = "user-import-rows"
+)
+
+// Row outcomes. Validation marks rows valid or invalid; the import job then
+// moves each valid row from pending to created or failed.
+const (
+	ImportRowValid   = "valid"
+	ImportRowInvalid = "invalid"
+	ImportRowPending = "pending"
+	ImportRowCreated = "created"
+	ImportRowFailed  = "failed"
+)
+
+var (
+	ErrUserImportNotFound = errors.New("import not found")
+	ErrUserImportInvalid  = errors.New("the import did not pass validation; nothing was imported")
+)
+
+type UserImportService struct {
+	userService *UserService
+}
+
+func NewUserImportService() *UserImportService {
+	return &UserImportService{userService: NewUserService()}
+}
+
+// ImportUserRow is one user as read from the file. Role is a role name or ID.
+type ImportUserRow struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+// ImportRowResult reports what happened to one row. Row counts data rows
+// from 1, so the CSV header is not counted.
+type ImportRowResult struct {
+	Row      int      `json:"row"`
+	Username string   `json:"username"`
+	Email    string   `json:"email"`
+	Status   string   `json:"status"`
+	Errors   []string `json:"errors,omitempty"`
+	UserID   uint     `json:"user_id,omitempty"`
+}
+
+// importedUser is a validated row as stored, encrypted, until the import job
+// creates it.
+type importedUser struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	RoleID   uint   `json:"role_id"`
+}
+
+// UserImportCheck is the result of validating an import.
+type UserImportCheck struct {
+	DryRun    bool              `json:"dry_run"`
+	Valid     bool              `json:"valid"`
+	TotalRows int               `json:"total_rows"`
+	Invalid   int               `json:"invalid"`
+	Errors    []string          `json:"errors,omitempty"` // Problems with the import as a whole
+	Results   []ImportRowResult `json:"results"`
+}
+
+// UserImportView is an import as returned by the API, with its row results.
+type UserImportView struct {
+	models.UserImport
+	Results []ImportRowResult `json:"results"`
+}
+
+// ParseUserImport reads rows from a CSV file with a header line naming the
+// username, email, password and optional role columns, or from a JSON array
+// of objects with the same fields.
+func ParseUserImport(format string, r io.Reader) ([]ImportUserRow, error) {
+	var rows []ImportUserRow
+	switch format {
+	case "json":
+		dec := json.NewDecoder(r)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&rows); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+	case "csv":
+		var err error
+		if rows, err = parseUserCSV(r); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported format %q, use csv or json", format)
+	}
+
+	if len(rows) == 0 {
+		return nil, errors.New("the file has no rows")
+	}
+	if len(rows) > maxImportRows {
+		return nil, fmt.Errorf("an import can have at most %d rows", maxImportRows)
+	}
+	return rows, nil
+}
+
+func parseUserCSV(r io.Reader) ([]ImportUserRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		switch name {
+		case "username", "email", "password", "role":
+			columns[name] = i
+		default:
+			return nil, fmt.Errorf("unknown CSV column %q", name)
+		}
+	}
+	for _, required := range []string{"username", "email", "password"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing CSV column %q", required)
+		}
+	}
+
+	cell := func(record []string, name string) string {
+		if i, ok := columns[name]; ok {
+			return record[i]
+		}
+		return ""
+	}
+	var rows []ImportUserRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("an import can have at most %d rows", maxImportRows)
+		}
+		rows = append(rows, ImportUserRow{
+			Username: cell(record, "username"),
+			Email:    cell(record, "email"),
+			Password: cell(record, "password"),
+			Role:     cell(record, "role"),
+		})
+	}
+}
+
+// validate checks every row the way CreateUser would, plus duplicates within
+// the file and the plan's free seats, and resolves roles to IDs.
+func (s *UserImportService) validate(tenantDB *gorm.DB, tenantID uint, rows []ImportUserRow) (*UserImportCheck, []CreateUserRequest, error) {
+	var roles []models.Role
+	if err := tenantDB.Where("tenant_id = ?", tenantID).Find(&roles).Error; err != nil {
+		return nil, nil, err
+	}
+	roleIDs := map[string]uint{}
+	for _, role := range roles {
+		roleIDs[strings.ToLower(role.Name)] = role.ID
+		roleIDs[strconv.Itoa(int(role.ID))] = role.ID
+	}
+
+	emails := make([]string, 0, len(rows))
+	for i := range rows {
+		rows[i].Username = strings.TrimSpace(rows[i].Username)
+		rows[i].Email = strings.TrimSpace(rows[i].Email)
+		rows[i].Role = strings.TrimSpace(rows[i].Role)
+		emails = append(emails, rows[i].Email)
+	}
+	var registered []string
+	if err := config.GetMasterDB().Model(&models.GlobalIdentity{}).
+		Where("email IN ?", emails).Pluck("email", &registered).Error; err != nil {
+		return nil, nil, err
+	}
+	taken := map[string]bool{}
+	for _, email := range registered {
+		taken[strings.ToLower(email)] = true
+	}
+
+	check := &UserImportCheck{TotalRows: len(rows), Results: make([]ImportRowResult, len(rows))}
+	requests := make([]CreateUserRequest, len(rows))
+	seen := map[string]int{}
+	for i, row := range rows {
+		result := ImportRowResult{Row: i + 1, Username: row.Username, Email: row.Email, Status: ImportRowValid}
+		email := strings.ToLower(row.Email)
+
+		if row.Username == "" {
+			result.Errors = append(result.Errors, "username is required")
+		}
+		switch {
+		case row.Email == "":
+			result.Errors = append(result.Errors, "email is required")
+		case !isValidEmail(row.Email):
+			result.Errors = append(result.Errors, "invalid email format")
+		case taken[email]:
+			result.Errors = append(result.Errors, "email already exists in the system")
+		case seen[email] > 0:
+			result.Errors = append(result.Errors, fmt.Sprintf("email is repeated from row %d", seen[email]))
+		}
+		if row.Password == "" {
+			result.Errors = append(result.Errors, "password is required")
+		}
+		var roleID uint
+		if row.Role != "" {
+			var ok bool
+			if roleID, ok = roleIDs[strings.ToLower(row.Role)]; !ok {
+				result.Errors = append(result.Errors, fmt.Sprintf("unknown role %q", row.Role))
+			}
+		}
+
+		if seen[email] == 0 {
+			seen[email] = i + 1
+		}
+		if len(result.Errors) > 0 {
+			result.Status = ImportRowInvalid
+			check.Invalid++
+		}
+		check.Results[i] = result
+		requests[i] = CreateUserRequest{Username: row.Username, Email: row.Email, Password: row.Password, RoleID: roleID}
+	}
+
+	remaining, limited, err := NewUsageService().Remaining(tenantDB, tenantID, models.MetricUsers)
+	if err != nil {
+		return nil, nil, err
+	}
+	if valid := int64(len(rows) - check.Invalid); limited && valid > remaining {
+		check.Errors = append(check.Errors, fmt.Sprintf("the plan has %d free user seats but the import adds %d users", remaining, valid))
+	}
+	check.Valid = check.Invalid == 0 && len(check.Errors) == 0
+	return check, requests, nil
+}
+
+// StartImport validates rows and, unless dryRun is set or a row is invalid,
+// queues a job that creates the users. With invalid rows it returns the check
+// and ErrUserImportInvalid.
+func (s *UserImportService) StartImport(tenantDB *gorm.DB, tenantID, userID uint, format string, rows []ImportUserRow, dryRun bool) (*UserImportCheck, *models.UserImport, error) {
+	check, requests, err := s.validate(tenantDB, tenantID, rows)
+	if err != nil {
+		return nil, nil, err
+	}
+	check.DryRun = dryRun
+	if !check.Valid {
+		return check, nil, ErrUserImportInvalid
+	}
+	if dryRun {
+		return check, nil, nil
+	}
+
+	results := make([]ImportRowResult, len(check.Results))
+	for i, result := range check.Results {
+		result.Status = ImportRowPending
+		results[i] = result
+	}
+	stored := make([]importedUser, len(requests))
+	for i, req := range requests {
+		stored[i] = importedUser{Username: req.Username, Email: req.Email, Password: req.Password, RoleID: req.RoleID}
+	}
+	rowsJSON, err := json.Marshal(stored)
+	if err != nil {
+		return nil, nil, err
+	}
+	sealedRows, err := utils.Seal(importSealPurpose, rowsJSON)
+	if err != nil {
+		return nil, nil, err
+	}
+	resultsJSON, err := json.Marshal(results)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	masterDB := config.GetMasterDB()
+	imp := &models.UserImport{
+		TenantID:    tenantID,
+		RequestedBy: userID,
+		Format:      format,
+		Status:      models.ImportPending,
+		TotalRows:   len(rows),
+		Rows:        sealedRows,
+		Results:     string(resultsJSON),
+	}
+	if err := masterDB.Create(imp).Error; err != nil {
+		return nil, nil, err
+	}
+
+	job, err := NewQueueService().Enqueue(JobUserImport, userImportJob{ImportID: imp.ID}, queue.EnqueueOptions{
+		TenantID:    tenantID,
+		CreatedBy:   userID,
+		MaxAttempts: importAttempts,
+	})
+	if err != nil {
+		masterDB.Model(imp).Updates(map[string]interface{}{"status": models.ImportFailed, "rows": "", "error": err.Error()})
+		return nil, nil, fmt.Errorf("failed to queue import: %w", err)
+	}
+	masterDB.Model(imp).Update("job_id", job.ID)
+	imp.JobID = job.ID
+	return check, imp, nil
+}
+
+// hashImportPasswords hashes the passwords of the rows at indexes in
+// parallel, since bcrypt takes tens of milliseconds per row.
+func hashImportPasswords(ctx context.Context, rows []importedUser, indexes []int) (map[int]string, error) {
+	hashes := make([]string, len(indexes))
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(runtime.NumCPU())
+	for i, row := range indexes {
+		g.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			hash, err := utils.HashPassword(rows[row].Password)
+			if err != nil {
+				return fmt.Errorf("failed to hash password of row %d: %w", row+1, err)
+			}
+			hashes[i] = hash
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	byRow := make(map[int]string, len(indexes))
+	for i, row := range indexes {
+		byRow[row] = hashes[i]
+	}
+	return byRow, nil
+}
+
+func (s *UserImportService) ListImports(tenantID uint, page, pageSize int) ([]models.UserImport, int64, error) {
+	query := config.GetMasterDB().Model(&models.UserImport{}).Where("tenant_id = ?", tenantID)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var imports []models.UserImport
+	err := query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&imports).Error
+	return imports, total, err
+}
+
+func (s *UserImportService) GetImport(tenantID, id uint) (*UserImportView, error) {
+	var imp models.UserImport
+	if err := config.GetMasterDB().Where("tenant_id = ?", tenantID).First(&imp, id).Error; err != nil {
+		return nil, ErrUserImportNotFound
+	}
+	view := &UserImportView{UserImport: imp, Results: []ImportRowResult{}}
+	if imp.Results != "" {
+		if err := json.Unmarshal([]byte(imp.Results), &view.Results); err != nil {
+			return nil, err
+		}
+	}
+	return view, nil
+}
+
+type userImportJob struct {
+	ImportID uint `json:"import_id"`
+}
+
+// runImportJob creates the users of an import through CreateUser, so plan
+// limits, identity checks and role assignment apply as for a single user.
+// Progress is saved as it goes and a retried job carries on where it stopped.
+func (s *UserImportService) runImportJob(ctx context.Context, job *models.Job, payload userImportJob) (interface{}, error) {
+	masterDB := config.GetMasterDB()
+
+	var imp models.UserImport
+	if err := masterDB.First(&imp, payload.ImportID).Error; err != nil {
+		return nil, queue.Permanent(fmt.Errorf("import %d not found", payload.ImportID))
+	}
+	if imp.Status == models.ImportCompleted || imp.Status == models.ImportFailed {
+		return map[string]interface{}{"import_id": imp.ID, "status": imp.Status}, nil
+	}
+	masterDB.Model(&imp).Update("status", models.ImportRunning)
+
+	err := s.importRows(ctx, job, &imp)
+	now := time.Now()
+	if err != nil {
+		log.Printf("User import %d for tenant %d failed (attempt %d/%d): %v", imp.ID, imp.TenantID, job.Attempts, job.MaxAttempts, err)
+		updates := map[string]interface{}{"status": models.ImportPending, "error": err.Error()}
+		if job.Attempts >= job.MaxAttempts || queue.IsPermanent(err) {
+			updates["status"] = models.ImportFailed
+			updates["rows"] = ""
+			updates["completed_at"] = &now
+		}
+		masterDB.Model(&imp).Updates(updates)
+		return nil, err
+	}
+
+	masterDB.Model(&imp).Updates(map[string]interface{}{
+		"status":       models.ImportCompleted,
+		"rows":         "",
+		"error":        "",
+		"completed_at": &now,
+	})
+	return map[string]interface{}{"import_id": imp.ID, "created": imp.Created, "failed": imp.Failed}, nil
+}
+
+func (s *UserImportService) importRows(ctx context.Context, job *models.Job, imp *models.UserImport) error {
+	var requests []importedUser
+	var results []ImportRowResult
+	rowsJSON, err := utils.Open(importSealPurpose, imp.Rows)
+	if err != nil {
+		return queue.Permanent(fmt.Errorf("cannot decrypt stored rows: %w", err))
+	}
+	if err := json.Unmarshal(rowsJSON, &requests); err != nil {
+		return queue.Permanent(fmt.Errorf("invalid stored rows: %w", err))
+	}
+	if err := json.Unmarshal([]byte(imp.Results), &results); err != nil || len(results) != len(requests) {
+		return queue.Permanent(errors.New("invalid stored results"))
+	}
+
+	var tenant models.Tenant
+	if err := config.GetMasterDB().First(&tenant, imp.TenantID).Error; err != nil {
+		return queue.Permanent(errors.New("tenant not found"))
+	}
+	tenantDB, err := config.TenantManager.GetTenantDB(&tenant)
+	if err != nil {
+		return err
+	}
+	tenantDB = config.ScopeToTenant(tenantDB.WithContext(ctx), tenant.ID)
+
+	var pending []int
+	for i, result := range results {
+		if result.Status == ImportRowPending && requests[i].Password != "" {
+			pending = append(pending, i)
+		}
+	}
+	hashes, err := hashImportPasswords(ctx, requests, pending)
+	if err != nil {
+		return err
+	}
+
+	var requester models.User
+	if err := tenantDB.Preload("Roles.Permissions").First(&requester, imp.RequestedBy).Error; err != nil {
+		return queue.Permanent(errors.New("the user who started the import no longer exists"))
+	}
+
+	save := func() error {
+		imp.Created, imp.Failed = 0, 0
+		for _, result := range results {
+			switch result.Status {
+			case ImportRowCreated:
+				imp.Created++
+			case ImportRowFailed:
+				imp.Failed++
+			}
+		}
+		resultsJSON, err := json.Marshal(results)
+		if err != nil {
+			return err
+		}
+		return config.GetMasterDB().Model(imp).Updates(map[string]interface{}{
+			"results": string(resultsJSON),
+			"created": imp.Created,
+			"failed":  imp.Failed,
+		}).Error
+	}
+
+	unsaved := 0
+	for i := range requests {
+		result := &results[i]
+		if result.Status != ImportRowPending {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			_ = save()
+			return err
+		}
+
+		// A retried job may find users it created before its progress was saved.
+		if job.Attempts > 1 {
+			var existing models.User
+			if err := tenantDB.Where("email = ? AND created_at >= ?", requests[i].Email, imp.CreatedAt).
+				Limit(1).Find(&existing).Error; err == nil && existing.ID != 0 {
+				result.Status, result.UserID = ImportRowCreated, existing.ID
+				continue
+			}
+		}
+
+		row := requests[i]
+		var user *models.User
+		err := errors.New("the stored row has no password")
+		if hash := hashes[i]; hash != "" {
+			user, err = s.userService.CreateUser(tenantDB, tenant.ID, &CreateUserRequest{
+				Username:     row.Username,
+				Email:        row.Email,
+				RoleID:       row.RoleID,
+				PasswordHash: hash,
+			}, &requester)
+		}
+		if err != nil {
+			result.Status, result.Errors = ImportRowFailed, []string{err.Error()}
+		} else {
+			result.Status, result.UserID = ImportRowCreated, user.ID
+		}
+
+		if unsaved++; unsaved == importSaveEvery {
+			if err := save(); err != nil {
+				return err
+			}
+			unsaved = 0
+		}
+	}
+	return save()
+}
+
+// FailAbandonedImports fails imports whose job was cancelled or dead-lettered
+// before it could finish, dropping the rows they still hold.
+func (s *UserImportService) FailAbandonedImports() (int64, error) {
+	abandoned := config.GetMasterDB().Model(&models.Job{}).Select("id").
+		Where("state IN ?", []string{models.JobCancelled, models.JobDead})
+	res := config.GetMasterDB().Model(&models.UserImport{}).
+		Where("status IN ? AND job_id IN (?)", []string{models.ImportPending, models.ImportRunning}, abandoned).
+		Updates(map[string]interface{}{
+			"status":       models.ImportFailed,
+			"rows":         "",
+			"error":        "the import job was cancelled or gave up",
+			"completed_at": time.Now(),
+		})
+	return res.RowsAffected, res.Error
+}
@@ -24,6 +24,9 @@ type CreateUserRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 	RoleID   uint   `json:"role_id"`
+	// PasswordHash, when set, is stored instead of a hash of Password. Bulk
+	// imports hash their rows in parallel in the import job.
+	PasswordHash string `json:"-"`
 }
 
 func isValidEmail(email string) bool {
@@ -42,25 +45,20 @@ func (s *UserService) CreateUser(tenantDB *gorm.DB, tenantID uint, req *CreateUs
 		return nil, errors.New("insufficient permissions")
 	}
 
-	var tenant models.Tenant
-	if err := config.GetMasterDB().Preload("Plan").First(&tenant, tenantID).Error; err != nil {
-		return nil, errors.New("failed to load tenant info")
-	}
-
-	if tenant.Plan.MaxUsers > 0 {
-		var currentCount int64
-		tenantDB.Model(&models.User{}).Count(&currentCount)
-		if int(currentCount) >= tenant.Plan.MaxUsers {
-			return nil, fmt.Errorf("plan limit reached: your plan allows max %d users", tenant.Plan.MaxUsers)
-		}
-	}
-
 	var count int64
 	config.GetMasterDB().Model(&models.GlobalIdentity{}).Where("email = ?", req.Email).Count(&count)
 	if count > 0 {
 		return nil, errors.New("email already exists in the system")
 	}
-	hashedPassword, _ := utils.HashPassword(req.Password)
+
+	usageService := NewUsageService()
+	if err := usageService.Reserve(tenantDB, tenantID, models.MetricUsers, 1); err != nil {
+		return nil, err
+	}
+	hashedPassword := req.PasswordHash
+	if hashedPassword == "" {
+		hashedPassword, _ = utils.HashPassword(req.Password)
+	}
 	user := &models.User{
 		TenantID: tenantID,
 		Username: req.Username,
@@ -71,6 +69,7 @@ func (s *UserService) CreateUser(tenantDB *gorm.DB, tenantID uint, req *CreateUs
 
 	userRepo := repositories.NewUserRepository(tenantDB)
 	if err := userRepo.Create(user); err != nil {
+		usageService.Release(tenantID, models.MetricUsers, 1)
 		return nil, err
 	}
 
@@ -84,7 +83,14 @@ func (s *UserService) CreateUser(tenantDB *gorm.DB, tenantID uint, req *CreateUs
 		TenantID: tenantID,
 	})
 
-	clearUserCache(tenantID)
+	publishEvent(UserCreated{
+		TenantID:  tenantID,
+		UserID:    user.ID,
+		Username:  user.Username,
+		Email:     user.Email,
+		RoleID:    req.RoleID,
+		CreatedAt: user.CreatedAt,
+	})
 	return user, nil
 }
 
@@ -149,6 +155,7 @@ func (s *UserService) UpdateUser(tenantDB *gorm.DB, userID uint, updateData map[
 		user.IsActive = isActive.(bool)
 	}
 
+	var newRoleID uint
 	if roleID, exists := updateData["role_id"]; exists {
 		var rID uint
 		switch v := roleID.(type) {
@@ -168,13 +175,17 @@ func (s *UserService) UpdateUser(tenantDB *gorm.DB, userID uint, updateData map[
 		if err := userRepo.ReplaceRole(user.ID, rID); err != nil {
 			return nil, fmt.Errorf("failed to update role: %w", err)
 		}
+		newRoleID = rID
 	}
 
 	if err := userRepo.Update(user); err != nil {
 		return nil, fmt.Errorf("failed to update user: %w", err)
 	}
 
-	clearUserCache(currentUser.TenantID)
+	publishEvent(UserUpdated{TenantID: currentUser.TenantID, UserID: userID})
+	if newRoleID != 0 {
+		publishEvent(RoleChanged{TenantID: currentUser.TenantID, RoleID: newRoleID, UserID: userID})
+	}
 	return s.GetUser(tenantDB, userID, currentUser)
 }
 
@@ -201,8 +212,9 @@ func (s *UserService) DeleteUser(tenantDB *gorm.DB, userID uint, currentUser *mo
 	if err := userRepo.Delete(userID); err != nil {
 		return fmt.Errorf("failed to delete user: %w", err)
 	}
+	NewUsageService().Release(currentUser.TenantID, models.MetricUsers, 1)
 
-	clearUserCache(currentUser.TenantID)
+	publishEvent(UserDeleted{TenantID: currentUser.TenantID, UserID: userID})
 	return nil
 }
 
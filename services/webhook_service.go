@@ -0,0 +1,742 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-multi-tenant/config"
+	"go-multi-tenant/events"
+	"go-multi-tenant/models"
+	"go-multi-tenant/queue"
+	"go-multi-tenant/utils"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Webhook event types.
+const (
+	EventPurchaseOrderApproved = "purchase_order.approved"
+	EventPurchaseOrderReceived = "purchase_order.received"
+	EventStockLow              = "inventory.stock_low"
+	EventUserCreated           = "user.created"
+)
+
+var webhookEvents = map[string]string{
+	EventPurchaseOrderApproved: "A purchase order was approved and dispatched",
+	EventPurchaseOrderReceived: "A purchase order was received into stock",
+	EventStockLow:              "A product's stock fell to or below the low-stock threshold",
+	EventUserCreated:           "A user was added to the workspace",
+}
+
+const (
+	// Retries back off exponentially, so eight attempts span about 20 minutes.
+	webhookAttempts = 8
+	// Failed attempts in a row after which a subscription is disabled.
+	webhookFailureLimit = 20
+	// How much of a response body is kept in the attempt log.
+	webhookResponseLimit = 4096
+	maxWebhooksPerTenant = 20
+)
+
+var (
+	ErrWebhookNotFound         = errors.New("webhook not found")
+	ErrWebhookDeliveryNotFound = errors.New("webhook delivery not found")
+)
+
+type WebhookService struct{}
+
+func NewWebhookService() *WebhookService {
+	return &WebhookService{}
+}
+
+type WebhookEventInfo struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+func (s *WebhookService) Events() []WebhookEventInfo {
+	events := make([]WebhookEventInfo, 0, len(webhookEvents))
+	for name, description := range webhookEvents {
+		events = append(events, WebhookEventInfo{Type: name, Description: description})
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Type < events[j].Type })
+	return events
+}
+
+type WebhookRequest struct {
+	URL         string   `json:"url" binding:"required"`
+	Description string   `json:"description"`
+	Events      []string `json:"events" binding:"required"`
+	// IsActive re-enables a disabled webhook when set to true on update.
+	IsActive *bool `json:"is_active"`
+}
+
+// WebhookView is a subscription as returned by the API. Secret is only
+// filled in when the subscription is created or its secret rotated.
+type WebhookView struct {
+	models.WebhookSubscription
+	Events []string `json:"events"`
+	Secret string   `json:"secret,omitempty"`
+}
+
+func newWebhookView(sub models.WebhookSubscription) WebhookView {
+	view := WebhookView{WebhookSubscription: sub, Events: []string{}}
+	_ = json.Unmarshal([]byte(sub.Events), &view.Events)
+	return view
+}
+
+func (s *WebhookService) validate(req *WebhookRequest) error {
+	if err := validateWebhookURL(req.URL); err != nil {
+		return err
+	}
+	if len(req.Events) == 0 {
+		return errors.New("at least one event type is required")
+	}
+	for _, event := range req.Events {
+		if _, ok := webhookEvents[event]; !ok {
+			return fmt.Errorf("unknown event type %q", event)
+		}
+	}
+	return nil
+}
+
+func (s *WebhookService) Create(tenantID, userID uint, req *WebhookRequest) (*WebhookView, error) {
+	if err := s.validate(req); err != nil {
+		return nil, err
+	}
+	masterDB := config.GetMasterDB()
+
+	var count int64
+	masterDB.Model(&models.WebhookSubscription{}).Where("tenant_id = ?", tenantID).Count(&count)
+	if count >= maxWebhooksPerTenant {
+		return nil, fmt.Errorf("a workspace can have at most %d webhooks", maxWebhooksPerTenant)
+	}
+
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+	events, _ := json.Marshal(sortedUnique(req.Events))
+	sub := models.WebhookSubscription{
+		TenantID:    tenantID,
+		URL:         req.URL,
+		Description: req.Description,
+		Events:      string(events),
+		Secret:      secret,
+		IsActive:    true,
+		CreatedBy:   userID,
+	}
+	if err := masterDB.Create(&sub).Error; err != nil {
+		return nil, err
+	}
+	view := newWebhookView(sub)
+	view.Secret = secret
+	return &view, nil
+}
+
+func (s *WebhookService) List(tenantID uint) ([]WebhookView, error) {
+	var subs []models.WebhookSubscription
+	if err := config.GetMasterDB().Where("tenant_id = ?", tenantID).Order("id").Find(&subs).Error; err != nil {
+		return nil, err
+	}
+	views := make([]WebhookView, len(subs))
+	for i, sub := range subs {
+		views[i] = newWebhookView(sub)
+	}
+	return views, nil
+}
+
+func (s *WebhookService) find(tenantID, id uint) (*models.WebhookSubscription, error) {
+	var sub models.WebhookSubscription
+	if err := config.GetMasterDB().Where("id = ? AND tenant_id = ?", id, tenantID).First(&sub).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, err
+	}
+	return &sub, nil
+}
+
+func (s *WebhookService) Get(tenantID, id uint) (*WebhookView, error) {
+	sub, err := s.find(tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	view := newWebhookView(*sub)
+	return &view, nil
+}
+
+// Update changes a subscription. Setting is_active to true re-enables one
+// that was disabled and resets its failure count.
+func (s *WebhookService) Update(tenantID, id uint, req *WebhookRequest) (*WebhookView, error) {
+	if err := s.validate(req); err != nil {
+		return nil, err
+	}
+	sub, err := s.find(tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	events, _ := json.Marshal(sortedUnique(req.Events))
+	sub.URL = req.URL
+	sub.Description = req.Description
+	sub.Events = string(events)
+	if req.IsActive != nil {
+		sub.IsActive = *req.IsActive
+		if sub.IsActive {
+			sub.ConsecutiveFailures = 0
+			sub.DisabledAt = nil
+			sub.DisabledReason = ""
+		}
+	}
+	if err := config.GetMasterDB().Save(sub).Error; err != nil {
+		return nil, err
+	}
+	view := newWebhookView(*sub)
+	return &view, nil
+}
+
+// Delete removes a subscription with its delivery log.
+func (s *WebhookService) Delete(tenantID, id uint) error {
+	sub, err := s.find(tenantID, id)
+	if err != nil {
+		return err
+	}
+	return config.GetMasterDB().Transaction(func(tx *gorm.DB) error {
+		deliveries := tx.Model(&models.WebhookDelivery{}).Select("id").Where("subscription_id = ?", sub.ID)
+		if err := tx.Where("delivery_id IN (?)", deliveries).Delete(&models.WebhookAttempt{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("subscription_id = ?", sub.ID).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(sub).Error
+	})
+}
+
+// RotateSecret replaces a subscription's signing secret and returns the new one.
+func (s *WebhookService) RotateSecret(tenantID, id uint) (*WebhookView, error) {
+	sub, err := s.find(tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := config.GetMasterDB().Model(sub).Update("secret", secret).Error; err != nil {
+		return nil, err
+	}
+	view := newWebhookView(*sub)
+	view.Secret = secret
+	return &view, nil
+}
+
+// WebhookDeliveryView is a delivery with its payload as JSON.
+type WebhookDeliveryView struct {
+	models.WebhookDelivery
+	Payload json.RawMessage `json:"payload"`
+}
+
+func newDeliveryView(delivery models.WebhookDelivery) WebhookDeliveryView {
+	return WebhookDeliveryView{WebhookDelivery: delivery, Payload: json.RawMessage(delivery.Payload)}
+}
+
+var deliveryStatuses = []string{models.DeliveryPending, models.DeliverySucceeded, models.DeliveryFailed, models.DeliverySuppressed}
+
+// ListDeliveries lists a subscription's deliveries, newest first, without their attempts.
+func (s *WebhookService) ListDeliveries(tenantID, subscriptionID uint, status string, page, pageSize int) ([]WebhookDeliveryView, int64, error) {
+	if _, err := s.find(tenantID, subscriptionID); err != nil {
+		return nil, 0, err
+	}
+	query := config.GetMasterDB().Model(&models.WebhookDelivery{}).Where("subscription_id = ?", subscriptionID)
+	if status != "" {
+		if !slices.Contains(deliveryStatuses, status) {
+			return nil, 0, fmt.Errorf("invalid status %q", status)
+		}
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var deliveries []models.WebhookDelivery
+	if err := query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&deliveries).Error; err != nil {
+		return nil, 0, err
+	}
+	views := make([]WebhookDeliveryView, len(deliveries))
+	for i, delivery := range deliveries {
+		views[i] = newDeliveryView(delivery)
+	}
+	return views, total, nil
+}
+
+// GetDelivery returns a delivery with the log of its attempts.
+func (s *WebhookService) GetDelivery(tenantID, id uint) (*WebhookDeliveryView, error) {
+	var delivery models.WebhookDelivery
+	err := config.GetMasterDB().Preload("AttemptLog", func(db *gorm.DB) *gorm.DB { return db.Order("attempt") }).
+		Where("id = ? AND tenant_id = ?", id, tenantID).First(&delivery).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWebhookDeliveryNotFound
+		}
+		return nil, err
+	}
+	view := newDeliveryView(delivery)
+	return &view, nil
+}
+
+// Redeliver sends a delivery's event again as a new delivery with the same
+// event ID, so receivers can recognise it as a duplicate.
+func (s *WebhookService) Redeliver(tenantID, id uint) (*WebhookDeliveryView, error) {
+	original, err := s.GetDelivery(tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := s.find(tenantID, original.SubscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	if !sub.IsActive {
+		return nil, errors.New("the webhook is disabled; enable it before redelivering")
+	}
+	var tenant models.Tenant
+	if err := config.GetMasterDB().First(&tenant, tenantID).Error; err != nil {
+		return nil, err
+	}
+
+	delivery := &models.WebhookDelivery{
+		TenantID:       tenantID,
+		SubscriptionID: sub.ID,
+		EventID:        original.EventID,
+		Event:          original.Event,
+		Payload:        original.WebhookDelivery.Payload,
+		RedeliveryOf:   &original.ID,
+	}
+	if err := s.deliver(&tenant, delivery); err != nil {
+		return nil, err
+	}
+	view := newDeliveryView(*delivery)
+	return &view, nil
+}
+
+// PurgeDeliveries removes finished deliveries last updated before cutoff,
+// with their attempts, and returns how many were removed.
+func (s *WebhookService) PurgeDeliveries(cutoff time.Time) (int64, error) {
+	var removed int64
+	err := config.GetMasterDB().Transaction(func(tx *gorm.DB) error {
+		old := tx.Model(&models.WebhookDelivery{}).Select("id").
+			Where("status <> ? AND updated_at < ?", models.DeliveryPending, cutoff)
+		if err := tx.Where("delivery_id IN (?)", old).Delete(&models.WebhookAttempt{}).Error; err != nil {
+			return err
+		}
+		res := tx.Where("status <> ? AND updated_at < ?", models.DeliveryPending, cutoff).Delete(&models.WebhookDelivery{})
+		removed = res.RowsAffected
+		return res.Error
+	})
+	return removed, err
+}
+
+// StockLowEvent is the payload of the inventory.stock_low webhook.
+type StockLowEvent struct {
+	ProductID uint   `json:"product_id"`
+	SKU       string `json:"sku"`
+	Name      string `json:"name"`
+	Quantity  int    `json:"quantity"`
+	Threshold int    `json:"threshold"`
+	Location  string `json:"location"`
+}
+
+// subscribeWebhooks turns domain events into webhook events.
+func subscribeWebhooks(bus *events.Bus) {
+	s := NewWebhookService()
+
+	events.Subscribe(bus, func(ctx context.Context, e UserCreated) {
+		s.Publish(e.TenantID, EventUserCreated, map[string]interface{}{
+			"id":         e.UserID,
+			"username":   e.Username,
+			"email":      e.Email,
+			"role_id":    e.RoleID,
+			"created_at": e.CreatedAt,
+		})
+	}, events.Async())
+
+	events.Subscribe(bus, func(ctx context.Context, e PurchaseOrderStatusChanged) {
+		switch e.To {
+		case models.PODispatched:
+			s.Publish(e.TenantID, EventPurchaseOrderApproved, e.Order)
+		case models.POReceived:
+			s.Publish(e.TenantID, EventPurchaseOrderReceived, e.Order)
+		}
+	}, events.Async())
+
+	events.Subscribe(bus, func(ctx context.Context, e StockAdjusted) {
+		threshold := NewSettingsService().LowStockThreshold(e.TenantID)
+		if !e.FellBelow(threshold) {
+			return
+		}
+		s.Publish(e.TenantID, EventStockLow, StockLowEvent{
+			ProductID: e.ProductID,
+			SKU:       e.SKU,
+			Name:      e.ProductName,
+			Quantity:  e.After,
+			Threshold: threshold,
+			Location:  e.Location,
+		})
+	}, events.Async())
+}
+
+type webhookEnvelope struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	TenantID  uint        `json:"tenant_id"`
+	Data      interface{} `json:"data"`
+}
+
+// Publish sends an event to every active subscription of the tenant that
+// wants it. Failures are logged.
+func (s *WebhookService) Publish(tenantID uint, event string, data interface{}) {
+	masterDB := config.GetMasterDB()
+
+	var subs []models.WebhookSubscription
+	if err := masterDB.Where("tenant_id = ? AND is_active = ?", tenantID, true).Find(&subs).Error; err != nil {
+		log.Printf("Webhooks: failed to load subscriptions of tenant %d: %v", tenantID, err)
+		return
+	}
+	subs = slices.DeleteFunc(subs, func(sub models.WebhookSubscription) bool {
+		return !slices.Contains(newWebhookView(sub).Events, event)
+	})
+	if len(subs) == 0 {
+		return
+	}
+
+	var tenant models.Tenant
+	if err := masterDB.First(&tenant, tenantID).Error; err != nil {
+		return
+	}
+	eventID, err := newEventID()
+	if err != nil {
+		log.Printf("Webhooks: %v", err)
+		return
+	}
+	payload, err := json.Marshal(webhookEnvelope{
+		ID:        eventID,
+		Type:      event,
+		CreatedAt: time.Now().UTC(),
+		TenantID:  tenantID,
+		Data:      data,
+	})
+	if err != nil {
+		log.Printf("Webhooks: failed to encode %s event: %v", event, err)
+		return
+	}
+
+	for _, sub := range subs {
+		delivery := &models.WebhookDelivery{
+			TenantID:       tenantID,
+			SubscriptionID: sub.ID,
+			EventID:        eventID,
+			Event:          event,
+			Payload:        string(payload),
+		}
+		if err := s.deliver(&tenant, delivery); err != nil {
+			log.Printf("Webhooks: failed to queue %s for webhook %d: %v", event, sub.ID, err)
+		}
+	}
+}
+
+type webhookDeliveryJob struct {
+	DeliveryID uint `json:"delivery_id"`
+}
+
+// deliver records a delivery and queues the job that sends it. Sandbox
+// tenants' deliveries are recorded as suppressed and never sent.
+func (s *WebhookService) deliver(tenant *models.Tenant, delivery *models.WebhookDelivery) error {
+	masterDB := config.GetMasterDB()
+
+	delivery.Status = models.DeliveryPending
+	if !tenant.AllowsOutboundDelivery() {
+		delivery.Status = models.DeliverySuppressed
+	}
+	if err := masterDB.Create(delivery).Error; err != nil {
+		return err
+	}
+	if delivery.Status == models.DeliverySuppressed {
+		return nil
+	}
+
+	job, err := NewQueueService().Enqueue(JobWebhookDelivery, webhookDeliveryJob{DeliveryID: delivery.ID}, queue.EnqueueOptions{
+		TenantID:    delivery.TenantID,
+		MaxAttempts: webhookAttempts,
+	})
+	if err != nil {
+		masterDB.Model(delivery).Update("status", models.DeliveryFailed)
+		return err
+	}
+	delivery.JobID = job.ID
+	return masterDB.Model(delivery).Update("job_id", job.ID).Error
+}
+
+// runDeliveryJob makes one attempt at a delivery. A failed attempt is
+// returned as an error so the queue retries it with backoff.
+func (s *WebhookService) runDeliveryJob(ctx context.Context, job *models.Job, payload webhookDeliveryJob) (interface{}, error) {
+	masterDB := config.GetMasterDB()
+
+	var delivery models.WebhookDelivery
+	if err := masterDB.First(&delivery, payload.DeliveryID).Error; err != nil {
+		return nil, queue.Permanent(fmt.Errorf("webhook delivery %d not found", payload.DeliveryID))
+	}
+	if delivery.Status != models.DeliveryPending {
+		return map[string]interface{}{"delivery_id": delivery.ID, "status": delivery.Status}, nil
+	}
+	var sub models.WebhookSubscription
+	if err := masterDB.First(&sub, delivery.SubscriptionID).Error; err != nil || !sub.IsActive {
+		masterDB.Model(&delivery).Update("status", models.DeliveryFailed)
+		return nil, queue.Permanent(errors.New("the webhook was deleted or disabled"))
+	}
+
+	attempt := s.send(ctx, &sub, &delivery)
+	attempt.Attempt = delivery.Attempts + 1
+	masterDB.Create(attempt)
+
+	updates := map[string]interface{}{
+		"attempts":      gorm.Expr("attempts + 1"),
+		"response_code": attempt.ResponseCode,
+	}
+	if attempt.Error == "" {
+		now := time.Now()
+		updates["status"] = models.DeliverySucceeded
+		updates["delivered_at"] = &now
+		masterDB.Model(&delivery).Updates(updates)
+		if sub.ConsecutiveFailures > 0 {
+			masterDB.Model(&sub).Update("consecutive_failures", 0)
+		}
+		return map[string]interface{}{"delivery_id": delivery.ID, "response_code": attempt.ResponseCode}, nil
+	}
+
+	if job.Attempts >= job.MaxAttempts {
+		updates["status"] = models.DeliveryFailed
+	}
+	masterDB.Model(&delivery).Updates(updates)
+	s.recordFailure(&sub, attempt.Error)
+	return nil, errors.New(attempt.Error)
+}
+
+// send POSTs the delivery's payload, signed with the subscription's secret.
+// Only a 2xx response is a success; redirects are not followed.
+func (s *WebhookService) send(ctx context.Context, sub *models.WebhookSubscription, delivery *models.WebhookDelivery) *models.WebhookAttempt {
+	attempt := &models.WebhookAttempt{DeliveryID: delivery.ID}
+	body := []byte(delivery.Payload)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-multi-tenant-webhooks/1")
+	req.Header.Set("X-Webhook-ID", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Event-ID", delivery.EventID)
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Webhook-Signature", SignWebhook(sub.Secret, timestamp, body))
+
+	start := time.Now()
+	resp, err := webhookClient().Do(req)
+	attempt.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseLimit))
+	attempt.ResponseCode = resp.StatusCode
+	attempt.ResponseBody = string(respBody)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("HTTP %d", resp.StatusCode)
+	}
+	return attempt
+}
+
+// recordFailure counts a failed attempt against the subscription and
+// disables it at the limit, telling the tenant's webhook managers by email.
+func (s *WebhookService) recordFailure(sub *models.WebhookSubscription, lastError string) {
+	masterDB := config.GetMasterDB()
+	masterDB.Model(sub).Update("consecutive_failures", gorm.Expr("consecutive_failures + 1"))
+
+	now := time.Now()
+	reason := fmt.Sprintf("disabled after %d failed attempts in a row; last: %s", webhookFailureLimit, lastError)
+	if len(reason) > 255 {
+		reason = reason[:255]
+	}
+	res := masterDB.Model(&models.WebhookSubscription{}).
+		Where("id = ? AND is_active = ? AND consecutive_failures >= ?", sub.ID, true, webhookFailureLimit).
+		Updates(map[string]interface{}{"is_active": false, "disabled_at": &now, "disabled_reason": reason})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return
+	}
+
+	// Deliveries waiting for a retry stop here; they can be redelivered once
+	// the webhook is enabled again.
+	masterDB.Model(&models.WebhookDelivery{}).Where("subscription_id = ? AND status = ?", sub.ID, models.DeliveryPending).
+		Update("status", models.DeliveryFailed)
+	log.Printf("Webhooks: disabled webhook %d of tenant %d: %s", sub.ID, sub.TenantID, reason)
+	NewMailService().notify(sub.TenantID, "webhook:manage", EmailWebhookDisabled, map[string]interface{}{
+		"URL":       sub.URL,
+		"Failures":  webhookFailureLimit,
+		"LastError": lastError,
+	})
+}
+
+// SignWebhook returns the X-Webhook-Signature header for a body sent at
+// timestamp: "t=<timestamp>,v1=<hex HMAC-SHA256 of "<timestamp>.<body>">".
+// Receivers recompute the HMAC and should reject old timestamps to stop replays.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+func newWebhookSecret() (string, error) {
+	key, err := utils.GenerateSecureKey()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return "whsec_" + key, nil
+}
+
+func newEventID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate event id: %w", err)
+	}
+	return "evt_" + hex.EncodeToString(b), nil
+}
+
+func sortedUnique(values []string) []string {
+	out := slices.Clone(values)
+	sort.Strings(out)
+	return slices.Compact(out)
+}
+
+// validateWebhookURL accepts https URLs, and http ones when private
+// addresses are allowed for development. Addresses are checked again when
+// connecting, since a hostname can resolve anywhere.
+func validateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return errors.New("url must be an absolute URL")
+	}
+	allowPrivate := config.AppConfig.WebhookAllowPrivate
+	if u.Scheme != "https" && !(allowPrivate && u.Scheme == "http") {
+		return errors.New("url must use https")
+	}
+	if u.User != nil {
+		return errors.New("url must not contain credentials")
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !allowPrivate && isPrivateIP(ip) {
+		return errors.New("url must not point to a private address")
+	}
+	return nil
+}
+
+// reservedPrefixes are special-purpose ranges the net.IP checks do not cover:
+// carrier-grade NAT, benchmarking, documentation and the IPv6 transition
+// ranges that embed an IPv4 address.
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // "This network"
+	netip.MustParsePrefix("100.64.0.0/10"),   // Carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // Documentation
+	netip.MustParsePrefix("198.18.0.0/15"),   // Benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // Documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  // Documentation
+	netip.MustParsePrefix("240.0.0.0/4"),     // Reserved, and broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),    // NAT64
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // Local-use NAT64
+	netip.MustParsePrefix("100::/64"),        // Discard-only
+	netip.MustParsePrefix("2001::/32"),       // Teredo
+	netip.MustParsePrefix("2001:db8::/32"),   // Documentation
+	netip.MustParsePrefix("2002::/16"),       // 6to4
+	netip.MustParsePrefix("fec0::/10"),       // Deprecated site-local
+}
+
+func isPrivateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return true
+	}
+	addr = addr.Unmap()
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	webhookClientOnce   sync.Once
+	sharedWebhookClient *http.Client
+)
+
+// webhookClient refuses to connect to private addresses, so a subscription
+// cannot be used to reach internal services.
+func webhookClient() *http.Client {
+	webhookClientOnce.Do(newWebhookClient)
+	return sharedWebhookClient
+}
+
+func newWebhookClient() {
+	cfg := config.AppConfig
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !cfg.WebhookAllowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+				return fmt.Errorf("refusing to connect to private address %s", host)
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+	sharedWebhookClient = &http.Client{
+		Timeout:   cfg.WebhookTimeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
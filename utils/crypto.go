@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+// Seal encrypts plaintext with AES-GCM under a key derived from the app secret
+// and purpose, so data sealed for one purpose cannot be opened for another.
+func Seal(purpose string, plaintext []byte) (string, error) {
+	gcm, err := sealCipher(purpose)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+// Open decrypts what Seal produced for the same purpose.
+func Open(purpose, sealed string) ([]byte, error) {
+	gcm, err := sealCipher(purpose)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("sealed data is too short")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func sealCipher(purpose string) (cipher.AEAD, error) {
+	if len(secretKey) == 0 {
+		return nil, errors.New("the app secret is not set")
+	}
+	mac := hmac.New(sha256.New, secretKey)
+	mac.Write([]byte(purpose))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -1,7 +1,10 @@
 package utils
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"slices"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -47,7 +50,60 @@ func ValidateToken(tokenString string) (*Claims, error) {
 	}
 
 	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+		// A stream ticket only opens the event stream.
+		if slices.Contains(claims.Audience, streamAudience) {
+			return nil, errors.New("invalid token")
+		}
 		return claims, nil
 	}
 	return nil, errors.New("invalid token")
 }
+
+const streamAudience = "stream"
+
+const (
+	// StreamTicketTTL is how long a new stream ticket can be used to open
+	// the stream.
+	StreamTicketTTL = time.Minute
+	// streamTicketMaxAge caps how long reconnects may keep reusing a ticket,
+	// like the session token it stands in for.
+	streamTicketMaxAge = 12 * time.Hour
+)
+
+// GenerateStreamTicket issues a token that only opens the event stream, for
+// clients such as EventSource that cannot send headers and must put it in the
+// URL. It must open the stream within StreamTicketTTL; the stream service then
+// makes it single-use.
+func GenerateStreamTicket(claims *Claims) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	ticket := &Claims{
+		UserID:   claims.UserID,
+		TenantID: claims.TenantID,
+		Email:    claims.Email,
+		Role:     claims.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(id),
+			Audience:  jwt.ClaimStrings{streamAudience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(streamTicketMaxAge)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, ticket).SignedString(secretKey)
+}
+
+func ValidateStreamTicket(ticket string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(ticket, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		return secretKey, nil
+	}, jwt.WithAudience(streamAudience), jwt.WithIssuedAt(), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	if claims, ok := token.Claims.(*Claims); ok && token.Valid && claims.ID != "" && claims.IssuedAt != nil {
+		return claims, nil
+	}
+	return nil, errors.New("invalid ticket")
+}